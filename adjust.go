@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/satraul/bca-go"
+	"github.com/shopspring/decimal"
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	pendingAdjustmentFile = "pending-adjustment.json"
+)
+
+// pendingAdjustment is a balance adjustment that failed after the
+// transactions were created, kept to retry it later
+type pendingAdjustment struct {
+	Balance decimal.Decimal `json:"balance"`
+	Time    time.Time       `json:"time"`
+	Error   string          `json:"error"`
+}
+
+func savePendingAdjustment(bal bca.Balance, err error) error {
+	b, _ := json.Marshal(pendingAdjustment{Balance: bal.Balance, Time: time.Now(), Error: err.Error()})
+	return writeFileAtomic(filepath.Join(profileFolder().Path, pendingAdjustmentFile), b, 0600)
+}
+
+func readPendingAdjustment() (*pendingAdjustment, error) {
+	b, err := ioutil.ReadFile(filepath.Join(profileFolder().Path, pendingAdjustmentFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var p pendingAdjustment
+	if err := json.Unmarshal(b, &p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func clearPendingAdjustment() error {
+	err := os.Remove(filepath.Join(profileFolder().Path, pendingAdjustmentFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// adjustAction retries a failed balance adjustment with the balance stored
+// by the failed run, without logging in to klikbca again
+func adjustAction(c *cli.Context) error {
+	p, err := readPendingAdjustment()
+	if err != nil {
+		return fmt.Errorf("failed to read pending adjustment: %w", err)
+	}
+	if p == nil {
+		fmt.Fprintln(stdout, "no pending balance adjustment")
+		return nil
+	}
+	fmt.Fprintf(stdout, "retrying balance adjustment to %s from %s\n", p.Balance, p.Time.Format(time.RFC3339))
+
+	config, err := getOrDeleteConfig(username, password, token, false, noninteractive, reset, nostore)
+	if err != nil {
+		return err
+	}
+	if config == nil {
+		return nil
+	}
+	yc, err := newYNABClient(c.Context, config.YNABToken)
+	if err != nil {
+		return err
+	}
+	a, err := getYNABAccount(yc, budget, accountName)
+	if err != nil {
+		return ynabHint(err)
+	}
+	if _, err := createYNABBalanceAdjustment(bca.Balance{Balance: p.Balance}, c.Context, nil, yc, budget, a); err != nil {
+		return withExitCode(fmt.Errorf("failed to create balance adjustment: %w", err), exitAdjustmentFailed)
+	}
+	return clearPendingAdjustment()
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/satraul/bca-go"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	roundHalfEven = "half-even"
+	roundHalfUp   = "half-up"
+	roundDown     = "down"
+	roundFloor    = "floor"
+	roundCeil     = "ceil"
+)
+
+var (
+	rounding string
+
+	milliunitsPerUnit = decimal.NewFromInt(1000)
+)
+
+// signedAmount is the amount of trx, negative for debits
+func signedAmount(trx bca.Entry) decimal.Decimal {
+	if trx.Type == "DB" {
+		return trx.Amount.Neg()
+	}
+	return trx.Amount
+}
+
+// toMilliunits converts an amount to ynab milliunits. everything is kept
+// down to a thousandth, like the sub-rupiah interest of deposits and cents
+// of foreign currency accounts, and anything finer is rounded with
+// --rounding. amounts are signed first, so that floor and ceil round debits
+// and credits the same way
+func toMilliunits(d decimal.Decimal) int64 {
+	m := d.Mul(milliunitsPerUnit)
+	switch rounding {
+	case roundHalfUp:
+		m = m.Round(0)
+	case roundDown:
+		m = m.Truncate(0)
+	case roundFloor:
+		m = m.Floor()
+	case roundCeil:
+		m = m.Ceil()
+	default:
+		m = m.RoundBank(0)
+	}
+	return m.IntPart()
+}
+
+func validateRounding() error {
+	switch rounding {
+	case roundHalfEven, roundHalfUp, roundDown, roundFloor, roundCeil:
+		return nil
+	default:
+		return fmt.Errorf("invalid --rounding %q, expected half-even, half-up, down, floor or ceil", rounding)
+	}
+}
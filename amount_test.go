@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestToMilliunits(t *testing.T) {
+	tests := []struct {
+		amount string
+		want   map[string]int64
+	}{
+		{"15000", map[string]int64{roundHalfEven: 15000000, roundHalfUp: 15000000, roundDown: 15000000, roundFloor: 15000000, roundCeil: 15000000}},
+		{"-15000", map[string]int64{roundHalfEven: -15000000, roundHalfUp: -15000000, roundDown: -15000000, roundFloor: -15000000, roundCeil: -15000000}},
+		{"1234.56", map[string]int64{roundHalfEven: 1234560, roundHalfUp: 1234560, roundDown: 1234560, roundFloor: 1234560, roundCeil: 1234560}},
+		{"-0.001", map[string]int64{roundHalfEven: -1, roundHalfUp: -1, roundDown: -1, roundFloor: -1, roundCeil: -1}},
+		{"12.3456", map[string]int64{roundHalfEven: 12346, roundHalfUp: 12346, roundDown: 12345, roundFloor: 12345, roundCeil: 12346}},
+		{"-12.3456", map[string]int64{roundHalfEven: -12346, roundHalfUp: -12346, roundDown: -12345, roundFloor: -12346, roundCeil: -12345}},
+		{"0.0005", map[string]int64{roundHalfEven: 0, roundHalfUp: 1, roundDown: 0, roundFloor: 0, roundCeil: 1}},
+		{"0.0015", map[string]int64{roundHalfEven: 2, roundHalfUp: 2, roundDown: 1, roundFloor: 1, roundCeil: 2}},
+		{"-0.0005", map[string]int64{roundHalfEven: 0, roundHalfUp: -1, roundDown: 0, roundFloor: -1, roundCeil: 0}},
+		{"-0.0025", map[string]int64{roundHalfEven: -2, roundHalfUp: -3, roundDown: -2, roundFloor: -3, roundCeil: -2}},
+		{"7.12345678", map[string]int64{roundHalfEven: 7123, roundHalfUp: 7123, roundDown: 7123, roundFloor: 7123, roundCeil: 7124}},
+		{"-7.12399", map[string]int64{roundHalfEven: -7124, roundHalfUp: -7124, roundDown: -7123, roundFloor: -7124, roundCeil: -7123}},
+	}
+
+	defer func(r string) { rounding = r }(rounding)
+	for _, tt := range tests {
+		for mode, want := range tt.want {
+			rounding = mode
+			if got := toMilliunits(decimal.RequireFromString(tt.amount)); got != want {
+				t.Errorf("toMilliunits(%s) with --rounding %s = %d, want %d", tt.amount, mode, got, want)
+			}
+		}
+	}
+}
+
+func TestToMilliunitsDefaultsToHalfEven(t *testing.T) {
+	defer func(r string) { rounding = r }(rounding)
+	rounding = ""
+	if got := toMilliunits(decimal.RequireFromString("0.0025")); got != 2 {
+		t.Errorf("toMilliunits(0.0025) without --rounding = %d, want 2", got)
+	}
+}
+
+func TestValidateRounding(t *testing.T) {
+	defer func(r string) { rounding = r }(rounding)
+	for _, mode := range []string{roundHalfEven, roundHalfUp, roundDown, roundFloor, roundCeil} {
+		rounding = mode
+		if err := validateRounding(); err != nil {
+			t.Errorf("validateRounding() with %s: %v", mode, err)
+		}
+	}
+	for _, mode := range []string{"", "up", "HALF-EVEN", "bankers"} {
+		rounding = mode
+		if err := validateRounding(); err == nil {
+			t.Errorf("validateRounding() with %q succeeded, want an error", mode)
+		}
+	}
+}
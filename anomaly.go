@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/satraul/bca-go"
+	"github.com/shopspring/decimal"
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	anomaliesFile = "anomalies.json"
+	// anomalyMinSamples is the number of earlier transactions with a payee
+	// needed before its amounts are judged
+	anomalyMinSamples = 5
+	// madScale makes the median absolute deviation comparable to a standard
+	// deviation for normally distributed amounts
+	madScale = 1.4826
+)
+
+var (
+	detectAnomalies  bool
+	anomalyThreshold float64
+	anomaliesClear   bool
+)
+
+// anomaly is a transaction flagged by a detector
+type anomaly struct {
+	Entry    ledgerEntry `json:"entry"`
+	Detector string      `json:"detector"`
+	Reason   string      `json:"reason"`
+	Time     time.Time   `json:"time"`
+}
+
+// anomalyDetector judges a new transaction against the ledger history
+type anomalyDetector interface {
+	name() string
+	// detect returns why e is unusual, or an empty string
+	detect(history []ledgerEntry, e ledgerEntry) string
+}
+
+// anomalyDetectors returns the detectors run by --detect-anomalies
+func anomalyDetectors() []anomalyDetector {
+	return []anomalyDetector{payeeAmountDetector{threshold: anomalyThreshold}}
+}
+
+// payeeAmountDetector flags amounts far outside the payee's earlier
+// amounts, using the median and median absolute deviation so that a few
+// earlier outliers don't hide new ones
+type payeeAmountDetector struct {
+	threshold float64
+}
+
+func (payeeAmountDetector) name() string { return "payee-amount" }
+
+func (d payeeAmountDetector) detect(history []ledgerEntry, e ledgerEntry) string {
+	var amounts []float64
+	for _, h := range history {
+		if strings.EqualFold(h.Payee, e.Payee) && h.Amount.IsNegative() == e.Amount.IsNegative() {
+			f, _ := h.Amount.Abs().Float64()
+			amounts = append(amounts, f)
+		}
+	}
+	if len(amounts) < anomalyMinSamples {
+		return ""
+	}
+
+	x, _ := e.Amount.Abs().Float64()
+	m := median(amounts)
+	deviations := make([]float64, len(amounts))
+	for i, a := range amounts {
+		deviations[i] = abs(a - m)
+	}
+	mad := median(deviations) * madScale
+	if mad == 0 {
+		// all earlier amounts are the same, like a subscription
+		if x != m {
+			return fmt.Sprintf("%s is usually exactly %s", e.Payee, decimal.NewFromFloat(m).StringFixed(0))
+		}
+		return ""
+	}
+	if z := (x - m) / mad; z > d.threshold || z < -d.threshold {
+		return fmt.Sprintf("%s is usually around %s, this is %.1f deviations away", e.Payee, decimal.NewFromFloat(m).StringFixed(0), z)
+	}
+	return ""
+}
+
+func median(values []float64) float64 {
+	s := append([]float64(nil), values...)
+	sort.Float64s(s)
+	if len(s)%2 == 1 {
+		return s[len(s)/2]
+	}
+	return (s[len(s)/2-1] + s[len(s)/2]) / 2
+}
+
+func abs(f float64) float64 {
+	if f < 0 {
+		return -f
+	}
+	return f
+}
+
+// checkAnomalies runs the detectors on transactions not yet in the ledger,
+// notifies about anomalies and keeps them for review. it must run before
+// the transactions are recorded. failing doesn't fail the run
+func checkAnomalies(ctx context.Context, trxs []bca.Entry) {
+	if !detectAnomalies {
+		return
+	}
+	history, err := readLedger()
+	if err != nil {
+		fmt.Fprintf(stdout, "failed to read ledger for anomaly detection: %v\n", err)
+		return
+	}
+	known := make(map[string]bool, len(history))
+	for _, h := range history {
+		known[h.ImportID] = true
+	}
+
+	var found []anomaly
+	for i, importID := range importIDs(trxs) {
+		// the payee as recorded in the ledger
+		e := toLedgerEntry(trxs[i], importID)
+		e.Payee = payeeName(trxs[i])
+		if known[e.ImportID] {
+			continue
+		}
+		for _, d := range anomalyDetectors() {
+			if reason := d.detect(history, e); reason != "" {
+				found = append(found, anomaly{Entry: e, Detector: d.name(), Reason: reason, Time: time.Now()})
+				break
+			}
+		}
+	}
+	if len(found) == 0 {
+		return
+	}
+
+	for _, a := range found {
+		notifyAll(ctx, notification{
+			Title:   "Unusual BCA transaction",
+			Message: fmt.Sprintf("%s %s on %s: %s", a.Entry.Payee, a.Entry.Amount.StringFixed(0), a.Entry.Date.Format(dateLayout), a.Reason),
+			High:    true,
+		})
+	}
+	if err := appendAnomalies(found); err != nil {
+		fmt.Fprintf(stdout, "failed to save anomalies: %v\n", err)
+	}
+	fmt.Fprintf(stdout, "%d unusual transaction(s) found. see review anomalies\n", len(found))
+}
+
+func readAnomalies() ([]anomaly, error) {
+	b, err := ioutil.ReadFile(filepath.Join(profileFolder().Path, anomaliesFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var as []anomaly
+	return as, json.Unmarshal(b, &as)
+}
+
+func appendAnomalies(found []anomaly) error {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	as, err := readAnomalies()
+	if err != nil {
+		return err
+	}
+	return writeAnomalies(append(as, found...))
+}
+
+func writeAnomalies(as []anomaly) error {
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(as); err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(profileFolder().Path, anomaliesFile), b.Bytes(), 0600)
+}
+
+func reviewAnomaliesAction(c *cli.Context) error {
+	as, err := readAnomalies()
+	if err != nil {
+		return fmt.Errorf("failed to read anomalies: %w", err)
+	}
+	if len(as) == 0 {
+		fmt.Fprintln(stdout, "no unusual transactions to review")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "DATE\tPAYEE\tAMOUNT\tREASON\n")
+	for _, a := range as {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", a.Entry.Date.Format(dateLayout), a.Entry.Payee, a.Entry.Amount.StringFixed(2), a.Reason)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if !anomaliesClear {
+		return nil
+	}
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	if err := writeAnomalies([]anomaly{}); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "%d reviewed anomalies cleared\n", len(as))
+	return nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/satraul/bca-go"
+	"github.com/urfave/cli/v2"
+)
+
+var (
+	archiveLocation string
+)
+
+// archivedStatement is a fetched statement window as kept by --archive.
+// klikbca only serves the last month, so the archive is the only long term
+// record of the statements
+type archivedStatement struct {
+	FetchedAt time.Time   `json:"fetchedAt"`
+	From      time.Time   `json:"from"`
+	To        time.Time   `json:"to"`
+	Balance   bca.Balance `json:"balance"`
+	Entries   []bca.Entry `json:"entries"`
+}
+
+// archiveStore is where statements are archived
+type archiveStore interface {
+	put(ctx context.Context, name string, data []byte) error
+	String() string
+}
+
+// archiveDir archives to a local directory
+type archiveDir string
+
+func (d archiveDir) put(ctx context.Context, name string, data []byte) error {
+	return writeFileAtomic(filepath.Join(string(d), name), data, 0600)
+}
+
+func (d archiveDir) String() string { return string(d) }
+
+// newArchiveStore returns the store of --archive, a directory or a remote
+// store
+func newArchiveStore() (archiveStore, error) {
+	if isRemote(archiveLocation) {
+		return newRemoteStore(archiveLocation)
+	}
+	return archiveDir(archiveLocation), nil
+}
+
+// archiveStatement writes the unfiltered entries of a statement window as
+// json and csv files named after the window
+func archiveStatement(ctx context.Context, s archivedStatement) error {
+	store, err := newArchiveStore()
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("bca-%s_%s", s.From.Format(dateLayout), s.To.Format(dateLayout))
+
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := store.put(ctx, name+".json", append(b, '\n')); err != nil {
+		return err
+	}
+	trxCsv, err := transactionsToCsv(s.Entries)
+	if err != nil {
+		return err
+	}
+	if err := store.put(ctx, name+".csv", []byte(trxCsv)); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "archived %d bca transaction(s) from %s to %s in %s\n", len(s.Entries), s.From.Format(dateLayout), s.To.Format(dateLayout), store)
+	return nil
+}
+
+// archiveFetched archives a sync's fetch with --archive. failing doesn't
+// fail the sync
+func archiveFetched(ctx context.Context, s archivedStatement) {
+	if archiveLocation == "" {
+		return
+	}
+	if err := archiveStatement(ctx, s); err != nil {
+		fmt.Fprintf(stdout, "failed to archive statement: %v\n", err)
+	}
+}
+
+// archiveAction only fetches the statement and archives it, without
+// pushing to any destination
+func archiveAction(c *cli.Context) error {
+	if archiveLocation == "" {
+		return fmt.Errorf("--archive is required")
+	}
+	config, err := getOrDeleteConfig(username, password, token, false, noninteractive, reset, nostore)
+	if err != nil {
+		return err
+	}
+	if config == nil {
+		return nil
+	}
+
+	from, to, err := statementRange()
+	if err != nil {
+		return err
+	}
+	bal, trxs, _, err := fetchBCA(c.Context, config)
+	if err != nil {
+		return err
+	}
+	return archiveStatement(c.Context, archivedStatement{FetchedAt: time.Now(), From: from, To: to, Balance: bal, Entries: trxs})
+}
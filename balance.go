@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/satraul/bca-go"
+	"github.com/shopspring/decimal"
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	balancesFile = "balances.jsonl"
+)
+
+var (
+	balanceDays   int
+	balanceOutput string
+)
+
+// balanceSnapshot is the bca balance read by a run
+type balanceSnapshot struct {
+	Time          time.Time       `json:"time"`
+	AccountNumber string          `json:"accountNumber"`
+	Balance       decimal.Decimal `json:"balance"`
+}
+
+// dailyBalance is the last balance read on a day
+type dailyBalance struct {
+	Date    string          `json:"date"`
+	Balance decimal.Decimal `json:"balance"`
+	Change  decimal.Decimal `json:"change"`
+}
+
+// recordBalance appends the balance read from klikbca to the balance
+// history. failing to do so doesn't fail the run
+func recordBalance(bal bca.Balance) {
+	b, err := json.Marshal(balanceSnapshot{Time: time.Now(), AccountNumber: bal.AccountNumber, Balance: bal.Balance})
+	if err != nil {
+		return
+	}
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	f, err := os.OpenFile(filepath.Join(profileFolder().Path, balancesFile), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		fmt.Fprintf(stdout, "failed to record balance: %v\n", err)
+		return
+	}
+	defer f.Close()
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		fmt.Fprintf(stdout, "failed to record balance: %v\n", err)
+	}
+}
+
+// readBalances returns the recorded balances, oldest first
+func readBalances() ([]balanceSnapshot, error) {
+	f, err := os.Open(filepath.Join(profileFolder().Path, balancesFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var (
+		snapshots []balanceSnapshot
+		s         = bufio.NewScanner(f)
+	)
+	for s.Scan() {
+		var b balanceSnapshot
+		if err := json.Unmarshal(s.Bytes(), &b); err != nil {
+			continue
+		}
+		snapshots = append(snapshots, b)
+	}
+	return snapshots, s.Err()
+}
+
+// dailyBalances keeps the last balance of every day since since
+func dailyBalances(snapshots []balanceSnapshot, since time.Time) []dailyBalance {
+	var days []dailyBalance
+	for _, b := range snapshots {
+		if b.Time.Before(since) {
+			continue
+		}
+		date := b.Time.In(time.Local).Format(dateLayout)
+		if n := len(days); n > 0 && days[n-1].Date == date {
+			days[n-1].Balance = b.Balance
+			continue
+		}
+		days = append(days, dailyBalance{Date: date, Balance: b.Balance})
+	}
+	for i := 1; i < len(days); i++ {
+		days[i].Change = days[i].Balance.Sub(days[i-1].Balance)
+	}
+	return days
+}
+
+func balanceHistoryAction(c *cli.Context) error {
+	snapshots, err := readBalances()
+	if err != nil {
+		return fmt.Errorf("failed to read balance history: %w", err)
+	}
+	since := time.Time{}
+	if balanceDays > 0 {
+		since = time.Now().AddDate(0, 0, -balanceDays)
+	}
+	days := dailyBalances(snapshots, since)
+
+	switch balanceOutput {
+	case "json":
+		return json.NewEncoder(stdout).Encode(days)
+	case "csv":
+		w := csv.NewWriter(stdout)
+		w.Write([]string{"date", "balance", "change"})
+		for _, d := range days {
+			w.Write([]string{d.Date, d.Balance.StringFixed(2), d.Change.StringFixed(2)})
+		}
+		w.Flush()
+		return w.Error()
+	case "table":
+		if len(days) == 0 {
+			fmt.Fprintln(stdout, "no balances recorded yet, they are recorded by every sync")
+			return nil
+		}
+		values := make([]decimal.Decimal, len(days))
+		for i, d := range days {
+			values[i] = d.Balance
+		}
+		low, high := decimal.Min(values[0], values...), decimal.Max(values[0], values...)
+		fmt.Fprintf(stdout, "%s to %s  %s  low %s, high %s\n\n", days[0].Date, days[len(days)-1].Date, rangeSparkline(values), low.StringFixed(0), high.StringFixed(0))
+		w := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+		fmt.Fprintf(w, "DATE\tBALANCE\tCHANGE\t\n")
+		for i := len(days) - 1; i >= 0; i-- {
+			d := days[i]
+			change := ""
+			if i > 0 {
+				change = d.Change.StringFixed(2)
+				if d.Change.IsPositive() {
+					change = "+" + change
+				}
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t\n", d.Date, d.Balance.StringFixed(2), change)
+		}
+		return w.Flush()
+	default:
+		return fmt.Errorf("unknown output %q, expected table, csv or json", balanceOutput)
+	}
+}
+
+// rangeSparkline draws values scaled between their lowest and highest, as
+// balances rarely come near zero
+func rangeSparkline(values []decimal.Decimal) string {
+	if len(values) == 0 {
+		return ""
+	}
+	low, high := decimal.Min(values[0], values...), decimal.Max(values[0], values...)
+	if low.Equal(high) {
+		return strings.Repeat(string([]rune(sparks)[3]), len(values))
+	}
+	shifted := make([]decimal.Decimal, len(values))
+	for i, v := range values {
+		shifted[i] = v.Sub(low)
+	}
+	return sparkline(shifted)
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	// accountField is the account select of the klikbca statement form,
+	// the index of the account in the login, escaped or not
+	accountField  = regexp.MustCompile(`(?:^|&)value(?:\(|%28)D1(?:\)|%29)=(\d*)`)
+	accountSelect = regexp.MustCompile(`(?is)<select[^>]*name="value\(D1\)"[^>]*>(.*?)</select>`)
+	accountOption = regexp.MustCompile(`(?is)<option[^>]*value="(\d+)"[^>]*>(.*?)</option>`)
+	tableRow      = regexp.MustCompile(`(?is)<tr[^>]*>.*?</tr>`)
+	accountDigits = regexp.MustCompile(`\d{10}`)
+)
+
+// accountTransport selects --bca-account-number on logins with several
+// linked accounts. bca-go always asks for the first account, so the
+// statement form is posted with the index of the account instead, and the
+// row of the account is moved first in the balance inquiry
+type accountTransport struct {
+	base    http.RoundTripper
+	account string
+
+	mu sync.Mutex
+	// index is the option of the account in the statement form, once read
+	index string
+}
+
+// withAccount selects --bca-account-number in the requests of base
+func withAccount(base http.RoundTripper) http.RoundTripper {
+	if bcaAccountNumber == "" {
+		return base
+	}
+	return &accountTransport{base: base, account: digitsOnly(bcaAccountNumber)}
+}
+
+func (t *accountTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !isBCARequest(req) {
+		return t.base.RoundTrip(req)
+	}
+	path := strings.ToLower(req.URL.Path)
+	switch {
+	case strings.Contains(path, "accountstmt") && req.Method == http.MethodPost && req.Body != nil:
+		return t.statement(req)
+	case strings.Contains(path, "balanceinquiry"):
+		return t.balance(req)
+	default:
+		return t.base.RoundTrip(req)
+	}
+}
+
+// statement posts the statement form for the account
+func (t *accountTransport) statement(req *http.Request) (*http.Response, error) {
+	b, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	// the field is replaced in place, keeping the form as bca-go sent it
+	if loc := accountField.FindSubmatchIndex(b); loc != nil {
+		index, err := t.accountIndex(req)
+		if err != nil {
+			return nil, err
+		}
+		b = append(append(append([]byte(nil), b[:loc[2]]...), index...), b[loc[3]:]...)
+	}
+	req = req.Clone(req.Context())
+	req.Body = ioutil.NopCloser(bytes.NewReader(b))
+	req.ContentLength = int64(len(b))
+	return t.base.RoundTrip(req)
+}
+
+// accountIndex reads the option of the account from the statement form,
+// with the cookies of req
+func (t *accountTransport) accountIndex(req *http.Request) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.index != "" {
+		return t.index, nil
+	}
+
+	u := *req.URL
+	q := u.Query()
+	q.Set("value(actions)", "acct_stmt")
+	u.RawQuery = q.Encode()
+	get, err := http.NewRequestWithContext(req.Context(), http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	get.Header = req.Header.Clone()
+	get.Header.Del("Content-Type")
+	resp, err := t.base.RoundTrip(get)
+	if err != nil {
+		return "", fmt.Errorf("failed to get the klikbca statement form: %w", err)
+	}
+	page, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return "", err
+	}
+
+	index, accounts := findAccountOption(page, t.account)
+	if index == "" {
+		return "", fmt.Errorf("the klikbca login has no account %s, only %s", bcaAccountNumber, strings.Join(accounts, ", "))
+	}
+	t.index = index
+	return index, nil
+}
+
+// findAccountOption returns the option of account in the account select of
+// a statement form page, and the accounts it lists
+func findAccountOption(page []byte, account string) (string, []string) {
+	m := accountSelect.FindSubmatch(page)
+	if m == nil {
+		return "", nil
+	}
+	var accounts []string
+	for _, o := range accountOption.FindAllSubmatch(m[1], -1) {
+		number := digitsOnly(htmlTag.ReplaceAllString(string(o[2]), ""))
+		if number == account {
+			return string(o[1]), nil
+		}
+		accounts = append(accounts, number)
+	}
+	return "", accounts
+}
+
+// balance moves the row of the account first in the balance inquiry, as
+// bca-go reads the first one
+func (t *accountTransport) balance(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+	b, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	b = accountRowFirst(b, t.account)
+	resp.Body = ioutil.NopCloser(bytes.NewReader(b))
+	resp.ContentLength = int64(len(b))
+	resp.Header.Set("Content-Length", strconv.Itoa(len(b)))
+	return resp, nil
+}
+
+// accountRowFirst swaps the first table row of an account in page with the
+// row of account. a page without it is returned as is, for checkBCAAccount
+// to report
+func accountRowFirst(page []byte, account string) []byte {
+	first, own := -1, -1
+	rows := tableRow.FindAllIndex(page, -1)
+	for i, r := range rows {
+		row := page[r[0]:r[1]]
+		number := accountDigits.Find(htmlTag.ReplaceAll(row, nil))
+		switch {
+		// rows of nested tables aren't whole rows
+		case number == nil || bytes.Contains(bytes.ToLower(row), []byte("<table")):
+		case string(number) == account:
+			own = i
+		case first < 0:
+			first = i
+		}
+		if own >= 0 {
+			break
+		}
+	}
+	if own < 0 || first < 0 || first > own {
+		return page
+	}
+	a, b := rows[first], rows[own]
+	var out bytes.Buffer
+	out.Write(page[:a[0]])
+	out.Write(page[b[0]:b[1]])
+	out.Write(page[a[1]:b[0]])
+	out.Write(page[a[0]:a[1]])
+	out.Write(page[b[1]:])
+	return out.Bytes()
+}
+
+func digitsOnly(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r < '0' || r > '9' {
+			return -1
+		}
+		return r
+	}, s)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// roundTripFunc answers requests with a func
+type roundTripFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+func htmlResponse(body string) *http.Response {
+	return &http.Response{StatusCode: http.StatusOK, Header: make(http.Header), Body: ioutil.NopCloser(strings.NewReader(body))}
+}
+
+const statementForm = `<form><select name="value(D1)">
+<option value="0">0123456789</option>
+<option value="1"> 9876543210 </option>
+</select></form>`
+
+func TestAccountTransportSelectsStatementAccount(t *testing.T) {
+	defer func(a string) { bcaAccountNumber = a }(bcaAccountNumber)
+	bcaAccountNumber = "987-654-3210"
+
+	var posted []string
+	base := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		if req.Method == http.MethodGet {
+			return htmlResponse(statementForm), nil
+		}
+		b, _ := ioutil.ReadAll(req.Body)
+		posted = append(posted, string(b))
+		return htmlResponse("statement"), nil
+	})
+	client := &http.Client{Transport: withAccount(base)}
+	for i := 0; i < 2; i++ {
+		resp, err := client.Post("https://ibank.klikbca.com/accountstmt.do?value(actions)=acctstmtview", "application/x-www-form-urlencoded",
+			strings.NewReader("value%28r1%29=1&value%28D1%29=0&value%28startDt%29=01"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	for _, p := range posted {
+		if want := "value%28r1%29=1&value%28D1%29=1&value%28startDt%29=01"; p != want {
+			t.Errorf("posted %s, want %s", p, want)
+		}
+	}
+}
+
+func TestAccountTransportUnknownAccount(t *testing.T) {
+	defer func(a string) { bcaAccountNumber = a }(bcaAccountNumber)
+	bcaAccountNumber = "1111111111"
+
+	base := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return htmlResponse(statementForm), nil
+	})
+	client := &http.Client{Transport: withAccount(base)}
+	_, err := client.Post("https://ibank.klikbca.com/accountstmt.do", "application/x-www-form-urlencoded", strings.NewReader("value(D1)=0"))
+	if err == nil || !strings.Contains(err.Error(), "0123456789, 9876543210") {
+		t.Errorf("post = %v, want the accounts of the login listed", err)
+	}
+}
+
+func TestAccountRowFirst(t *testing.T) {
+	page := `<table><tr><th>Account</th></tr><tr><td>0123456789</td><td>1.000</td></tr><tr><td>9876543210</td><td>2.000</td></tr></table>`
+	want := `<table><tr><th>Account</th></tr><tr><td>9876543210</td><td>2.000</td></tr><tr><td>0123456789</td><td>1.000</td></tr></table>`
+	if got := string(accountRowFirst([]byte(page), "9876543210")); got != want {
+		t.Errorf("accountRowFirst() =\n%s\nwant\n%s", got, want)
+	}
+	if got := string(accountRowFirst([]byte(page), "0123456789")); got != page {
+		t.Errorf("accountRowFirst() of the first account changed the page to\n%s", got)
+	}
+	if got := string(accountRowFirst([]byte(page), "1111111111")); got != page {
+		t.Errorf("accountRowFirst() of another account changed the page to\n%s", got)
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	// bootstrapRounding rounds proposals up to whole thousands of rupiah
+	bootstrapRounding = 1000
+	daysPerMonth      = 30.44
+)
+
+var (
+	bootstrapMonths int
+	bootstrapApply  bool
+)
+
+// budgetProposal is the proposed monthly budget of a ynab category
+type budgetProposal struct {
+	CategoryID string
+	Category   string
+	Spent      decimal.Decimal
+	Monthly    decimal.Decimal
+}
+
+// bootstrapAction proposes a monthly budget per category from the imported
+// transactions of the last --months and optionally budgets it for the
+// current month
+func bootstrapAction(c *cli.Context) error {
+	if bootstrapMonths < 1 {
+		return fmt.Errorf("--months must be at least 1")
+	}
+
+	config, err := getOrDeleteConfig(username, password, token, false, noninteractive, reset, nostore)
+	if err != nil {
+		return err
+	}
+	if config == nil {
+		return nil
+	}
+
+	yc, err := newYNABClient(c.Context, config.YNABToken)
+	if err != nil {
+		return err
+	}
+	a, err := getYNABAccount(yc, budget, accountName)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	ts, err := yc.getTransactions(budget, a.ID, now.AddDate(0, -bootstrapMonths, 0))
+	if err != nil {
+		return fmt.Errorf("failed to get ynab transactions: %w", err)
+	}
+
+	var (
+		byCat    = make(map[string]*budgetProposal)
+		earliest = now
+	)
+	for _, t := range ts {
+		if t.Deleted || !isImported(t) || t.Amount >= 0 || t.CategoryID == nil || t.TransferAccountID != nil {
+			continue
+		}
+		if t.Date.Before(earliest) {
+			earliest = t.Date.Time
+		}
+		p, ok := byCat[*t.CategoryID]
+		if !ok {
+			p = &budgetProposal{CategoryID: *t.CategoryID, Category: *t.CategoryID}
+			if t.CategoryName != nil {
+				p.Category = *t.CategoryName
+			}
+			byCat[*t.CategoryID] = p
+		}
+		p.Spent = p.Spent.Add(decimal.New(-t.Amount, -3))
+	}
+	if len(byCat) == 0 {
+		fmt.Fprintf(stdout, "no categorized transactions in the last %d month(s). categorize some in ynab first\n", bootstrapMonths)
+		return nil
+	}
+
+	// only the covered days count, so a short history isn't averaged over
+	// months without data. anything under a statement is treated as one
+	days := now.Sub(earliest).Hours() / 24
+	if days < maxStatementDays {
+		days = maxStatementDays
+	}
+	months := decimal.NewFromFloat(days / daysPerMonth)
+
+	proposals := make([]*budgetProposal, 0, len(byCat))
+	for _, p := range byCat {
+		p.Monthly = p.Spent.Div(months).Div(decimal.NewFromInt(bootstrapRounding)).Ceil().Mul(decimal.NewFromInt(bootstrapRounding))
+		proposals = append(proposals, p)
+	}
+	sort.Slice(proposals, func(i, j int) bool {
+		return proposals[i].Monthly.GreaterThan(proposals[j].Monthly) ||
+			proposals[i].Monthly.Equal(proposals[j].Monthly) && proposals[i].Category < proposals[j].Category
+	})
+
+	fmt.Fprintf(stdout, "proposed monthly budget from %.0f days of transactions:\n", days)
+	w := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "CATEGORY\tSPENT\tMONTHLY\n")
+	total := decimal.Zero
+	for _, p := range proposals {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", p.Category, p.Spent.StringFixed(0), p.Monthly.StringFixed(0))
+		total = total.Add(p.Monthly)
+	}
+	fmt.Fprintf(w, "TOTAL\t\t%s\n", total.StringFixed(0))
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if !bootstrapApply {
+		fmt.Fprintf(stdout, "use --apply to budget these amounts for %s\n", now.Format("2006-01"))
+		return nil
+	}
+	if !noninteractive {
+		if err := reviewProposals(proposals); err != nil {
+			return err
+		}
+	}
+
+	month := now.Format("2006-01") + "-01"
+	n := 0
+	for _, p := range proposals {
+		if p.Monthly.IsZero() {
+			continue
+		}
+		if err := yc.setBudgeted(budget, month, p.CategoryID, toMilliunits(p.Monthly)); err != nil {
+			return fmt.Errorf("failed to budget %s after %d succeeded: %w", p.Category, n, err)
+		}
+		n++
+	}
+	fmt.Fprintf(stdout, "%d categories were successfully budgeted for %s\n", n, now.Format("2006-01"))
+	return nil
+}
+
+// reviewProposals lets the user accept, change or skip each proposal
+func reviewProposals(proposals []*budgetProposal) error {
+	fmt.Fprintln(stdout, "press enter to accept, type an amount to change it or 0 to skip")
+	r := bufio.NewReader(os.Stdin)
+	for _, p := range proposals {
+		fmt.Fprintf(stdout, "%s [%s]: ", p.Category, p.Monthly.StringFixed(0))
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		amount, err := decimal.NewFromString(line)
+		if err != nil || amount.IsNegative() {
+			return fmt.Errorf("invalid amount %q", line)
+		}
+		p.Monthly = amount
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/satraul/bca-go"
+)
+
+const (
+	fetchCacheFile = "fetch-cache.json"
+	lastFetchFile  = "last-fetch.json"
+)
+
+var (
+	cacheMaxAge, rerunWindow time.Duration
+)
+
+// fetchCache keeps the klikbca entries of a run whose push failed, so that
+// the next run pushes them again without logging in to klikbca. fewer
+// logins mean less risk of klikbca locking the account
+type fetchCache struct {
+	Time    time.Time   `json:"time"`
+	From    time.Time   `json:"from"`
+	To      time.Time   `json:"to"`
+	Balance bca.Balance `json:"balance"`
+	Entries []bca.Entry `json:"entries"`
+	// Destinations are the destinations that failed. the others already
+	// have the entries, and not all of them deduplicate
+	Destinations []string `json:"destinations"`
+}
+
+func readFetchCache() (*fetchCache, error) {
+	b, err := ioutil.ReadFile(filepath.Join(profileFolder().Path, fetchCacheFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var c fetchCache
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func writeFetchCache(c fetchCache) error {
+	b, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(profileFolder().Path, fetchCacheFile), b, 0600)
+}
+
+func clearFetchCache() error {
+	err := os.Remove(filepath.Join(profileFolder().Path, fetchCacheFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// usableFetchCache returns the cache if the next run should push it instead
+// of fetching from klikbca
+func usableFetchCache() *fetchCache {
+	if cacheMaxAge <= 0 {
+		return nil
+	}
+	c, err := readFetchCache()
+	if err != nil {
+		fmt.Fprintf(stdout, "failed to read fetch cache, fetching from klikbca: %v\n", err)
+		return nil
+	}
+	if c == nil || time.Since(c.Time) > cacheMaxAge {
+		return nil
+	}
+	return c
+}
+
+// cacheFailedPush keeps the entries for the destinations that failed, or
+// clears the cache if none did
+func cacheFailedPush(c fetchCache, results []destinationResult) {
+	c.Destinations = nil
+	for _, r := range results {
+		if r.Error != "" {
+			c.Destinations = append(c.Destinations, r.Name)
+		}
+	}
+	if cacheMaxAge <= 0 || len(c.Destinations) == 0 {
+		if err := clearFetchCache(); err != nil {
+			fmt.Fprintf(stdout, "failed to clear fetch cache: %v\n", err)
+		}
+		return
+	}
+	if err := writeFetchCache(c); err != nil {
+		fmt.Fprintf(stdout, "failed to write fetch cache: %v\n", err)
+		return
+	}
+	fmt.Fprintf(stdout, "%d bca transaction(s) cached, the next run pushes them to %v without logging in to klikbca\n", len(c.Entries), c.Destinations)
+}
+
+// onlyDestinations returns the destinations of ds named in names
+func onlyDestinations(ds []destination, names []string) []destination {
+	keep := make(map[string]bool, len(names))
+	for _, n := range names {
+		keep[n] = true
+	}
+	var only []destination
+	for _, d := range ds {
+		if keep[d.name] {
+			only = append(only, d)
+		}
+	}
+	return only
+}
+
+// lastFetch is the content hash of the statement of the last run that
+// pushed everything, to recognize reruns
+type lastFetch struct {
+	Time time.Time `json:"time"`
+	Hash string    `json:"hash"`
+}
+
+// statementHash hashes the balance and entries of a fetch. the statement
+// range isn't part of it, as it moves with the time of the run
+func statementHash(bal bca.Balance, trxs []bca.Entry) (string, error) {
+	b, err := json.Marshal(struct {
+		Balance bca.Balance `json:"balance"`
+		Entries []bca.Entry `json:"entries"`
+	}{bal, trxs})
+	if err != nil {
+		return "", err
+	}
+	return sha256Hex(b), nil
+}
+
+// isRerun tells whether the last successful run fetched the same statement
+// less than --rerun-window ago, like when a flaky cron retries, so that the
+// destinations needn't be called again
+func isRerun(hash string) (*lastFetch, bool) {
+	if rerunWindow <= 0 {
+		return nil, false
+	}
+	b, err := ioutil.ReadFile(filepath.Join(profileFolder().Path, lastFetchFile))
+	if err != nil {
+		return nil, false
+	}
+	var last lastFetch
+	if err := json.Unmarshal(b, &last); err != nil {
+		return nil, false
+	}
+	return &last, last.Hash == hash && time.Since(last.Time) < rerunWindow
+}
+
+// recordFetch keeps the hash of a statement pushed everywhere. failing to
+// do so doesn't fail the run
+func recordFetch(hash string, t time.Time) {
+	b, err := json.Marshal(lastFetch{Time: t, Hash: hash})
+	if err == nil {
+		err = writeFileAtomic(filepath.Join(profileFolder().Path, lastFetchFile), b, 0600)
+	}
+	if err != nil {
+		fmt.Fprintf(stdout, "failed to record fetch: %v\n", err)
+	}
+}
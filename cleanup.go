@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+	"go.bmvs.io/ynab/api/transaction"
+)
+
+const (
+	// importIDPrefix marks import ids generated by the structhash strategy
+	importIDPrefix = "v1_"
+)
+
+var (
+	// memoMarker matches the marker appended by --memo-marker
+	memoMarker = regexp.MustCompile(`\s*\[bca-sync \d{4}-\d{2}-\d{2}\]$`)
+)
+
+var (
+	memoMarkerFlag bool
+	cleanupSince   string
+	cleanupAll     bool
+	cleanupFlag    bool
+)
+
+func cleanupYNABAction(c *cli.Context) error {
+	since, err := time.ParseInLocation(dateLayout, cleanupSince, time.Local)
+	if err != nil {
+		return fmt.Errorf("invalid --since: %w", err)
+	}
+
+	config, err := getOrDeleteConfig(username, password, token, false, noninteractive, reset, nostore)
+	if err != nil {
+		return err
+	}
+	if config == nil {
+		return nil
+	}
+
+	yc, err := newYNABClient(c.Context, config.YNABToken)
+	if err != nil {
+		return err
+	}
+	a, err := getYNABAccount(yc, budget, accountName)
+	if err != nil {
+		return err
+	}
+	ts, err := yc.getTransactions(budget, a.ID, since)
+	if err != nil {
+		return fmt.Errorf("failed to get ynab transactions: %w", err)
+	}
+
+	var targets []*transaction.Transaction
+	for _, t := range ts {
+		if t.Deleted {
+			continue
+		}
+		// transactions entered by hand or by other tools are left alone
+		// unless asked for explicitly
+		if !cleanupAll && !isImported(t) {
+			continue
+		}
+		targets = append(targets, t)
+	}
+
+	verb, action := "delete", "deleted"
+	if cleanupFlag {
+		verb, action = "flag", "flagged"
+	}
+	kind := "imported"
+	if cleanupAll {
+		kind = "imported and other"
+	}
+	if len(targets) > 0 {
+		if err := confirm("%s %d %s ynab transaction(s) of %s since %s", verb, len(targets), kind, accountName, cleanupSince); err != nil {
+			return err
+		}
+	}
+
+	n := 0
+	for _, t := range targets {
+		if cleanupFlag {
+			p := transactionToPayload(t)
+			red := transaction.FlagColorRed
+			p.FlagColor = &red
+			err = yc.updateTransaction(budget, t.ID, p)
+		} else {
+			err = yc.deleteTransaction(budget, t.ID)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to clean up ynab transaction %s after %d succeeded: %w", t.ID, n, err)
+		}
+		n++
+	}
+
+	fmt.Fprintf(stdout, "%d ynab transaction(s) were successfully %s\n", n, action)
+	return nil
+}
+
+// isImported reports whether t was created by this tool
+func isImported(t *transaction.Transaction) bool {
+	return t.ImportID != nil && isImportID(*t.ImportID)
+}
+
+func transactionToPayload(t *transaction.Transaction) transaction.PayloadTransaction {
+	return transaction.PayloadTransaction{
+		AccountID:  t.AccountID,
+		Date:       t.Date,
+		Amount:     t.Amount,
+		Cleared:    t.Cleared,
+		Approved:   t.Approved,
+		PayeeID:    t.PayeeID,
+		PayeeName:  t.PayeeName,
+		CategoryID: t.CategoryID,
+		Memo:       t.Memo,
+		FlagColor:  t.FlagColor,
+		ImportID:   t.ImportID,
+	}
+}
+
+// withMemoMarker appends the provenance marker of --memo-marker to memo
+func withMemoMarker(memo string, now time.Time) string {
+	if !memoMarkerFlag {
+		return memo
+	}
+	return strings.TrimSpace(memo + " [bca-sync " + now.Format("2006-01-02") + "]")
+}
+
+// cleanupMemoMarkersAction strips the markers of --memo-marker from the
+// memos of ynab transactions in a date range, leaving the rest of the memo
+func cleanupMemoMarkersAction(c *cli.Context) error {
+	since, err := time.ParseInLocation(dateLayout, cleanupSince, time.Local)
+	if err != nil {
+		return fmt.Errorf("invalid --since: %w", err)
+	}
+
+	config, err := getOrDeleteConfig(username, password, token, false, noninteractive, reset, nostore)
+	if err != nil {
+		return err
+	}
+	if config == nil {
+		return nil
+	}
+
+	yc, err := newYNABClient(c.Context, config.YNABToken)
+	if err != nil {
+		return err
+	}
+	a, err := getYNABAccount(yc, budget, accountName)
+	if err != nil {
+		return err
+	}
+	ts, err := yc.getTransactions(budget, a.ID, since)
+	if err != nil {
+		return fmt.Errorf("failed to get ynab transactions: %w", err)
+	}
+
+	var targets []*transaction.Transaction
+	for _, t := range ts {
+		if !t.Deleted && t.Memo != nil && memoMarker.MatchString(*t.Memo) {
+			targets = append(targets, t)
+		}
+	}
+	if len(targets) == 0 {
+		fmt.Fprintln(stdout, "no memo markers to strip")
+		return nil
+	}
+	if err := confirm("strip the memo markers of %d ynab transaction(s) of %s since %s", len(targets), accountName, cleanupSince); err != nil {
+		return err
+	}
+
+	n := 0
+	for _, t := range targets {
+		p := transactionToPayload(t)
+		memo := memoMarker.ReplaceAllString(*t.Memo, "")
+		p.Memo = &memo
+		if err := yc.updateTransaction(budget, t.ID, p); err != nil {
+			return fmt.Errorf("failed to strip memo marker of ynab transaction %s after %d succeeded: %w", t.ID, n, err)
+		}
+		n++
+	}
+
+	fmt.Fprintf(stdout, "memo markers of %d ynab transaction(s) were successfully stripped\n", n)
+	return nil
+}
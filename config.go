@@ -4,9 +4,8 @@ import (
 	"bufio"
 	"encoding/json"
 	"fmt"
-	"io/ioutil" // TODO Implement https://godoc.org/github.com/apex/log/handlers/cli
-	"net/http"
 	"os"
+	"path/filepath"
 	"reflect"
 	"syscall"
 
@@ -22,29 +21,59 @@ type config struct {
 }
 
 func getOrDeleteConfig(username string, password string, token string, delete bool, noninteractive bool, reset bool, nostore bool) (*config, error) {
+	if err := migrateLegacyConfig(); err != nil {
+		return nil, err
+	}
+
 	var (
 		config = config{BCAUser: username, BCAPassword: password, YNABToken: token}
-		folder = configDirs.QueryFolderContainsFile("credentials")
+		folder = credentialsFolder()
 	)
 
 	if delete {
 		if folder != nil {
-			if err := os.RemoveAll(folder.Path); err != nil {
+			// only the credentials go, the ledger and other state of the
+			// profile are kept
+			if err := confirm("delete the credentials in %s", folder.Path); err != nil {
+				return nil, err
+			}
+			if err := os.Remove(filepath.Join(folder.Path, "credentials")); err != nil {
 				return nil, errors.Wrap(err, "failed to delete")
 			}
-			fmt.Printf("credentials file in %s has been deleted\n", folder.Path)
+			fmt.Fprintf(stdout, "credentials file in %s has been deleted\n", folder.Path)
 			return nil, nil
 		}
-		fmt.Println("credentials file already inexistant")
+		fmt.Fprintln(stdout, "credentials file already inexistant")
 		return nil, nil
 	}
 
 	if noninteractive || reset || folder == nil {
-		readConfig(noninteractive, nostore, &config)
+		if err := readConfig(noninteractive, nostore, &config); err != nil {
+			return nil, err
+		}
 	} else {
 		data, _ := folder.ReadFile("credentials")
+		if isEncrypted(data) {
+			secret, err := credentialsSecret()
+			if err != nil {
+				return nil, err
+			}
+			if secret == nil {
+				return nil, errEncrypted
+			}
+			if data, err = decrypt(data, secret); err != nil {
+				return nil, err
+			}
+		}
 		json.Unmarshal(data, &config)
 	}
+
+	if err := resolveCredentials(&config); err != nil {
+		return nil, err
+	}
+	registerSecret(config.BCAUser)
+	registerSecret(config.BCAPassword)
+	registerSecret(config.YNABToken)
 	return &config, nil
 }
 
@@ -54,7 +83,7 @@ func readConfig(noninteractive, nostore bool, c *config) error {
 			panic(errEmpty)
 		}
 
-		fmt.Print("Enter KlikBCA Username: ")
+		fmt.Fprint(stdout, "Enter KlikBCA Username: ")
 		byteUser, _, err := bufio.NewReader(os.Stdin).ReadLine()
 		if err != nil {
 			panic(err)
@@ -70,7 +99,7 @@ func readConfig(noninteractive, nostore bool, c *config) error {
 		if noninteractive {
 			panic(errEmpty)
 		}
-		fmt.Print("Enter KlikBCA Password: ")
+		fmt.Fprint(stdout, "Enter KlikBCA Password: ")
 		bytePassword, err := terminal.ReadPassword(int(syscall.Stdin))
 		if err != nil {
 			panic(err)
@@ -81,14 +110,14 @@ func readConfig(noninteractive, nostore bool, c *config) error {
 			panic(errEmpty)
 		}
 
-		fmt.Println()
+		fmt.Fprintln(stdout)
 	}
 
-	if isZero(c.YNABToken) && !(csvFlag || fireflyUrl != "") {
+	if isZero(c.YNABToken) && ynabEnabled() {
 		if noninteractive {
 			panic(errEmpty)
 		}
-		fmt.Print("Enter YNAB Personal Access Token: ")
+		fmt.Fprint(stdout, "Enter YNAB Personal Access Token: ")
 		byteToken, err := terminal.ReadPassword(int(syscall.Stdin))
 		if err != nil {
 			panic(err)
@@ -99,7 +128,7 @@ func readConfig(noninteractive, nostore bool, c *config) error {
 			panic(errEmpty)
 		}
 
-		fmt.Println()
+		fmt.Fprintln(stdout)
 	}
 
 	if noninteractive || nostore {
@@ -107,30 +136,96 @@ func readConfig(noninteractive, nostore bool, c *config) error {
 	}
 
 	// store credentials to user configdir
-	folders := configDirs.QueryFolders(configdir.Global)
+	folder := profileFolder()
 	data, _ := json.Marshal(&c)
-	folders[0].WriteFile("credentials", data)
-	fmt.Printf("saved credentials to %s. use -d to delete or -r to reset anew\n", folders[0].Path)
+	secret, err := credentialsSecret()
+	if err != nil {
+		return err
+	}
+	if secret != nil {
+		if data, err = encrypt(data, secret); err != nil {
+			return errors.Wrap(err, "failed to encrypt credentials")
+		}
+	}
+	if err := writeFileAtomic(filepath.Join(folder.Path, "credentials"), data, 0600); err != nil {
+		return errors.Wrap(err, "failed to save credentials")
+	}
+	fmt.Fprintf(stdout, "saved credentials to %s. use -d to delete or -r to reset anew\n", folder.Path)
 
 	return nil
 }
 
-func isZero(i interface{}) bool {
-	return reflect.ValueOf(i).IsZero()
+// profileDirs are the config dirs of the current profile. credentials and
+// all state are kept per profile so that accounts don't share dedup state
+func profileDirs() configdir.ConfigDir {
+	return configdir.New("satraul", filepath.Join("bca-sync-ynab", "profiles", profile))
 }
 
-// getPublicIP ref: https://gist.github.com/ankanch/8c8ec5aaf374039504946e7e2b2cdf7f
-func getPublicIP() string {
-	url := "https://api.ipify.org?format=text"
+// profileFolder is the writable folder of the current profile, under
+// --config if given
+func profileFolder() *configdir.Config {
+	if configDir != "" {
+		return &configdir.Config{Path: filepath.Join(configDir, "profiles", profile), Type: configdir.Local}
+	}
+	return profileDirs().QueryFolders(configdir.Global)[0]
+}
 
-	resp, err := http.Get(url)
-	if err != nil {
-		panic(err)
+// credentialsFolder is the folder of the current profile holding
+// credentials, or nil if there are none
+func credentialsFolder() *configdir.Config {
+	if configDir != "" {
+		folder := profileFolder()
+		if !folder.Exists("credentials") {
+			return nil
+		}
+		return folder
 	}
-	defer resp.Body.Close()
-	ip, err := ioutil.ReadAll(resp.Body)
+	return profileDirs().QueryFolderContainsFile("credentials")
+}
+
+// validateConfigDir checks that --config isn't a file, like a config file
+// of another tool
+func validateConfigDir() error {
+	if configDir == "" {
+		return nil
+	}
+	fi, err := os.Stat(configDir)
+	if err == nil && !fi.IsDir() {
+		return fmt.Errorf("--config %s is not a directory", configDir)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// migrateLegacyConfig moves credentials stored before profiles existed to
+// the default profile
+func migrateLegacyConfig() error {
+	// credentials in an explicit --config never came from older versions
+	if profile != defaultProfile || configDir != "" {
+		return nil
+	}
+	legacy := configDirs.QueryFolderContainsFile("credentials")
+	if legacy == nil || credentialsFolder() != nil {
+		return nil
+	}
+
+	data, err := legacy.ReadFile("credentials")
 	if err != nil {
-		panic(err)
+		return errors.Wrap(err, "failed to read legacy credentials")
+	}
+	folder := profileFolder()
+	if err := writeFileAtomic(filepath.Join(folder.Path, "credentials"), data, 0600); err != nil {
+		return errors.Wrap(err, "failed to migrate legacy credentials")
+	}
+	if err := os.Remove(filepath.Join(legacy.Path, "credentials")); err != nil {
+		return errors.Wrap(err, "failed to remove legacy credentials")
 	}
-	return string(ip)
+	fmt.Fprintf(stdout, "migrated credentials to profile %q in %s\n", profile, folder.Path)
+	return nil
+}
+
+func isZero(i interface{}) bool {
+	return reflect.ValueOf(i).IsZero()
 }
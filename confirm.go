@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	assumeYes bool
+)
+
+var errNotConfirmed = errors.New("aborted")
+
+// confirm asks before a destructive action. --yes skips the question, and
+// without it a non-interactive run refuses rather than assuming yes
+func confirm(format string, a ...interface{}) error {
+	if assumeYes {
+		return nil
+	}
+	question := fmt.Sprintf(format, a...)
+	if noninteractive {
+		return fmt.Errorf("%s: use --yes to confirm in non-interactive mode", question)
+	}
+
+	fmt.Fprintf(stdout, "%s [y/N]: ", question)
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return errors.Wrap(err, "failed to read confirmation")
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return nil
+	default:
+		return errNotConfirmed
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// credentialProvider resolves a secret reference like op://vault/item/field
+// to its value at runtime, so that secrets don't have to be stored
+type credentialProvider interface {
+	resolve(ref string) (string, error)
+}
+
+// credentialProviders by reference scheme
+var credentialProviders = map[string]credentialProvider{
+	"op://":    onePassword{},
+	"bw://":    bitwarden{},
+	"vault://": &vault{},
+}
+
+// resolveCredentials replaces secret references in c with their values
+func resolveCredentials(c *config) error {
+	for _, field := range []*string{&c.BCAUser, &c.BCAPassword, &c.YNABToken} {
+		for scheme, p := range credentialProviders {
+			if !strings.HasPrefix(*field, scheme) {
+				continue
+			}
+			v, err := p.resolve(*field)
+			if err != nil {
+				return fmt.Errorf("failed to resolve %s: %w", *field, err)
+			}
+			*field = v
+		}
+	}
+	return nil
+}
+
+// onePassword resolves op://vault/item/field with the 1password cli
+type onePassword struct{}
+
+func (onePassword) resolve(ref string) (string, error) {
+	return runCredentialCommand("op", "read", "--no-newline", ref)
+}
+
+// bitwarden resolves bw://item/field with the bitwarden cli. field is one of
+// username, password, totp or notes. the vault has to be unlocked with
+// BW_SESSION set
+type bitwarden struct{}
+
+func (bitwarden) resolve(ref string) (string, error) {
+	parts := strings.Split(strings.TrimPrefix(ref, "bw://"), "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", errors.New("expected bw://item/field")
+	}
+	return runCredentialCommand("bw", "get", parts[1], parts[0])
+}
+
+func runCredentialCommand(name string, args ...string) (string, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command(name, args...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("%s: %w: %s", name, err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimRight(string(out), "\r\n"), nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"syscall"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+var (
+	errEncrypted = errors.New("credentials are encrypted. use --credentials-key or --credentials-passphrase")
+)
+
+// encryptedFile is the on-disk format of an encrypted file. the key is
+// derived from the secret with scrypt and the data sealed with aes-256-gcm
+type encryptedFile struct {
+	Version    int    `json:"version"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// credentialsSecret returns the secret from --credentials-key or
+// --credentials-passphrase, or nil if neither is used
+func credentialsSecret() ([]byte, error) {
+	switch {
+	case credentialsKey != "":
+		key, err := ioutil.ReadFile(credentialsKey)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read credentials key")
+		}
+		key = bytes.TrimSpace(key)
+		if len(key) == 0 {
+			return nil, errors.New("credentials key file is empty")
+		}
+		return key, nil
+	case credentialsPassphrase:
+		if noninteractive {
+			return nil, errEmptyNonInteractive
+		}
+		fmt.Fprint(stdout, "Enter Credentials Passphrase: ")
+		passphrase, err := terminal.ReadPassword(int(syscall.Stdin))
+		fmt.Fprintln(stdout)
+		if err != nil {
+			return nil, err
+		}
+		if len(passphrase) == 0 {
+			return nil, errEmpty
+		}
+		return passphrase, nil
+	default:
+		return nil, nil
+	}
+}
+
+func isEncrypted(data []byte) bool {
+	var f encryptedFile
+	return json.Unmarshal(data, &f) == nil && len(f.Ciphertext) > 0
+}
+
+func deriveKey(secret, salt []byte) ([]byte, error) {
+	return scrypt.Key(secret, salt, 1<<15, 8, 1, 32)
+}
+
+func encrypt(data, secret []byte) ([]byte, error) {
+	f := encryptedFile{Version: 1, Salt: make([]byte, 16)}
+	if _, err := io.ReadFull(rand.Reader, f.Salt); err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(secret, f.Salt)
+	if err != nil {
+		return nil, err
+	}
+	f.Nonce = make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, f.Nonce); err != nil {
+		return nil, err
+	}
+	f.Ciphertext = gcm.Seal(nil, f.Nonce, data, nil)
+	return json.Marshal(f)
+}
+
+func decrypt(data, secret []byte) ([]byte, error) {
+	var f encryptedFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, err
+	}
+	if f.Version != 1 {
+		return nil, fmt.Errorf("unsupported encrypted file version %d", f.Version)
+	}
+	gcm, err := newGCM(secret, f.Salt)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := gcm.Open(nil, f.Nonce, f.Ciphertext, nil)
+	if err != nil {
+		return nil, errors.New("failed to decrypt credentials. wrong key or passphrase?")
+	}
+	return plain, nil
+}
+
+func newGCM(secret, salt []byte) (cipher.AEAD, error) {
+	key, err := deriveKey(secret, salt)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
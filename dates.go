@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	// defaultDateLayouts are tried in order. layouts without a year take it
+	// from the end of the statement period
+	defaultDateLayouts    = []string{"02/01", "02/01/2006", "2 Jan 2006", "2 January 2006", "2006-01-02"}
+	defaultPendingMarkers = []string{"PEND"}
+
+	dateLayouts, pendingMarkers []string
+
+	// indonesianMonths maps indonesian month names to english ones, longest first
+	indonesianMonths = strings.NewReplacer(
+		"Januari", "January", "Februari", "February", "Maret", "March", "Mei", "May",
+		"Juni", "June", "Juli", "July", "Agustus", "August", "Oktober", "October",
+		"Desember", "December", "Agt", "Aug", "Agu", "Aug", "Okt", "Oct", "Des", "Dec",
+	)
+)
+
+// statementDates parses the dates of bca statements. it is configured with
+// --date-layout and --pending-marker so that format changes can be handled
+// without a new release
+type statementDates struct {
+	layouts []string
+	pending []string
+}
+
+func newStatementDates() statementDates {
+	d := statementDates{layouts: dateLayouts, pending: pendingMarkers}
+	if len(d.layouts) == 0 {
+		d.layouts = defaultDateLayouts
+	}
+	if len(d.pending) == 0 {
+		d.pending = defaultPendingMarkers
+	}
+	return d
+}
+
+// isPending reports whether s marks a pending transaction without a date
+func (d statementDates) isPending(s string) bool {
+	for _, p := range d.pending {
+		if strings.EqualFold(strings.TrimSpace(s), p) {
+			return true
+		}
+	}
+	return false
+}
+
+// parse parses s with the first matching layout. end is the end of the
+// statement period and is used for layouts without a year
+func (d statementDates) parse(s string, end time.Time) (time.Time, error) {
+	s = indonesianMonths.Replace(strings.TrimSpace(s))
+	for _, layout := range d.layouts {
+		t, err := time.ParseInLocation(layout, s, time.Local)
+		if err != nil {
+			continue
+		}
+		if strings.Contains(layout, "2006") {
+			return t, nil
+		}
+		if end.IsZero() {
+			return time.Time{}, fmt.Errorf("date %q has no year and the statement period is unknown", s)
+		}
+		t = time.Date(end.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local)
+		// statements spanning new year
+		if t.After(end) {
+			t = t.AddDate(-1, 0, 0)
+		}
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("date %q matches none of the date layouts", s)
+}
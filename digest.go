@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+const (
+	digestTopPayees = 5
+)
+
+var (
+	digestDay  string
+	digestTime string
+)
+
+// nextDigest returns the next occurrence of --digest-day at --digest-time after now
+func nextDigest(now time.Time) (time.Time, error) {
+	var weekday time.Weekday = -1
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		if strings.EqualFold(d.String(), digestDay) {
+			weekday = d
+		}
+	}
+	if weekday < 0 {
+		return time.Time{}, fmt.Errorf("invalid --digest-day %q", digestDay)
+	}
+	at, err := time.Parse("15:04", digestTime)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid --digest-time: %w", err)
+	}
+
+	next := time.Date(now.Year(), now.Month(), now.Day(), at.Hour(), at.Minute(), 0, 0, now.Location())
+	next = next.AddDate(0, 0, int(weekday-next.Weekday()+7)%7)
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 7)
+	}
+	return next, nil
+}
+
+// runDigests sends the weekly digest until ctx is done
+func (s *server) runDigests(ctx context.Context) {
+	for {
+		next, err := nextDigest(time.Now())
+		if err != nil {
+			fmt.Fprintln(stdout, err)
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Until(next)):
+		}
+
+		n, err := s.digest(time.Now())
+		if err != nil {
+			fmt.Fprintf(stdout, "failed to build weekly digest: %v\n", err)
+			continue
+		}
+		notifyAll(ctx, n)
+	}
+}
+
+// digest summarizes the week before now from the ledger and the runs of the server
+func (s *server) digest(now time.Time) (notification, error) {
+	entries, err := readLedger()
+	if err != nil {
+		return notification{}, err
+	}
+
+	var (
+		since     = now.AddDate(0, 0, -7)
+		in, out   = decimal.Zero, decimal.Zero
+		payees    = make(map[string]decimal.Decimal)
+		b         strings.Builder
+		runs, bad int
+		balances  []decimal.Decimal
+	)
+	for _, e := range entries {
+		if e.Date.Before(since) || e.Date.After(now) {
+			continue
+		}
+		if e.Amount.IsNegative() {
+			out = out.Add(e.Amount.Neg())
+			payees[e.Payee] = payees[e.Payee].Add(e.Amount.Neg())
+		} else {
+			in = in.Add(e.Amount)
+		}
+	}
+
+	fmt.Fprintf(&b, "%s to %s\n", since.Format(dateLayout), now.Format(dateLayout))
+	fmt.Fprintf(&b, "in: %s\nout: %s\n", in.StringFixed(0), out.StringFixed(0))
+
+	names := make([]string, 0, len(payees))
+	for p := range payees {
+		names = append(names, p)
+	}
+	sort.Slice(names, func(i, j int) bool { return payees[names[i]].GreaterThan(payees[names[j]]) })
+	if len(names) > digestTopPayees {
+		names = names[:digestTopPayees]
+	}
+	if len(names) > 0 {
+		b.WriteString("top payees:\n")
+		for _, p := range names {
+			fmt.Fprintf(&b, "  %s: %s\n", obfuscation().payee(p), payees[p].StringFixed(0))
+		}
+	}
+
+	for _, r := range s.recentRuns(since) {
+		runs++
+		if r.Error != "" {
+			bad++
+			continue
+		}
+		balances = append(balances, r.Balance)
+	}
+	if len(balances) > 0 {
+		fmt.Fprintf(&b, "balance: %s -> %s\n", balances[0].StringFixed(0), balances[len(balances)-1].StringFixed(0))
+	}
+	fmt.Fprintf(&b, "syncs: %d, failed: %d\n", runs, bad)
+
+	return notification{
+		Title:   "bca-sync-ynab weekly digest",
+		Message: b.String(),
+	}, nil
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	// maxClockSkew is how far the local clock may be off. statement ranges
+	// and dates are computed from it
+	maxClockSkew = 5 * time.Minute
+	// wibOffset is the offset of western indonesian time, the time zone of
+	// klikbca's dates
+	wibOffset = 7 * 60 * 60
+)
+
+var (
+	doctorSkipBCA bool
+)
+
+// doctorCheck is a check of the setup. run returns what was found, or why
+// the check failed
+type doctorCheck struct {
+	name string
+	run  func(ctx context.Context) (string, error)
+}
+
+// doctorAction runs the checks of the setup one after another and prints a
+// pass/fail report, continuing past failures so everything wrong is shown
+// at once
+func doctorAction(c *cli.Context) error {
+	config, err := getOrDeleteConfig(username, password, token, false, noninteractive, reset, nostore)
+	if err != nil {
+		return err
+	}
+	if config == nil {
+		return nil
+	}
+
+	unlock, err := lockProfile()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	checks, done := doctorChecks(config)
+	defer done()
+	failed := 0
+	for _, check := range checks {
+		found, err := check.run(c.Context)
+		if err != nil {
+			failed++
+			fmt.Fprintf(stdout, "%s %s: %v\n", colorize(colorRed, "fail"), check.name, ynabHint(err))
+			continue
+		}
+		fmt.Fprintf(stdout, "%s %s: %s\n", colorize(colorGreen, "ok  "), check.name, found)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d check(s) failed", failed)
+	}
+	return nil
+}
+
+// doctorChecks returns the checks for the configured destinations, and a
+// func logging out of klikbca once they ran
+func doctorChecks(config *config) ([]doctorCheck, func()) {
+	checks := configChecks()
+	klikbca, done := bcaChecks(config)
+	checks = append(checks, klikbca...)
+	checks = append(checks, ynabChecks(config)...)
+	if fireflyUrl != "" {
+		checks = append(checks, doctorCheck{"firefly account " + accountName, func(ctx context.Context) (string, error) {
+			ff, auth := newFireflyClient(ctx)
+			a, err := getFireflyAccount(ff, auth)
+			if createAccount && errors.Is(err, errFireflyAccountNotFound) {
+				return "missing, created by the next sync with --create-account", nil
+			}
+			if err != nil {
+				return "", fmt.Errorf("%s: %w", fireflyUrl, err)
+			}
+			return a.Id + " at " + fireflyUrl, nil
+		}})
+	}
+	return checks, done
+}
+
+// configChecks check that the config files parse, with unknown fields
+// reported as they are likely typos, and the clock
+func configChecks() []doctorCheck {
+	checks := []doctorCheck{
+		{"preferences", func(ctx context.Context) (string, error) {
+			return checkConfigFile(preferencesFilePath(), &preferences{})
+		}},
+		{"template", func(ctx context.Context) (string, error) {
+			return checkConfigFile(filepath.Join(profileFolder().Path, templateFile), &template{})
+		}},
+		{"pipelines", func(ctx context.Context) (string, error) {
+			found, err := checkConfigFile(pipelinesFilePath(), &pipelinesConfig{})
+			if err != nil || found == "none" {
+				return found, err
+			}
+			ps, err := readPipelines()
+			return fmt.Sprintf("%d pipeline(s) in %s", len(ps), pipelinesFilePath()), err
+		}},
+		{"type map", func(ctx context.Context) (string, error) {
+			path := typeMapPath
+			if path == "" {
+				path = filepath.Join(profileFolder().Path, typeMapFile)
+			}
+			return checkConfigFile(path, &typeMapping{})
+		}},
+		{"clock", checkClock},
+	}
+	return checks
+}
+
+// checkConfigFile decodes the json file at path into v strictly
+func checkConfigFile(path string, v interface{}) (string, error) {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return "none", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return "", fmt.Errorf("%s: %w", path, err)
+	}
+	return path, nil
+}
+
+// checkClock compares the local clock with the date of a ynab response
+func checkClock(ctx context.Context) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, ynabBaseURL, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	server, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return "", fmt.Errorf("no date in the response of %s", ynabBaseURL)
+	}
+	skew := time.Since(server).Round(time.Second)
+	if skew > maxClockSkew || skew < -maxClockSkew {
+		return "", fmt.Errorf("the local clock is off by %s, synchronize it", skew)
+	}
+
+	zone, offset := time.Now().Zone()
+	found := fmt.Sprintf("off by %s, time zone %s", skew, zone)
+	if offset != wibOffset {
+		found += ". klikbca dates are in WIB and read as dates of this time zone"
+	}
+	return found, nil
+}
+
+// bcaChecks log in to klikbca and fetch the balance and statement with the
+// stored credentials
+func bcaChecks(config *config) ([]doctorCheck, func()) {
+	if doctorSkipBCA {
+		return nil, func() {}
+	}
+	var s *bcaSession
+	done := func() {
+		if s == nil {
+			return
+		}
+		if err := s.logout(); err != nil {
+			fmt.Fprintf(stdout, "failed to logout: %v\n", err)
+		}
+	}
+	skipped := fmt.Errorf("skipped, the klikbca login failed")
+	return []doctorCheck{
+		{"klikbca login", func(ctx context.Context) (string, error) {
+			var err error
+			if s, err = newBCASession(ctx, config); err != nil {
+				s = nil
+				return "", err
+			}
+			return "logged in as " + config.BCAUser, nil
+		}},
+		{"klikbca balance", func(ctx context.Context) (string, error) {
+			if s == nil {
+				return "", skipped
+			}
+			bal, err := s.balance(ctx)
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("account %s, balance %s", bal.AccountNumber, bal.Balance.StringFixed(2)), nil
+		}},
+		{"klikbca statement", func(ctx context.Context) (string, error) {
+			if s == nil {
+				return "", skipped
+			}
+			trxs, err := getBCATransactions(ctx, s)
+			if err != nil {
+				return "", err
+			}
+			start, end, err := statementRange()
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("%d transaction(s) parsed from %s to %s", len(trxs), start.Format(dateLayout), end.Format(dateLayout)), nil
+		}},
+	}, done
+}
+
+// ynabChecks check the token and that the accounts and categories used can
+// be found
+func ynabChecks(config *config) []doctorCheck {
+	var (
+		checks []doctorCheck
+		yc     ynabClient
+	)
+	if !ynabEnabled() {
+		return checks
+	}
+	checks = append(checks, doctorCheck{"ynab token", func(ctx context.Context) (string, error) {
+		var data struct {
+			User struct {
+				ID string `json:"id"`
+			} `json:"user"`
+		}
+		if err := newYNABAPI(ctx, config.YNABToken).do(http.MethodGet, "/user", nil, &data); err != nil {
+			return "", err
+		}
+		var err error
+		yc, err = newYNABClient(ctx, config.YNABToken)
+		return "valid for user " + data.User.ID, err
+	}})
+
+	targets := append([]ynabTarget{{Budget: budget, Account: accountName}}, ynabTargets...)
+	for _, t := range targets {
+		t := t
+		checks = append(checks, doctorCheck{"ynab account " + t.String(), func(ctx context.Context) (string, error) {
+			if yc == nil {
+				return "", fmt.Errorf("skipped, the ynab token is invalid")
+			}
+			a, err := getYNABAccount(yc, t.Budget, t.Account)
+			if createAccount && errors.Is(err, errAccountNotFound) {
+				return "missing, created by the next sync with --create-account", nil
+			}
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("%s, balance %s", a.ID, milliunitsToDecimal(a.Balance).StringFixed(2)), nil
+		}})
+	}
+	if adjustCategory != "" && !noadjust {
+		checks = append(checks, doctorCheck{"ynab adjustment category", func(ctx context.Context) (string, error) {
+			if yc == nil {
+				return "", fmt.Errorf("skipped, the ynab token is invalid")
+			}
+			cs, err := yc.getCategories(budget)
+			if err != nil {
+				return "", err
+			}
+			for _, group := range cs {
+				for _, c := range group.Categories {
+					if c.Name == adjustCategory {
+						return c.ID, nil
+					}
+				}
+			}
+			return "", fmt.Errorf("no category %s in budget %s, set --adjust-category", adjustCategory, budget)
+		}})
+	}
+	for _, name := range transferAccounts {
+		name := name
+		checks = append(checks, doctorCheck{"ynab transfer account " + name, func(ctx context.Context) (string, error) {
+			if yc == nil {
+				return "", fmt.Errorf("skipped, the ynab token is invalid")
+			}
+			a, err := getYNABAccount(yc, budget, name)
+			if err != nil {
+				return "", err
+			}
+			return a.ID, nil
+		}})
+	}
+	return checks
+}
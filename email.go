@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	"mime/multipart"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+var (
+	emailTo      string
+	emailFrom    string
+	emailWhen    string
+	smtpHost     string
+	smtpPort     int
+	smtpUsername string
+	smtpPassword string
+)
+
+var emailTemplate = htmltemplate.Must(htmltemplate.New("email").Parse(`<html><body style="font-family: sans-serif">
+<h2>{{.Title}}</h2>
+<p>{{.From}} to {{.To}} &middot; balance {{.Balance}}</p>
+{{if .Error}}<p style="color: #b00020"><b>failed:</b> {{.Error}}</p>{{end}}
+<table cellpadding="4" style="border-collapse: collapse">
+<tr><th align="left">destination</th><th align="right">created</th><th align="right">duplicates</th><th align="left">adjustment</th><th align="left">error</th></tr>
+{{range .Destinations}}<tr><td>{{.Name}}</td><td align="right">{{.Created}}</td><td align="right">{{.Duplicates}}</td><td>{{.Adjustment}}</td><td style="color: #b00020">{{.Error}}</td></tr>
+{{end}}</table>
+{{if .Transactions}}<h3>new transactions</h3>
+<table cellpadding="4" style="border-collapse: collapse">
+<tr><th align="left">date</th><th align="left">payee</th><th align="right">amount</th></tr>
+{{range .Transactions}}<tr><td>{{.Date}}</td><td>{{.Payee}}</td><td align="right">{{.Amount}}</td></tr>
+{{end}}</table>{{end}}
+<p style="color: #888">run {{.ID}}</p>
+</body></html>
+`))
+
+// emailDigest is what the email of a run shows, already redacted
+type emailDigest struct {
+	Title, ID, From, To, Balance, Error string
+	Destinations                        []emailDestination
+	Transactions                        []emailTransaction
+}
+
+type emailDestination struct {
+	Name, Adjustment, Error string
+	Created, Duplicates     int
+}
+
+type emailTransaction struct {
+	Date, Payee, Amount string
+}
+
+// shouldReport reports whether a run should be reported when, which is
+// always, change or failure
+func shouldReport(when string, s *runSummary) bool {
+	failed := s.Error != ""
+	for _, d := range s.Destinations {
+		failed = failed || d.Error != "" || d.AdjustmentError != ""
+	}
+	switch when {
+	case "failure":
+		return failed
+	case "change":
+		return failed || s.Created > 0 || s.Adjusted
+	default:
+		return true
+	}
+}
+
+func toEmailDigest(s *runSummary) emailDigest {
+	o := obfuscation()
+	d := emailDigest{
+		Title:   "bca-sync-ynab: " + runStatus(s),
+		ID:      s.ID,
+		From:    s.From.Format(dateLayout),
+		To:      s.To.Format(dateLayout),
+		Balance: s.Balance.StringFixed(2),
+		Error:   redact(o.text(s.Error)),
+	}
+	// the same transaction pushed to several destinations is listed once
+	seen := make(map[string]bool)
+	for _, r := range s.Destinations {
+		adjustment := "none"
+		switch {
+		case r.AdjustmentError != "":
+			adjustment = "failed"
+		case r.Adjusted:
+			adjustment = "created"
+		}
+		d.Destinations = append(d.Destinations, emailDestination{
+			Name:       r.Name,
+			Created:    r.Created,
+			Duplicates: r.Duplicates,
+			Adjustment: adjustment,
+			Error:      redact(o.text(strings.TrimSpace(r.Error + " " + r.AdjustmentError))),
+		})
+		for _, t := range r.Transactions {
+			if seen[t.ImportID] {
+				continue
+			}
+			seen[t.ImportID] = true
+			d.Transactions = append(d.Transactions, emailTransaction{
+				Date:   t.Date.Format(dateLayout),
+				Payee:  redact(o.payee(t.Payee)),
+				Amount: t.Amount.StringFixed(2),
+			})
+		}
+	}
+	return d
+}
+
+func runStatus(s *runSummary) string {
+	switch {
+	case s.Error != "":
+		return "sync failed"
+	case s.Created == 0:
+		return "nothing new"
+	default:
+		return fmt.Sprintf("%d new transaction(s)", s.Created)
+	}
+}
+
+// text is the plain text alternative of the html email
+func (d emailDigest) text() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\n%s to %s, balance %s\n", d.Title, d.From, d.To, d.Balance)
+	if d.Error != "" {
+		fmt.Fprintf(&b, "failed: %s\n", d.Error)
+	}
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "\nDESTINATION\tCREATED\tDUPLICATES\tADJUSTMENT\tERROR\n")
+	for _, r := range d.Destinations {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%s\n", r.Name, r.Created, r.Duplicates, r.Adjustment, r.Error)
+	}
+	if len(d.Transactions) > 0 {
+		fmt.Fprintf(w, "\nDATE\tPAYEE\tAMOUNT\n")
+		for _, t := range d.Transactions {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", t.Date, t.Payee, t.Amount)
+		}
+	}
+	w.Flush()
+	fmt.Fprintf(&b, "\nrun %s\n", d.ID)
+	return b.String()
+}
+
+// message builds the multipart email with the text and html parts
+func (d emailDigest) message(from string, to []string) ([]byte, error) {
+	var (
+		body bytes.Buffer
+		mw   = multipart.NewWriter(&body)
+	)
+	for _, part := range []struct {
+		contentType string
+		render      func(*bytes.Buffer) error
+	}{
+		{"text/plain", func(b *bytes.Buffer) error { _, err := b.WriteString(d.text()); return err }},
+		{"text/html", func(b *bytes.Buffer) error { return emailTemplate.Execute(b, d) }},
+	} {
+		w, err := mw.CreatePart(textproto.MIMEHeader{"Content-Type": {part.contentType + "; charset=utf-8"}})
+		if err != nil {
+			return nil, err
+		}
+		var b bytes.Buffer
+		if err := part.render(&b); err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(b.Bytes()); err != nil {
+			return nil, err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", from)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", d.Title)
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&msg, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", mw.Boundary())
+	msg.Write(body.Bytes())
+	return msg.Bytes(), nil
+}
+
+// emailRun sends the digest of a run to --email. failing doesn't fail the run
+func emailRun(s *runSummary) {
+	if emailTo == "" || !shouldReport(emailWhen, s) {
+		return
+	}
+	if err := sendEmail(toEmailDigest(s)); err != nil {
+		fmt.Fprintf(stdout, "failed to send email: %v\n", err)
+	}
+}
+
+func sendEmail(d emailDigest) error {
+	var to []string
+	for _, addr := range strings.Split(emailTo, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			to = append(to, addr)
+		}
+	}
+	from := emailFrom
+	if from == "" {
+		from = smtpUsername
+	}
+	msg, err := d.message(from, to)
+	if err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if smtpUsername != "" {
+		auth = smtp.PlainAuth("", smtpUsername, smtpPassword, smtpHost)
+	}
+	// smtp.SendMail uses starttls when the server offers it
+	return smtp.SendMail(net.JoinHostPort(smtpHost, strconv.Itoa(smtpPort)), auth, from, to, msg)
+}
+
+func validateReportWhen(flag, when string) error {
+	switch when {
+	case "always", "change", "failure":
+		return nil
+	default:
+		return fmt.Errorf("invalid --%s %q, expected always, change or failure", flag, when)
+	}
+}
+
+func validateEmail() error {
+	if emailTo == "" {
+		return nil
+	}
+	if err := validateReportWhen("email-when", emailWhen); err != nil {
+		return err
+	}
+	if smtpHost == "" {
+		return fmt.Errorf("--smtp-host is required with --email")
+	}
+	if emailFrom == "" && smtpUsername == "" {
+		return fmt.Errorf("--email-from or --smtp-username is required with --email")
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/satraul/bca-go"
+)
+
+const (
+	channelMBCA    = "m-BCA"
+	channelKlikBCA = "KlikBCA"
+	channelATM     = "ATM"
+	channelQRIS    = "QRIS"
+)
+
+var (
+	// bcaReference matches transfer references like 0101/FTSCY/WS95051,
+	// where the last segment is the terminal the transfer was made from
+	bcaReference = regexp.MustCompile(`\b\d{4}/[A-Z]{5}/([A-Z]{2}\d{5})\b`)
+
+	// counterpartyNoise matches the parts of a description that aren't the
+	// counterparty: transaction kinds, dates, amounts and bank codes
+	counterpartyNoise = regexp.MustCompile(`(?i)\b(TRSF E-BANKING|SWITCHING|BI-FAST|TRANSAKSI DEBIT|KARTU DEBIT|TARIKAN ATM|TRANSFER|BIF|KE|DARI|TGL:?|DB|CR|DR|QRIS|QR)\b|\b\d+(\.\d{2})?\b|[/:]`)
+)
+
+// mBCATerminals and klikBCATerminals are the terminals in transfer
+// references of transfers made with m-BCA and KlikBCA
+var (
+	mBCATerminals    = map[string]bool{"WS95051": true}
+	klikBCATerminals = map[string]bool{"WS95031": true}
+)
+
+// enrichment is what can be read from the structured segments of a bca
+// description
+type enrichment struct {
+	Counterparty string `json:"counterparty,omitempty"`
+	Reference    string `json:"reference,omitempty"`
+	Channel      string `json:"channel,omitempty"`
+}
+
+// enrich parses the counterparty, transfer reference and channel out of
+// trx. fields that can't be read are left empty
+func enrich(trx bca.Entry) enrichment {
+	var (
+		e    enrichment
+		text = strings.ToUpper(trx.Description + " " + trx.Payee)
+	)
+
+	terminal := ""
+	if m := bcaReference.FindStringSubmatch(text); m != nil {
+		e.Reference = m[0]
+		terminal = m[1]
+	}
+
+	switch {
+	case strings.Contains(text, "QRIS") || strings.Contains(text, " QR "):
+		e.Channel = channelQRIS
+	case strings.Contains(text, "TARIKAN ATM") || strings.Contains(text, "SETORAN ATM"):
+		e.Channel = channelATM
+	case mBCATerminals[terminal] || strings.Contains(text, "M-BCA"):
+		e.Channel = channelMBCA
+	case klikBCATerminals[terminal] || strings.Contains(text, "E-BANKING"):
+		e.Channel = channelKlikBCA
+	}
+
+	name := trx.Payee
+	if i := strings.Index(strings.ToUpper(name), e.Reference); e.Reference != "" && i >= 0 {
+		name = name[:i] + name[i+len(e.Reference):]
+	}
+	name = counterpartyNoise.ReplaceAllString(name, " ")
+	e.Counterparty = strings.Join(strings.Fields(name), " ")
+	return e
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/satraul/bca-go"
+)
+
+func TestEnrich(t *testing.T) {
+	tests := []struct {
+		name        string
+		description string
+		payee       string
+		want        enrichment
+	}{
+		{
+			"m-bca transfer out",
+			"TRSF E-BANKING DB 0205/FTSCY/WS95051 50000.00 JOHN DOE",
+			"JOHN DOE",
+			enrichment{Counterparty: "JOHN DOE", Reference: "0205/FTSCY/WS95051", Channel: channelMBCA},
+		},
+		{
+			"klikbca transfer in",
+			"TRSF E-BANKING CR 0305/FTSCY/WS95031 7500000.00 PT EMPLOYER",
+			"PT EMPLOYER",
+			enrichment{Counterparty: "PT EMPLOYER", Reference: "0305/FTSCY/WS95031", Channel: channelKlikBCA},
+		},
+		{
+			"reference in the payee",
+			"TRSF E-BANKING DB 1206/FTSCY/WS95051 25000.00",
+			"1206/FTSCY/WS95051 JANE DOE",
+			enrichment{Counterparty: "JANE DOE", Reference: "1206/FTSCY/WS95051", Channel: channelMBCA},
+		},
+		{
+			"e-banking from an unknown terminal",
+			"TRSF E-BANKING DB 0205/FTFVA/WS95221 15000.00 TOKOPEDIA",
+			"TOKOPEDIA",
+			enrichment{Counterparty: "TOKOPEDIA", Reference: "0205/FTFVA/WS95221", Channel: channelKlikBCA},
+		},
+		{
+			"qris purchase",
+			"QRIS 0405 KOPI KENANGAN",
+			"KOPI KENANGAN",
+			enrichment{Counterparty: "KOPI KENANGAN", Channel: channelQRIS},
+		},
+		{
+			"atm withdrawal",
+			"TARIKAN ATM 02/05",
+			"TARIKAN ATM",
+			enrichment{Channel: channelATM},
+		},
+		{
+			"debit card purchase",
+			"KARTU DEBIT KOPI KENANGAN",
+			"KOPI KENANGAN",
+			enrichment{Counterparty: "KOPI KENANGAN"},
+		},
+		{
+			"bi-fast transfer",
+			"BI-FAST DB BIF TRANSFER KE 014 JOHN DOE",
+			"JOHN DOE",
+			enrichment{Counterparty: "JOHN DOE"},
+		},
+		{
+			"interest",
+			"BUNGA",
+			"BUNGA",
+			enrichment{Counterparty: "BUNGA"},
+		},
+		{
+			"lower-case payee",
+			"TRSF E-BANKING CR 0305/ftscy/ws95031 100000.00 john doe",
+			"0305/ftscy/ws95031 john doe",
+			enrichment{Counterparty: "john doe", Reference: "0305/FTSCY/WS95031", Channel: channelKlikBCA},
+		},
+	}
+	for _, tt := range tests {
+		got := enrich(bca.Entry{Description: tt.description, Payee: tt.payee})
+		if got != tt.want {
+			t.Errorf("%s: enrich() = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// exit codes, so that cron wrappers and monitoring can tell failures apart.
+// other failures exit with 1
+const (
+	exitPartialFailure    = 2
+	exitAdjustmentFailed  = 3
+	exitAuthFailed        = 4
+	exitScrapeFailed      = 5
+	exitDestinationFailed = 6
+	exitNothingToSync     = 7
+	exitLocked            = 8
+	exitYNABAuth          = 9
+	exitYNABNotFound      = 10
+	exitYNABRateLimited   = 11
+)
+
+var exitCodes = []struct {
+	code        int
+	description string
+}{
+	{1, "any other failure"},
+	{exitPartialFailure, "some destinations failed, the others were synced"},
+	{exitAdjustmentFailed, "transactions were synced but the balance adjustment failed"},
+	{exitAuthFailed, "klikbca login failed, e.g. wrong credentials or another session is active"},
+	{exitScrapeFailed, "the klikbca balance or statement couldn't be read, e.g. klikbca changed its pages"},
+	{exitDestinationFailed, "all destinations failed, e.g. ynab is down"},
+	{exitNothingToSync, "no new transactions, only with --exit-empty"},
+	{exitLocked, "another run of the profile is in progress"},
+	{exitYNABAuth, "ynab rejected the token, e.g. it expired or was revoked"},
+	{exitYNABNotFound, "the ynab budget or account doesn't exist"},
+	{exitYNABRateLimited, "the ynab rate limit was used up"},
+}
+
+var (
+	exitEmpty bool
+)
+
+// exitCodesHelp documents the exit codes for --help
+func exitCodesHelp() string {
+	var b strings.Builder
+	b.WriteString("exit codes:")
+	for _, c := range exitCodes {
+		fmt.Fprintf(&b, "\n   %d  %s", c.code, c.description)
+	}
+	return b.String()
+}
+
+// exitError is an error exiting with code. main maps it to the exit code
+// once the app is done, so that After still pushes the state
+type exitError struct {
+	err  error
+	code int
+}
+
+func (e exitError) Error() string { return e.err.Error() }
+func (e exitError) Unwrap() error { return e.err }
+
+// withExitCode makes err exit with code, unless it is nil or already has one
+func withExitCode(err error, code int) error {
+	if err == nil {
+		return nil
+	}
+	if errors.As(err, &exitError{}) {
+		return err
+	}
+	return exitError{err: err, code: code}
+}
+
+// exitCode is the code to exit with for err, however it was wrapped
+func exitCode(err error) int {
+	var e exitError
+	switch {
+	case err == nil:
+		return 0
+	case errors.As(err, &e):
+		return e.code
+	default:
+		return 1
+	}
+}
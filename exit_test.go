@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestExitCode(t *testing.T) {
+	empty := withExitCode(errors.New("no new transactions"), exitNothingToSync)
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"nil", nil, 0},
+		{"plain", errors.New("failed"), 1},
+		{"exit error", empty, exitNothingToSync},
+		{"wrapped with %w", fmt.Errorf("sync: %w", empty), exitNothingToSync},
+		{"wrapped with errors.Wrap", errors.Wrap(empty, "sync"), exitNothingToSync},
+		{"code kept when given another", withExitCode(fmt.Errorf("ynab: %w", empty), exitDestinationFailed), exitNothingToSync},
+	}
+	for _, tt := range tests {
+		if got := exitCode(tt.err); got != tt.want {
+			t.Errorf("%s: exitCode() = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
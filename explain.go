@@ -0,0 +1,247 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/satraul/bca-go"
+	"github.com/urfave/cli/v2"
+	"go.bmvs.io/ynab/api/transaction"
+)
+
+var (
+	explainHash string
+)
+
+// explainMatch is an entry of the statement as a destination gets it
+type explainMatch struct {
+	dest string
+	// raw is the fetched entry, trx the entry after the type map of dest
+	raw, trx bca.Entry
+	importID string
+	// occurrence counts the identical entries up to this one
+	occurrence int
+}
+
+func explainAction(c *cli.Context) error {
+	config, err := getOrDeleteConfig(username, password, token, false, noninteractive, reset, nostore)
+	if err != nil {
+		return err
+	}
+	if config == nil {
+		return nil
+	}
+	start, end, err := statementRange()
+	if err != nil {
+		return err
+	}
+
+	_, trxs, _, err := fetchBCA(c.Context, config)
+	if err != nil {
+		return err
+	}
+
+	// the same pipeline as a sync, so that the import ids and payloads are
+	// the ones the destinations would get. --review is interactive and left
+	// out
+	prepared, err := prepareEntries(c.Context, trxs)
+	if err != nil {
+		return err
+	}
+	var (
+		matches []explainMatch
+		ids     = make(map[string]bool)
+	)
+	for _, d := range destinations() {
+		mapped, idx := mapTypes(d.name, prepared)
+		occurrences := make(map[string]int)
+		for i, id := range importIDs(mapped) {
+			base := importIDBase(importIDStrategy, mapped[i])
+			occurrences[base]++
+			if strings.HasPrefix(id, explainHash) {
+				matches = append(matches, explainMatch{d.name, prepared[idx[i]], mapped[i], id, occurrences[base]})
+				ids[id] = true
+			}
+		}
+	}
+	switch len(ids) {
+	case 0:
+		return explainDropped(trxs, start, end)
+	case 1:
+	default:
+		return fmt.Errorf("%d bca transactions match import id %q, use a longer prefix", len(ids), explainHash)
+	}
+	m := matches[0]
+
+	printSection("raw bca entry", m.raw)
+	printSection("parsed description", enrich(m.raw))
+
+	fmt.Fprintln(stdout, "transforms:")
+	for _, step := range explainTransforms(m) {
+		fmt.Fprintf(stdout, "  - %s\n", step)
+	}
+	fmt.Fprintln(stdout)
+
+	for _, m := range matches {
+		if err := explainDestination(c, config, m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// explainDropped explains why the entry of --hash reached no destination,
+// or fails if the statement has no such entry
+func explainDropped(trxs []bca.Entry, start, end time.Time) error {
+	for i, id := range importIDs(trxs) {
+		if !strings.HasPrefix(id, explainHash) {
+			continue
+		}
+		trx := trxs[i]
+		printSection("raw bca entry", trx)
+		printSection("parsed description", enrich(trx))
+		if reason := filterReason(trx); reason != "" {
+			fmt.Fprintf(stdout, "filter: skipped, %s\n", reason)
+		} else {
+			fmt.Fprintln(stdout, "skipped by --transform or the type map of every destination")
+		}
+		return nil
+	}
+	return fmt.Errorf("no bca transaction with import id %q from %s to %s", explainHash, start.Format(dateLayout), end.Format(dateLayout))
+}
+
+// explainTransforms describes what the pipeline did to turn the fetched
+// entry into the transaction pushed
+func explainTransforms(m explainMatch) []string {
+	var (
+		steps []string
+		trx   = m.trx
+		p     = toPayloadTransaction(trx, "", m.importID)
+	)
+	if trx.Date.IsZero() {
+		steps = append(steps, fmt.Sprintf("pending: PEND entry hashed with predicted clear date %s", clearDate(time.Now()).Format("2006-01-02")))
+	}
+	if !trx.Date.IsZero() && !p.Date.Time.Equal(trx.Date) {
+		steps = append(steps, fmt.Sprintf("future-date: date %s capped to today", trx.Date.Format("2006-01-02")))
+	}
+	if trx.Type != m.raw.Type {
+		steps = append(steps, fmt.Sprintf("type map: %s entry pushed as %s", m.raw.Type, trx.Type))
+	}
+	if trx.Type == "DB" {
+		steps = append(steps, "debit: amount negated")
+	}
+	edit, edited := reviewed(trx)
+	switch payee := payeeName(trx); {
+	case payee == trx.Payee:
+	case edited && payee == edit.Payee:
+		steps = append(steps, fmt.Sprintf("edited: payee %q renamed to %q by --transform", trx.Payee, payee))
+	case payee == learnedPayee(trx):
+		steps = append(steps, fmt.Sprintf("learned: payee %q renamed to %q as in an earlier review", trx.Payee, payee))
+	default:
+		steps = append(steps, fmt.Sprintf("merchant: payee %q cleaned to %q", trx.Payee, payee))
+	}
+	if edited && edit.Memo != "" && edit.Memo != trx.Description {
+		steps = append(steps, fmt.Sprintf("edited: memo set to %q by --transform", edit.Memo))
+	}
+	if edited && edit.Category != "" {
+		steps = append(steps, fmt.Sprintf("edited: category set to %q by --transform", edit.Category))
+	}
+	if edited && len(edit.Splits) > 0 {
+		steps = append(steps, fmt.Sprintf("edited: split in %d by --transform, pushed to ynab as subtransactions", len(edit.Splits)))
+	}
+	steps = append(steps, "hash: "+describeImportID(trx))
+	if m.occurrence > 1 {
+		steps = append(steps, fmt.Sprintf("occurrence: identical to %d earlier entries of the statement, told apart as occurrence %d", m.occurrence-1, m.occurrence))
+	}
+	return steps
+}
+
+// describeImportID describes what the import id of trx is built from with
+// the active --import-id strategy
+func describeImportID(trx bca.Entry) string {
+	switch importIDStrategy {
+	case strategyYNAB:
+		return "ynab strategy, YNAB:amount:date:occurrence as in ynab's own file imports"
+	case strategyReference:
+		if ref := enrich(trx).Reference; ref != "" {
+			return fmt.Sprintf("reference strategy, transfer reference %s with amount and date", ref)
+		}
+		return "reference strategy without a transfer reference, falls back to date, type, amount and payee"
+	case strategyFields:
+		return "fields strategy, date, type, amount and payee"
+	default:
+		return "structhash strategy, every field of the bca entry but the description, which is used as memo instead"
+	}
+}
+
+// explainDestination prints the payload m becomes for its destination and
+// whether the destination would deduplicate it
+func explainDestination(c *cli.Context, config *config, m explainMatch) error {
+	switch {
+	case m.dest == "csv":
+		printSection("csv row", m.trx)
+	case m.dest == "firefly":
+		fftrx := toFireflyTrx(m.trx, "<account id>")
+		setFireflyProvenance(&fftrx, m.trx, m.importID, provenance{Source: sourceKlikBCA})
+		printSection("firefly payload", fftrx)
+		fmt.Fprintln(stdout, "firefly dedup: the import id is kept as external id, which firefly doesn't deduplicate on. a regular run always stores the transaction, a run resuming an interrupted one asks firefly to refuse it if it already has an identical transaction")
+	case m.dest == "journal":
+		entry, err := journalEntry(m.trx, m.importID)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "journal entry:\n%s\n\n", entry)
+		fmt.Fprintln(stdout, "journal dedup: skipped if the journal already has the import id")
+	case m.dest == "sheets":
+		printSection("sheet row", sheetRow(m.trx, m.importID))
+		fmt.Fprintln(stdout, "sheets dedup: skipped if the sheet already has the import id")
+	default:
+		t := ynabTarget{Budget: budget, Account: accountName}
+		for _, other := range ynabTargets {
+			if m.dest == "ynab:"+other.String() {
+				t = other
+			}
+		}
+		yc, err := newYNABClient(c.Context, config.YNABToken)
+		if err != nil {
+			return err
+		}
+		a, err := getYNABAccount(yc, t.Budget, t.Account)
+		if err != nil {
+			return err
+		}
+		p := toPayloadTransaction(m.trx, a.ID, m.importID)
+		printSection(m.dest+" payload", p)
+
+		dup, err := findYNABImportID(yc, t.Budget, a.ID, p)
+		if err != nil {
+			return err
+		}
+		if dup != nil {
+			fmt.Fprintf(stdout, "%s dedup: skipped, ynab transaction %s already has import id %s\n\n", m.dest, dup.ID, *p.ImportID)
+		} else {
+			fmt.Fprintf(stdout, "%s dedup: created, no ynab transaction has this import id yet\n\n", m.dest)
+		}
+	}
+	return nil
+}
+
+func findYNABImportID(yc ynabClient, budget, accountID string, p transaction.PayloadTransaction) (*transaction.Transaction, error) {
+	ts, err := yc.getTransactions(budget, accountID, p.Date.AddDate(0, 0, -1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get ynab transactions: %w", err)
+	}
+	for _, t := range ts {
+		if t.ImportID != nil && *t.ImportID == *p.ImportID {
+			return t, nil
+		}
+	}
+	return nil, nil
+}
+
+func printSection(title string, v interface{}) {
+	b, _ := json.MarshalIndent(v, "", "  ")
+	fmt.Fprintf(stdout, "%s:\n%s\n\n", title, b)
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeYNAB is an in-memory ynab api. like ynab it reports transactions
+// whose import id the account already has as duplicates instead of
+// creating them
+type fakeYNAB struct {
+	*httptest.Server
+
+	mu           sync.Mutex
+	accounts     []map[string]interface{}
+	transactions []map[string]interface{}
+	scheduled    []map[string]interface{}
+	// posts counts the requests creating transactions
+	posts int
+	// updates are the ids of the transactions updated, in order
+	updates []string
+	// reject are import ids ynab refuses. a request with one of them
+	// fails as a whole
+	reject map[string]bool
+}
+
+func newFakeYNAB(t *testing.T, accounts ...string) *fakeYNAB {
+	f := &fakeYNAB{}
+	for i, name := range accounts {
+		f.accounts = append(f.accounts, map[string]interface{}{
+			"id": fmt.Sprintf("account-%d", i+1), "name": name, "type": "checking", "on_budget": true,
+			"closed": false, "balance": 0, "cleared_balance": 0, "uncleared_balance": 0, "deleted": false,
+		})
+	}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.Close)
+	return f
+}
+
+// client returns a native ynab client of the fake
+func (f *fakeYNAB) client() *ynabAPI {
+	y := newYNABAPI(context.Background(), "token")
+	y.baseURL = f.URL
+	return y
+}
+
+func (f *fakeYNAB) serve(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.Header.Get("Authorization") != "Bearer token" {
+		fakeYNABError(w, http.StatusUnauthorized, "401", "unauthorized")
+		return
+	}
+	// /budgets/{budget}/...
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) < 3 || parts[0] != "budgets" {
+		fakeYNABError(w, http.StatusNotFound, "404.2", "resource_not_found")
+		return
+	}
+	switch route := strings.Join(parts[2:], "/"); {
+	case r.Method == http.MethodGet && route == "accounts":
+		fakeData(w, map[string]interface{}{"accounts": f.accounts})
+	case r.Method == http.MethodGet && len(parts) == 4 && parts[2] == "accounts":
+		for _, a := range f.accounts {
+			if a["id"] == parts[3] {
+				fakeData(w, map[string]interface{}{"account": a})
+				return
+			}
+		}
+		fakeYNABError(w, http.StatusNotFound, "404.2", "resource_not_found")
+	case r.Method == http.MethodGet && len(parts) == 5 && parts[2] == "accounts" && parts[4] == "transactions":
+		since := r.URL.Query().Get("since_date")
+		var ts []map[string]interface{}
+		for _, t := range f.transactions {
+			if t["account_id"] == parts[3] && t["date"].(string) >= since {
+				ts = append(ts, t)
+			}
+		}
+		fakeData(w, map[string]interface{}{"transactions": ts})
+	case r.Method == http.MethodGet && route == "transactions":
+		fakeData(w, map[string]interface{}{"transactions": f.transactions})
+	case r.Method == http.MethodGet && route == "scheduled_transactions":
+		scheduled := []map[string]interface{}{}
+		fakeData(w, map[string]interface{}{"scheduled_transactions": append(scheduled, f.scheduled...)})
+	case r.Method == http.MethodGet && route == "payees":
+		// every account has its transfer payee
+		payees := []map[string]interface{}{}
+		for _, a := range f.accounts {
+			payees = append(payees, map[string]interface{}{
+				"id": "payee-" + a["id"].(string), "name": "Transfer : " + a["name"].(string), "transfer_account_id": a["id"], "deleted": false,
+			})
+		}
+		fakeData(w, map[string]interface{}{"payees": payees})
+	case r.Method == http.MethodGet && route == "categories":
+		fakeData(w, map[string]interface{}{"category_groups": []interface{}{}})
+	case r.Method == http.MethodPost && route == "transactions":
+		f.posts++
+		f.create(w, r)
+	case r.Method == http.MethodPut && len(parts) == 4 && parts[2] == "transactions":
+		f.update(w, r, parts[3])
+	default:
+		fakeYNABError(w, http.StatusNotFound, "404.1", "not_found")
+	}
+}
+
+// create saves the transactions of a request, single or bulk
+func (f *fakeYNAB) create(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Transaction  map[string]interface{}   `json:"transaction"`
+		Transactions []map[string]interface{} `json:"transactions"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		fakeYNABError(w, http.StatusBadRequest, "400", err.Error())
+		return
+	}
+	if body.Transaction != nil {
+		body.Transactions = append(body.Transactions, body.Transaction)
+	}
+	for _, t := range body.Transactions {
+		if id, ok := t["import_id"].(string); ok && f.reject[id] {
+			fakeYNABError(w, http.StatusBadRequest, "400", "invalid transaction "+id)
+			return
+		}
+	}
+
+	var (
+		ids        = []string{}
+		duplicates = []string{}
+		created    = []map[string]interface{}{}
+	)
+	for _, t := range body.Transactions {
+		if id, ok := t["import_id"].(string); ok && f.hasImportID(t["account_id"], id) {
+			duplicates = append(duplicates, id)
+			continue
+		}
+		t["id"] = fmt.Sprintf("transaction-%d", len(f.transactions)+1)
+		t["deleted"] = false
+		for _, field := range []string{"flag_name", "import_payee_name", "category_name"} {
+			if _, ok := t[field]; !ok {
+				t[field] = nil
+			}
+		}
+		f.transactions = append(f.transactions, t)
+		ids = append(ids, t["id"].(string))
+		created = append(created, t)
+	}
+	w.WriteHeader(http.StatusCreated)
+	fakeData(w, map[string]interface{}{"transaction_ids": ids, "duplicate_import_ids": duplicates, "transactions": created})
+}
+
+// update saves the fields of a request to transaction id. a transfer payee
+// turns it into a transfer
+func (f *fakeYNAB) update(w http.ResponseWriter, r *http.Request, id string) {
+	var body struct {
+		Transaction map[string]interface{} `json:"transaction"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		fakeYNABError(w, http.StatusBadRequest, "400", err.Error())
+		return
+	}
+	for _, t := range f.transactions {
+		if t["id"] != id {
+			continue
+		}
+		for k, v := range body.Transaction {
+			t[k] = v
+		}
+		if payee, ok := t["payee_id"].(string); ok && strings.HasPrefix(payee, "payee-") {
+			t["transfer_account_id"] = strings.TrimPrefix(payee, "payee-")
+		}
+		f.updates = append(f.updates, id)
+		fakeData(w, map[string]interface{}{"transaction": t})
+		return
+	}
+	fakeYNABError(w, http.StatusNotFound, "404.2", "resource_not_found")
+}
+
+func (f *fakeYNAB) hasImportID(accountID interface{}, id string) bool {
+	for _, t := range f.transactions {
+		if t["account_id"] == accountID && t["import_id"] == id {
+			return true
+		}
+	}
+	return false
+}
+
+func fakeData(w http.ResponseWriter, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+}
+
+func fakeYNABError(w http.ResponseWriter, status int, id, name string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{"error": map[string]string{"id": id, "name": name, "detail": name}})
+}
+
+// fakeFirefly is an in-memory firefly iii api with a single asset account
+type fakeFirefly struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	account map[string]interface{}
+	// stores are the transaction store requests, as sent
+	stores []map[string]interface{}
+}
+
+func newFakeFirefly(t *testing.T, account string) *fakeFirefly {
+	f := &fakeFirefly{account: map[string]interface{}{
+		"type": "accounts",
+		"id":   "1",
+		"attributes": map[string]interface{}{
+			"name": account, "type": "asset", "current_balance": "0", "currency_code": "IDR",
+		},
+	}}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.Close)
+	return f
+}
+
+// routes are matched from the api version on, so that the fake works
+// whether or not the url includes /api
+func (f *fakeFirefly) serve(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.Header.Get("Authorization") != "Bearer token" {
+		http.Error(w, `{"message":"Unauthenticated."}`, http.StatusUnauthorized)
+		return
+	}
+	path := r.URL.Path
+	if i := strings.Index(path, "/v1/"); i >= 0 {
+		path = path[i+len("/v1"):]
+	}
+	w.Header().Set("Content-Type", "application/json")
+	switch {
+	case r.Method == http.MethodGet && path == "/search/accounts":
+		data := []interface{}{}
+		if name := f.account["attributes"].(map[string]interface{})["name"]; r.URL.Query().Get("query") == name {
+			data = append(data, f.account)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+	case r.Method == http.MethodGet && path == "/accounts/1":
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": f.account})
+	case r.Method == http.MethodPost && path == "/transactions":
+		b, _ := ioutil.ReadAll(r.Body)
+		var store map[string]interface{}
+		if err := json.Unmarshal(b, &store); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.stores = append(f.stores, store)
+		id := fmt.Sprint(len(f.stores))
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{
+			"type": "transactions",
+			"id":   id,
+			"attributes": map[string]interface{}{
+				"group_title":  nil,
+				"transactions": store["transactions"],
+			},
+		}})
+	default:
+		http.Error(w, `{"message":"Resource not found"}`, http.StatusNotFound)
+	}
+}
+
+// splits returns the transaction splits stored so far
+func (f *fakeFirefly) splits() []map[string]interface{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var splits []map[string]interface{}
+	for _, s := range f.stores {
+		ts, _ := s["transactions"].([]interface{})
+		for _, t := range ts {
+			splits = append(splits, t.(map[string]interface{}))
+		}
+	}
+	return splits
+}
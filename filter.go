@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/satraul/bca-go"
+	"github.com/shopspring/decimal"
+)
+
+var (
+	minAmount, maxAmount           string
+	includePattern, excludePattern string
+	onlyType, onlyChannel          string
+
+	filterMin, filterMax         *decimal.Decimal
+	filterInclude, filterExclude *regexp.Regexp
+)
+
+// compileFilters parses the filter flags
+func compileFilters() error {
+	for _, f := range []struct {
+		name  string
+		value string
+		dst   **decimal.Decimal
+	}{
+		{"--min-amount", minAmount, &filterMin},
+		{"--max-amount", maxAmount, &filterMax},
+	} {
+		if f.value == "" {
+			continue
+		}
+		d, err := decimal.NewFromString(f.value)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", f.name, err)
+		}
+		*f.dst = &d
+	}
+
+	for _, f := range []struct {
+		name  string
+		value string
+		dst   **regexp.Regexp
+	}{
+		{"--include", includePattern, &filterInclude},
+		{"--exclude", excludePattern, &filterExclude},
+	} {
+		if f.value == "" {
+			continue
+		}
+		re, err := regexp.Compile(f.value)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", f.name, err)
+		}
+		*f.dst = re
+	}
+
+	switch onlyType {
+	case "", "DB", "CR":
+	default:
+		return fmt.Errorf("invalid --type %q, expected DB or CR", onlyType)
+	}
+	switch onlyChannel {
+	case "", channelMBCA, channelKlikBCA, channelATM, channelQRIS:
+	default:
+		return fmt.Errorf("invalid --channel %q, expected %s, %s, %s or %s", onlyChannel, channelMBCA, channelKlikBCA, channelATM, channelQRIS)
+	}
+	return nil
+}
+
+// filterReason returns why trx is filtered out, or "" if it's kept
+func filterReason(trx bca.Entry) string {
+	switch {
+	case filterMin != nil && trx.Amount.LessThan(*filterMin):
+		return fmt.Sprintf("amount below --min-amount %s", filterMin)
+	case filterMax != nil && trx.Amount.GreaterThan(*filterMax):
+		return fmt.Sprintf("amount above --max-amount %s", filterMax)
+	case onlyType != "" && trx.Type != onlyType:
+		return fmt.Sprintf("type is not %s", onlyType)
+	case onlyChannel != "" && enrich(trx).Channel != onlyChannel:
+		return fmt.Sprintf("channel is not %s", onlyChannel)
+	case filterInclude != nil && !filterInclude.MatchString(trx.Payee) && !filterInclude.MatchString(trx.Description):
+		return "payee and description don't match --include"
+	case filterExclude != nil && (filterExclude.MatchString(trx.Payee) || filterExclude.MatchString(trx.Description)):
+		return "payee or description match --exclude"
+	}
+	return ""
+}
+
+// filterTransactions drops transactions excluded by the filter flags
+func filterTransactions(trxs []bca.Entry) []bca.Entry {
+	kept := make([]bca.Entry, 0, len(trxs))
+	for _, trx := range trxs {
+		if filterReason(trx) == "" {
+			kept = append(kept, trx)
+		}
+	}
+	if n := len(trxs) - len(kept); n > 0 {
+		fmt.Fprintf(stdout, "%d transaction(s) filtered out\n", n)
+	}
+	return kept
+}
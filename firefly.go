@@ -3,10 +3,13 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/satraul/bca-go"
@@ -18,55 +21,95 @@ const (
 	reconciliationTimeLayout = "January 2, 2006"
 )
 
-func createFireflyTransactions(ctx context.Context, bal bca.Balance, trxs []bca.Entry) error {
-	ff := gofirefly.NewAPIClient(&gofirefly.APIConfiguration{
-		DefaultHeader: make(map[string]string),
-		UserAgent:     "OpenAPI-Generator/1.0.0/go",
-		Debug:         false,
-		Servers: gofirefly.ServerConfigurations{
-			{
-				URL: fireflyUrl,
-			},
-		},
-		OperationServers: map[string]gofirefly.ServerConfigurations{},
-	})
-	auth := context.WithValue(ctx, gofirefly.ContextAccessToken, fireflyToken)
+var (
+	fireflyReconcileThreshold string
+	fireflyReconcileDryRun    bool
+	fireflyConcurrency        int
+	fireflyCurrency           string
 
-	account, err := getFireflyAccount(ff, auth)
+	// fireflyReconcileMin is --firefly-reconcile-threshold parsed by
+	// validatePolicy
+	fireflyReconcileMin decimal.Decimal
+)
+
+// createFireflyTransactions returns the transactions created and the ones
+// firefly already had, even if it fails part way
+func createFireflyTransactions(ctx context.Context, bal bca.Balance, trxs []bca.Entry) (created, duplicates []createdTransaction, err error) {
+	ff, auth := newFireflyClient(ctx)
+
+	account, err := getOrCreateFireflyAccount(ff, auth, bal, trxs)
 	if err != nil {
-		return fmt.Errorf("failed to get account: %w", err)
+		return nil, nil, fmt.Errorf("failed to get account: %w", err)
 	}
 
-	for _, trx := range trxs {
-		err := createFireflyTransaction(trx, account, ff, auth)
-		if err != nil {
-			return fmt.Errorf("failed to create firefly transaction: %w", err)
-		}
+	created, duplicates, err = storeFireflyTransactions(ctx, trxs, account, ff, auth)
+	if err != nil {
+		return created, duplicates, fmt.Errorf("failed to create firefly transaction: %w", err)
 	}
 
-	fmt.Printf("%d firefly transaction(s) were successfully created\n", len(trxs))
+	if len(duplicates) > 0 {
+		fmt.Fprintf(stdout, "%d firefly transaction(s) already exist\n", len(duplicates))
+	}
+	fmt.Fprintf(stdout, "%d firefly transaction(s) were successfully created\n", len(created))
 
 	account, err = getFireflyAccountByID(ff, auth, account.Id)
 	if err != nil {
-		return fmt.Errorf("failed to get account: %w", err)
+		return created, duplicates, fmt.Errorf("failed to get account: %w", err)
 	}
 
 	if !noadjust {
 		ffBalance, err := decimal.NewFromString(*account.Attributes.CurrentBalance)
 		if err != nil {
-			return fmt.Errorf("cannot parse decimal from firefly balance: %w", err)
+			return created, duplicates, fmt.Errorf("cannot parse decimal from firefly balance: %w", err)
 		}
 		if bal.Balance.Equal(ffBalance) {
-			return nil
+			return created, duplicates, nil
+		}
+		delta := bal.Balance.Sub(ffBalance)
+		if delta.Abs().LessThan(fireflyReconcileMin) {
+			fmt.Fprintf(stdout, "firefly balance differs by %s, below --firefly-reconcile-threshold. no reconciliation created\n", delta.StringFixed(2))
+			return created, duplicates, nil
+		}
+		if fireflyReconcileDryRun {
+			fmt.Fprintf(stdout, "firefly balance %s differs from bca by %s. a reconciliation would be created without --firefly-reconcile-dry-run\n", ffBalance.StringFixed(2), delta.StringFixed(2))
+			return created, duplicates, nil
 		}
-		err = createFireflyReconciliation(ffBalance, account.Id, bal, ff, auth)
+		err = createFireflyReconciliation(ffBalance, account.Id, fireflyCurrencyCode(), bal, ff, auth)
 		if err != nil {
-			return fmt.Errorf("failed to create firefly reconciliation: %w", err)
+			return created, duplicates, fmt.Errorf("failed to create firefly reconciliation: %w", err)
 		}
-		fmt.Printf("firefly reconciliation successfully created\n")
+		fmt.Fprintf(stdout, "firefly reconciliation successfully created\n")
 	}
 
-	return nil
+	return created, duplicates, nil
+}
+
+// fireflyCurrencyCode is the currency of the bca account in firefly,
+// --firefly-currency or IDR. it is set on every transaction, as firefly
+// otherwise books them in its default currency
+func fireflyCurrencyCode() string {
+	if fireflyCurrency != "" {
+		return strings.ToUpper(fireflyCurrency)
+	}
+	return commodity
+}
+
+// newFireflyClient returns a client for --firefly-url and ctx authenticated
+// with --firefly-token
+func newFireflyClient(ctx context.Context) (*gofirefly.APIClient, context.Context) {
+	ff := gofirefly.NewAPIClient(&gofirefly.APIConfiguration{
+		DefaultHeader: make(map[string]string),
+		UserAgent:     "OpenAPI-Generator/1.0.0/go",
+		Debug:         false,
+		Servers: gofirefly.ServerConfigurations{
+			{
+				URL: fireflyUrl,
+			},
+		},
+		OperationServers: map[string]gofirefly.ServerConfigurations{},
+	})
+	auth := context.WithValue(ctx, gofirefly.ContextAccessToken, fireflyToken)
+	return ff, auth
 }
 
 func getFireflyAccount(ff *gofirefly.APIClient, auth context.Context) (*gofirefly.AccountRead, error) {
@@ -82,11 +125,73 @@ func getFireflyAccount(ff *gofirefly.APIClient, auth context.Context) (*gofirefl
 		return nil, fmt.Errorf("status code not OK with query %q response %q", accountName, string(b))
 	}
 	if len(ac.Data) == 0 {
-		return nil, fmt.Errorf("no accounts found with name %q", accountName)
+		return nil, fmt.Errorf("%w with name %q", errFireflyAccountNotFound, accountName)
 	}
 	return &ac.Data[0], nil
 }
 
+var (
+	errFireflyAccountNotFound = errors.New("no accounts found")
+	errFireflyDuplicate       = errors.New("duplicate of a firefly transaction")
+)
+
+// getOrCreateFireflyAccount gets the asset account, creating it with
+// --create-account. like for ynab, the opening balance is the bca balance
+// before trxs
+func getOrCreateFireflyAccount(ff *gofirefly.APIClient, auth context.Context, bal bca.Balance, trxs []bca.Entry) (*gofirefly.AccountRead, error) {
+	account, err := getFireflyAccount(ff, auth)
+	if !createAccount || !errors.Is(err, errFireflyAccountNotFound) {
+		return account, err
+	}
+
+	opening := bal.Balance
+	for _, trx := range trxs {
+		opening = opening.Sub(signedAmount(trx))
+	}
+	openingDate, _, err := statementRange()
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		role     = gofirefly.ACCOUNTROLEPROPERTY_DEFAULT_ASSET
+		currency = fireflyCurrencyCode()
+		amount   = opening.String()
+		store    = gofirefly.NewAccountStore(accountName, gofirefly.SHORTACCOUNTTYPEPROPERTY_ASSET)
+	)
+	store.AccountRole = *gofirefly.NewNullableAccountRoleProperty(&role)
+	store.CurrencyCode = &currency
+	store.OpeningBalance = &amount
+	store.OpeningBalanceDate = *gofirefly.NewNullableTime(&openingDate)
+	// indonesian accounts have no iban, only the account number
+	if bal.AccountNumber != "" {
+		store.AccountNumber = *gofirefly.NewNullableString(&bal.AccountNumber)
+	}
+
+	stored, err := storeAccount(ff, auth, *store)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(stdout, "firefly account %s successfully created with an opening balance of %s\n", accountName, opening.StringFixed(2))
+	return stored, nil
+}
+
+func storeAccount(ff *gofirefly.APIClient, auth context.Context, store gofirefly.AccountStore) (*gofirefly.AccountRead, error) {
+	ac, resp, err := ff.AccountsApi.StoreAccount(auth).AccountStore(store).Execute()
+	if err != nil {
+		if resp != nil {
+			b, _ := io.ReadAll(resp.Body)
+			return nil, fmt.Errorf("failed to create account %q response %q: %w", store.Name, string(b), err)
+		}
+		return nil, fmt.Errorf("failed to create account %q: %w", store.Name, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("status code not OK creating account %q response %q", store.Name, string(b))
+	}
+	return &ac.Data, nil
+}
+
 func getFireflyAccountByID(ff *gofirefly.APIClient, auth context.Context, id string) (*gofirefly.AccountRead, error) {
 	ac, resp, err := ff.AccountsApi.GetAccount(auth, stringToInt32(id)).
 		Execute()
@@ -100,58 +205,197 @@ func getFireflyAccountByID(ff *gofirefly.APIClient, auth context.Context, id str
 	return &ac.Data, nil
 }
 
-func createFireflyReconciliation(ffBalance decimal.Decimal, accountID string, bal bca.Balance, ff *gofirefly.APIClient, auth context.Context) error {
-	recAcc, err := getReconciliationAccount(ff, auth)
+func createFireflyReconciliation(ffBalance decimal.Decimal, accountID, currency string, bal bca.Balance, ff *gofirefly.APIClient, auth context.Context) error {
+	recAcc, err := getReconciliationAccount(ff, auth, currency)
 	if err != nil {
 		return fmt.Errorf("failed to get reconciliation account: %w", err)
 	}
 
-	fftrx := toFireflyReconciliationTrx(ffBalance, bal, accountID, recAcc.Id)
+	fftrx, err := toFireflyReconciliationTrx(ffBalance, bal, accountID, recAcc.Id)
+	if err != nil {
+		return err
+	}
 
-	return storeTransaction(ff, auth, fftrx)
+	_, err = storeTransaction(ff, auth, fftrx, false)
+	return err
+}
+
+// storeFireflyTransactions creates trxs with --firefly-concurrency workers.
+// firefly stores one transaction per request, as splits of a single request
+// would be one transaction. after a failure no more are started, and the
+// ones created are returned in the order of trxs so they can be undone,
+// along with the ones firefly already had
+func storeFireflyTransactions(ctx context.Context, trxs []bca.Entry, account *gofirefly.AccountRead, ff *gofirefly.APIClient, auth context.Context) ([]createdTransaction, []createdTransaction, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		ids       = importIDs(trxs)
+		p         = provenanceFrom(ctx)
+		resume    = resuming(ctx)
+		results   = make([]string, len(trxs))
+		duplicate = make([]bool, len(trxs))
+		errs      = make([]error, len(trxs))
+		jobs      = make(chan int)
+		wg        sync.WaitGroup
+	)
+	workers := fireflyConcurrency
+	if workers < 1 {
+		workers = 1
+	}
+	for n := 0; n < workers; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i], errs[i] = createFireflyTransaction(trxs[i], ids[i], p, resume, account, ff, auth)
+				if errors.Is(errs[i], errFireflyDuplicate) {
+					// created before, by a run that was interrupted
+					errs[i], duplicate[i] = nil, true
+					continue
+				}
+				if errs[i] != nil {
+					cancel()
+				}
+			}
+		}()
+	}
+dispatch:
+	for i := range trxs {
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	var (
+		created    = make([]createdTransaction, 0, len(trxs))
+		duplicates []createdTransaction
+		firstErr   error
+	)
+	for i, trx := range trxs {
+		switch {
+		case errs[i] != nil && firstErr == nil:
+			firstErr = errs[i]
+		case duplicate[i]:
+			duplicates = append(duplicates, toCreatedTransaction(trx, ids[i], ""))
+		case errs[i] == nil && results[i] != "":
+			created = append(created, toCreatedTransaction(trx, ids[i], results[i]))
+		}
+	}
+	if firstErr == nil {
+		firstErr = ctx.Err()
+	}
+	return created, duplicates, firstErr
 }
 
-func createFireflyTransaction(trx bca.Entry, account *gofirefly.AccountRead, ff *gofirefly.APIClient, auth context.Context) error {
+// createFireflyTransaction stores trx. firefly's duplicate check is only
+// asked for when resuming an interrupted run, the only time the transaction
+// may have been stored already
+func createFireflyTransaction(trx bca.Entry, importID string, p provenance, resume bool, account *gofirefly.AccountRead, ff *gofirefly.APIClient, auth context.Context) (string, error) {
 	fftrx := toFireflyTrx(trx, account.Id)
+	setFireflyProvenance(&fftrx, trx, importID, p)
 
-	return storeTransaction(ff, auth, fftrx)
+	return storeTransaction(ff, auth, fftrx, resume)
 }
 
-func storeTransaction(ff *gofirefly.APIClient, auth context.Context, fftrx gofirefly.TransactionSplitStore) error {
-	_, resp, err := ff.TransactionsApi.
+// setFireflyProvenance tags fftrx with the run and source it was synced by
+// and keeps the raw bca description and import id, so that every imported
+// transaction can be traced back from firefly
+func setFireflyProvenance(fftrx *gofirefly.TransactionSplitStore, trx bca.Entry, importID string, p provenance) {
+	fftrx.Tags = append(fftrx.Tags, "bca-sync-ynab")
+	if p.Source != "" {
+		fftrx.Tags = append(fftrx.Tags, "source:"+p.Source)
+	}
+	if p.RunID != "" {
+		fftrx.Tags = append(fftrx.Tags, "run:"+p.RunID)
+	}
+	fftrx.ExternalId = *gofirefly.NewNullableString(&importID)
+
+	notes := fmt.Sprintf("imported by bca-sync-ynab run %s from %s\nimport id: %s\noriginal description: %s", p.RunID, p.Source, importID, trx.Description)
+	fftrx.Notes = *gofirefly.NewNullableString(&notes)
+}
+
+// storeTransaction returns the id of the stored transaction. with
+// errorIfDuplicate firefly refuses a transaction identical to one it
+// already has, which storeTransaction returns as errFireflyDuplicate
+func storeTransaction(ff *gofirefly.APIClient, auth context.Context, fftrx gofirefly.TransactionSplitStore, errorIfDuplicate bool) (string, error) {
+	store := gofirefly.NewTransactionStore([]gofirefly.TransactionSplitStore{fftrx})
+	store.ErrorIfDuplicateHash = &errorIfDuplicate
+	stored, resp, err := ff.TransactionsApi.
 		StoreTransaction(auth).
-		TransactionStore(*gofirefly.NewTransactionStore([]gofirefly.TransactionSplitStore{fftrx})).
+		TransactionStore(*store).
 		Execute()
 
+	rb, _ := json.Marshal(fftrx)
 	if err != nil {
+		if resp == nil {
+			return "", fmt.Errorf("err with request %q: %w", string(rb), err)
+		}
 		b, _ := io.ReadAll(resp.Body)
 		defer resp.Body.Close()
-		rb, _ := json.Marshal(fftrx)
-		return fmt.Errorf("err with request %q response %q: %w", string(rb), string(b), err)
+		if errorIfDuplicate && resp.StatusCode == http.StatusUnprocessableEntity && isFireflyDuplicate(b) {
+			return "", errFireflyDuplicate
+		}
+		return "", fmt.Errorf("err with request %q response %q: %w", string(rb), string(b), err)
 	}
 
 	if resp.StatusCode != http.StatusOK {
 		b, _ := io.ReadAll(resp.Body)
 		defer resp.Body.Close()
-		rb, _ := json.Marshal(fftrx)
-		return fmt.Errorf("status code not OK with request %q response %q", string(rb), string(b))
+		return "", fmt.Errorf("status code not OK with request %q response %q", string(rb), string(b))
 	}
-	return nil
+	return stored.Data.Id, nil
 }
 
-func toFireflyReconciliationTrx(ffBalance decimal.Decimal, bal bca.Balance, accountID, recAccID string) gofirefly.TransactionSplitStore {
+// fireflyValidationError is the body of firefly's 422 answers, with the
+// messages by the field they are about
+type fireflyValidationError struct {
+	Message string              `json:"message"`
+	Errors  map[string][]string `json:"errors"`
+}
+
+// isFireflyDuplicate tells whether body is firefly refusing a transaction
+// for error_if_duplicate_hash. firefly reports it on the description of the
+// split as "Duplicate of transaction #<id>."
+func isFireflyDuplicate(body []byte) bool {
+	var e fireflyValidationError
+	if json.Unmarshal(body, &e) != nil {
+		return false
+	}
+	for field, messages := range e.Errors {
+		if !strings.HasPrefix(field, "transactions.") || !strings.HasSuffix(field, ".description") {
+			continue
+		}
+		for _, m := range messages {
+			if strings.HasPrefix(m, "Duplicate of transaction") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func toFireflyReconciliationTrx(ffBalance decimal.Decimal, bal bca.Balance, accountID, recAccID string) (gofirefly.TransactionSplitStore, error) {
 	amount := bal.Balance.Sub(ffBalance)
 	t := time.Now()
 	to := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local)
-	from := to.AddDate(0, 0, -days)
+	from, _, err := statementRange()
+	if err != nil {
+		return gofirefly.TransactionSplitStore{}, err
+	}
 	description := fmt.Sprintf("Reconciliation (%s to %s)", from.Format(reconciliationTimeLayout), to.Format(reconciliationTimeLayout))
 	reconciled := true
+	currency := fireflyCurrencyCode()
 	fftrx := gofirefly.TransactionSplitStore{
-		Type:        "reconciliation",
-		Date:        to,
-		Amount:      amount.Abs().String(),
-		Description: description,
-		Reconciled:  &reconciled,
+		Type:         "reconciliation",
+		Date:         to,
+		Amount:       amount.Abs().String(),
+		Description:  description,
+		CurrencyCode: *gofirefly.NewNullableString(&currency),
+		Reconciled:   &reconciled,
 	}
 
 	switch {
@@ -163,42 +407,53 @@ func toFireflyReconciliationTrx(ffBalance decimal.Decimal, bal bca.Balance, acco
 		fftrx.DestinationId = *gofirefly.NewNullableString(&recAccID)
 	}
 
-	return fftrx
+	return fftrx, nil
 }
 
 func toFireflyTrx(trx bca.Entry, accountID string) gofirefly.TransactionSplitStore {
 	fftrx := gofirefly.TransactionSplitStore{}
 	fftrx.Amount = trx.Amount.String()
+	currency := fireflyCurrencyCode()
+	fftrx.CurrencyCode = *gofirefly.NewNullableString(&currency)
 
 	switch {
+	// pending entries are dated with their predicted clearance, like their
+	// import id, so that a resumed run stores the same transaction
 	case trx.Date.IsZero():
-		fftrx.Date = time.Now()
+		fftrx.Date = clearDate(time.Now())
 	default:
 		fftrx.Date = trx.Date
 	}
 
+	payee := payeeName(trx)
 	switch trx.Type {
 	case "DB":
 		fftrx.Type = "withdrawal"
 		fftrx.SourceId = *gofirefly.NewNullableString(&accountID)
-		fftrx.DestinationName = *gofirefly.NewNullableString(&trx.Payee)
+		fftrx.DestinationName = *gofirefly.NewNullableString(&payee)
 	default:
 		fftrx.Type = "deposit"
-		fftrx.SourceName = *gofirefly.NewNullableString(&trx.Payee)
+		fftrx.SourceName = *gofirefly.NewNullableString(&payee)
 		fftrx.DestinationId = *gofirefly.NewNullableString(&accountID)
 	}
 
-	switch {
-	case trx.Description != "":
-		fftrx.Description = trx.Description
+	switch memo := reviewedMemo(trx); {
+	case memo != "":
+		fftrx.Description = memo
 	default:
-		fftrx.Description = trx.Payee
+		fftrx.Description = payee
+	}
+	if category := reviewedCategory(trx); category != "" {
+		fftrx.CategoryName = *gofirefly.NewNullableString(&category)
 	}
 
 	return fftrx
 }
 
-func getReconciliationAccount(ff *gofirefly.APIClient, auth context.Context) (*gofirefly.AccountRead, error) {
+// getReconciliationAccount gets the reconciliation account of the asset
+// account. firefly only creates it on the first reconciliation made in its
+// ui, so it is created here as firefly would name it if it's missing
+func getReconciliationAccount(ff *gofirefly.APIClient, auth context.Context, currency string) (*gofirefly.AccountRead, error) {
 	ac, resp, err := ff.SearchApi.SearchAccounts(auth).
 		Field("name").
 		Query(accountName).
@@ -211,10 +466,17 @@ func getReconciliationAccount(ff *gofirefly.APIClient, auth context.Context) (*g
 		b, _ := io.ReadAll(resp.Body)
 		return nil, fmt.Errorf("status code not OK with query %q response %q", accountName, string(b))
 	}
-	if len(ac.Data) == 0 {
-		return nil, fmt.Errorf("no accounts found with name %q", accountName)
+	if len(ac.Data) > 0 {
+		return &ac.Data[0], nil
 	}
-	return &ac.Data[0], nil
+
+	name := fmt.Sprintf("%s reconciliation (%s)", accountName, currency)
+	stored, err := storeAccount(ff, auth, *gofirefly.NewAccountStore(name, gofirefly.SHORTACCOUNTTYPEPROPERTY_RECONCILIATION))
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(stdout, "firefly reconciliation account %s successfully created\n", name)
+	return stored, nil
 }
 
 func stringToInt32(s string) int32 {
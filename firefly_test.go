@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/satraul/bca-go"
+	"github.com/shopspring/decimal"
+)
+
+func TestIsFireflyDuplicate(t *testing.T) {
+	tests := []struct {
+		body string
+		want bool
+	}{
+		{`{"message":"Duplicate of transaction #12.","errors":{"transactions.0.description":["Duplicate of transaction #12."]}}`, true},
+		{`{"message":"The given data was invalid.","errors":{"transactions.0.amount":["The amount must be positive."]}}`, false},
+		// only the duplicate check reports on the description
+		{`{"message":"The given data was invalid.","errors":{"transactions.0.source_id":["Invalid source for Duplicate of transaction"]}}`, false},
+		{`Duplicate of transaction #12.`, false},
+		{``, false},
+	}
+	for _, tt := range tests {
+		if got := isFireflyDuplicate([]byte(tt.body)); got != tt.want {
+			t.Errorf("isFireflyDuplicate(%s) = %v, want %v", tt.body, got, tt.want)
+		}
+	}
+}
+
+func TestPendingFireflyDateSurvivesResume(t *testing.T) {
+	useDefaults(t)
+	pending := bca.Entry{Description: "KARTU DEBIT KOPI KENANGAN", Payee: "KOPI KENANGAN", Amount: decimal.RequireFromString("18000"), Type: "DB"}
+	start := time.Now()
+	first := toFireflyTrx(pending, "1").Date
+
+	// a resume, whenever it runs, dates it as of the start of the run
+	resumed := toFireflyTrx(datePending([]bca.Entry{pending}, start)[0], "1").Date
+	if !first.Equal(resumed) {
+		t.Errorf("pending entry stored on %s, resumed as %s", first, resumed)
+	}
+	if want := clearDate(start); !first.Equal(want) {
+		t.Errorf("pending entry stored on %s, want its clearance %s", first, want)
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const (
+	// browserUserAgent is a current desktop chrome, for --user-agent browser
+	browserUserAgent = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/129.0.0.0 Safari/537.36"
+)
+
+var (
+	userAgent  string
+	bcaHeaders []string
+
+	// browserHeaders go with browserUserAgent, so that requests look like
+	// they come from that browser and not only claim to
+	browserHeaders = http.Header{
+		"Accept":                    {"text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/webp,*/*;q=0.8"},
+		"Accept-Language":           {"id-ID,id;q=0.9,en-US;q=0.8,en;q=0.7"},
+		"Sec-Ch-Ua":                 {`"Google Chrome";v="129", "Not=A?Brand";v="8", "Chromium";v="129"`},
+		"Sec-Ch-Ua-Mobile":          {"?0"},
+		"Sec-Ch-Ua-Platform":        {`"Windows"`},
+		"Sec-Fetch-Dest":            {"document"},
+		"Sec-Fetch-Mode":            {"navigate"},
+		"Sec-Fetch-Site":            {"same-origin"},
+		"Upgrade-Insecure-Requests": {"1"},
+	}
+)
+
+// headerTransport sets the headers of klikbca requests
+type headerTransport struct {
+	base http.RoundTripper
+	set  http.Header
+	// del are headers removed from requests
+	del []string
+}
+
+// withHeaders makes klikbca requests present --user-agent and
+// --bca-header, for when klikbca blocks the default go user agent
+func withHeaders(base http.RoundTripper) (http.RoundTripper, error) {
+	t := &headerTransport{base: base, set: make(http.Header)}
+	switch userAgent {
+	case "":
+	case "browser":
+		for name, values := range browserHeaders {
+			t.set[name] = values
+		}
+		t.set.Set("User-Agent", browserUserAgent)
+	default:
+		t.set.Set("User-Agent", userAgent)
+	}
+	for _, h := range bcaHeaders {
+		i := strings.Index(h, ":")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid --bca-header %q, expected Name: value", h)
+		}
+		name, value := strings.TrimSpace(h[:i]), strings.TrimSpace(h[i+1:])
+		if value == "" {
+			t.set.Del(name)
+			t.del = append(t.del, name)
+			continue
+		}
+		t.set.Set(name, value)
+	}
+	if len(t.set) == 0 && len(t.del) == 0 {
+		return base, nil
+	}
+	return t, nil
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !isBCARequest(req) {
+		return t.base.RoundTrip(req)
+	}
+	// a round tripper mustn't change the request it is given
+	req = req.Clone(req.Context())
+	for name, values := range t.set {
+		req.Header[name] = values
+	}
+	for _, name := range t.del {
+		req.Header.Del(name)
+	}
+	return t.base.RoundTrip(req)
+}
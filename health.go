@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	heartbeatFile     = "heartbeat"
+	lastSuccessFile   = "last-success"
+	heartbeatInterval = time.Minute
+)
+
+var (
+	healthMaxAge time.Duration
+)
+
+func writeStateTime(name string, t time.Time) error {
+	return writeFileAtomic(filepath.Join(profileFolder().Path, name), []byte(t.Format(time.RFC3339)), 0644)
+}
+
+func readStateTime(name string) (time.Time, error) {
+	b, err := ioutil.ReadFile(filepath.Join(profileFolder().Path, name))
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Parse(time.RFC3339, strings.TrimSpace(string(b)))
+}
+
+// runHeartbeat writes the heartbeat file until ctx is done so that a hung
+// daemon can be told apart from one that has nothing to do
+func runHeartbeat(ctx context.Context) {
+	t := time.NewTicker(heartbeatInterval)
+	defer t.Stop()
+	for {
+		if err := writeStateTime(heartbeatFile, time.Now()); err != nil {
+			fmt.Fprintf(stdout, "failed to write heartbeat: %v\n", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+func healthcheckAction(c *cli.Context) error {
+	last, err := readStateTime(lastSuccessFile)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("unhealthy: no successful sync yet")
+	}
+	if err != nil {
+		return fmt.Errorf("unhealthy: %w", err)
+	}
+	if age := time.Since(last); age > healthMaxAge {
+		return fmt.Errorf("unhealthy: last successful sync was %s ago", age.Round(time.Second))
+	}
+
+	// the heartbeat is only written in serve mode
+	beat, err := readStateTime(heartbeatFile)
+	if err == nil && time.Since(beat) > 3*heartbeatInterval {
+		return fmt.Errorf("unhealthy: last heartbeat was %s ago", time.Since(beat).Round(time.Second))
+	}
+
+	fmt.Fprintf(stdout, "healthy: last successful sync at %s\n", last.Format(time.RFC3339))
+	return nil
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/satraul/bca-go"
+	"github.com/shopspring/decimal"
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	historyFile     = "history.jsonl"
+	runIDLayout     = "20060102-150405"
+	historyTimeShow = "2006-01-02 15:04:05"
+)
+
+var (
+	historyLimit int
+)
+
+// createdTransaction is a transaction a destination created in a run
+type createdTransaction struct {
+	// ID is the id of the transaction at the destination, if it has one
+	ID       string          `json:"id,omitempty"`
+	ImportID string          `json:"importId"`
+	Date     time.Time       `json:"date"`
+	Payee    string          `json:"payee"`
+	Amount   decimal.Decimal `json:"amount"` // negative for debits
+}
+
+func toCreatedTransaction(trx bca.Entry, importID, id string) createdTransaction {
+	e := toLedgerEntry(trx, importID)
+	return createdTransaction{
+		ID:       id,
+		ImportID: e.ImportID,
+		Date:     e.Date,
+		Payee:    e.Payee,
+		Amount:   e.Amount,
+	}
+}
+
+func newRunSummary() *runSummary {
+	now := time.Now()
+	return &runSummary{ID: now.Format(runIDLayout), Start: now}
+}
+
+// recordHistory appends summary to the run history. failing to do so
+// doesn't fail the run
+func recordHistory(summary *runSummary) {
+	if err := appendHistory(summary); err != nil {
+		fmt.Fprintf(stdout, "failed to update history: %v\n", err)
+	}
+}
+
+func appendHistory(summary *runSummary) error {
+	b, err := json.Marshal(summary)
+	if err != nil {
+		return err
+	}
+
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	f, err := os.OpenFile(filepath.Join(profileFolder().Path, historyFile), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// readHistory returns all recorded runs, oldest first. a line cut short by
+// a crash is skipped
+func readHistory() ([]*runSummary, error) {
+	f, err := os.Open(filepath.Join(profileFolder().Path, historyFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var (
+		runs []*runSummary
+		s    = bufio.NewScanner(f)
+	)
+	s.Buffer(nil, 16*1024*1024)
+	for s.Scan() {
+		var r runSummary
+		if err := json.Unmarshal(s.Bytes(), &r); err != nil {
+			continue
+		}
+		runs = append(runs, &r)
+	}
+	return runs, s.Err()
+}
+
+func findRun(id string) (*runSummary, error) {
+	runs, err := readHistory()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read history: %w", err)
+	}
+	for _, r := range runs {
+		if r.ID == id {
+			return r, nil
+		}
+	}
+	return nil, errors.New("no run with id " + id + ". see history")
+}
+
+func historyAction(c *cli.Context) error {
+	runs, err := readHistory()
+	if err != nil {
+		return fmt.Errorf("failed to read history: %w", err)
+	}
+	if historyLimit > 0 && len(runs) > historyLimit {
+		runs = runs[len(runs)-historyLimit:]
+	}
+
+	w := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "RUN\tSTART\tWINDOW\tFETCHED\tCREATED\tDUPLICATES\tSTATUS\n")
+	for i := len(runs) - 1; i >= 0; i-- {
+		r := runs[i]
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t%d\t%s\n", r.ID, r.Start.Format(historyTimeShow), r.window(), r.Fetched, r.Created, r.Duplicates, r.status())
+	}
+	return w.Flush()
+}
+
+func historyShowAction(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return fmt.Errorf("expected a run id")
+	}
+	r, err := findRun(c.Args().First())
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(stdout, "run %s\n", r.ID)
+	fmt.Fprintf(stdout, "started %s, took %s\n", r.Start.Format(historyTimeShow), r.End.Sub(r.Start).Round(time.Second))
+	fmt.Fprintf(stdout, "window %s\n", r.window())
+	fmt.Fprintf(stdout, "fetched %d, created %d, duplicates %d, adjusted %t\n", r.Fetched, r.Created, r.Duplicates, r.Adjusted)
+	if r.Error != "" {
+		fmt.Fprintf(stdout, "error: %s\n", r.Error)
+	}
+	if r.Undone {
+		fmt.Fprintf(stdout, "undone %s\n", r.UndoneAt.Format(historyTimeShow))
+	}
+	for _, d := range r.Destinations {
+		fmt.Fprintf(stdout, "\n%s: created %d, duplicates %d\n", d.Name, d.Created, d.Duplicates)
+		if d.Error != "" {
+			fmt.Fprintf(stdout, "error: %s\n", d.Error)
+		}
+		if d.AdjustmentError != "" {
+			fmt.Fprintf(stdout, "adjustment error: %s\n", d.AdjustmentError)
+		}
+		if err := printTransactions(stdout, d.Transactions); err != nil {
+			return err
+		}
+		if len(d.DuplicateTransactions) > 0 {
+			fmt.Fprintln(stdout, "already there:")
+			if err := printTransactions(stdout, d.DuplicateTransactions); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (r *runSummary) window() string {
+	if r.From.IsZero() {
+		return "-"
+	}
+	return r.From.Format(dateLayout) + " to " + r.To.Format(dateLayout)
+}
+
+func (r *runSummary) status() string {
+	switch {
+	case r.Undone:
+		return "undone"
+	case r.Error != "":
+		return "failed"
+	}
+	for _, d := range r.Destinations {
+		if d.AdjustmentError != "" {
+			return "adjustment failed"
+		}
+	}
+	return "ok"
+}
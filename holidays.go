@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// clearingCutoff is the hour after which klikbca transactions clear on
+	// the next business day
+	clearingCutoff = 22
+)
+
+var (
+	holidaysPath string
+
+	// bankHolidays are the indonesian public holidays and cuti bersama
+	// (collective leave) days on which banks don't clear transactions.
+	// --holidays adds the dates of later years
+	bankHolidays = holidaySet(
+		// 2024
+		"2024-01-01", "2024-02-08", "2024-02-09", "2024-02-10", "2024-03-11", "2024-03-12",
+		"2024-03-29", "2024-04-08", "2024-04-09", "2024-04-10", "2024-04-11", "2024-04-12",
+		"2024-04-15", "2024-05-01", "2024-05-09", "2024-05-10", "2024-05-23", "2024-05-24",
+		"2024-06-17", "2024-06-18", "2024-09-16", "2024-12-25", "2024-12-26",
+		// 2025
+		"2025-01-01", "2025-01-27", "2025-01-28", "2025-01-29", "2025-03-28", "2025-03-31",
+		"2025-04-01", "2025-04-02", "2025-04-03", "2025-04-04", "2025-04-07", "2025-04-18",
+		"2025-05-01", "2025-05-12", "2025-05-13", "2025-05-29", "2025-05-30", "2025-06-06",
+		"2025-06-09", "2025-06-27", "2025-08-18", "2025-09-05", "2025-12-25", "2025-12-26",
+		// 2026
+		"2026-01-01", "2026-01-16", "2026-02-16", "2026-02-17", "2026-03-18", "2026-03-19",
+		"2026-03-20", "2026-03-23", "2026-03-24", "2026-04-03", "2026-05-01", "2026-05-14",
+		"2026-05-15", "2026-05-27", "2026-05-28", "2026-06-01", "2026-06-16", "2026-08-17",
+		"2026-08-25", "2026-12-24", "2026-12-25",
+	)
+)
+
+func holidaySet(dates ...string) map[string]bool {
+	set := make(map[string]bool, len(dates))
+	for _, d := range dates {
+		set[d] = true
+	}
+	return set
+}
+
+// loadHolidays adds the dates of --holidays, one YYYY-MM-DD per line.
+// empty lines and lines starting with # are skipped
+func loadHolidays() error {
+	if holidaysPath == "" {
+		return nil
+	}
+	f, err := os.Open(holidaysPath)
+	if err != nil {
+		return fmt.Errorf("failed to open --holidays: %w", err)
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		// anything after the date, like the holiday's name, is ignored
+		date := strings.Fields(line)[0]
+		if _, err := time.Parse(dateLayout, date); err != nil {
+			return fmt.Errorf("%s line %d: invalid date %q", holidaysPath, n, date)
+		}
+		bankHolidays[date] = true
+	}
+	return s.Err()
+}
+
+// isBusinessDay tells whether banks clear transactions on day
+func isBusinessDay(day time.Time) bool {
+	if day.Weekday() == time.Saturday || day.Weekday() == time.Sunday {
+		return false
+	}
+	return !bankHolidays[day.Format(dateLayout)]
+}
+
+// nextBusinessDay returns the first business day after day
+func nextBusinessDay(day time.Time) time.Time {
+	for {
+		day = day.AddDate(0, 0, 1)
+		if isBusinessDay(day) {
+			return day
+		}
+	}
+}
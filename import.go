@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/satraul/bca-go"
+	"github.com/shopspring/decimal"
+	"github.com/urfave/cli/v2"
+)
+
+var (
+	statementPeriod = regexp.MustCompile(`(\d{2}/\d{2}/\d{4})\s*-\s*(\d{2}/\d{2}/\d{4})`)
+	// statementDate tells transaction rows apart from headers and summaries
+	statementDate   = regexp.MustCompile(`^\d{1,4}[/ -]\d`)
+	statementAmount = regexp.MustCompile(`^([\d,]+\.\d{2})\s*(DB|CR)?$`)
+)
+
+func importAction(c *cli.Context) error {
+	if c.NArg() == 0 {
+		return errors.New("expected at least one e-statement file")
+	}
+	if err := validateImportFormat(); err != nil {
+		return err
+	}
+
+	trxs := make([]bca.Entry, 0)
+	for _, path := range c.Args().Slice() {
+		ts, err := readStatementFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		trxs = append(trxs, ts...)
+	}
+	fmt.Fprintf(stdout, "%d bca transaction(s) read from %d file(s)\n", len(trxs), c.NArg())
+	trxs = filterTransactions(trxs)
+	trxs, err := transformTransactions(c.Context, trxs)
+	if err != nil {
+		return err
+	}
+
+	config, err := getOrDeleteConfig(username, password, token, false, noninteractive, reset, nostore)
+	if err != nil {
+		return err
+	}
+	if config == nil {
+		return nil
+	}
+
+	// the balance at the time of a historical statement isn't known
+	noadjust = true
+
+	ctx := c.Context
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	unlock, err := lockProfile()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	summary := newRunSummary()
+	summary.Source = walletSource(importFormat)
+	summary.Fetched = len(trxs)
+	err = pushTransactions(ctx, destinations(), config, bca.Balance{}, nil, trxs, summary)
+	recordLedger(summary)
+	summary.End = time.Now()
+	if err != nil {
+		summary.Error = err.Error()
+	}
+	recordHistory(summary)
+	return err
+}
+
+func readStatementFile(path string) ([]bca.Entry, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		if importFormat != formatKlikBCA {
+			return parseWalletCSV(f)
+		}
+		return parseStatementCSV(f)
+	case ".pdf":
+		return nil, errors.New("pdf e-statements are not supported yet, download the statement as csv from klikbca instead")
+	default:
+		return nil, fmt.Errorf("unknown e-statement format %q", filepath.Ext(path))
+	}
+}
+
+// parseStatementCSV parses a klikbca account statement csv download. rows
+// only carry day and month, the year is taken from the statement period
+func parseStatementCSV(r io.Reader) ([]bca.Entry, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.LazyQuotes = true
+
+	var (
+		end  time.Time
+		trxs = make([]bca.Entry, 0)
+	)
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		for i := range record {
+			record[i] = strings.TrimSpace(strings.Trim(record[i], "'"))
+		}
+
+		if m := statementPeriod.FindStringSubmatch(strings.Join(record, ",")); m != nil {
+			end, err = time.ParseInLocation("02/01/2006", m[2], time.Local)
+			if err != nil {
+				return nil, fmt.Errorf("invalid statement period: %w", err)
+			}
+			continue
+		}
+		if len(record) < 4 {
+			continue
+		}
+
+		trx, ok, err := parseStatementRow(record, end)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			trxs = append(trxs, trx)
+		}
+	}
+	return trxs, nil
+}
+
+func parseStatementRow(record []string, end time.Time) (bca.Entry, bool, error) {
+	var (
+		trx   bca.Entry
+		dates = newStatementDates()
+	)
+
+	if !dates.isPending(record[0]) {
+		if !statementDate.MatchString(record[0]) {
+			// header, summary or blank row
+			return trx, false, nil
+		}
+		date, err := dates.parse(record[0], end)
+		if err != nil {
+			return trx, false, fmt.Errorf("invalid transaction date: %w", err)
+		}
+		trx.Date = date
+	}
+
+	m := statementAmount.FindStringSubmatch(record[3])
+	if m == nil {
+		return trx, false, fmt.Errorf("invalid transaction amount %q", record[3])
+	}
+	amount, err := decimal.NewFromString(strings.ReplaceAll(m[1], ",", ""))
+	if err != nil {
+		return trx, false, fmt.Errorf("invalid transaction amount %q: %w", record[3], err)
+	}
+	trx.Amount = amount
+	trx.Type = m[2]
+	if trx.Type == "" && len(record) > 4 {
+		trx.Type = record[4]
+	}
+	if trx.Type != "DB" && trx.Type != "CR" {
+		return trx, false, fmt.Errorf("invalid transaction type %q", trx.Type)
+	}
+
+	trx.Description = strings.Join(strings.Fields(record[1]), " ")
+	trx.Payee = trx.Description
+	return trx, true, nil
+}
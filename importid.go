@@ -0,0 +1,329 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cnf/structhash"
+	"github.com/satraul/bca-go"
+	"github.com/urfave/cli/v2"
+	"go.bmvs.io/ynab/api/transaction"
+)
+
+const (
+	importIDStrategyFile = "import-id-strategy"
+
+	// strategyStructhash hashes the whole bca-go entry. it changes whenever
+	// bca-go changes its Entry struct
+	strategyStructhash = "structhash"
+	// strategyYNAB is the YNAB:amount:date:occurrence format of ynab's own
+	// file imports
+	strategyYNAB = "ynab"
+	// strategyFields hashes a fixed list of fields
+	strategyFields = "fields"
+	// strategyReference hashes the bca transfer reference, falling back to
+	// the fields for entries without one
+	strategyReference = "reference"
+
+	fieldsIDPrefix    = "f1_"
+	referenceIDPrefix = "r1_"
+	ynabIDPrefix      = "YNAB:"
+)
+
+var (
+	importIDStrategy   string
+	migrateDryRun      bool
+	strategyDuplicates string
+)
+
+// resolveImportIDStrategy falls back from --import-id to the strategy saved
+// in the profile by migrate-import-ids, and then to structhash
+func resolveImportIDStrategy() error {
+	if importIDStrategy == "" {
+		b, err := ioutil.ReadFile(filepath.Join(profileFolder().Path, importIDStrategyFile))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		importIDStrategy = strings.TrimSpace(string(b))
+	}
+	if importIDStrategy == "" {
+		importIDStrategy = strategyStructhash
+	}
+	return validateImportIDStrategy(importIDStrategy)
+}
+
+func validateImportIDStrategy(s string) error {
+	switch s {
+	case strategyStructhash, strategyYNAB, strategyFields, strategyReference:
+		return nil
+	default:
+		return fmt.Errorf("invalid import id strategy %q, expected structhash, ynab, fields or reference", s)
+	}
+}
+
+// importIDs returns the import ids of trxs with the current strategy.
+// identical entries in trxs, like two coffees bought on the same day, are
+// told apart by their occurrence so that both are created
+func importIDs(trxs []bca.Entry) []string {
+	return importIDsWith(importIDStrategy, trxs)
+}
+
+func importIDsWith(strategy string, trxs []bca.Entry) []string {
+	var (
+		ids         = make([]string, len(trxs))
+		occurrences = make(map[string]int)
+	)
+	for i, trx := range trxs {
+		base := importIDBase(strategy, trx)
+		occurrences[base]++
+		ids[i] = finishImportID(strategy, base, occurrences[base])
+	}
+	return ids
+}
+
+// importIDBase is what identifies trx for strategy, before the occurrence
+func importIDBase(strategy string, trx bca.Entry) string {
+	// pending entries have no date, the predicted clearance date is used
+	if trx.Date.IsZero() {
+		trx.Date = clearDate(time.Now())
+	}
+	date := trx.Date
+	if date.After(time.Now()) {
+		date = time.Now()
+	}
+	milliunits := toMilliunits(signedAmount(trx))
+
+	switch strategy {
+	case strategyYNAB:
+		return fmt.Sprintf("%s%d:%s", ynabIDPrefix, milliunits, date.Format("2006-01-02"))
+	case strategyReference:
+		if ref := enrich(trx).Reference; ref != "" {
+			return fmt.Sprintf("%s%s|%d|%s", referenceIDPrefix, ref, milliunits, date.Format("2006-01-02"))
+		}
+		fallthrough
+	case strategyFields:
+		return fmt.Sprintf("%s%s|%s|%d|%s", fieldsIDPrefix, date.Format("2006-01-02"), trx.Type, milliunits, strings.ToUpper(strings.TrimSpace(trx.Payee)))
+	default:
+		// description unreliable for hash
+		trx.Description = ""
+		id, _ := structhash.Hash(trx, 1)
+		return id
+	}
+}
+
+func finishImportID(strategy, base string, occurrence int) string {
+	switch strategy {
+	case strategyYNAB:
+		return fmt.Sprintf("%s:%d", base, occurrence)
+	case strategyFields, strategyReference:
+		// the base starts with the prefix of what it was built from
+		sum := sha256.Sum256([]byte(fmt.Sprintf("%s|%d", base, occurrence)))
+		return base[:len(fieldsIDPrefix)] + hex.EncodeToString(sum[:])[:32]
+	default:
+		// the first occurrence keeps the plain hash of earlier versions.
+		// ynab import ids are at most 36 characters, too short to append
+		// the occurrence, so later ones are hashed with it
+		if occurrence == 1 {
+			return base
+		}
+		sum := sha256.Sum256([]byte(fmt.Sprintf("%s|%d", base, occurrence)))
+		return importIDPrefix + hex.EncodeToString(sum[:])[:32]
+	}
+}
+
+// isImportID reports whether id was generated by this tool. ids of the ynab
+// strategy look like those of ynab's own file imports, so they only count
+// when it is the current strategy
+func isImportID(id string) bool {
+	for _, p := range []string{importIDPrefix, fieldsIDPrefix, referenceIDPrefix} {
+		if strings.HasPrefix(id, p) {
+			return true
+		}
+	}
+	return importIDStrategy == strategyYNAB && strings.HasPrefix(id, ynabIDPrefix)
+}
+
+// isCurrentImportID reports whether id could have been generated by the
+// current strategy
+func isCurrentImportID(id string) bool {
+	switch importIDStrategy {
+	case strategyYNAB:
+		return strings.HasPrefix(id, ynabIDPrefix)
+	case strategyFields:
+		return strings.HasPrefix(id, fieldsIDPrefix)
+	case strategyReference:
+		return strings.HasPrefix(id, referenceIDPrefix) || strings.HasPrefix(id, fieldsIDPrefix)
+	default:
+		return strings.HasPrefix(id, importIDPrefix)
+	}
+}
+
+// checkStrategyDuplicates finds the payloads matching a ynab transaction
+// of ts imported with another import id strategy, or from a file by ynab,
+// by date, amount and payee. ynab can't tell they are duplicates, so
+// without it switching strategies imports the statement window again. the
+// matches are marked in skip, or flagged red with --strategy-duplicates flag
+func checkStrategyDuplicates(ts []*transaction.Transaction, ps []transaction.PayloadTransaction, skip map[int]bool) {
+	if strategyDuplicates == "create" {
+		return
+	}
+	var others []*transaction.Transaction
+	for _, t := range ts {
+		if !t.Deleted && t.ImportID != nil && !isCurrentImportID(*t.ImportID) {
+			others = append(others, t)
+		}
+	}
+
+	var (
+		matched = make([]bool, len(others))
+		n       int
+	)
+	for i, p := range ps {
+		if skip[i] {
+			continue
+		}
+		for j, t := range others {
+			if matched[j] || t.Amount != p.Amount || !t.Date.Equal(p.Date.Time) {
+				continue
+			}
+			if t.PayeeName != nil && p.PayeeName != nil && !strings.EqualFold(*t.PayeeName, *p.PayeeName) {
+				continue
+			}
+			matched[j] = true
+			n++
+			if strategyDuplicates == "flag" {
+				red := transaction.FlagColorRed
+				ps[i].FlagColor = &red
+			} else {
+				skip[i] = true
+			}
+			break
+		}
+	}
+	if n > 0 {
+		verb := "skipped"
+		if strategyDuplicates == "flag" {
+			verb = "flagged"
+		}
+		fmt.Fprintf(stdout, "%d transaction(s) look like ones imported with another import id strategy and were %s\n", n, verb)
+	}
+}
+
+// ledgerEntryToBCA rebuilds the bca entry of a ledger entry, as far as the
+// import id strategies other than structhash need it
+func ledgerEntryToBCA(e ledgerEntry) bca.Entry {
+	trx := bca.Entry{Date: e.Date, Payee: e.Payee, Description: e.Description, Amount: e.Amount.Abs(), Type: "CR"}
+	if e.Amount.IsNegative() {
+		trx.Type = "DB"
+	}
+	return trx
+}
+
+// migrateImportIDsAction rewrites the import ids of the ynab transactions
+// in the ledger to --to, so that switching strategies doesn't duplicate
+// them, and makes --to the strategy of the profile
+func migrateImportIDsAction(c *cli.Context) error {
+	to := c.String("to")
+	if err := validateImportIDStrategy(to); err != nil {
+		return err
+	}
+	if to == strategyStructhash {
+		return fmt.Errorf("structhash ids can't be rebuilt from the ledger, only migrated away from")
+	}
+	if to == importIDStrategy {
+		return fmt.Errorf("the profile already uses %s import ids", to)
+	}
+
+	entries, err := readLedger()
+	if err != nil {
+		return fmt.Errorf("failed to read ledger: %w", err)
+	}
+	if len(entries) == 0 {
+		return fmt.Errorf("the ledger is empty, nothing to migrate. the ledger is kept by syncs and imports")
+	}
+	trxs := make([]bca.Entry, len(entries))
+	since := entries[0].Date
+	for i, e := range entries {
+		trxs[i] = ledgerEntryToBCA(e)
+		if e.Date.Before(since) {
+			since = e.Date
+		}
+	}
+	newIDs := importIDsWith(to, trxs)
+	mapping := make(map[string]string, len(entries))
+	for i, e := range entries {
+		mapping[e.ImportID] = newIDs[i]
+	}
+
+	config, err := getOrDeleteConfig(username, password, token, false, noninteractive, reset, nostore)
+	if err != nil {
+		return err
+	}
+	if config == nil {
+		return nil
+	}
+	yc, err := newYNABClient(c.Context, config.YNABToken)
+	if err != nil {
+		return err
+	}
+	a, err := getYNABAccount(yc, budget, accountName)
+	if err != nil {
+		return err
+	}
+	ts, err := yc.getTransactions(budget, a.ID, since)
+	if err != nil {
+		return fmt.Errorf("failed to get ynab transactions: %w", err)
+	}
+
+	var targets []*transaction.Transaction
+	for _, t := range ts {
+		if t.Deleted || t.ImportID == nil {
+			continue
+		}
+		if id, ok := mapping[*t.ImportID]; ok && id != *t.ImportID {
+			targets = append(targets, t)
+		}
+	}
+	fmt.Fprintf(stdout, "%d ynab transaction(s) to migrate from %s to %s import ids\n", len(targets), importIDStrategy, to)
+	if migrateDryRun {
+		for _, t := range targets {
+			fmt.Fprintf(stdout, "%s  %s -> %s\n", t.Date.Format(dateLayout), *t.ImportID, mapping[*t.ImportID])
+		}
+		return nil
+	}
+	if err := confirm("rewrite the import ids of %d ynab transaction(s)", len(targets)); err != nil {
+		return err
+	}
+
+	n := 0
+	for _, t := range targets {
+		p := transactionToPayload(t)
+		id := mapping[*t.ImportID]
+		p.ImportID = &id
+		if err := yc.updateTransaction(budget, t.ID, p); err != nil {
+			return fmt.Errorf("failed to migrate ynab transaction %s after %d succeeded. run again to continue: %w", t.ID, n, err)
+		}
+		n++
+	}
+
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	for i := range entries {
+		entries[i].ImportID = newIDs[i]
+	}
+	if err := writeLedger(entries); err != nil {
+		return fmt.Errorf("failed to update ledger: %w", err)
+	}
+	if err := writeFileAtomic(filepath.Join(profileFolder().Path, importIDStrategyFile), []byte(to+"\n"), 0644); err != nil {
+		return err
+	}
+	importIDStrategy = to
+	fmt.Fprintf(stdout, "%d ynab transaction(s) were successfully migrated. the profile now uses %s import ids\n", n, to)
+	return nil
+}
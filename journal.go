@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/satraul/bca-go"
+)
+
+const (
+	commodity = "IDR"
+)
+
+var (
+	journalFormat, journalFile                                  string
+	journalAccount, journalExpenseAccount, journalIncomeAccount string
+)
+
+// journalEntry formats trx as a plaintext accounting transaction
+func journalEntry(trx bca.Entry, importID string) (string, error) {
+	var (
+		p      = toPayloadTransaction(trx, "", importID)
+		date   = p.Date.Format(dateLayout)
+		amount = trx.Amount
+		other  = journalIncomeAccount
+	)
+	if trx.Type == "DB" {
+		amount = amount.Neg()
+		other = journalExpenseAccount
+	}
+
+	var b strings.Builder
+	switch journalFormat {
+	case "hledger":
+		fmt.Fprintf(&b, "%s * %s", date, sanitizeJournal(payeeName(trx)))
+		if trx.Description != "" {
+			fmt.Fprintf(&b, "  ; %s", sanitizeJournal(trx.Description))
+		}
+		fmt.Fprintf(&b, "\n    ; import_id:%s\n", importID)
+		for _, m := range enrichmentMetadata(trx) {
+			fmt.Fprintf(&b, "    ; %s:%s\n", m[0], sanitizeJournal(m[1]))
+		}
+		fmt.Fprintf(&b, "    %s    %s %s\n", journalAccount, amount.StringFixed(2), commodity)
+		fmt.Fprintf(&b, "    %s\n", other)
+	case "beancount":
+		fmt.Fprintf(&b, "%s * %q %q\n", date, payeeName(trx), trx.Description)
+		fmt.Fprintf(&b, "  import_id: %q\n", importID)
+		for _, m := range enrichmentMetadata(trx) {
+			fmt.Fprintf(&b, "  %s: %q\n", m[0], m[1])
+		}
+		fmt.Fprintf(&b, "  %s  %s %s\n", journalAccount, amount.StringFixed(2), commodity)
+		fmt.Fprintf(&b, "  %s\n", other)
+	default:
+		return "", fmt.Errorf("unknown journal format %q, expected hledger or beancount", journalFormat)
+	}
+	return b.String(), nil
+}
+
+// enrichmentMetadata returns the parsed fields of trx that are set as
+// journal metadata key value pairs
+func enrichmentMetadata(trx bca.Entry) [][2]string {
+	e := enrich(trx)
+	var ms [][2]string
+	for _, m := range [][2]string{{"counterparty", e.Counterparty}, {"reference", e.Reference}, {"channel", e.Channel}} {
+		if m[1] != "" {
+			ms = append(ms, m)
+		}
+	}
+	return ms
+}
+
+// sanitizeJournal keeps free text on one line and out of comments
+func sanitizeJournal(s string) string {
+	return strings.NewReplacer("\n", " ", ";", ",").Replace(s)
+}
+
+// pushJournal prints journal entries, or appends those not yet in
+// --journal-file to it
+func pushJournal(ctx context.Context, config *config, bal bca.Balance, auth []*http.Cookie, trxs []bca.Entry) (destinationResult, error) {
+	var existing string
+	if journalFile != "" {
+		b, err := ioutil.ReadFile(journalFile)
+		if err != nil && !os.IsNotExist(err) {
+			return destinationResult{}, err
+		}
+		existing = string(b)
+	}
+
+	var (
+		b      strings.Builder
+		result destinationResult
+	)
+	for i, id := range importIDs(trxs) {
+		trx := trxs[i]
+		if existing != "" && strings.Contains(existing, id) {
+			result.Duplicates++
+			result.DuplicateTransactions = append(result.DuplicateTransactions, toCreatedTransaction(trx, id, ""))
+			continue
+		}
+		entry, err := journalEntry(trx, id)
+		if err != nil {
+			return result, err
+		}
+		b.WriteString("\n" + entry)
+		result.Created++
+		result.Transactions = append(result.Transactions, toCreatedTransaction(trx, id, ""))
+	}
+
+	if journalFile == "" {
+		fmt.Fprint(dataout, b.String())
+		return result, nil
+	}
+	f, err := os.OpenFile(journalFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err == nil {
+		defer f.Close()
+		_, err = f.WriteString(b.String())
+	}
+	if err != nil {
+		// nothing new is known to be in the journal
+		result.Created, result.Transactions = 0, nil
+		return result, err
+	}
+	fmt.Fprintf(stdout, "%d journal entries were successfully appended to %s\n", result.Created, journalFile)
+	return result, f.Sync()
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/satraul/bca-go"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	ledgerFile = "ledger.jsonl"
+)
+
+// ledgerEntry is a fetched bca transaction kept locally for reports
+type ledgerEntry struct {
+	ImportID    string          `json:"importId"`
+	Date        time.Time       `json:"date"`
+	Payee       string          `json:"payee"`
+	Description string          `json:"description"`
+	Amount      decimal.Decimal `json:"amount"` // negative for debits
+	Category    string          `json:"category,omitempty"`
+	enrichment
+}
+
+// toLedgerEntry keeps trx with importID, as given by importIDs
+func toLedgerEntry(trx bca.Entry, importID string) ledgerEntry {
+	p := toPayloadTransaction(trx, "", importID)
+	return ledgerEntry{
+		ImportID:    importID,
+		Date:        p.Date.Time,
+		Payee:       trx.Payee,
+		Description: trx.Description,
+		Amount:      signedAmount(trx),
+		enrichment:  enrich(trx),
+	}
+}
+
+// recordLedger adds the entries a destination created or already had to
+// the ledger as the destination got them, after its type map and with the
+// import id it was sent, and with the categories the destinations
+// assigned. entries no destination took are left to the next run. failing
+// to do so doesn't fail the run
+func recordLedger(summary *runSummary) {
+	var (
+		entries    []ledgerEntry
+		categories = make(map[string]string)
+	)
+	for _, d := range summary.Destinations {
+		entries = append(entries, d.accepted...)
+		for id, c := range d.categories {
+			categories[id] = c
+		}
+	}
+	if err := appendLedger(entries, categories); err != nil {
+		fmt.Fprintf(stdout, "failed to update ledger: %v\n", err)
+	}
+}
+
+func readLedger() ([]ledgerEntry, error) {
+	entries, _, err := readLedgerFile(true)
+	return entries, err
+}
+
+// readLedgerFile reads the ledger. unless strict, corrupt lines are skipped
+// and counted
+func readLedgerFile(strict bool) ([]ledgerEntry, int, error) {
+	f, err := os.Open(filepath.Join(profileFolder().Path, ledgerFile))
+	if os.IsNotExist(err) {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+
+	var (
+		entries = make([]ledgerEntry, 0)
+		corrupt int
+		s       = bufio.NewScanner(f)
+	)
+	for s.Scan() {
+		var e ledgerEntry
+		if err := json.Unmarshal(s.Bytes(), &e); err != nil {
+			if strict {
+				return nil, 0, errors.Wrap(err, "corrupt ledger entry. try state verify --repair")
+			}
+			corrupt++
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries, corrupt, s.Err()
+}
+
+// appendLedger adds the entries not yet in the ledger. categories by import
+// id are filled in for new and existing entries without one
+func appendLedger(add []ledgerEntry, categories map[string]string) error {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+
+	entries, err := readLedger()
+	if err != nil {
+		return err
+	}
+
+	index := make(map[string]int, len(entries))
+	for i, e := range entries {
+		index[e.ImportID] = i
+	}
+	for _, e := range add {
+		if _, ok := index[e.ImportID]; ok {
+			continue
+		}
+		index[e.ImportID] = len(entries)
+		entries = append(entries, e)
+	}
+	for id, c := range categories {
+		if i, ok := index[id]; ok && entries[i].Category == "" {
+			entries[i].Category = c
+		}
+	}
+
+	return writeLedger(entries)
+}
+
+func writeLedger(entries []ledgerEntry) error {
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	for _, e := range entries {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+	return writeFileAtomic(filepath.Join(profileFolder().Path, ledgerFile), b.Bytes(), 0600)
+}
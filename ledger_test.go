@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/satraul/bca-go"
+)
+
+// fakeDestination creates the entries of trxs at the indices in create and
+// fails with err
+func fakeDestination(name string, create []int, err error) destination {
+	return destination{name, func(ctx context.Context, config *config, bal bca.Balance, auth []*http.Cookie, trxs []bca.Entry) (destinationResult, error) {
+		var result destinationResult
+		ids := importIDs(trxs)
+		for _, i := range create {
+			result.Transactions = append(result.Transactions, toCreatedTransaction(trxs[i], ids[i], ""))
+		}
+		result.Created = len(create)
+		return result, err
+	}}
+}
+
+func TestRecordLedgerOnlyKeepsPushedEntries(t *testing.T) {
+	useDefaults(t)
+	ds := []destination{
+		fakeDestination("csv", []int{0, 2}, nil),
+		fakeDestination("firefly", []int{1}, errors.New("firefly is down")),
+	}
+	summary := newRunSummary()
+	if err := pushTransactions(context.Background(), ds, &config{}, bca.Balance{}, nil, statement, summary); err == nil {
+		t.Fatal("pushTransactions succeeded with a failing destination")
+	}
+	recordLedger(summary)
+
+	entries, err := readLedger()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := importIDs(statement)
+	want := map[string]bool{ids[0]: true, ids[1]: true, ids[2]: true}
+	if len(entries) != len(want) {
+		t.Fatalf("ledger has %d entries, want %d", len(entries), len(want))
+	}
+	for _, e := range entries {
+		if !want[e.ImportID] {
+			t.Errorf("ledger has %s, which no destination took", e.ImportID)
+		}
+	}
+}
+
+func TestRecordLedgerWithoutDestinations(t *testing.T) {
+	useDefaults(t)
+	summary := newRunSummary()
+	summary.Destinations = []destinationResult{{Name: "ynab", Error: "unauthorized"}}
+	recordLedger(summary)
+
+	entries, err := readLedger()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("ledger has %d entries after a failed push, want none", len(entries))
+	}
+}
+
+func TestRecordLedgerKeepsMappedEntries(t *testing.T) {
+	useDefaults(t)
+	defer func(m typeMapping) { entryTypes = m }(entryTypes)
+	// bunga is pushed as a debit to csv
+	entryTypes = typeMapping{Destinations: map[string]typeMapping{
+		"csv": {Descriptions: map[string]string{"BUNGA": mapDebit}},
+	}}
+	trxs := statement[4:5]
+	summary := newRunSummary()
+	if err := pushTransactions(context.Background(), []destination{fakeDestination("csv", []int{0}, nil)}, &config{}, bca.Balance{}, nil, trxs, summary); err != nil {
+		t.Fatal(err)
+	}
+	recordLedger(summary)
+
+	entries, err := readLedger()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mapped, _ := mapTypes("csv", trxs)
+	want := importIDs(mapped)[0]
+	if len(entries) != 1 || entries[0].ImportID != want || !entries[0].Amount.IsNegative() {
+		t.Fatalf("ledger = %+v, want the debit pushed with import id %s", entries, want)
+	}
+	if want == importIDs(trxs)[0] {
+		t.Errorf("the mapped entry has the import id of the fetched one, the test doesn't cover the type map")
+	}
+}
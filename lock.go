@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	lockFile         = "lock"
+	lockPollInterval = time.Second
+)
+
+var (
+	lockWait time.Duration
+
+	errLocked = errors.New("locked")
+
+	// profileLockMu guards the lock of this process, which is held once
+	// for nested callers like a sync fetching from klikbca
+	profileLockMu    sync.Mutex
+	profileLockCount int
+	profileLockFile  *os.File
+)
+
+// lockProfile takes the lock of the profile, so that overlapping cron runs
+// or a manual run during serve don't both log in to klikbca, which ends the
+// other session, or push the same transactions twice. it waits up to
+// --lock-wait for another run to finish. the lock is released by the
+// returned func, or by the os if the process dies
+func lockProfile() (func(), error) {
+	profileLockMu.Lock()
+	defer profileLockMu.Unlock()
+	if profileLockCount == 0 {
+		f, err := acquireLock(filepath.Join(profileFolder().Path, lockFile))
+		if err != nil {
+			return nil, err
+		}
+		profileLockFile = f
+	}
+	profileLockCount++
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			profileLockMu.Lock()
+			defer profileLockMu.Unlock()
+			profileLockCount--
+			if profileLockCount == 0 {
+				releaseLock(profileLockFile)
+				profileLockFile = nil
+			}
+		})
+	}, nil
+}
+
+func acquireLock(path string) (*os.File, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(lockWait)
+	for {
+		f, err := tryLock(path)
+		if err == nil {
+			// the pid is only informational, the os lock is what counts
+			f.Truncate(0)
+			f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+			return f, nil
+		}
+		if err != errLocked {
+			return nil, fmt.Errorf("failed to lock profile: %w", err)
+		}
+		if time.Now().After(deadline) {
+			return nil, withExitCode(fmt.Errorf("another run of profile %s is in progress%s. use --lock-wait to wait for it", profile, lockHolder(path)), exitLocked)
+		}
+		time.Sleep(lockPollInterval)
+	}
+}
+
+// lockHolder describes the process holding the lock at path, if known
+func lockHolder(path string) string {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	if pid := strings.TrimSpace(string(b)); pid != "" {
+		return " (pid " + pid + ")"
+	}
+	return ""
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// errorSharingViolation is returned when another process has the file open
+const errorSharingViolation syscall.Errno = 32
+
+// tryLock opens path without sharing it, which windows refuses while another
+// process has it open
+func tryLock(path string) (*os.File, error) {
+	p, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, err
+	}
+	h, err := syscall.CreateFile(p, syscall.GENERIC_READ|syscall.GENERIC_WRITE, 0, nil, syscall.OPEN_ALWAYS, syscall.FILE_ATTRIBUTE_NORMAL, 0)
+	if err == errorSharingViolation {
+		return nil, errLocked
+	}
+	if err != nil {
+		return nil, err
+	}
+	return os.NewFile(uintptr(h), path), nil
+}
+
+func releaseLock(f *os.File) {
+	f.Close()
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+const (
+	redacted = "[redacted]"
+	// minSecretLength avoids redacting short strings that are likely to
+	// appear by chance
+	minSecretLength = 4
+)
+
+var (
+	// stdout and stderr redact secrets and account numbers from everything
+	// written to them. all output goes through them instead of os.Stdout
+	// and os.Stderr
+	stdout io.Writer = &redactingWriter{w: os.Stdout}
+	stderr io.Writer = &redactingWriter{w: os.Stderr}
+
+	secretsMu sync.RWMutex
+	secrets   []string
+)
+
+// registerSecret makes s redacted from all output
+func registerSecret(s string) {
+	if len(s) < minSecretLength {
+		return
+	}
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+	for _, existing := range secrets {
+		if existing == s {
+			return
+		}
+	}
+	secrets = append(secrets, s)
+}
+
+// redact replaces registered secrets and masks account numbers in s
+func redact(s string) string {
+	secretsMu.RLock()
+	for _, secret := range secrets {
+		s = strings.ReplaceAll(s, secret, redacted)
+	}
+	secretsMu.RUnlock()
+	return maskAccountNumbers(s)
+}
+
+type redactingWriter struct {
+	w io.Writer
+}
+
+func (r *redactingWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(r.w, redact(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
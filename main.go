@@ -5,22 +5,35 @@ import (
 	"encoding/csv"
 	"fmt"
 	"io"
-	"log" // TODO Implement https://godoc.org/github.com/apex/log/handlers/cli
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"go.bmvs.io/ynab/api/transaction"
 
 	"github.com/gocarina/gocsv"
 	"github.com/satraul/bca-go"
-	"go.bmvs.io/ynab"
+	"github.com/shopspring/decimal"
 
 	"github.com/pkg/errors"
 	"github.com/shibukawa/configdir"
 	"github.com/urfave/cli/v2"
 )
 
+const (
+	dateLayout       = "2006-01-02"
+	maxStatementDays = 27
+	logoutTimeout    = 15 * time.Second
+	defaultProfile   = "default"
+
+	modeSync        = "sync"
+	modeBalanceOnly = "balance-only"
+)
+
 var (
 	errEmpty               = errors.New("empty input")
 	errEmptyNonInteractive = errors.New("non-interactive but -u, -p and -t or environment variables not set")
@@ -28,20 +41,26 @@ var (
 )
 
 var (
-	noadjust, delete, noninteractive, nostore, reset, csvFlag                bool
-	accountName, budget, password, token, username, fireflyUrl, fireflyToken string
-	days                                                                     int
+	noadjust, delete, noninteractive, nostore, reset, csvFlag, ynabFlag, verbose, credentialsPassphrase, unapproved, obfuscate                                                                                      bool
+	accountName, budget, password, token, username, fireflyUrl, fireflyToken, flagName, from, to, ynabClientName, profile, credentialsKey, notifyWebhook, cleared, flagColor, bcaAccountNumber, configDir, syncMode string
+	days, fetchConcurrency                                                                                                                                                                                          int
+	timeout, fetchDelay                                                                                                                                                                                             time.Duration
+	since                                                                                                                                                                                                           string
 )
 
 func main() {
 	time.Local = time.FixedZone("WIB", +7*60*60)
+	cli.ErrWriter = stderr
 
+	cli.VersionPrinter = printVersion
 	app := &cli.App{
+		Writer:               stdout,
+		ErrWriter:            stderr,
 		Compiled:             time.Now(),
 		Copyright:            "(c) 2020 Ahmad Satryaji Aulia",
-		Description:          "Synchronize your BCA transactions with YNAB",
+		Description:          "Synchronize your BCA transactions with YNAB\n\n" + exitCodesHelp(),
 		EnableBashCompletion: true,
-		Version:              "v1.3.4",
+		Version:              appVersion(),
 		Flags: []cli.Flag{
 			&cli.StringFlag{
 				Name:        "username",
@@ -59,6 +78,23 @@ func main() {
 				EnvVars:     []string{"BCA_PASSWORD"},
 				DefaultText: "-",
 			},
+			&cli.BoolFlag{
+				Name:        "password-stdin",
+				Usage:       "read the klikbca password from stdin",
+				Destination: &passwordStdin,
+			},
+			&cli.StringFlag{
+				Name:        "password-file",
+				Usage:       "read the klikbca password from a file, e.g. a docker secret or systemd credential",
+				EnvVars:     []string{"BCA_PASSWORD_FILE"},
+				Destination: &passwordFile,
+			},
+			&cli.StringFlag{
+				Name:        "bca-account-number",
+				Usage:       "account to sync when the klikbca login has several linked accounts, the default one otherwise. can be set from environment variable",
+				EnvVars:     []string{"BCA_ACCOUNT_NUMBER"},
+				Destination: &bcaAccountNumber,
+			},
 			&cli.StringFlag{
 				Name:        "token",
 				Aliases:     []string{"t"},
@@ -67,6 +103,32 @@ func main() {
 				EnvVars:     []string{"YNAB_TOKEN"},
 				DefaultText: "-",
 			},
+			&cli.StringFlag{
+				Name:        "token-file",
+				Usage:       "read the ynab personal access token from a file, e.g. a docker secret or systemd credential",
+				EnvVars:     []string{"YNAB_TOKEN_FILE"},
+				Destination: &tokenFile,
+			},
+			&cli.StringFlag{
+				Name:        "profile",
+				Aliases:     []string{"P"},
+				Value:       defaultProfile,
+				Usage:       "profile to store credentials and state under. use one profile per bca account",
+				EnvVars:     []string{"BCA_SYNC_PROFILE"},
+				Destination: &profile,
+			},
+			&cli.StringFlag{
+				Name:        "config",
+				Usage:       "directory to keep profiles in instead of the user configuration folder, e.g. a mounted volume in containers",
+				EnvVars:     []string{"BCA_SYNC_CONFIG"},
+				Destination: &configDir,
+			},
+			&cli.StringFlag{
+				Name:        "preferences",
+				Usage:       "non-secret preferences file, used for flags that aren't set. defaults to preferences.json in the profile",
+				EnvVars:     []string{"BCA_SYNC_PREFERENCES"},
+				Destination: &preferencesPath,
+			},
 			&cli.StringFlag{
 				Name:        "account",
 				Aliases:     []string{"a"},
@@ -81,6 +143,54 @@ func main() {
 				Usage:       "ynab budget ID",
 				Destination: &budget,
 			},
+			&cli.StringSliceFlag{
+				Name:  "ynab-target",
+				Usage: "another ynab account to push to, as budget:account. can be repeated",
+			},
+			&cli.BoolFlag{
+				Name:        "create-account",
+				Usage:       "create ynab and firefly accounts that don't exist, with the bca balance as opening balance",
+				Destination: &createAccount,
+			},
+			&cli.StringFlag{
+				Name:        "account-type",
+				Value:       "checking",
+				Usage:       "type of accounts created by --create-account: checking, savings or cash",
+				Destination: &accountType,
+			},
+			&cli.StringFlag{
+				Name:        "rounding",
+				Value:       roundHalfEven,
+				Usage:       "rounding of amounts finer than a thousandth for ynab: half-even, half-up, down, floor or ceil",
+				Destination: &rounding,
+			},
+			&cli.StringFlag{
+				Name:        "mode",
+				Value:       modeSync,
+				Usage:       "sync, or balance-only to only adjust the ynab or firefly balance to the bca balance, for tracking accounts like deposits",
+				Destination: &syncMode,
+			},
+			&cli.BoolFlag{
+				Name:        "review",
+				Usage:       "review the fetched transactions before pushing them, excluding or editing their payee, memo and category",
+				Destination: &reviewFlag,
+			},
+			&cli.BoolFlag{
+				Name:        "no-learn-payees",
+				Usage:       "don't remember the payees renamed in --review for the next runs",
+				Destination: &noLearnPayees,
+			},
+			&cli.StringFlag{
+				Name:        "import-id",
+				Usage:       "import id strategy: structhash, ynab, fields or reference. defaults to the one set by migrate-import-ids, or structhash",
+				Destination: &importIDStrategy,
+			},
+			&cli.StringFlag{
+				Name:        "strategy-duplicates",
+				Value:       "skip",
+				Usage:       "what to do with transactions matching ynab ones imported with another import id strategy by date, amount and payee: skip, flag or create",
+				Destination: &strategyDuplicates,
+			},
 			&cli.BoolFlag{
 				Name:        "reset",
 				Aliases:     []string{"r"},
@@ -95,18 +205,123 @@ func main() {
 				Usage:       "delete credentials",
 				Destination: &delete,
 			},
+			&cli.BoolFlag{
+				Name:        "yes",
+				Aliases:     []string{"y"},
+				Usage:       "don't ask for confirmation before destructive actions, like -d, undo and cleanup",
+				Destination: &assumeYes,
+			},
+			&cli.StringFlag{
+				Name:        "flag-name",
+				Usage:       "ynab flag name for created transactions, if supported by the ynab api",
+				Destination: &flagName,
+			},
+			&cli.BoolFlag{
+				Name:        "unapproved",
+				Value:       false,
+				Usage:       "create ynab transactions unapproved, to review them in ynab first",
+				Destination: &unapproved,
+			},
+			&cli.StringFlag{
+				Name:        "cleared",
+				Value:       "cleared",
+				Usage:       "ynab clearing status of created transactions, cleared, uncleared or reconciled",
+				Destination: &cleared,
+			},
+			&cli.StringFlag{
+				Name:        "flag-color",
+				Usage:       "ynab flag color of created transactions, e.g. blue",
+				Destination: &flagColor,
+			},
+			&cli.StringFlag{
+				Name:        "scheduled",
+				Value:       "off",
+				Usage:       "match ynab scheduled transactions of the account by amount and skip the bca transactions they account for: off, skip or approve (also approve the entered ones)",
+				Destination: &scheduledMatch,
+			},
+			&cli.StringSliceFlag{
+				Name:  "transfer-account",
+				Usage: "ynab account synced by another pipeline, like a credit card paid from bca. opposite transactions of the two are paired into ynab transfers. can be repeated",
+			},
+			&cli.IntFlag{
+				Name:        "transfer-window",
+				Value:       2,
+				Usage:       "days apart the two sides of a --transfer-account transfer may be",
+				Destination: &transferWindow,
+			},
+			&cli.StringFlag{
+				Name:        "transfer-tolerance",
+				Usage:       "amount the two sides of a --transfer-account transfer may differ by, like a fee",
+				Destination: &transferTolerance,
+			},
+			&cli.BoolFlag{
+				Name:        "memo-marker",
+				Usage:       "append a [bca-sync YYYY-MM-DD] marker with the sync date to ynab memos. strip them with cleanup memo-markers",
+				Destination: &memoMarkerFlag,
+			},
+			&cli.StringFlag{
+				Name:        "ynab-client",
+				Value:       "lib",
+				Usage:       "ynab client implementation, lib (go.bmvs.io/ynab) or native. only native requests are rate limit aware",
+				Destination: &ynabClientName,
+			},
 			&cli.BoolFlag{
 				Name:        "no-adjust",
 				Value:       false,
 				Usage:       "don't create balance adjustment if applicable after creating transactions",
 				Destination: &noadjust,
 			},
+			&cli.StringFlag{
+				Name:        "adjust-payee",
+				Value:       "Automated Balance Adjustment",
+				Usage:       "payee of ynab balance adjustments",
+				Destination: &adjustPayee,
+			},
+			&cli.StringFlag{
+				Name:        "adjust-category",
+				Value:       "Inflows",
+				Usage:       "category of ynab balance adjustments. empty leaves them uncategorized",
+				Destination: &adjustCategory,
+			},
+			&cli.StringFlag{
+				Name:        "adjust-memo",
+				Usage:       "memo of ynab balance adjustments",
+				Destination: &adjustMemo,
+			},
+			&cli.BoolFlag{
+				Name:        "adjust-unapproved",
+				Usage:       "create ynab balance adjustments unapproved",
+				Destination: &adjustUnapproved,
+			},
+			&cli.StringFlag{
+				Name:        "adjust-cleared",
+				Value:       "reconciled",
+				Usage:       "ynab clearing status of balance adjustments, cleared, uncleared or reconciled",
+				Destination: &adjustCleared,
+			},
+			&cli.StringFlag{
+				Name:        "adjust-threshold",
+				Usage:       "don't create balance adjustments smaller than this amount",
+				Destination: &adjustThreshold,
+			},
 			&cli.BoolFlag{
 				Name:        "no-store",
 				Value:       false,
 				Usage:       "don't store credentials",
 				Destination: &nostore,
 			},
+			&cli.StringFlag{
+				Name:        "credentials-key",
+				Usage:       "encrypt the stored credentials with the contents of this key file",
+				EnvVars:     []string{"BCA_SYNC_CREDENTIALS_KEY"},
+				Destination: &credentialsKey,
+			},
+			&cli.BoolFlag{
+				Name:        "credentials-passphrase",
+				Value:       false,
+				Usage:       "encrypt the stored credentials with a passphrase read from stdin",
+				Destination: &credentialsPassphrase,
+			},
 			&cli.BoolFlag{
 				Name:        "non-interactive",
 				Value:       false,
@@ -119,6 +334,12 @@ func main() {
 				Usage:       "instead of creating ynab transactions, generate a csv",
 				Destination: &csvFlag,
 			},
+			&cli.BoolFlag{
+				Name:        "ynab",
+				Value:       false,
+				Usage:       "also create ynab transactions when used with --csv, --firefly-url, --journal or --sheet",
+				Destination: &ynabFlag,
+			},
 			&cli.StringFlag{
 				Name:        "firefly-url",
 				Aliases:     []string{"f"},
@@ -131,6 +352,101 @@ func main() {
 				Usage:       "firefly iii oauth token for use with -f / --firefly-url",
 				Destination: &fireflyToken,
 			},
+			&cli.StringFlag{
+				Name:        "firefly-token-file",
+				Usage:       "read the firefly iii oauth token from a file",
+				EnvVars:     []string{"FIREFLY_TOKEN_FILE"},
+				Destination: &fireflyTokenFile,
+			},
+			&cli.IntFlag{
+				Name:        "firefly-concurrency",
+				Value:       4,
+				Usage:       "number of firefly transactions created at once",
+				Destination: &fireflyConcurrency,
+			},
+			&cli.StringFlag{
+				Name:        "firefly-currency",
+				Usage:       "currency code of the firefly transactions and account, for a firefly whose default currency isn't IDR (default: IDR)",
+				EnvVars:     []string{"FIREFLY_CURRENCY"},
+				Destination: &fireflyCurrency,
+			},
+			&cli.StringFlag{
+				Name:        "firefly-reconcile-threshold",
+				Usage:       "don't create firefly reconciliations smaller than this amount",
+				Destination: &fireflyReconcileThreshold,
+			},
+			&cli.BoolFlag{
+				Name:        "firefly-reconcile-dry-run",
+				Usage:       "print the difference a firefly reconciliation would book instead of creating it",
+				Destination: &fireflyReconcileDryRun,
+			},
+			&cli.StringFlag{
+				Name:        "journal",
+				Usage:       "instead of creating ynab transactions, write plaintext accounting entries, hledger or beancount",
+				Destination: &journalFormat,
+			},
+			&cli.StringFlag{
+				Name:        "journal-file",
+				Usage:       "append journal entries not yet in this file instead of printing them",
+				Destination: &journalFile,
+			},
+			&cli.StringFlag{
+				Name:        "journal-account",
+				Value:       "Assets:Bank:BCA",
+				Usage:       "journal account of the bca account",
+				Destination: &journalAccount,
+			},
+			&cli.StringFlag{
+				Name:        "journal-expense-account",
+				Value:       "Expenses:Uncategorized",
+				Usage:       "journal account debits are booked against",
+				Destination: &journalExpenseAccount,
+			},
+			&cli.StringFlag{
+				Name:        "journal-income-account",
+				Value:       "Income:Uncategorized",
+				Usage:       "journal account credits are booked against",
+				Destination: &journalIncomeAccount,
+			},
+			&cli.StringFlag{
+				Name:        "sheet",
+				Usage:       "instead of creating ynab transactions, append to this google spreadsheet id. new creates one",
+				Destination: &sheetID,
+			},
+			&cli.StringFlag{
+				Name:        "sheet-name",
+				Value:       "Transactions",
+				Usage:       "name of the sheet within the spreadsheet",
+				Destination: &sheetName,
+			},
+			&cli.StringFlag{
+				Name:        "sheet-credentials",
+				Usage:       "google service account key or oauth desktop client json file for --sheet",
+				EnvVars:     []string{"BCA_SYNC_SHEET_CREDENTIALS"},
+				Destination: &sheetCredentials,
+			},
+			&cli.StringSliceFlag{
+				Name:  "date-layout",
+				Usage: "go time layout of statement dates, tried in order. replaces the defaults",
+				Value: cli.NewStringSlice(defaultDateLayouts...),
+			},
+			&cli.StringSliceFlag{
+				Name:  "pending-marker",
+				Usage: "text marking pending statement entries without a date",
+				Value: cli.NewStringSlice(defaultPendingMarkers...),
+			},
+			&cli.StringFlag{
+				Name:        "verify-totals",
+				Value:       "abort",
+				Usage:       "check the parsed bca transactions against the totals of the statement pages: abort, warn or off",
+				Destination: &verifyTotalsMode,
+			},
+			&cli.StringFlag{
+				Name:        "holidays",
+				Usage:       "file of extra bank holidays, one YYYY-MM-DD per line, for predicting when pending transactions clear",
+				EnvVars:     []string{"BCA_HOLIDAYS"},
+				Destination: &holidaysPath,
+			},
 			&cli.IntFlag{
 				Name:        "days",
 				Aliases:     []string{"n"},
@@ -138,17 +454,984 @@ func main() {
 				Usage:       "fetch transactions from n number of days ago (0 to 27 inclusive)",
 				Destination: &days,
 			},
+			&cli.StringFlag{
+				Name:        "notify-webhook",
+				Usage:       "post notifications as json to this url",
+				EnvVars:     []string{"BCA_SYNC_NOTIFY_WEBHOOK"},
+				Destination: &notifyWebhook,
+			},
+			&cli.StringFlag{
+				Name:        "notify-when",
+				Value:       "change",
+				Usage:       "when to send sync results to the notification channels: always, change (new transactions, adjustments or failures) or failure",
+				Destination: &notifyWhen,
+			},
+			&cli.StringFlag{
+				Name:        "ntfy-topic",
+				Usage:       "send notifications to this ntfy.sh topic, or topic url on another ntfy server",
+				EnvVars:     []string{"BCA_SYNC_NTFY_TOPIC"},
+				Destination: &ntfyTopic,
+			},
+			&cli.StringFlag{
+				Name:        "ntfy-token",
+				Usage:       "access token of a protected --ntfy-topic",
+				EnvVars:     []string{"BCA_SYNC_NTFY_TOKEN"},
+				Destination: &ntfyToken,
+			},
+			&cli.StringFlag{
+				Name:        "ntfy-priority",
+				Value:       "default",
+				Usage:       "ntfy priority of notifications: min, low, default, high or max. failures are at least high",
+				Destination: &ntfyPriority,
+			},
+			&cli.StringFlag{
+				Name:        "pushover-token",
+				Usage:       "send notifications with this pushover application token",
+				EnvVars:     []string{"BCA_SYNC_PUSHOVER_TOKEN"},
+				Destination: &pushoverToken,
+			},
+			&cli.StringFlag{
+				Name:        "pushover-user",
+				Usage:       "pushover user or group key to notify",
+				EnvVars:     []string{"BCA_SYNC_PUSHOVER_USER"},
+				Destination: &pushoverUser,
+			},
+			&cli.IntFlag{
+				Name:        "pushover-priority",
+				Usage:       "pushover priority of notifications, -2 to 1. failures are sent with 1",
+				Destination: &pushoverPriority,
+			},
+			&cli.StringFlag{
+				Name:        "email",
+				Usage:       "email a digest of each sync to these comma separated addresses",
+				EnvVars:     []string{"BCA_SYNC_EMAIL"},
+				Destination: &emailTo,
+			},
+			&cli.StringFlag{
+				Name:        "email-from",
+				Usage:       "sender of --email, defaults to --smtp-username",
+				Destination: &emailFrom,
+			},
+			&cli.StringFlag{
+				Name:        "email-when",
+				Value:       "always",
+				Usage:       "when to send --email: always, change (new transactions, adjustments or failures) or failure",
+				Destination: &emailWhen,
+			},
+			&cli.StringFlag{
+				Name:        "smtp-host",
+				Usage:       "smtp server of --email",
+				EnvVars:     []string{"BCA_SYNC_SMTP_HOST"},
+				Destination: &smtpHost,
+			},
+			&cli.IntFlag{
+				Name:        "smtp-port",
+				Value:       587,
+				Usage:       "smtp port of --email",
+				Destination: &smtpPort,
+			},
+			&cli.StringFlag{
+				Name:        "smtp-username",
+				Usage:       "smtp username of --email",
+				EnvVars:     []string{"BCA_SYNC_SMTP_USERNAME"},
+				Destination: &smtpUsername,
+			},
+			&cli.StringFlag{
+				Name:        "smtp-password",
+				Usage:       "smtp password of --email",
+				EnvVars:     []string{"BCA_SYNC_SMTP_PASSWORD"},
+				Destination: &smtpPassword,
+			},
+			&cli.BoolFlag{
+				Name:        "obfuscate",
+				Value:       false,
+				Usage:       "mask account numbers and replace payees with keyed hashes in exports and notifications. reverse with reveal",
+				Destination: &obfuscate,
+			},
+			&cli.StringFlag{
+				Name:        "min-amount",
+				Usage:       "skip transactions below this amount",
+				Destination: &minAmount,
+			},
+			&cli.StringFlag{
+				Name:        "max-amount",
+				Usage:       "skip transactions above this amount",
+				Destination: &maxAmount,
+			},
+			&cli.StringFlag{
+				Name:        "include",
+				Usage:       "only sync transactions whose payee or description match this regular expression",
+				Destination: &includePattern,
+			},
+			&cli.StringFlag{
+				Name:        "exclude",
+				Usage:       "skip transactions whose payee or description match this regular expression",
+				Destination: &excludePattern,
+			},
+			&cli.StringFlag{
+				Name:        "type",
+				Usage:       "only sync transactions of this type, DB or CR",
+				Destination: &onlyType,
+			},
+			&cli.BoolFlag{
+				Name:        "clean-merchants",
+				Usage:       "strip codes and city suffixes from qris and debit card merchant names and title-case them",
+				Destination: &cleanMerchants,
+			},
+			&cli.StringFlag{
+				Name:        "merchant-aliases",
+				Usage:       "json file of merchant name prefixes to payees used by --clean-merchants. defaults to merchant-aliases.json in the profile",
+				Destination: &merchantAliasesPath,
+			},
+			&cli.StringFlag{
+				Name:        "type-map",
+				Usage:       "json file classifying bca entry types and descriptions as debits or credits or skipping them, per destination. defaults to type-map.json in the profile",
+				Destination: &typeMapPath,
+			},
+			&cli.StringFlag{
+				Name:        "transform",
+				Usage:       "executable given the transactions as json lines on stdin, answering with a json line per transaction to change its payee, memo or category, split it or skip it",
+				EnvVars:     []string{"BCA_SYNC_TRANSFORM"},
+				Destination: &transformCommand,
+			},
+			&cli.StringFlag{
+				Name:        "channel",
+				Usage:       "only sync transactions made with this channel, parsed from the description: m-BCA, KlikBCA, ATM or QRIS",
+				Destination: &onlyChannel,
+			},
+			&cli.DurationFlag{
+				Name:        "timeout",
+				Usage:       "give up on a sync after this long, e.g. 5m. 0 waits indefinitely",
+				Destination: &timeout,
+			},
+			&cli.StringFlag{
+				Name:        "record",
+				Usage:       "save klikbca responses to this directory, to be used with --replay",
+				Destination: &recordDir,
+			},
+			&cli.StringFlag{
+				Name:        "replay",
+				Usage:       "answer klikbca requests with the responses saved by --record in this directory, without network access",
+				Destination: &replayDir,
+			},
+			&cli.StringFlag{
+				Name:        "trace-http",
+				Usage:       "append all http requests and responses to this file, with credentials, cookies and tokens redacted",
+				Destination: &traceHTTP,
+			},
+			&cli.StringFlag{
+				Name:        "proxy",
+				Usage:       "http, https or socks5 proxy url for all requests. HTTPS_PROXY is used otherwise",
+				EnvVars:     []string{"BCA_SYNC_PROXY"},
+				Destination: &proxy,
+			},
+			&cli.DurationFlag{
+				Name:        "bca-pace",
+				Value:       time.Second,
+				Usage:       "minimum time between klikbca requests",
+				Destination: &bcaPace,
+			},
+			&cli.DurationFlag{
+				Name:        "bca-jitter",
+				Value:       time.Second,
+				Usage:       "random extra time of up to this between klikbca requests",
+				Destination: &bcaJitter,
+			},
+			&cli.StringFlag{
+				Name:        "user-agent",
+				Usage:       "user agent of klikbca requests, or browser for the user agent and headers of a desktop browser",
+				EnvVars:     []string{"BCA_USER_AGENT"},
+				Destination: &userAgent,
+			},
+			&cli.StringSliceFlag{
+				Name:  "bca-header",
+				Usage: "header of klikbca requests, as Name: value. an empty value removes the header",
+			},
+			&cli.StringFlag{
+				Name:        "public-ip",
+				Usage:       "public ip to send to klikbca instead of looking it up",
+				EnvVars:     []string{"BCA_SYNC_PUBLIC_IP"},
+				Destination: &publicIP,
+			},
+			&cli.StringSliceFlag{
+				Name:  "public-ip-provider",
+				Usage: "urls returning the public ip as plain text, tried in order. replaces the defaults",
+				Value: cli.NewStringSlice(defaultPublicIPProviders...),
+			},
+			&cli.BoolFlag{
+				Name:        "minimal-network",
+				Usage:       "only make the essential klikbca and destination requests, skipping the public ip lookup and ynab capability detection",
+				Destination: &minimalNetwork,
+			},
+			&cli.BoolFlag{
+				Name:        "detect-anomalies",
+				Usage:       "flag new transactions whose amount is unusual for the payee, notifying and keeping them for review anomalies",
+				Destination: &detectAnomalies,
+			},
+			&cli.Float64Flag{
+				Name:        "anomaly-threshold",
+				Value:       3.5,
+				Usage:       "deviations from the payee's median amount above which a transaction is unusual",
+				Destination: &anomalyThreshold,
+			},
+			&cli.BoolFlag{
+				Name:        "quiet",
+				Aliases:     []string{"q"},
+				Usage:       "only print errors and data like --csv, for cron",
+				Destination: &quiet,
+			},
+			&cli.BoolFlag{
+				Name:        "no-color",
+				Usage:       "print without colors. NO_COLOR is also honored, and colors are only used on a terminal",
+				Destination: &noColor,
+			},
+			&cli.BoolFlag{
+				Name:        "verbose",
+				Aliases:     []string{"V"},
+				Value:       false,
+				Usage:       "print extra details, like the remaining ynab api quota",
+				Destination: &verbose,
+			},
+			&cli.StringFlag{
+				Name:        "archive",
+				Usage:       "also write every fetched statement as json and csv to this directory or s3://bucket/prefix, as klikbca only keeps about a month",
+				EnvVars:     []string{"BCA_SYNC_ARCHIVE"},
+				Destination: &archiveLocation,
+			},
+			&cli.StringFlag{
+				Name:        "state-remote",
+				Usage:       "keep the ledger, history and other shared state of the profile in s3://bucket/prefix or a webdav https:// folder, for several machines syncing one account",
+				EnvVars:     []string{"BCA_SYNC_STATE_REMOTE"},
+				Destination: &stateRemote,
+			},
+			&cli.StringFlag{
+				Name:        "s3-endpoint",
+				Aliases:     []string{"archive-endpoint"},
+				Usage:       "endpoint of an s3 compatible service like minio for s3:// locations, instead of aws s3",
+				EnvVars:     []string{"BCA_SYNC_S3_ENDPOINT", "BCA_SYNC_ARCHIVE_ENDPOINT"},
+				Destination: &s3Endpoint,
+			},
+			&cli.DurationFlag{
+				Name:        "lock-wait",
+				Usage:       "how long to wait for another run of the profile to finish before giving up",
+				Destination: &lockWait,
+			},
+			&cli.DurationFlag{
+				Name:        "cache-max-age",
+				Value:       24 * time.Hour,
+				Usage:       "push bca transactions cached by a run whose push failed if they are newer than this, instead of logging in to klikbca. 0 disables the cache",
+				Destination: &cacheMaxAge,
+			},
+			&cli.DurationFlag{
+				Name:        "rerun-window",
+				Value:       5 * time.Minute,
+				Usage:       "skip pushing when klikbca returns the same statement as a successful run less than this ago, like a retried cron job. 0 disables it",
+				Destination: &rerunWindow,
+			},
+			&cli.IntFlag{
+				Name:        "fetch-concurrency",
+				Value:       1,
+				Usage:       "number of statement windows fetched at once when --from spans several windows",
+				Destination: &fetchConcurrency,
+			},
+			&cli.DurationFlag{
+				Name:        "fetch-delay",
+				Value:       time.Second,
+				Usage:       "minimum time between starting statement requests",
+				Destination: &fetchDelay,
+			},
+			&cli.IntFlag{
+				Name:        "ynab-batch-size",
+				Value:       100,
+				Usage:       "maximum number of transactions created in one ynab request. 0 sends them all at once",
+				Destination: &ynabBatchSize,
+			},
+			&cli.DurationFlag{
+				Name:        "ynab-batch-delay",
+				Value:       time.Second,
+				Usage:       "time to wait between ynab requests of a push split in batches",
+				Destination: &ynabBatchDelay,
+			},
+			&cli.BoolFlag{
+				Name:        "all",
+				Usage:       "sync every pipeline of the pipelines file, or every profile without one, each in its own process, and print a combined summary",
+				Destination: &allPipelines,
+			},
+			&cli.StringFlag{
+				Name:        "pipelines",
+				Usage:       "json file of the pipelines synced by --all. defaults to pipelines.json in the config folder",
+				EnvVars:     []string{"BCA_SYNC_PIPELINES"},
+				Destination: &pipelinesPath,
+			},
+			&cli.BoolFlag{
+				Name:        "exit-empty",
+				Usage:       "exit with code 7 if no new transactions were synced",
+				Destination: &exitEmpty,
+			},
+			&cli.StringFlag{
+				Name:        "from",
+				Usage:       "fetch transactions from this date (YYYY-MM-DD) instead of --days. longer ranges are fetched in multiple statement windows",
+				Destination: &from,
+			},
+			&cli.StringFlag{
+				Name:        "to",
+				Usage:       "fetch transactions until this date (YYYY-MM-DD), used with --from",
+				DefaultText: "today",
+				Destination: &to,
+			},
+			&cli.StringFlag{
+				Name:        "since",
+				Usage:       "last to fetch transactions from the day before the last successful sync, up to 27 days ago, instead of --days",
+				Destination: &since,
+			},
+		},
+		Before: func(c *cli.Context) error {
+			recordFlagSources(c)
+			configureOutput()
+			if quiet && (verbose || reviewFlag) {
+				return fmt.Errorf("--quiet can't be used with --verbose or --review")
+			}
+			if profile == "" || strings.ContainsAny(profile, `/\.`) {
+				return fmt.Errorf("invalid profile name %q", profile)
+			}
+			if err := validateConfigDir(); err != nil {
+				return err
+			}
+			if err := readSecretFlags(); err != nil {
+				return err
+			}
+			registerSecret(fireflyToken)
+			registerSecret(credentialsKey)
+			registerSecret(smtpPassword)
+			registerSecret(ntfyToken)
+			registerSecret(pushoverToken)
+			registerSecret(pushoverUser)
+			if err := configureNetwork(); err != nil {
+				return err
+			}
+			if err := configureStateRemote(c.Context); err != nil {
+				return err
+			}
+			if err := applyPreferences(c); err != nil {
+				return err
+			}
+			if err := applyTemplate(c); err != nil {
+				return err
+			}
+			bcaHeaders = c.StringSlice("bca-header")
+			if err := configureBCATransport(); err != nil {
+				return err
+			}
+			if err := loadHolidays(); err != nil {
+				return err
+			}
+			if err := compileFilters(); err != nil {
+				return err
+			}
+			if cleanMerchants {
+				if err := loadMerchantAliases(); err != nil {
+					return err
+				}
+			}
+			if err := loadPayeeAliases(); err != nil {
+				return err
+			}
+			if err := loadTypeMap(); err != nil {
+				return err
+			}
+			publicIPProviders = c.StringSlice("public-ip-provider")
+			dateLayouts = c.StringSlice("date-layout")
+			pendingMarkers = c.StringSlice("pending-marker")
+			var err error
+			if ynabTargets, err = parseYNABTargets(c.StringSlice("ynab-target")); err != nil {
+				return err
+			}
+			transferAccounts = c.StringSlice("transfer-account")
+			if err := validateTransfers(); err != nil {
+				return err
+			}
+			if err := resolveImportIDStrategy(); err != nil {
+				return err
+			}
+			switch journalFormat {
+			case "", "hledger", "beancount":
+			default:
+				return fmt.Errorf("invalid --journal %q, expected hledger or beancount", journalFormat)
+			}
+			switch syncMode {
+			case modeSync:
+			case modeBalanceOnly:
+				if noadjust {
+					return fmt.Errorf("--mode balance-only can't be used with --no-adjust")
+				}
+			default:
+				return fmt.Errorf("invalid --mode %q, expected sync or balance-only", syncMode)
+			}
+			if err := validateEmail(); err != nil {
+				return err
+			}
+			if err := validateNotifiers(); err != nil {
+				return err
+			}
+			return validatePolicy()
 		},
 		Action: actionFunc,
+		// errors exit in main, once After has run, and not in the action
+		ExitErrHandler: func(c *cli.Context, err error) {},
+		// the shared state is pushed once a command is done, whether it failed
+		// or not, as state is written as it changes
+		After: func(c *cli.Context) error {
+			return pushState(context.Background())
+		},
+		Commands: []*cli.Command{
+			{
+				Name:  "serve",
+				Usage: "expose sync over http with POST /sync, GET /status and GET /last-run",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:        "listen",
+						Aliases:     []string{"l"},
+						Value:       "127.0.0.1:8080",
+						Usage:       "address to listen on. only local clients can connect by default, use e.g. :8080 to listen on every interface",
+						Destination: &listen,
+					},
+					&cli.StringFlag{
+						Name:        "serve-token",
+						Usage:       "token clients have to send as Authorization: Bearer <token>",
+						EnvVars:     []string{"BCA_SYNC_SERVE_TOKEN"},
+						Destination: &serveToken,
+					},
+					&cli.DurationFlag{
+						Name:        "interval",
+						Usage:       "also sync on this interval, e.g. 6h. 0 to only sync on POST /sync",
+						Destination: &interval,
+					},
+					&cli.BoolFlag{
+						Name:        "digest",
+						Usage:       "send a weekly digest to the notification channels",
+						Destination: &digest,
+					},
+					&cli.StringFlag{
+						Name:        "digest-day",
+						Value:       "sunday",
+						Usage:       "day of the week to send the digest on",
+						Destination: &digestDay,
+					},
+					&cli.StringFlag{
+						Name:        "digest-time",
+						Value:       "09:00",
+						Usage:       "time of day (HH:MM) to send the digest at",
+						Destination: &digestTime,
+					},
+					&cli.DurationFlag{
+						Name:        "session-ttl",
+						Usage:       "keep the klikbca session logged in this long after a run, to log in less often. other runs of the profile are locked out meanwhile. 0 logs out right away",
+						Destination: &sessionTTL,
+					},
+					&cli.BoolFlag{
+						Name:        "persist-session",
+						Usage:       "write the kept klikbca session to disk, encrypted, so that a restart within --session-ttl doesn't log in again",
+						EnvVars:     []string{"BCA_PERSIST_SESSION"},
+						Destination: &persistSession,
+					},
+				},
+				Action: serveAction,
+			},
+			{
+				Name:  "healthcheck",
+				Usage: "exit non-zero if the last successful sync is too old, for container health checks",
+				Flags: []cli.Flag{
+					&cli.DurationFlag{
+						Name:        "max-age",
+						Value:       25 * time.Hour,
+						Usage:       "maximum age of the last successful sync",
+						Destination: &healthMaxAge,
+					},
+				},
+				Action: healthcheckAction,
+			},
+			{
+				Name:   "archive",
+				Usage:  "fetch the bca statement and only archive it to --archive, without pushing it",
+				Action: archiveAction,
+			},
+			{
+				Name:  "explain",
+				Usage: "trace a single transaction through the pipeline by its import id",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:        "hash",
+						Usage:       "import id (or a unique prefix of it) of the transaction to explain",
+						Required:    true,
+						Destination: &explainHash,
+					},
+				},
+				Action: explainAction,
+			},
+			{
+				Name:      "import",
+				Usage:     "import historical transactions from klikbca e-statement or e-wallet csv downloads",
+				ArgsUsage: "<statement.csv>...",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:        "format",
+						Value:       formatKlikBCA,
+						Usage:       "format of the csv files: klikbca, or the gopay, ovo or shopeepay e-wallet exports",
+						Destination: &importFormat,
+					},
+				},
+				Action: importAction,
+			},
+			{
+				Name:  "balance",
+				Usage: "the bca balances read by past runs",
+				Subcommands: []*cli.Command{
+					{
+						Name:  "history",
+						Usage: "print the balance at the end of every day with a sync, with a sparkline",
+						Flags: []cli.Flag{
+							&cli.IntFlag{
+								Name:        "days",
+								Value:       90,
+								Usage:       "number of days to print, 0 for all",
+								Destination: &balanceDays,
+							},
+							&cli.StringFlag{
+								Name:        "output",
+								Aliases:     []string{"o"},
+								Value:       "table",
+								Usage:       "output format, table, csv or json",
+								Destination: &balanceOutput,
+							},
+						},
+						Action: balanceHistoryAction,
+					},
+				},
+			},
+			{
+				Name:  "report",
+				Usage: "reports from the local ledger of synced transactions. without a subcommand, the totals of a month per category and payee",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:        "month",
+						Usage:       "month to report on (YYYY-MM)",
+						DefaultText: "this month",
+						Destination: &reportMonth,
+					},
+					&cli.IntFlag{
+						Name:        "top",
+						Value:       10,
+						Usage:       "number of payees to list, 0 for all",
+						Destination: &reportTop,
+					},
+					&cli.StringFlag{
+						Name:        "output",
+						Aliases:     []string{"o"},
+						Value:       "table",
+						Usage:       "output format, table, csv or json",
+						Destination: &reportOutput,
+					},
+				},
+				Action: reportMonthAction,
+				Subcommands: []*cli.Command{
+					{
+						Name:  "trends",
+						Usage: "month over month spending per category with 3, 6 and 12 month averages",
+						Flags: []cli.Flag{
+							&cli.IntFlag{
+								Name:        "months",
+								Value:       12,
+								Usage:       "number of months to report on",
+								Destination: &reportMonths,
+							},
+							&cli.StringFlag{
+								Name:        "output",
+								Aliases:     []string{"o"},
+								Value:       "table",
+								Usage:       "output format, table, csv or json",
+								Destination: &reportOutput,
+							},
+						},
+						Action: reportTrendsAction,
+					},
+				},
+			},
+			{
+				Name:  "install-service",
+				Usage: "schedule unattended syncs of the profile with systemd, launchd or the windows task scheduler",
+				Flags: []cli.Flag{
+					&cli.DurationFlag{
+						Name:        "interval",
+						Value:       time.Hour,
+						Usage:       "how often to sync",
+						Destination: &serviceInterval,
+					},
+					&cli.BoolFlag{
+						Name:        "dry-run",
+						Usage:       "print the files and commands instead of installing them",
+						Destination: &serviceDryRun,
+					},
+					&cli.BoolFlag{
+						Name:        "remove",
+						Usage:       "remove the scheduled sync of the profile instead",
+						Destination: &serviceRemove,
+					},
+				},
+				Action: installServiceAction,
+			},
+			{
+				Name:  "get",
+				Usage: "print bca data without pushing it to any destination",
+				Subcommands: []*cli.Command{
+					{
+						Name:  "transactions",
+						Usage: "print the bca transactions of the statement window",
+						Flags: []cli.Flag{
+							&cli.IntFlag{
+								Name:  "days",
+								Value: 27,
+								Usage: "fetch transactions from n number of days ago (0 to 27 inclusive)",
+							},
+							&cli.StringFlag{
+								Name:        "output",
+								Aliases:     []string{"o"},
+								Value:       "table",
+								Usage:       "output format, table or json",
+								Destination: &getOutput,
+							},
+						},
+						Action: getTransactionsAction,
+					},
+					{
+						Name:  "balance",
+						Usage: "print the bca balance",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:        "output",
+								Aliases:     []string{"o"},
+								Value:       "table",
+								Usage:       "output format, table or json",
+								Destination: &getOutput,
+							},
+						},
+						Action: getBalanceAction,
+					},
+				},
+			},
+			{
+				Name:  "reconcile",
+				Usage: "list transactions of the statement window that are only in bca or only in ynab",
+				Flags: []cli.Flag{
+					&cli.IntFlag{
+						Name:        "tolerance-days",
+						Value:       3,
+						Usage:       "match transactions without the same import id by amount within this many days",
+						Destination: &reconcileToleranceDays,
+					},
+				},
+				Action: reconcileAction,
+			},
+			{
+				Name:  "watch",
+				Usage: "print new transactions as they are synced, by serve or any other run of the profile",
+				Flags: []cli.Flag{
+					&cli.DurationFlag{
+						Name:        "poll",
+						Value:       5 * time.Second,
+						Usage:       "how often to check for new transactions",
+						Destination: &watchPoll,
+					},
+					&cli.IntFlag{
+						Name:        "backlog",
+						Usage:       "first print this many of the most recent transactions",
+						Destination: &watchBacklog,
+					},
+					&cli.BoolFlag{
+						Name:        "desktop",
+						Usage:       "also show a desktop notification per transaction, using notify-send or osascript",
+						Destination: &watchDesktop,
+					},
+				},
+				Action: watchAction,
+			},
+			{
+				Name:  "telegram",
+				Usage: "answer /sync, /balance and /last sent to a telegram bot",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:        "telegram-token",
+						Usage:       "telegram bot token from @BotFather. can be set from environment variable",
+						EnvVars:     []string{"BCA_SYNC_TELEGRAM_TOKEN"},
+						Required:    true,
+						Destination: &telegramToken,
+					},
+					&cli.Int64Flag{
+						Name:        "telegram-chat",
+						Usage:       "id of the only chat answered, e.g. your own. can be set from environment variable",
+						EnvVars:     []string{"BCA_SYNC_TELEGRAM_CHAT"},
+						Required:    true,
+						Destination: &telegramChat,
+					},
+					&cli.DurationFlag{
+						Name:        "session-ttl",
+						Usage:       "keep the klikbca session logged in this long after a run, to log in less often. other runs of the profile are locked out meanwhile. 0 logs out right away",
+						Destination: &sessionTTL,
+					},
+					&cli.BoolFlag{
+						Name:        "persist-session",
+						Usage:       "write the kept klikbca session to disk, encrypted, so that a restart within --session-ttl doesn't log in again",
+						EnvVars:     []string{"BCA_PERSIST_SESSION"},
+						Destination: &persistSession,
+					},
+				},
+				Action: telegramAction,
+			},
+			{
+				Name:  "history",
+				Usage: "list past runs",
+				Flags: []cli.Flag{
+					&cli.IntFlag{
+						Name:        "limit",
+						Aliases:     []string{"n"},
+						Value:       20,
+						Usage:       "number of most recent runs to list, 0 for all",
+						Destination: &historyLimit,
+					},
+				},
+				Action: historyAction,
+				Subcommands: []*cli.Command{
+					{
+						Name:      "show",
+						Usage:     "show a run and the transactions it created",
+						ArgsUsage: "<run-id>",
+						Action:    historyShowAction,
+					},
+				},
+			},
+			{
+				Name:      "undo",
+				Usage:     "delete the ynab and firefly transactions created by a run",
+				ArgsUsage: "<run-id>",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:        "flag",
+						Usage:       "flag ynab transactions red instead of deleting them",
+						Destination: &undoFlag,
+					},
+				},
+				Action: undoAction,
+			},
+			{
+				Name:  "bootstrap",
+				Usage: "propose a monthly ynab budget per category from categorized bca transactions",
+				Flags: []cli.Flag{
+					&cli.IntFlag{
+						Name:        "months",
+						Value:       1,
+						Usage:       "number of months of transactions to average",
+						Destination: &bootstrapMonths,
+					},
+					&cli.BoolFlag{
+						Name:        "apply",
+						Usage:       "budget the proposed amounts for the current month, reviewing each one unless --non-interactive",
+						Destination: &bootstrapApply,
+					},
+				},
+				Action: bootstrapAction,
+			},
+			{
+				Name:  "config",
+				Usage: "manage configuration",
+				Subcommands: []*cli.Command{
+					{
+						Name:   "export-template",
+						Usage:  "print the current setup as a shareable template, without secrets or account ids",
+						Action: exportTemplateAction,
+					},
+					{
+						Name:      "import-template",
+						Usage:     "use a template for flags that aren't set",
+						ArgsUsage: "<template.json>",
+						Action:    importTemplateAction,
+					},
+					{
+						Name:  "show",
+						Usage: "print the flags that aren't at their default and where their values came from",
+						Flags: []cli.Flag{
+							&cli.BoolFlag{
+								Name:        "effective",
+								Usage:       "print every flag, including those at their default",
+								Destination: &showEffective,
+							},
+						},
+						Action: configShowAction,
+					},
+					{
+						Name:   "save-preferences",
+						Usage:  "save the current non-secret flags as preferences, to be used for flags that aren't set",
+						Action: savePreferencesAction,
+					},
+				},
+			},
+			{
+				Name:  "review",
+				Usage: "review what a sync flagged",
+				Subcommands: []*cli.Command{
+					{
+						Name:  "anomalies",
+						Usage: "list transactions flagged as unusual by --detect-anomalies",
+						Flags: []cli.Flag{
+							&cli.BoolFlag{
+								Name:        "clear",
+								Usage:       "clear the listed anomalies once reviewed",
+								Destination: &anomaliesClear,
+							},
+						},
+						Action: reviewAnomaliesAction,
+					},
+				},
+			},
+			{
+				Name:  "state",
+				Usage: "manage local state",
+				Subcommands: []*cli.Command{
+					{
+						Name:  "verify",
+						Usage: "check the ledger for corruption and against the ynab account",
+						Flags: []cli.Flag{
+							&cli.BoolFlag{
+								Name:        "repair",
+								Usage:       "drop corrupt and duplicate entries and refresh categories from ynab",
+								Destination: &stateRepair,
+							},
+						},
+						Action: stateVerifyAction,
+					},
+				},
+			},
+			{
+				Name:      "reveal",
+				Usage:     "reverse payee hashes created by --obfuscate",
+				ArgsUsage: "<payee-hash>...",
+				Action:    revealAction,
+			},
+			{
+				Name:   "adjust",
+				Usage:  "retry a failed ynab balance adjustment with the balance of the run it failed in",
+				Action: adjustAction,
+			},
+			{
+				Name:  "version",
+				Usage: "print the version, commit and build date",
+				Action: func(c *cli.Context) error {
+					printVersion(c)
+					return nil
+				},
+			},
+			{
+				Name:  "self-update",
+				Usage: "replace this binary with the latest github release, after verifying its checksum",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:        "check",
+						Usage:       "only tell whether a newer release is available",
+						Destination: &updateCheck,
+					},
+				},
+				Action: selfUpdateAction,
+			},
+			{
+				Name:  "doctor",
+				Usage: "check the config files, clock, klikbca login and statement, ynab and firefly and print a pass/fail report",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:        "skip-bca",
+						Usage:       "don't log in to klikbca, e.g. while another session is active",
+						Destination: &doctorSkipBCA,
+					},
+				},
+				Action: doctorAction,
+			},
+			{
+				Name:  "cleanup",
+				Usage: "delete or flag previously imported transactions",
+				Subcommands: []*cli.Command{
+					{
+						Name:  "ynab",
+						Usage: "delete or flag the ynab transactions bca-sync-ynab created in the account in a date range",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:        "since",
+								Usage:       "clean up transactions dated on or after this date (YYYY-MM-DD)",
+								Required:    true,
+								Destination: &cleanupSince,
+							},
+							&cli.BoolFlag{
+								Name:        "all",
+								Usage:       "also clean up transactions not created by bca-sync-ynab, like ones entered by hand",
+								Destination: &cleanupAll,
+							},
+							&cli.BoolFlag{
+								Name:        "flag",
+								Usage:       "flag transactions red instead of deleting them",
+								Destination: &cleanupFlag,
+							},
+						},
+						Action: cleanupYNABAction,
+					},
+					{
+						Name:  "memo-markers",
+						Usage: "strip the markers added by --memo-marker from ynab memos in a date range",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:        "since",
+								Usage:       "strip markers of transactions dated on or after this date (YYYY-MM-DD)",
+								Required:    true,
+								Destination: &cleanupSince,
+							},
+						},
+						Action: cleanupMemoMarkersAction,
+					},
+				},
+			},
+			{
+				Name:  "migrate-import-ids",
+				Usage: "rewrite the import ids of synced ynab transactions to another strategy and make it the profile's",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     "to",
+						Usage:    "import id strategy to migrate to: ynab, fields or reference",
+						Required: true,
+					},
+					&cli.BoolFlag{
+						Name:        "dry-run",
+						Usage:       "list the import ids that would be rewritten without changing anything",
+						Destination: &migrateDryRun,
+					},
+				},
+				Action: migrateImportIDsAction,
+			},
+		},
 	}
 
-	err := app.Run(os.Args)
-	if err != nil {
-		log.Fatal(err)
+	// the first ctrl+c cancels in-flight requests so the bca session can
+	// still be logged out, a second one exits immediately
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	if err := app.RunContext(ctx, os.Args); err != nil {
+		printError(err)
+		os.Exit(exitCode(err))
 	}
 }
 
 func actionFunc(c *cli.Context) error {
+	if allPipelines {
+		return runPipelines(c)
+	}
 	config, err := getOrDeleteConfig(username, password, token, delete, noninteractive, reset, nostore)
 	if err != nil {
 		return err
@@ -157,88 +1440,393 @@ func actionFunc(c *cli.Context) error {
 		return nil
 	}
 
-	var (
-		bc  = bca.NewAPIClient(bca.NewConfiguration())
-		ctx = c.Context
-		ip  = getPublicIP()
-	)
+	summary, err := runSync(c.Context, config)
+	if err := printSummary(stdout, summary); err != nil {
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	if err := writeStateTime(lastSuccessFile, summary.End); err != nil {
+		fmt.Fprintf(stdout, "failed to write last success: %v\n", err)
+	}
+	for _, d := range summary.Destinations {
+		if d.AdjustmentError != "" {
+			return withExitCode(errors.New("transactions were synced but the balance adjustment failed"), exitAdjustmentFailed)
+		}
+	}
+	// a balance-only sync has nothing new when nothing was adjusted
+	if exitEmpty && summary.Created == 0 && !(syncMode == modeBalanceOnly && summary.Adjusted) {
+		return withExitCode(errors.New("no new transactions"), exitNothingToSync)
+	}
+	return nil
+}
 
-	auth, err := bc.Login(ctx, config.BCAUser, config.BCAPassword, ip)
+// runSummary describes the outcome of a single sync run
+type runSummary struct {
+	ID           string              `json:"id"`
+	Start        time.Time           `json:"start"`
+	End          time.Time           `json:"end"`
+	From         time.Time           `json:"from,omitempty"`
+	To           time.Time           `json:"to,omitempty"`
+	Fetched      int                 `json:"fetched"`
+	Created      int                 `json:"created"`
+	Duplicates   int                 `json:"duplicates"`
+	Adjusted     bool                `json:"adjusted"`
+	Balance      decimal.Decimal     `json:"balance"`
+	Destinations []destinationResult `json:"destinations,omitempty"`
+	Error        string              `json:"error,omitempty"`
+	// Source is where the transactions were read from
+	Source   string    `json:"source,omitempty"`
+	Undone   bool      `json:"undone,omitempty"`
+	UndoneAt time.Time `json:"undoneAt,omitempty"`
+}
+
+func runSync(ctx context.Context, config *config) (summary *runSummary, err error) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	summary = newRunSummary()
+	summary.Source = sourceKlikBCA
+	// a run turned away by the lock isn't recorded, the other run is
+	unlock, err := lockProfile()
+	if err != nil {
+		return summary, err
+	}
+	defer unlock()
+	defer func() {
+		summary.End = time.Now()
+		if err != nil {
+			summary.Error = err.Error()
+		}
+		recordHistory(summary)
+		pushRunState()
+		emailRun(summary)
+		notifyRun(summary)
+	}()
+
+	if err = pullState(ctx); err != nil {
+		return summary, err
+	}
+	if err = resumeInterrupted(ctx, config); err != nil {
+		return summary, err
+	}
+	summary.From, summary.To, err = statementRange()
+	if err != nil {
+		return summary, err
+	}
+	ds := destinations()
+	if syncMode == modeBalanceOnly {
+		return summary, syncBalance(ctx, ds, config, summary)
+	}
+	if c := usableFetchCache(); c != nil {
+		fmt.Fprintf(stdout, "pushing %d cached bca transaction(s) from %s to %v, skipping klikbca\n", len(c.Entries), c.Time.Format(time.RFC3339), c.Destinations)
+		if ds = onlyDestinations(ds, c.Destinations); len(ds) == 0 {
+			return summary, fmt.Errorf("none of the cached destinations %v are configured. run with --cache-max-age 0 to fetch from klikbca instead", c.Destinations)
+		}
+		summary.From, summary.To = c.From, c.To
+		err = syncEntries(ctx, ds, config, c.Balance, nil, c.Entries, summary)
+		cacheFailedPush(*c, summary.Destinations)
+		return summary, err
+	}
+
+	bal, trxs, auth, err := fetchBCA(ctx, config)
 	if err != nil {
-		return errors.Wrap(err, "failed to get bca login")
+		return summary, err
 	}
-	bal, err := bc.BalanceInquiry(ctx, auth)
+	archiveFetched(ctx, archivedStatement{FetchedAt: summary.Start, From: summary.From, To: summary.To, Balance: bal, Entries: trxs})
+	hash, err := statementHash(bal, trxs)
 	if err != nil {
-		return errors.Wrap(err, "failed to get bca balance")
+		return summary, err
 	}
-	trxs, err := getBCATransactions(ctx, bc, auth)
+	if last, ok := isRerun(hash); ok {
+		summary.Fetched = len(trxs)
+		summary.Balance = bal.Balance
+		fmt.Fprintf(stdout, "bca statement unchanged since the run at %s, nothing to push\n", last.Time.Local().Format("15:04:05"))
+		return summary, nil
+	}
+	err = syncEntries(ctx, ds, config, bal, auth, trxs, summary)
+	cacheFailedPush(fetchCache{Time: summary.Start, From: summary.From, To: summary.To, Balance: bal, Entries: trxs}, summary.Destinations)
+	if err == nil {
+		recordFetch(hash, summary.Start)
+	}
+	return summary, err
+}
+
+// syncEntries filters the fetched entries and pushes them to ds
+func syncEntries(ctx context.Context, ds []destination, config *config, bal bca.Balance, auth []*http.Cookie, trxs []bca.Entry, summary *runSummary) error {
+	summary.Fetched = len(trxs)
+	summary.Balance = bal.Balance
+	trxs, err := prepareEntries(ctx, trxs)
 	if err != nil {
 		return err
 	}
-	if err := bc.Logout(ctx, auth); err != nil {
-		return fmt.Errorf("failed to logout: %w", err)
+	if reviewFlag {
+		if trxs, err = reviewTransactions(trxs); err != nil {
+			return err
+		}
 	}
 
-	if csvFlag {
-		trxCsv, err := transactionsToCsv(trxs)
-		if err != nil {
-			return fmt.Errorf("enable to csv marshal string: %w", err)
+	if err := markInflight(summary, ds, bal, trxs); err != nil {
+		fmt.Fprintf(stdout, "failed to write %s, an interrupted push won't be resumed: %v\n", inflightFile, err)
+	}
+	err = pushTransactions(ctx, ds, config, bal, auth, trxs, summary)
+	clearInflight()
+	checkAnomalies(ctx, trxs)
+	recordLedger(summary)
+	return err
+}
+
+// prepareEntries runs the fetched entries through --include and the other
+// filters and --transform, and returns the ones to push. the payees, memos
+// and categories the transform sets are kept as review edits in memory,
+// and nothing is prompted for or persisted, so that explain can use it
+func prepareEntries(ctx context.Context, trxs []bca.Entry) ([]bca.Entry, error) {
+	trxs = filterTransactions(trxs)
+	return transformTransactions(ctx, trxs)
+}
+
+// syncBalance brings the balance of the ynab and firefly accounts in line
+// with bca with a single adjustment, for tracking accounts like deposits
+// whose individual transactions don't matter
+func syncBalance(ctx context.Context, ds []destination, config *config, summary *runSummary) error {
+	var balanced []destination
+	for _, d := range ds {
+		if d.name == "ynab" || strings.HasPrefix(d.name, "ynab:") || d.name == "firefly" {
+			balanced = append(balanced, d)
+			continue
 		}
-		fmt.Print(trxCsv)
+		fmt.Fprintf(stdout, "%s: skipped, only ynab and firefly balances are synced in balance-only mode\n", d.name)
+	}
+	if len(balanced) == 0 {
+		return fmt.Errorf("balance-only mode needs ynab or --firefly-url")
+	}
+
+	bal, err := fetchBCABalance(ctx, config)
+	if err != nil {
+		return err
+	}
+	summary.Balance = bal.Balance
+	return pushTransactions(ctx, balanced, config, bal, nil, nil, summary)
+}
+
+// checkBCAAccount fails if --bca-account-number is given and klikbca
+// answered for another account despite accountTransport selecting it, so
+// that the wrong account is never pushed
+func checkBCAAccount(bal bca.Balance) error {
+	if bcaAccountNumber == "" {
 		return nil
 	}
+	if got := digitsOnly(bal.AccountNumber); got != digitsOnly(bcaAccountNumber) {
+		return fmt.Errorf("klikbca returned account %s instead of --bca-account-number %s", got, bcaAccountNumber)
+	}
+	return nil
+}
 
-	if fireflyUrl != "" {
-		err := createFireflyTransactions(ctx, bal, trxs)
-		if err != nil {
-			return fmt.Errorf("failed to create firefly transactions: %w", err)
+// fetchBCA logs in to klikbca, fetches the balance and transactions and logs out
+func fetchBCA(ctx context.Context, config *config) (bca.Balance, []bca.Entry, []*http.Cookie, error) {
+	unlock, err := lockProfile()
+	if err != nil {
+		return bca.Balance{}, nil, nil, err
+	}
+	defer unlock()
+
+	loggedIn := startSpinner("logging in to klikbca")
+	s, err := openBCASession(ctx, config)
+	loggedIn(err)
+	if err != nil {
+		return bca.Balance{}, nil, nil, err
+	}
+	// klikbca allows a single session, so log out even when cancelled or
+	// timed out
+	loggedOut := false
+	defer func() {
+		if loggedOut {
+			return
 		}
-		return nil
+		if err := closeBCASession(s, false); err != nil {
+			fmt.Fprintf(stdout, "failed to logout: %v\n", err)
+		}
+	}()
+
+	fetched := startSpinner("fetching bca balance and transactions")
+	bal, err := s.balance(ctx)
+	if err != nil {
+		fetched(err)
+		return bca.Balance{}, nil, nil, err
+	}
+	recordBalance(bal)
+	trxs, err := getBCATransactions(ctx, s)
+	fetched(err)
+	if err != nil {
+		return bca.Balance{}, nil, nil, err
+	}
+	loggedOut = true
+	auth, _ := s.cookies()
+	if err := closeBCASession(s, true); err != nil {
+		return bca.Balance{}, nil, nil, fmt.Errorf("failed to logout: %w", err)
+	}
+	return bal, trxs, auth, nil
+}
+
+func getBCATransactions(ctx context.Context, s *bcaSession) ([]bca.Entry, error) {
+	start, end, err := statementRange()
+	if err != nil {
+		return nil, err
+	}
+
+	// klikbca only serves a limited window per statement request
+	type window struct{ start, end time.Time }
+	var windows []window
+	for wstart := start; !wstart.After(end); wstart = wstart.AddDate(0, 0, maxStatementDays+1) {
+		wend := wstart.AddDate(0, 0, maxStatementDays)
+		if wend.After(end) {
+			wend = end
+		}
+		windows = append(windows, window{wstart, wend})
 	}
 
+	// windows are fetched by --fetch-concurrency workers, starting at most
+	// one request per --fetch-delay so that backfills stay polite
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	statementTotals.reset()
 	var (
-		yc = ynab.NewClient(config.YNABToken)
+		results = make([][]bca.Entry, len(windows))
+		errs    = make([]error, len(windows))
+		jobs    = make(chan int)
+		wg      sync.WaitGroup
+		pace    <-chan time.Time
 	)
-
-	a, err := getYNABAccount(yc, budget, accountName)
-	if err != nil {
-		return err
+	if fetchDelay > 0 && len(windows) > 1 {
+		t := time.NewTicker(fetchDelay)
+		defer t.Stop()
+		pace = t.C
+	}
+	workers := fetchConcurrency
+	if workers < 1 {
+		workers = 1
+	}
+	for n := 0; n < workers; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				w := windows[i]
+				errs[i] = s.do(ctx, func(auth []*http.Cookie) (err error) {
+					results[i], err = s.bc.AccountStatementView(ctx, w.start, w.end, auth)
+					return err
+				})
+				if errs[i] != nil {
+					cancel()
+				}
+			}
+		}()
 	}
+dispatch:
+	for i := range windows {
+		if i > 0 && pace != nil {
+			select {
+			case <-pace:
+			case <-ctx.Done():
+				break dispatch
+			}
+		}
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	close(jobs)
+	wg.Wait()
 
-	if len(trxs) > 0 {
-		if err := createYNABTransactions(yc, trxs, a, budget); err != nil {
-			return fmt.Errorf("failed to create ynab transactions: %w", err)
+	trxs := make([]bca.Entry, 0)
+	for i := range windows {
+		if errs[i] != nil {
+			return nil, withExitCode(errors.Wrap(errs[i], "failed to get bca transactions. try -r"), exitScrapeFailed)
 		}
+		trxs = append(trxs, results[i]...)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if err := verifyTotals(trxs); err != nil {
+		return nil, err
 	}
+	if len(trxs) == 0 {
+		fmt.Fprintf(stdout, "0 bca transactions from %s to %s\n", start.Format("2006-01-02"), end.Format("2006-01-02"))
+	}
+	return trxs, nil
+}
 
-	if !noadjust {
-		if err := createYNABBalanceAdjustment(bal, ctx, auth, yc, budget, a); err != nil {
-			return fmt.Errorf("failed to create balance adjustment: %w", err)
+// statementRange returns the range to fetch from --from and --to, falling
+// back to --days ago until now
+func statementRange() (time.Time, time.Time, error) {
+	if since != "" {
+		return sinceLastSync()
+	}
+	if from == "" && to == "" {
+		if days > maxStatementDays {
+			days = maxStatementDays
+		}
+		if days < 0 {
+			days = 0
 		}
+		end := time.Now()
+		return end.AddDate(0, 0, -days), end, nil
 	}
 
-	return nil
+	if from == "" {
+		return time.Time{}, time.Time{}, errors.New("--to requires --from")
+	}
+	start, err := time.ParseInLocation(dateLayout, from, time.Local)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid --from: %w", err)
+	}
+	end := time.Now()
+	if to != "" {
+		end, err = time.ParseInLocation(dateLayout, to, time.Local)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid --to: %w", err)
+		}
+	}
+	if end.Before(start) {
+		return time.Time{}, time.Time{}, errors.New("--from must not be after --to")
+	}
+	return start, end, nil
 }
 
-func getBCATransactions(ctx context.Context, bc *bca.BCAApiService, auth []*http.Cookie) ([]bca.Entry, error) {
-	if days > 27 {
-		days = 27
+// sinceLastSync returns the range of --since last, from the day before the
+// last successful sync so that transactions booked late on that day aren't
+// missed. klikbca only serves maxStatementDays, so older syncs are capped
+func sinceLastSync() (time.Time, time.Time, error) {
+	if since != "last" {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid --since %q, expected last", since)
 	}
-	if days < 0 {
-		days = 0
+	if from != "" || to != "" {
+		return time.Time{}, time.Time{}, errors.New("--since can't be used with --from or --to")
+	}
+	end := time.Now()
+	earliest := end.AddDate(0, 0, -maxStatementDays)
+	last, err := readStateTime(lastSuccessFile)
+	if os.IsNotExist(err) {
+		fmt.Fprintf(stdout, "no successful sync yet, fetching the last %d days\n", maxStatementDays)
+		return earliest, end, nil
 	}
-	var (
-		end   = time.Now()
-		start = end.AddDate(0, 0, -days)
-	)
-	trxs, err := bc.AccountStatementView(ctx, start, end, auth)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to get bca transactions. try -r")
+		return time.Time{}, time.Time{}, fmt.Errorf("failed to read last successful sync: %w", err)
 	}
-	if len(trxs) == 0 {
-		fmt.Printf("0 bca transactions from %s to %s\n", start.Format("2006-01-02"), end.Format("2006-01-02"))
+	start := last.Local().AddDate(0, 0, -1)
+	if start.Before(earliest) {
+		fmt.Fprintf(stdout, "last successful sync was %s, more than %d days ago. transactions before %s can only be synced with import\n",
+			last.Local().Format(dateLayout), maxStatementDays, earliest.Format(dateLayout))
+		start = earliest
 	}
-	return trxs, nil
+	return start, end, nil
 }
 
 func transactionsToCsv(trxs []bca.Entry) (string, error) {
@@ -250,7 +1838,7 @@ func transactionsToCsv(trxs []bca.Entry) (string, error) {
 
 	ps := make([]transaction.PayloadTransaction, 0)
 	for _, trx := range trxs {
-		ps = append(ps, toPayloadTransaction(trx, ""))
+		ps = append(ps, toPayloadTransaction(trx, "", ""))
 	}
 
 	trxCsv, err := gocsv.MarshalString(&trxs)
@@ -258,22 +1846,12 @@ func transactionsToCsv(trxs []bca.Entry) (string, error) {
 }
 
 // clearDate ref: https://cekmutasi.co.id/news/6/jadwal-jam-cut-off-jam-aktif-mutasi-ibanking
+// transactions after the cutoff, on weekends and on bank holidays clear on
+// the next business day
 func clearDate(now time.Time) time.Time {
 	rounded := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
-	switch now.Weekday() {
-	case time.Friday:
-		if now.Hour() < 22 {
-			return rounded
-		}
-		return rounded.AddDate(0, 0, 3)
-	case time.Saturday:
-		return rounded.AddDate(0, 0, 2)
-	case time.Sunday:
-		return rounded.AddDate(0, 0, 1)
-	default:
-		if now.Hour() < 22 {
-			return rounded
-		}
-		return rounded.AddDate(0, 0, 1)
+	if now.Hour() >= clearingCutoff || !isBusinessDay(rounded) {
+		return nextBusinessDay(rounded)
 	}
+	return rounded
 }
@@ -0,0 +1,369 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/satraul/bca-go"
+	"github.com/shopspring/decimal"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// useDefaults sets the flags the mapping depends on to their defaults for
+// the test, and keeps the profile in a temporary folder
+func useDefaults(t *testing.T) {
+	saved := []struct {
+		p *string
+		v string
+	}{
+		{&rounding, roundHalfEven}, {&cleared, "cleared"}, {&flagColor, ""}, {&flagName, ""},
+		{&importIDStrategy, strategyFields}, {&strategyDuplicates, "skip"}, {&scheduledMatch, "off"},
+		{&configDir, t.TempDir()}, {&fireflyUrl, ""}, {&fireflyToken, ""}, {&fireflyCurrency, ""},
+	}
+	for i, s := range saved {
+		v := *s.p
+		*s.p = s.v
+		saved[i].v = v
+	}
+	bools := []*bool{&memoMarkerFlag, &unapproved, &cleanMerchants, &noadjust, &createAccount}
+	oldBools := make([]bool, len(bools))
+	for i, b := range bools {
+		oldBools[i], *b = *b, false
+	}
+	oldStdout := stdout
+	stdout = ioutil.Discard
+	t.Cleanup(func() {
+		for _, s := range saved {
+			*s.p = s.v
+		}
+		for i, b := range bools {
+			*b = oldBools[i]
+		}
+		stdout = oldStdout
+	})
+}
+
+func day(s string) time.Time {
+	t, err := time.ParseInLocation(dateLayout, s, time.Local)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// statement is a bca statement covering the cases the mapping has to get
+// right: debits and credits, sub-rupiah interest, transfers with a
+// reference and identical purchases on the same day
+var statement = []bca.Entry{
+	{Date: day("2024-05-02"), Description: "TRSF E-BANKING DB 0205/FTSCY/WS95051 50000.00 JOHN DOE", Payee: "JOHN DOE", Amount: decimal.RequireFromString("50000"), Type: "DB"},
+	{Date: day("2024-05-02"), Description: "KARTU DEBIT KOPI KENANGAN", Payee: "KOPI KENANGAN", Amount: decimal.RequireFromString("18000"), Type: "DB"},
+	{Date: day("2024-05-02"), Description: "KARTU DEBIT KOPI KENANGAN", Payee: "KOPI KENANGAN", Amount: decimal.RequireFromString("18000"), Type: "DB"},
+	{Date: day("2024-05-03"), Description: "TRSF E-BANKING CR 0305/FTSCY/WS95031 7500000.00 PT EMPLOYER", Payee: "PT EMPLOYER", Amount: decimal.RequireFromString("7500000"), Type: "CR"},
+	{Date: day("2024-05-31"), Description: "BUNGA", Payee: "BUNGA", Amount: decimal.RequireFromString("1234.5678"), Type: "CR"},
+	{Date: day("2024-05-31"), Description: "PAJAK BUNGA", Payee: "PAJAK BUNGA", Amount: decimal.RequireFromString("246.9135"), Type: "DB"},
+}
+
+// golden compares got with testdata/name, or rewrites it with -update
+func golden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := ioutil.WriteFile(path, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v, run go test -update to create it", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s differs, run go test -update if the change is intended\ngot:\n%s\nwant:\n%s", path, got, want)
+	}
+}
+
+func TestPayloadGolden(t *testing.T) {
+	useDefaults(t)
+	var b strings.Builder
+	for _, strategy := range []string{strategyFields, strategyReference, strategyYNAB} {
+		importIDStrategy = strategy
+		fmt.Fprintf(&b, "# %s\n", strategy)
+		for i, id := range importIDs(statement) {
+			p := toPayloadTransaction(statement[i], "account", id)
+			fmt.Fprintf(&b, "%s\t%d\t%s\t%s\t%s\t%s\n", p.Date.Format(dateLayout), p.Amount, *p.PayeeName, *p.Memo, p.Cleared, *p.ImportID)
+		}
+	}
+	golden(t, "payloads.golden", []byte(b.String()))
+}
+
+func TestClearDateGolden(t *testing.T) {
+	var b strings.Builder
+	for _, now := range []string{
+		"2024-05-02 09:00", // thursday
+		"2024-05-02 21:59",
+		"2024-05-02 22:00", // after the cutoff
+		"2024-05-03 23:30", // friday night, clears on monday
+		"2024-05-04 10:00", // saturday
+		"2024-05-05 10:00", // sunday
+		"2024-05-08 23:00", // before ascension day and its cuti bersama
+		"2024-05-09 10:00", // ascension day
+		"2024-12-24 22:30", // before christmas
+		"2024-12-31 22:30", // new year's eve
+	} {
+		n, err := time.ParseInLocation("2006-01-02 15:04", now, time.Local)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fmt.Fprintf(&b, "%s %s\t%s\n", now, n.Weekday().String()[:3], clearDate(n).Format("2006-01-02 Mon"))
+	}
+	golden(t, "cleardate.golden", []byte(b.String()))
+}
+
+func TestStructhashImportIDs(t *testing.T) {
+	useDefaults(t)
+	importIDStrategy = strategyStructhash
+	ids := importIDs(statement)
+
+	// the description isn't part of the id
+	edited := append([]bca.Entry(nil), statement...)
+	edited[0].Description = "TRSF E-BANKING DB 0205/FTSCY/WS95051 50000.00 JOHN DOE LUNCH"
+	if got := importIDs(edited)[0]; got != ids[0] {
+		t.Errorf("import id changed with the description: %s, want %s", got, ids[0])
+	}
+
+	// identical purchases are told apart, the first keeps the plain hash
+	if ids[1] == ids[2] {
+		t.Fatalf("identical purchases got the same import id %s", ids[1])
+	}
+	if got := importIDs(statement[1:2])[0]; got != ids[1] {
+		t.Errorf("first occurrence = %s, want the id of a lone entry %s", ids[1], got)
+	}
+	for _, id := range ids {
+		if len(id) > 36 {
+			t.Errorf("import id %s is longer than the 36 characters ynab allows", id)
+		}
+	}
+}
+
+func TestPushYNAB(t *testing.T) {
+	useDefaults(t)
+	fake := newFakeYNAB(t, "BCA")
+	yc := fake.client()
+	a, err := getYNABAccount(yc, "budget", "BCA")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := createYNABTransactions(context.Background(), yc, statement, a, "budget")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.TransactionIDs) != len(statement) || len(resp.DuplicateImportIDs) != 0 {
+		t.Fatalf("first push created %d and reported %d duplicates, want %d and 0", len(resp.TransactionIDs), len(resp.DuplicateImportIDs), len(statement))
+	}
+	var total int64
+	for _, tr := range fake.transactions {
+		total += int64(tr["amount"].(float64))
+	}
+	if want := int64(7500000000 + 1234568 - 50000000 - 2*18000000 - 246914); total != want {
+		t.Errorf("ynab total = %d milliunits, want %d", total, want)
+	}
+
+	// a second run finds everything in ynab already
+	resp, err = createYNABTransactions(context.Background(), yc, statement, a, "budget")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.TransactionIDs) != 0 || len(resp.DuplicateImportIDs) != len(statement) {
+		t.Errorf("second push created %d and reported %d duplicates, want 0 and %d", len(resp.TransactionIDs), len(resp.DuplicateImportIDs), len(statement))
+	}
+}
+
+func TestPushYNABBatches(t *testing.T) {
+	useDefaults(t)
+	defer func(size int) { ynabBatchSize = size }(ynabBatchSize)
+	ynabBatchSize = 4
+	fake := newFakeYNAB(t, "BCA")
+	yc := fake.client()
+	a, err := getYNABAccount(yc, "budget", "BCA")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := createYNABTransactions(context.Background(), yc, statement, a, "budget")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.TransactionIDs) != len(statement) || fake.posts != 2 {
+		t.Errorf("created %d in %d requests, want %d in 2", len(resp.TransactionIDs), fake.posts, len(statement))
+	}
+}
+
+func TestPushYNABBatchDelayStopsWithContext(t *testing.T) {
+	useDefaults(t)
+	defer func(size int, delay time.Duration) { ynabBatchSize, ynabBatchDelay = size, delay }(ynabBatchSize, ynabBatchDelay)
+	ynabBatchSize, ynabBatchDelay = 4, time.Hour
+	fake := newFakeYNAB(t, "BCA")
+	yc := fake.client()
+	a, err := getYNABAccount(yc, "budget", "BCA")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the first batch goes out, the wait for the second ends with ctx
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	resp, err := createYNABTransactions(ctx, yc, statement, a, "budget")
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("push = %v, want it canceled", err)
+	}
+	if resp == nil || len(resp.TransactionIDs) != 4 || fake.posts != 1 {
+		t.Errorf("created %v in %d requests, want the 4 of the first batch in 1", resp, fake.posts)
+	}
+	var batchErr *ynabBatchError
+	if !errors.As(err, &batchErr) || len(batchErr.Failed) != len(statement)-4 {
+		t.Errorf("push = %v, want the %d transactions of the second batch failed", err, len(statement)-4)
+	}
+}
+
+func TestPushYNABBatchSkipsFailingTransaction(t *testing.T) {
+	useDefaults(t)
+	defer func(size int) { ynabBatchSize = size }(ynabBatchSize)
+	ynabBatchSize = 0
+	fake := newFakeYNAB(t, "BCA")
+	yc := fake.client()
+	a, err := getYNABAccount(yc, "budget", "BCA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := importIDs(statement)
+	fake.reject = map[string]bool{ids[2]: true}
+
+	// the batch and the halves with the bad transaction are rejected, the
+	// others go through
+	resp, err := createYNABTransactions(context.Background(), yc, statement, a, "budget")
+	var batchErr *ynabBatchError
+	if !errors.As(err, &batchErr) {
+		t.Fatalf("push = %v, want a batch error", err)
+	}
+	if len(batchErr.Failed) != 1 || batchErr.Failed[0].ImportID != ids[2] || batchErr.Failed[0].Entry.Description != statement[2].Description {
+		t.Errorf("failed %v, want only transaction %s", batchErr.Failed, ids[2])
+	}
+	if ynabStatus(err) != 400 {
+		t.Errorf("push = %v, want the 400 of the failed transaction", err)
+	}
+	if resp == nil || len(resp.TransactionIDs) != len(statement)-1 {
+		t.Fatalf("created %v, want the other %d transactions", resp, len(statement)-1)
+	}
+	for _, trx := range fake.transactions {
+		if trx["import_id"] == ids[2] {
+			t.Errorf("the rejected transaction %s was created", ids[2])
+		}
+	}
+	// 6, then 3 and 3, then 1 and 2 of the first half, then 1 and 1
+	if fake.posts != 7 {
+		t.Errorf("pushed in %d requests, want 7", fake.posts)
+	}
+}
+
+func TestPushFirefly(t *testing.T) {
+	useDefaults(t)
+	noadjust = true
+	fake := newFakeFirefly(t, "BCA")
+	fireflyUrl, fireflyToken = fake.URL, "token"
+	defer func(a string, n int) { accountName, fireflyConcurrency = a, n }(accountName, fireflyConcurrency)
+	accountName, fireflyConcurrency = "BCA", 1
+
+	created, _, err := createFireflyTransactions(context.Background(), bca.Balance{}, statement)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(created) != len(statement) {
+		t.Fatalf("created %d firefly transactions, want %d", len(created), len(statement))
+	}
+
+	ids := importIDs(statement)
+	splits := fake.splits()
+	if len(splits) != len(statement) {
+		t.Fatalf("firefly got %d transactions, want %d", len(splits), len(statement))
+	}
+	for i, s := range splits {
+		want := "deposit"
+		if statement[i].Type == "DB" {
+			want = "withdrawal"
+		}
+		if s["type"] != want || s["amount"] != statement[i].Amount.String() || s["currency_code"] != "IDR" || s["external_id"] != ids[i] {
+			t.Errorf("firefly transaction %d = %v %v %v %v, want %s %s IDR %s", i, s["type"], s["amount"], s["currency_code"], s["external_id"], want, statement[i].Amount, ids[i])
+		}
+	}
+}
+
+func TestPushYNABSendsImportPayeeName(t *testing.T) {
+	useDefaults(t)
+	fake := newFakeYNAB(t, "BCA")
+	yc := fake.client()
+	a, err := getYNABAccount(yc, "budget", "BCA")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// without --flag-name and without transactions to detect the fields on
+	if _, err := createYNABTransactions(context.Background(), yc, statement[:1], a, "budget"); err != nil {
+		t.Fatal(err)
+	}
+	got := fake.transactions[0]
+	if got["import_payee_name"] != statement[0].Payee {
+		t.Errorf("import_payee_name = %v, want %s", got["import_payee_name"], statement[0].Payee)
+	}
+	if got["flag_name"] != nil {
+		t.Errorf("flag_name = %v without --flag-name, want none", got["flag_name"])
+	}
+}
+
+func TestPushYNABSendsSplitsAsSubtransactions(t *testing.T) {
+	useDefaults(t)
+	fake := newFakeYNAB(t, "BCA")
+	yc := fake.client()
+	a, err := getYNABAccount(yc, "budget", "BCA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	trx := statement[0]
+	splits := []split{
+		{Amount: decimal.RequireFromString("-30000"), Memo: "lunch"},
+		{Amount: decimal.RequireFromString("-20000"), Payee: "JANE DOE"},
+	}
+	if err := checkSplits(trx, splits); err != nil {
+		t.Fatal(err)
+	}
+	setReviewEdits(map[string]reviewEdit{reviewKey(trx): {Payee: payeeName(trx), Memo: trx.Description, Splits: splits}})
+	defer func() { reviewEdits = make(map[string]reviewEdit) }()
+
+	if _, err := createYNABTransactions(context.Background(), yc, []bca.Entry{trx}, a, "budget"); err != nil {
+		t.Fatal(err)
+	}
+	subs, _ := fake.transactions[0]["subtransactions"].([]interface{})
+	if len(subs) != 2 {
+		t.Fatalf("ynab got subtransactions %v, want 2", fake.transactions[0]["subtransactions"])
+	}
+	first, second := subs[0].(map[string]interface{}), subs[1].(map[string]interface{})
+	if first["amount"] != float64(-30000000) || first["memo"] != "lunch" || second["amount"] != float64(-20000000) || second["payee_name"] != "JANE DOE" {
+		t.Errorf("subtransactions = %v, want -30000000 lunch and -20000000 JANE DOE", subs)
+	}
+
+	if err := checkSplits(trx, splits[:1]); err == nil {
+		t.Error("checkSplits accepted a single split")
+	}
+	if err := checkSplits(trx, []split{splits[0], splits[0]}); err == nil {
+		t.Error("checkSplits accepted splits not adding up to the amount")
+	}
+}
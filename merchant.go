@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/satraul/bca-go"
+)
+
+const (
+	merchantAliasesFile = "merchant-aliases.json"
+)
+
+var (
+	cleanMerchants      bool
+	merchantAliasesPath string
+
+	// merchantAliases maps upper-case merchant name prefixes to payees
+	merchantAliases map[string]string
+
+	// merchantCode matches terminal and merchant ids mixed into qris and edc
+	// merchant names
+	merchantCode = regexp.MustCompile(`\b[A-Z]*\d[A-Z0-9]*\b`)
+
+	// merchantSuffixes are the city and country suffixes of qris and edc
+	// merchant names, longest first so that JAKARTA SEL is stripped whole
+	merchantSuffixes = []string{
+		"JAKARTA SELATAN", "JAKARTA BARAT", "JAKARTA TIMUR", "JAKARTA UTARA", "JAKARTA PUSAT",
+		"TANGERANG SELATAN", "JAKARTA SEL", "JAKARTA BAR", "JAKARTA TIM", "JAKARTA UTA", "JAKARTA PUS",
+		"JAKARTA", "TANGERANG", "BEKASI", "DEPOK", "BOGOR", "BANDUNG", "SURABAYA", "SEMARANG",
+		"YOGYAKARTA", "SLEMAN", "MEDAN", "MAKASSAR", "DENPASAR", "BADUNG", "MALANG", "PALEMBANG",
+		"BALIKPAPAN", "BATAM", "KOTA", "KAB", "INDONESIA", "IDN", "ID",
+	}
+)
+
+// isMerchantPayment tells whether trx is a qris or debit card payment,
+// whose payee is a raw merchant name
+func isMerchantPayment(trx bca.Entry) bool {
+	return enrich(trx).Channel == channelQRIS || strings.Contains(strings.ToUpper(trx.Description), "KARTU DEBIT")
+}
+
+// payeeName is the payee pushed to destinations. payees renamed in an
+// earlier --review get the same name again. with --clean-merchants,
+// merchant names of qris and edc payments lose their codes and city and
+// country suffixes and are title-cased, or replaced by their alias. the
+// import id keeps using the raw payee so cleaning doesn't cause duplicates
+func payeeName(trx bca.Entry) string {
+	if e, ok := reviewed(trx); ok && e.Payee != "" {
+		return e.Payee
+	}
+	if payee := learnedPayee(trx); payee != "" {
+		return payee
+	}
+	if !cleanMerchants || !isMerchantPayment(trx) {
+		return trx.Payee
+	}
+
+	name := strings.ToUpper(enrich(trx).Counterparty)
+	name = strings.Join(strings.Fields(merchantCode.ReplaceAllString(name, " ")), " ")
+	for trimmed := true; trimmed; {
+		trimmed = false
+		for _, s := range merchantSuffixes {
+			if strings.HasSuffix(name, " "+s) {
+				name = strings.TrimSpace(strings.TrimSuffix(name, s))
+				trimmed = true
+			}
+		}
+	}
+	if name == "" {
+		return trx.Payee
+	}
+
+	if alias := lookupMerchantAlias(name); alias != "" {
+		return alias
+	}
+	return titleCase(name)
+}
+
+// lookupMerchantAlias returns the alias of the longest prefix of name
+func lookupMerchantAlias(name string) string {
+	var match, alias string
+	for prefix, a := range merchantAliases {
+		if strings.HasPrefix(name, prefix) && len(prefix) > len(match) {
+			match, alias = prefix, a
+		}
+	}
+	return alias
+}
+
+func titleCase(s string) string {
+	words := strings.Fields(strings.ToLower(s))
+	for i, w := range words {
+		words[i] = strings.ToUpper(w[:1]) + w[1:]
+	}
+	return strings.Join(words, " ")
+}
+
+// loadMerchantAliases reads --merchant-aliases, or the file in the profile
+// if it exists. the file is a json object of merchant name prefixes to
+// payees, e.g. {"KOPI KENANGAN": "Kopi Kenangan"}
+func loadMerchantAliases() error {
+	path := merchantAliasesPath
+	if path == "" {
+		path = filepath.Join(profileFolder().Path, merchantAliasesFile)
+	}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) && merchantAliasesPath == "" {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var aliases map[string]string
+	if err := json.Unmarshal(b, &aliases); err != nil {
+		return fmt.Errorf("invalid merchant aliases %s: %w", path, err)
+	}
+	merchantAliases = make(map[string]string, len(aliases))
+	for prefix, alias := range aliases {
+		merchantAliases[strings.ToUpper(strings.TrimSpace(prefix))] = alias
+	}
+	return nil
+}
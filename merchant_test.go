@@ -0,0 +1,56 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/satraul/bca-go"
+)
+
+func TestPayeeNameCleansMerchants(t *testing.T) {
+	useDefaults(t)
+	cleanMerchants = true
+	defer func(a map[string]string) { merchantAliases = a }(merchantAliases)
+	merchantAliases = map[string]string{"STARBUCKS": "Starbucks", "STARBUCKS RESERVE": "Starbucks Reserve"}
+
+	tests := []struct {
+		name        string
+		description string
+		payee       string
+		want        string
+	}{
+		{"qris with codes and city", "QRIS 0405 KOPI KENANGAN ID1023 JAKARTA SEL", "KOPI KENANGAN ID1023 JAKARTA SEL", "Kopi Kenangan"},
+		{"debit card with city and country", "KARTU DEBIT INDOMARET T4J1 TANGERANG IDN", "INDOMARET T4J1 TANGERANG IDN", "Indomaret"},
+		{"stacked suffixes", "KARTU DEBIT WARUNG PASTA KOTA BANDUNG ID", "WARUNG PASTA KOTA BANDUNG ID", "Warung Pasta"},
+		{"alias", "QRIS 0405 STARBUCKS GRAND INDONESIA", "STARBUCKS GRAND INDONESIA", "Starbucks"},
+		{"longest alias prefix", "QRIS 0405 STARBUCKS RESERVE DEWATA", "STARBUCKS RESERVE DEWATA", "Starbucks Reserve"},
+		{"nothing left keeps the raw payee", "KARTU DEBIT T4J1", "T4J1", "T4J1"},
+		{"transfers are left alone", "TRSF E-BANKING DB 0205/FTSCY/WS95051 50000.00 JOHN DOE", "JOHN DOE", "JOHN DOE"},
+	}
+	for _, tt := range tests {
+		if got := payeeName(bca.Entry{Description: tt.description, Payee: tt.payee, Type: "DB"}); got != tt.want {
+			t.Errorf("%s: payeeName() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPayeeNameWithoutCleanMerchants(t *testing.T) {
+	useDefaults(t)
+	trx := bca.Entry{Description: "QRIS 0405 KOPI KENANGAN ID1023 JAKARTA SEL", Payee: "KOPI KENANGAN ID1023 JAKARTA SEL", Type: "DB"}
+	if got := payeeName(trx); got != trx.Payee {
+		t.Errorf("payeeName() = %q without --clean-merchants, want the raw payee", got)
+	}
+}
+
+func TestCleanMerchantsKeepsImportIDs(t *testing.T) {
+	useDefaults(t)
+	trxs := []bca.Entry{{Date: day("2024-05-04"), Description: "QRIS 0405 KOPI KENANGAN ID1023 JAKARTA SEL", Payee: "KOPI KENANGAN ID1023 JAKARTA SEL", Type: "DB"}}
+	for _, strategy := range []string{strategyFields, strategyStructhash, strategyReference} {
+		importIDStrategy = strategy
+		cleanMerchants = false
+		raw := importIDs(trxs)[0]
+		cleanMerchants = true
+		if cleaned := importIDs(trxs)[0]; cleaned != raw {
+			t.Errorf("%s: import id %s changed to %s with --clean-merchants", strategy, raw, cleaned)
+		}
+	}
+}
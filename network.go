@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/satraul/bca-go"
+)
+
+const (
+	capabilitiesFile = "ynab-capabilities.json"
+	// placeholderIP is sent to klikbca as the client ip when the public ip
+	// isn't looked up
+	placeholderIP   = "0.0.0.0"
+	publicIPTimeout = 10 * time.Second
+)
+
+var (
+	minimalNetwork    bool
+	publicIP          string
+	publicIPProviders []string
+	proxy             string
+
+	// networkTransport reaches the network, through --proxy if given
+	networkTransport = http.DefaultTransport.(*http.Transport)
+	// bcaTransport carries the requests of the bca client, see
+	// configureBCATransport
+	bcaTransport http.RoundTripper
+)
+
+// clientIP returns the ip klikbca is told about, --public-ip if given.
+// --minimal-network skips the lookup
+func clientIP(ctx context.Context) (string, error) {
+	switch {
+	case publicIP != "":
+		return publicIP, nil
+	case minimalNetwork:
+		return placeholderIP, nil
+	}
+	return getPublicIP(ctx)
+}
+
+// ynabCapabilitiesFor detects the capabilities of the ynab api and keeps
+// them in the profile. --minimal-network uses the kept ones instead, or
+// assumes none if there are none yet
+func ynabCapabilitiesFor(yc ynabClient, budget, accountID string) (ynabCapabilities, error) {
+	path := filepath.Join(profileFolder().Path, capabilitiesFile)
+	if minimalNetwork {
+		var caps ynabCapabilities
+		b, err := ioutil.ReadFile(path)
+		if os.IsNotExist(err) {
+			return caps, nil
+		}
+		if err != nil {
+			return caps, err
+		}
+		return caps, json.Unmarshal(b, &caps)
+	}
+
+	caps, err := yc.capabilities(budget, accountID)
+	if err != nil {
+		return caps, err
+	}
+	if b, err := json.Marshal(caps); err == nil {
+		writeFileAtomic(path, b, 0644)
+	}
+	return caps, nil
+}
+
+// defaultPublicIPProviders return the caller's ip as plain text
+var defaultPublicIPProviders = []string{
+	"https://api.ipify.org?format=text",
+	"https://ifconfig.me/ip",
+	"https://icanhazip.com",
+	"https://checkip.amazonaws.com",
+}
+
+// getPublicIP asks each of --public-ip-provider in turn
+// ref: https://gist.github.com/ankanch/8c8ec5aaf374039504946e7e2b2cdf7f
+func getPublicIP(ctx context.Context) (string, error) {
+	client := &http.Client{Timeout: publicIPTimeout}
+	var errs []string
+	for _, url := range publicIPProviders {
+		ip, err := fetchPublicIP(ctx, client, url)
+		if err == nil {
+			return ip, nil
+		}
+		errs = append(errs, fmt.Sprintf("%s: %v", url, err))
+	}
+	return "", fmt.Errorf("failed to look up public ip, set --public-ip instead:\n%s", strings.Join(errs, "\n"))
+}
+
+func fetchPublicIP(ctx context.Context, client *http.Client, url string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("returned %d", resp.StatusCode)
+	}
+	b, err := io.ReadAll(io.LimitReader(resp.Body, 64))
+	if err != nil {
+		return "", err
+	}
+	ip := net.ParseIP(strings.TrimSpace(string(b)))
+	if ip == nil {
+		return "", fmt.Errorf("returned %q, not an ip", strings.TrimSpace(string(b)))
+	}
+	return ip.String(), nil
+}
+
+// configureNetwork builds the default transport, which every client but
+// the bca one is built on, from --proxy and --trace-http
+func configureNetwork() error {
+	if proxy != "" {
+		if err := configureProxy(proxy); err != nil {
+			return err
+		}
+	}
+	if traceHTTP != "" {
+		if err := openHTTPTrace(traceHTTP); err != nil {
+			return err
+		}
+	}
+	http.DefaultTransport = traced(networkTransport)
+	return nil
+}
+
+// configureBCATransport builds the transport of the bca client on the
+// network: --record or --replay, --trace-http, --bca-account-number,
+// --verify-totals, the headers of --user-agent and --bca-header, then
+// --bca-pace
+func configureBCATransport() error {
+	t, err := withRecording(networkTransport)
+	if err != nil {
+		return err
+	}
+	t = withAccount(traced(t))
+	if t, err = withTotals(t); err != nil {
+		return err
+	}
+	if t, err = withHeaders(t); err != nil {
+		return err
+	}
+	bcaTransport = withPacing(t)
+	return nil
+}
+
+// newBCAClient returns a bca client sending its requests through
+// bcaTransport
+func newBCAClient() *bca.BCAApiService {
+	cfg := bca.NewConfiguration()
+	cfg.HTTPClient = &http.Client{Transport: bcaTransport}
+	return bca.NewAPIClient(cfg)
+}
+
+// configureProxy routes networkTransport through --proxy. without it
+// HTTPS_PROXY and friends apply
+func configureProxy(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("invalid --proxy: %w", err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return fmt.Errorf("invalid --proxy %q, expected an http, https or socks5 url", raw)
+	}
+	if p, ok := u.User.Password(); ok {
+		registerSecret(p)
+	}
+
+	t := networkTransport.Clone()
+	t.Proxy = http.ProxyURL(u)
+	networkTransport = t
+	return nil
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ntfyServer     = "https://ntfy.sh/"
+	pushoverAPI    = "https://api.pushover.net/1/messages.json"
+	notifyTimeout  = 30 * time.Second
+	ntfyHigh       = "high"
+	pushoverHigh   = 1
+	pushoverLowest = -2
+)
+
+var (
+	notifyWhen       string
+	ntfyTopic        string
+	ntfyToken        string
+	ntfyPriority     string
+	pushoverToken    string
+	pushoverUser     string
+	pushoverPriority int
+)
+
+// notification is a message sent to every configured notification channel
+type notification struct {
+	Title   string `json:"title"`
+	Message string `json:"message"`
+	// High marks failures that should stand out
+	High bool `json:"high"`
+}
+
+type notifier interface {
+	notify(ctx context.Context, n notification) error
+}
+
+// notifiers returns the configured notification channels
+func notifiers() []notifier {
+	var ns []notifier
+	if notifyWebhook != "" {
+		ns = append(ns, webhookNotifier{url: notifyWebhook})
+	}
+	if ntfyTopic != "" {
+		ns = append(ns, ntfyNotifier{topic: ntfyTopic, token: ntfyToken, priority: ntfyPriority})
+	}
+	if pushoverToken != "" {
+		ns = append(ns, pushoverNotifier{token: pushoverToken, user: pushoverUser, priority: pushoverPriority})
+	}
+	return ns
+}
+
+// notifyRun sends the result of a sync to the notification channels if
+// --notify-when wants it. failures are sent with high priority
+func notifyRun(s *runSummary) {
+	if len(notifiers()) == 0 || !shouldReport(notifyWhen, s) {
+		return
+	}
+	// the run's context may be why it failed
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	defer cancel()
+
+	var b strings.Builder
+	failed := s.Error != ""
+	if failed {
+		fmt.Fprintf(&b, "%s\n", s.Error)
+	}
+	for _, d := range s.Destinations {
+		fmt.Fprintf(&b, "%s: %d created, %d duplicates", d.Name, d.Created, d.Duplicates)
+		switch {
+		case d.Error != "":
+			fmt.Fprintf(&b, ", failed: %s", d.Error)
+			failed = true
+		case d.AdjustmentError != "":
+			fmt.Fprintf(&b, ", adjustment failed: %s", d.AdjustmentError)
+			failed = true
+		case d.Adjusted:
+			b.WriteString(", adjusted")
+		}
+		b.WriteString("\n")
+	}
+	fmt.Fprintf(&b, "balance %s", s.Balance.StringFixed(2))
+	notifyAll(ctx, notification{
+		Title:   "bca-sync-ynab: " + runStatus(s),
+		Message: b.String(),
+		High:    failed,
+	})
+}
+
+func validateNotifiers() error {
+	if err := validateReportWhen("notify-when", notifyWhen); err != nil {
+		return err
+	}
+	switch ntfyPriority {
+	case "min", "low", "default", ntfyHigh, "max":
+	default:
+		return fmt.Errorf("invalid --ntfy-priority %q, expected min, low, default, high or max", ntfyPriority)
+	}
+	if pushoverToken != "" && pushoverUser == "" {
+		return fmt.Errorf("--pushover-user is required with --pushover-token")
+	}
+	if pushoverPriority < pushoverLowest || pushoverPriority > pushoverHigh {
+		return fmt.Errorf("invalid --pushover-priority %d, expected -2 to 1", pushoverPriority)
+	}
+	return nil
+}
+
+// notifyAll sends n to every channel. failing channels are reported but
+// don't fail the caller
+func notifyAll(ctx context.Context, n notification) {
+	n.Title = redact(n.Title)
+	n.Message = redact(obfuscation().text(n.Message))
+	for _, nt := range notifiers() {
+		if err := nt.notify(ctx, n); err != nil {
+			fmt.Fprintf(stdout, "failed to send notification: %v\n", err)
+		}
+	}
+}
+
+// webhookNotifier posts the notification as json
+type webhookNotifier struct {
+	url string
+}
+
+func (w webhookNotifier) notify(ctx context.Context, n notification) error {
+	b, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("webhook returned %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// ntfyNotifier publishes to an ntfy topic, a bare topic name being one on
+// ntfy.sh
+type ntfyNotifier struct {
+	topic, token, priority string
+}
+
+func (n ntfyNotifier) notify(ctx context.Context, msg notification) error {
+	u := n.topic
+	if !strings.Contains(u, "://") {
+		u = ntfyServer + u
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, strings.NewReader(msg.Message))
+	if err != nil {
+		return err
+	}
+	priority := n.priority
+	if msg.High {
+		priority = ntfyHigh
+		if n.priority == "max" {
+			priority = "max"
+		}
+	}
+	req.Header.Set("Title", msg.Title)
+	req.Header.Set("Priority", priority)
+	if n.token != "" {
+		req.Header.Set("Authorization", "Bearer "+n.token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("ntfy returned %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// pushoverNotifier sends the notification with the pushover api
+type pushoverNotifier struct {
+	token, user string
+	priority    int
+}
+
+func (p pushoverNotifier) notify(ctx context.Context, n notification) error {
+	priority := p.priority
+	if n.High {
+		priority = pushoverHigh
+	}
+	form := url.Values{
+		"token":    {p.token},
+		"user":     {p.user},
+		"title":    {n.Title},
+		"message":  {n.Message},
+		"priority": {strconv.Itoa(priority)},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, pushoverAPI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("pushover returned %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// desktopNotifier shows the notification with notify-send on linux or
+// osascript on macos
+type desktopNotifier struct{}
+
+func (desktopNotifier) notify(ctx context.Context, n notification) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "linux", "freebsd", "openbsd":
+		urgency := "normal"
+		if n.High {
+			urgency = "critical"
+		}
+		cmd = exec.CommandContext(ctx, "notify-send", "-u", urgency, n.Title, n.Message)
+	case "darwin":
+		script := fmt.Sprintf("display notification %q with title %q", n.Message, n.Title)
+		cmd = exec.CommandContext(ctx, "osascript", "-e", script)
+	default:
+		return fmt.Errorf("desktop notifications are not supported on %s", runtime.GOOS)
+	}
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s: %w: %s", cmd.Path, err, out)
+	}
+	return nil
+}
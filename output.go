@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	colorRed    = "\033[31m"
+	colorGreen  = "\033[32m"
+	colorYellow = "\033[33m"
+	colorReset  = "\033[0m"
+
+	spinnerInterval = 100 * time.Millisecond
+)
+
+var (
+	noColor, quiet bool
+
+	// dataout is where destinations print their data, like --csv. it stays
+	// on stdout when --quiet discards the progress messages
+	dataout = stdout
+
+	spinnerFrames = []string{"|", "/", "-", `\`}
+)
+
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// colorEnabled is false with --no-color, NO_COLOR or when stdout isn't a
+// terminal, like under cron
+func colorEnabled() bool {
+	if noColor || quiet || os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	return isTerminal(os.Stdout)
+}
+
+func colorize(color, s string) string {
+	if !colorEnabled() {
+		return s
+	}
+	return color + s + colorReset
+}
+
+// configureOutput discards progress messages with --quiet. errors still go
+// to stderr and data to stdout
+func configureOutput() {
+	if quiet {
+		stdout = &redactingWriter{w: ioutil.Discard}
+	}
+}
+
+// printError prints the error that ended the run
+func printError(err error) {
+	fmt.Fprintf(stderr, "%s %v\n", colorize(colorRed, "error:"), err)
+}
+
+// startSpinner shows msg with a spinner on a terminal until the returned
+// func is called, which replaces it with a status line for the outcome.
+// without a terminal only failures are printed, by the caller
+func startSpinner(msg string) func(err error) {
+	if quiet || !isTerminal(os.Stdout) {
+		return func(error) {}
+	}
+	var (
+		done = make(chan struct{})
+		wg   sync.WaitGroup
+	)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		t := time.NewTicker(spinnerInterval)
+		defer t.Stop()
+		for i := 0; ; i++ {
+			fmt.Fprintf(stdout, "\r\033[K%s %s", spinnerFrames[i%len(spinnerFrames)], msg)
+			select {
+			case <-done:
+				fmt.Fprint(stdout, "\r\033[K")
+				return
+			case <-t.C:
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func(err error) {
+		once.Do(func() {
+			close(done)
+			wg.Wait()
+			if err != nil {
+				fmt.Fprintf(stdout, "%s %s\n", colorize(colorRed, "✗"), msg)
+				return
+			}
+			fmt.Fprintf(stdout, "%s %s\n", colorize(colorGreen, "✓"), msg)
+		})
+	}
+}
+
+// destinationStatus is the colored status of r
+func destinationStatus(r destinationResult) string {
+	switch {
+	case r.Error != "":
+		return colorize(colorRed, "failed")
+	case r.AdjustmentError != "":
+		return colorize(colorYellow, "adjustment failed")
+	default:
+		return colorize(colorGreen, "ok")
+	}
+}
+
+// printSummary prints a table of what the run did in each destination
+func printSummary(w io.Writer, s *runSummary) error {
+	if len(s.Destinations) == 0 {
+		return nil
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "DESTINATION\tCREATED\tDUPLICATES\tADJUSTED\tSTATUS\n")
+	for _, d := range s.Destinations {
+		adjusted := "no"
+		if d.Adjusted {
+			adjusted = "yes"
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%s\t%s\n", d.Name, d.Created, d.Duplicates, adjusted, destinationStatus(d))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if err := printDuplicates(w, s); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "%d bca transaction(s) fetched in %s, balance %s\n", s.Fetched, s.End.Sub(s.Start).Round(time.Second), s.Balance.StringFixed(2))
+	return nil
+}
+
+// printDuplicates lists the transactions each destination reported as
+// already there, to check that no legitimate transaction was dropped
+func printDuplicates(w io.Writer, s *runSummary) error {
+	for _, d := range s.Destinations {
+		if len(d.DuplicateTransactions) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "\nalready in %s:\n", d.Name)
+		if err := printTransactions(w, d.DuplicateTransactions); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// printTransactions prints a line per transaction
+func printTransactions(w io.Writer, ts []createdTransaction) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, t := range ts {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", t.Date.Format(dateLayout), t.Payee, t.Amount.StringFixed(2), t.ImportID, t.ID)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var (
+	bcaPace, bcaJitter time.Duration
+)
+
+// pacingTransport spaces out klikbca requests by --bca-pace plus up to
+// --bca-jitter at random, like a person clicking through the pages, as
+// klikbca throttles clients that request too quickly
+type pacingTransport struct {
+	base http.RoundTripper
+
+	mu   sync.Mutex
+	last time.Time
+	rand *rand.Rand
+}
+
+// withPacing paces klikbca requests, unless they are replayed
+func withPacing(base http.RoundTripper) http.RoundTripper {
+	if bcaPace <= 0 && bcaJitter <= 0 || replayDir != "" {
+		return base
+	}
+	return &pacingTransport{
+		base: base,
+		rand: rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+func (t *pacingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !isBCARequest(req) {
+		return t.base.RoundTrip(req)
+	}
+	select {
+	case <-time.After(t.wait()):
+	case <-req.Context().Done():
+		return nil, req.Context().Err()
+	}
+	return t.base.RoundTrip(req)
+}
+
+// wait reserves the next slot for a request and returns how long to wait
+// for it
+func (t *pacingTransport) wait() time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	gap := bcaPace
+	if bcaJitter > 0 {
+		gap += time.Duration(t.rand.Int63n(int64(bcaJitter)))
+	}
+	now := time.Now()
+	next := t.last.Add(gap)
+	if t.last.IsZero() || next.Before(now) {
+		next = now
+	}
+	t.last = next
+	return next.Sub(now)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/satraul/bca-go"
+)
+
+const (
+	payeeAliasesFile = "payee-aliases.json"
+)
+
+var (
+	noLearnPayees bool
+
+	payeeAliasesMu sync.RWMutex
+	// payeeAliases map raw bca payees, by payeeAliasKey, to the payees they
+	// were renamed to in --review
+	payeeAliases map[string]string
+)
+
+// payeeAliasKey is the raw payee of trx without codes and spacing, so that
+// payments to one merchant through different terminals share their alias
+func payeeAliasKey(trx bca.Entry) string {
+	name := strings.ToUpper(trx.Payee)
+	return strings.Join(strings.Fields(merchantCode.ReplaceAllString(name, " ")), " ")
+}
+
+// learnedPayee returns the payee trx was renamed to in an earlier --review
+func learnedPayee(trx bca.Entry) string {
+	payeeAliasesMu.RLock()
+	defer payeeAliasesMu.RUnlock()
+	if len(payeeAliases) == 0 {
+		return ""
+	}
+	return payeeAliases[payeeAliasKey(trx)]
+}
+
+// loadPayeeAliases reads the payee aliases learned in the profile
+func loadPayeeAliases() error {
+	path := filepath.Join(profileFolder().Path, payeeAliasesFile)
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var aliases map[string]string
+	if err := json.Unmarshal(b, &aliases); err != nil {
+		return fmt.Errorf("invalid payee aliases %s: %w", path, err)
+	}
+	payeeAliasesMu.Lock()
+	defer payeeAliasesMu.Unlock()
+	payeeAliases = aliases
+	return nil
+}
+
+// learnPayees remembers the payees renamed in a confirmed --review, so that
+// the next runs name them the same way. renaming a payee back to its raw
+// bca name forgets its alias
+func learnPayees(rows []reviewRow) {
+	if noLearnPayees {
+		return
+	}
+	edits := make(map[string]string)
+	for _, r := range rows {
+		key := payeeAliasKey(r.trx)
+		if !r.included || key == "" || r.edit.Payee == "" || r.edit.Payee == payeeName(r.trx) {
+			continue
+		}
+		edits[key] = r.edit.Payee
+		if r.edit.Payee == r.trx.Payee {
+			edits[key] = ""
+		}
+	}
+	if len(edits) == 0 {
+		return
+	}
+
+	payeeAliasesMu.Lock()
+	defer payeeAliasesMu.Unlock()
+	// the builtin delete is shadowed by the --delete flag
+	aliases := make(map[string]string, len(payeeAliases)+len(edits))
+	for key, payee := range payeeAliases {
+		aliases[key] = payee
+	}
+	for key, payee := range edits {
+		aliases[key] = payee
+	}
+	payeeAliases = make(map[string]string, len(aliases))
+	for key, payee := range aliases {
+		if payee != "" {
+			payeeAliases[key] = payee
+		}
+	}
+	b, err := json.MarshalIndent(payeeAliases, "", "  ")
+	if err == nil {
+		stateMu.Lock()
+		err = writeFileAtomic(filepath.Join(profileFolder().Path, payeeAliasesFile), b, 0600)
+		stateMu.Unlock()
+	}
+	if err != nil {
+		fmt.Fprintf(stdout, "failed to save payee aliases: %v\n", err)
+		return
+	}
+	fmt.Fprintf(stdout, "remembered %d payee(s) for the next runs\n", len(edits))
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/shibukawa/configdir"
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	pipelinesFile = "pipelines.json"
+)
+
+var (
+	allPipelines  bool
+	pipelinesPath string
+)
+
+// pipeline is a sync of one profile run by --all, like a bca account or
+// an e-wallet export. each profile keeps its own credentials, preferences
+// and state, and args are the flags and command it is run with
+type pipeline struct {
+	Name    string   `json:"name"`
+	Profile string   `json:"profile"`
+	Args    []string `json:"args,omitempty"`
+}
+
+type pipelinesConfig struct {
+	Pipelines []pipeline `json:"pipelines"`
+}
+
+// pipelineResult is the outcome of a pipeline for the combined summary
+type pipelineResult struct {
+	pipeline
+	ExitCode int
+	Run      *runSummary
+}
+
+// configRoot is the folder holding the profiles
+func configRoot() string {
+	if configDir != "" {
+		return configDir
+	}
+	return configDirs.QueryFolders(configdir.Global)[0].Path
+}
+
+// pipelinesFilePath is --pipelines or the file in the config folder
+func pipelinesFilePath() string {
+	if pipelinesPath != "" {
+		return pipelinesPath
+	}
+	return filepath.Join(configRoot(), pipelinesFile)
+}
+
+// readPipelines reads the pipelines file. without one, every profile is
+// synced with its preferences
+func readPipelines() ([]pipeline, error) {
+	b, err := ioutil.ReadFile(pipelinesFilePath())
+	if os.IsNotExist(err) && pipelinesPath == "" {
+		fis, err := ioutil.ReadDir(filepath.Join(configRoot(), "profiles"))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		var ps []pipeline
+		for _, fi := range fis {
+			if fi.IsDir() {
+				ps = append(ps, pipeline{Name: fi.Name(), Profile: fi.Name()})
+			}
+		}
+		if len(ps) == 0 {
+			return nil, fmt.Errorf("no %s and no profiles to sync", pipelinesFilePath())
+		}
+		return ps, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var c pipelinesConfig
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, errors.Wrap(err, "invalid pipelines")
+	}
+	if len(c.Pipelines) == 0 {
+		return nil, fmt.Errorf("no pipelines in %s", pipelinesFilePath())
+	}
+	names := make(map[string]bool)
+	for i, p := range c.Pipelines {
+		if p.Profile == "" || strings.ContainsAny(p.Profile, `/\.`) {
+			return nil, fmt.Errorf("pipeline %d has invalid profile name %q", i+1, p.Profile)
+		}
+		for _, arg := range p.Args {
+			if arg == "--all" {
+				return nil, fmt.Errorf("pipeline %d can't run --all", i+1)
+			}
+		}
+		if p.Name == "" {
+			c.Pipelines[i].Name = p.Profile
+		}
+		if names[c.Pipelines[i].Name] {
+			return nil, fmt.Errorf("pipeline %s is configured twice", c.Pipelines[i].Name)
+		}
+		names[c.Pipelines[i].Name] = true
+	}
+	return c.Pipelines, nil
+}
+
+// runPipelines runs every pipeline one after another in its own process, so
+// that a failing pipeline, or its global state, can't affect the others,
+// and prints a combined summary
+func runPipelines(c *cli.Context) error {
+	ps, err := readPipelines()
+	if err != nil {
+		return err
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	results := make([]pipelineResult, len(ps))
+	for i, p := range ps {
+		results[i] = runPipeline(c, exe, p)
+	}
+
+	w := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "PIPELINE\tPROFILE\tFETCHED\tCREATED\tDUPLICATES\tSTATUS\n")
+	failed := 0
+	for _, r := range results {
+		fetched, created, duplicates := "-", "-", "-"
+		if r.Run != nil {
+			fetched, created, duplicates = fmt.Sprint(r.Run.Fetched), fmt.Sprint(r.Run.Created), fmt.Sprint(r.Run.Duplicates)
+		}
+		status := colorize(colorGreen, "ok")
+		if r.ExitCode != 0 && r.ExitCode != exitNothingToSync {
+			failed++
+			status = colorize(colorRed, fmt.Sprintf("failed (%d)", r.ExitCode))
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", r.Name, r.Profile, fetched, created, duplicates, status)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	switch {
+	case failed == 0:
+		return nil
+	case failed < len(results):
+		return withExitCode(fmt.Errorf("%d of %d pipelines failed", failed, len(results)), exitPartialFailure)
+	default:
+		return errors.New("all pipelines failed")
+	}
+}
+
+func runPipeline(c *cli.Context, exe string, p pipeline) pipelineResult {
+	args := []string{"--profile", p.Profile}
+	if configDir != "" {
+		args = append(args, "--config", configDir)
+	}
+	if quiet {
+		args = append(args, "--quiet")
+	}
+	if noColor {
+		args = append(args, "--no-color")
+	}
+	args = append(args, p.Args...)
+
+	start := time.Now()
+	cmd := exec.CommandContext(c.Context, exe, args...)
+	prefix := "[" + p.Name + "] "
+	out, errOut := &prefixWriter{w: dataout, prefix: prefix}, &prefixWriter{w: stderr, prefix: prefix}
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, out, errOut
+	err := cmd.Run()
+	out.Flush()
+	errOut.Flush()
+
+	r := pipelineResult{pipeline: p}
+	if err != nil {
+		r.ExitCode = 1
+		if ee, ok := err.(*exec.ExitError); ok {
+			r.ExitCode = ee.ExitCode()
+		} else {
+			fmt.Fprintf(stderr, "%sfailed to run: %v\n", prefix, err)
+		}
+	}
+	r.Run = lastRunOf(p.Profile, start)
+	return r
+}
+
+// lastRunOf returns the run of profile recorded since start, if any
+func lastRunOf(name string, start time.Time) *runSummary {
+	current := profile
+	profile = name
+	defer func() { profile = current }()
+
+	runs, err := readHistory()
+	if err != nil || len(runs) == 0 {
+		return nil
+	}
+	last := runs[len(runs)-1]
+	if last.Start.Before(start.Truncate(time.Second)) {
+		return nil
+	}
+	return last
+}
+
+// prefixWriter prefixes every line written to w, for interleaved output
+type prefixWriter struct {
+	w      io.Writer
+	prefix string
+
+	mu  sync.Mutex
+	buf []byte
+}
+
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.buf = append(p.buf, b...)
+	for {
+		i := bytes.IndexByte(p.buf, '\n')
+		if i < 0 {
+			return len(b), nil
+		}
+		if _, err := io.WriteString(p.w, p.prefix+string(p.buf[:i+1])); err != nil {
+			return 0, err
+		}
+		p.buf = p.buf[i+1:]
+	}
+}
+
+// Flush writes a last line without a newline
+func (p *prefixWriter) Flush() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.buf) > 0 {
+		io.WriteString(p.w, p.prefix+string(p.buf)+"\n")
+		p.buf = nil
+	}
+}
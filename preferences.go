@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	preferencesFile = "preferences.json"
+)
+
+var (
+	preferencesPath string
+)
+
+// preferences are the non-secret settings of a profile. unlike the
+// credentials they are plain json meant to be kept in dotfiles, and unlike
+// a template they include settings specific to one person's accounts
+type preferences struct {
+	template
+	Budget      string `json:"budget,omitempty"`
+	CSV         bool   `json:"csv,omitempty"`
+	YNAB        bool   `json:"ynab,omitempty"`
+	FireflyURL  string `json:"fireflyUrl,omitempty"`
+	Journal     string `json:"journal,omitempty"`
+	JournalFile string `json:"journalFile,omitempty"`
+	Sheet       string `json:"sheet,omitempty"`
+	SheetName   string `json:"sheetName,omitempty"`
+	// FireflyCurrency is --firefly-currency
+	FireflyCurrency string `json:"fireflyCurrency,omitempty"`
+	// BCAAccountNumber is --bca-account-number
+	BCAAccountNumber string `json:"bcaAccountNumber,omitempty"`
+	// Mode is --mode, balance-only for tracking accounts
+	Mode string `json:"mode,omitempty"`
+	// --email settings. the smtp password is a secret and isn't kept here
+	Email        string `json:"email,omitempty"`
+	EmailFrom    string `json:"emailFrom,omitempty"`
+	EmailWhen    string `json:"emailWhen,omitempty"`
+	SMTPHost     string `json:"smtpHost,omitempty"`
+	SMTPPort     int    `json:"smtpPort,omitempty"`
+	SMTPUsername string `json:"smtpUsername,omitempty"`
+	// notification channels, without their tokens
+	NotifyWhen       string `json:"notifyWhen,omitempty"`
+	NtfyTopic        string `json:"ntfyTopic,omitempty"`
+	NtfyPriority     string `json:"ntfyPriority,omitempty"`
+	PushoverUser     string `json:"pushoverUser,omitempty"`
+	PushoverPriority int    `json:"pushoverPriority,omitempty"`
+}
+
+func currentPreferences() preferences {
+	return preferences{
+		template:    currentTemplate(),
+		Budget:      budget,
+		CSV:         csvFlag,
+		YNAB:        ynabFlag,
+		FireflyURL:  fireflyUrl,
+		Journal:     journalFormat,
+		JournalFile: journalFile,
+		Sheet:       sheetID,
+		SheetName:   sheetName,
+
+		FireflyCurrency:  fireflyCurrency,
+		BCAAccountNumber: bcaAccountNumber,
+		Mode:             syncMode,
+
+		Email:        emailTo,
+		EmailFrom:    emailFrom,
+		EmailWhen:    emailWhen,
+		SMTPHost:     smtpHost,
+		SMTPPort:     smtpPort,
+		SMTPUsername: smtpUsername,
+
+		NotifyWhen:       notifyWhen,
+		NtfyTopic:        ntfyTopic,
+		NtfyPriority:     ntfyPriority,
+		PushoverUser:     pushoverUser,
+		PushoverPriority: pushoverPriority,
+	}
+}
+
+// flags returns the preferences as flag values by flag name
+func (p preferences) flags() map[string]string {
+	fs := p.template.flags()
+	if p.Budget != "" {
+		fs["budget"] = p.Budget
+	}
+	if p.CSV {
+		fs["csv"] = strconv.FormatBool(p.CSV)
+	}
+	if p.YNAB {
+		fs["ynab"] = strconv.FormatBool(p.YNAB)
+	}
+	if p.FireflyURL != "" {
+		fs["firefly-url"] = p.FireflyURL
+	}
+	if p.Journal != "" {
+		fs["journal"] = p.Journal
+	}
+	if p.JournalFile != "" {
+		fs["journal-file"] = p.JournalFile
+	}
+	if p.Sheet != "" {
+		fs["sheet"] = p.Sheet
+	}
+	if p.SheetName != "" {
+		fs["sheet-name"] = p.SheetName
+	}
+	if p.FireflyCurrency != "" {
+		fs["firefly-currency"] = p.FireflyCurrency
+	}
+	if p.BCAAccountNumber != "" {
+		fs["bca-account-number"] = p.BCAAccountNumber
+	}
+	if p.Mode != "" {
+		fs["mode"] = p.Mode
+	}
+	if p.Email != "" {
+		fs["email"] = p.Email
+	}
+	if p.EmailFrom != "" {
+		fs["email-from"] = p.EmailFrom
+	}
+	if p.EmailWhen != "" {
+		fs["email-when"] = p.EmailWhen
+	}
+	if p.SMTPHost != "" {
+		fs["smtp-host"] = p.SMTPHost
+	}
+	if p.SMTPPort != 0 {
+		fs["smtp-port"] = strconv.Itoa(p.SMTPPort)
+	}
+	if p.SMTPUsername != "" {
+		fs["smtp-username"] = p.SMTPUsername
+	}
+	if p.NotifyWhen != "" {
+		fs["notify-when"] = p.NotifyWhen
+	}
+	if p.NtfyTopic != "" {
+		fs["ntfy-topic"] = p.NtfyTopic
+	}
+	if p.NtfyPriority != "" {
+		fs["ntfy-priority"] = p.NtfyPriority
+	}
+	if p.PushoverUser != "" {
+		fs["pushover-user"] = p.PushoverUser
+	}
+	if p.PushoverPriority != 0 {
+		fs["pushover-priority"] = strconv.Itoa(p.PushoverPriority)
+	}
+	return fs
+}
+
+// preferencesFilePath is --preferences or the file in the profile folder
+func preferencesFilePath() string {
+	if preferencesPath != "" {
+		return preferencesPath
+	}
+	return filepath.Join(profileFolder().Path, preferencesFile)
+}
+
+func savePreferencesAction(c *cli.Context) error {
+	b, err := json.MarshalIndent(currentPreferences(), "", "  ")
+	if err != nil {
+		return err
+	}
+	path := preferencesFilePath()
+	if err := writeFileAtomic(path, append(b, '\n'), 0644); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "saved preferences to %s. they are used for flags that aren't set\n", path)
+	return nil
+}
+
+// applyPreferences sets flags that weren't given from the saved
+// preferences. it runs before applyTemplate, so preferences win over an
+// imported template
+func applyPreferences(c *cli.Context) error {
+	b, err := ioutil.ReadFile(preferencesFilePath())
+	if os.IsNotExist(err) && preferencesPath == "" {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var p preferences
+	if err := json.Unmarshal(b, &p); err != nil {
+		return errors.Wrap(err, "invalid preferences")
+	}
+	if p.Version != templateVersion {
+		return fmt.Errorf("unsupported preferences version %d", p.Version)
+	}
+	source := sourcePreferences + " " + preferencesFilePath()
+	for name, value := range p.flags() {
+		if err := setFlagFrom(c, source, name, value); err != nil {
+			return fmt.Errorf("failed to apply preference %s: %w", name, err)
+		}
+	}
+	return nil
+}
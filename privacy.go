@@ -0,0 +1,176 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/satraul/bca-go"
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	obfuscationKeyFile = "obfuscation-key"
+	obfuscationMapFile = "obfuscation-map.json"
+	payeeTokenPrefix   = "payee-"
+)
+
+var (
+	// accountNumber matches bca account and card numbers
+	accountNumber = regexp.MustCompile(`\b\d{6,}(\d{4})\b`)
+
+	obfuscatorOnce sync.Once
+	obfuscatorImpl obfuscator
+)
+
+// obfuscator hides personal data in anything that leaves the machine, like
+// exports and notifications
+type obfuscator interface {
+	payee(s string) string
+	text(s string) string
+}
+
+// obfuscation returns the configured obfuscator
+func obfuscation() obfuscator {
+	obfuscatorOnce.Do(func() {
+		if !obfuscate {
+			obfuscatorImpl = noopObfuscator{}
+			return
+		}
+		o, err := newKeyedObfuscator()
+		if err != nil {
+			// never leak data because the key is unavailable
+			fmt.Fprintf(stdout, "failed to load obfuscation key, redacting instead: %v\n", err)
+			obfuscatorImpl = redactingObfuscator{}
+			return
+		}
+		obfuscatorImpl = o
+	})
+	return obfuscatorImpl
+}
+
+func obfuscateEntries(trxs []bca.Entry) []bca.Entry {
+	o := obfuscation()
+	out := make([]bca.Entry, len(trxs))
+	for i, trx := range trxs {
+		payee := o.payee(trx.Payee)
+		if trx.Payee != "" {
+			trx.Description = strings.ReplaceAll(trx.Description, trx.Payee, payee)
+		}
+		trx.Description = o.text(trx.Description)
+		trx.Payee = payee
+		out[i] = trx
+	}
+	return out
+}
+
+type noopObfuscator struct{}
+
+func (noopObfuscator) payee(s string) string { return s }
+func (noopObfuscator) text(s string) string  { return s }
+
+type redactingObfuscator struct{}
+
+func (redactingObfuscator) payee(s string) string { return "redacted" }
+func (redactingObfuscator) text(s string) string  { return maskAccountNumbers(s) }
+
+// keyedObfuscator replaces payees with an hmac of them. the key and the
+// token to payee map stay in the profile folder, so only this machine can
+// reverse them
+type keyedObfuscator struct {
+	key []byte
+
+	mu      sync.Mutex
+	mapping map[string]string
+}
+
+func newKeyedObfuscator() (*keyedObfuscator, error) {
+	folder := profileFolder()
+	keyPath := filepath.Join(folder.Path, obfuscationKeyFile)
+	key, err := ioutil.ReadFile(keyPath)
+	if os.IsNotExist(err) {
+		key = make([]byte, 32)
+		if _, err := io.ReadFull(rand.Reader, key); err != nil {
+			return nil, err
+		}
+		if err := writeFileAtomic(keyPath, key, 0600); err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	}
+
+	mapping, err := readObfuscationMap()
+	if err != nil {
+		return nil, err
+	}
+	return &keyedObfuscator{key: key, mapping: mapping}, nil
+}
+
+func (k *keyedObfuscator) payee(s string) string {
+	if s == "" {
+		return s
+	}
+	mac := hmac.New(sha256.New, k.key)
+	mac.Write([]byte(s))
+	token := payeeTokenPrefix + hex.EncodeToString(mac.Sum(nil))[:12]
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if _, ok := k.mapping[token]; !ok {
+		k.mapping[token] = s
+		b, _ := json.Marshal(k.mapping)
+		if err := writeFileAtomic(filepath.Join(profileFolder().Path, obfuscationMapFile), b, 0600); err != nil {
+			fmt.Fprintf(stdout, "failed to save obfuscation map: %v\n", err)
+		}
+	}
+	return token
+}
+
+func (k *keyedObfuscator) text(s string) string {
+	return maskAccountNumbers(s)
+}
+
+// maskAccountNumbers keeps only the last 4 digits of account numbers
+func maskAccountNumbers(s string) string {
+	return accountNumber.ReplaceAllStringFunc(s, func(n string) string {
+		return strings.Repeat("*", len(n)-4) + n[len(n)-4:]
+	})
+}
+
+func readObfuscationMap() (map[string]string, error) {
+	mapping := make(map[string]string)
+	b, err := ioutil.ReadFile(filepath.Join(profileFolder().Path, obfuscationMapFile))
+	if os.IsNotExist(err) {
+		return mapping, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return mapping, json.Unmarshal(b, &mapping)
+}
+
+func revealAction(c *cli.Context) error {
+	mapping, err := readObfuscationMap()
+	if err != nil {
+		return err
+	}
+	for _, token := range c.Args().Slice() {
+		payee, ok := mapping[token]
+		if !ok {
+			payee = "unknown"
+		}
+		fmt.Fprintf(stdout, "%s: %s\n", token, payee)
+	}
+	return nil
+}
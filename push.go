@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/satraul/bca-go"
+)
+
+// destinationResult is the outcome of pushing to a single destination
+type destinationResult struct {
+	Name       string `json:"name"`
+	Created    int    `json:"created"`
+	Duplicates int    `json:"duplicates"`
+	Adjusted   bool   `json:"adjusted"`
+	// AdjustmentError is set when only the balance adjustment failed
+	AdjustmentError string `json:"adjustmentError,omitempty"`
+	Error           string `json:"error,omitempty"`
+	// Transactions are the transactions created by the destination
+	Transactions []createdTransaction `json:"transactions,omitempty"`
+	// DuplicateTransactions are the transactions the destination reported
+	// as already there, so that deduplication can be checked
+	DuplicateTransactions []createdTransaction `json:"duplicateTransactions,omitempty"`
+	// Budget and Account identify the target of a ynab destination
+	Budget  string `json:"budget,omitempty"`
+	Account string `json:"account,omitempty"`
+
+	// categories are the categories assigned by the destination by import id
+	categories map[string]string
+	// accepted are the entries the destination created or already had, as
+	// it got them
+	accepted []ledgerEntry
+}
+
+// acceptedEntries returns the ledger entries of those r created or
+// reported as duplicates, with the type, payee and import id the
+// destination got. mapped are the entries as it got them
+func acceptedEntries(r destinationResult, mapped []bca.Entry) []ledgerEntry {
+	have := make(map[string]bool, len(r.Transactions)+len(r.DuplicateTransactions))
+	for _, t := range r.Transactions {
+		have[t.ImportID] = true
+	}
+	for _, t := range r.DuplicateTransactions {
+		have[t.ImportID] = true
+	}
+	var accepted []ledgerEntry
+	for i, id := range importIDs(mapped) {
+		if have[id] {
+			e := toLedgerEntry(mapped[i], id)
+			e.Payee = payeeName(mapped[i])
+			accepted = append(accepted, e)
+		}
+	}
+	return accepted
+}
+
+const (
+	sourceKlikBCA    = "KlikBCA"
+	sourceEStatement = "e-statement"
+)
+
+type provenanceKey struct{}
+
+// provenance is where pushed transactions come from, for destinations that
+// can keep it with them
+type provenance struct {
+	RunID  string
+	Source string
+}
+
+func provenanceFrom(ctx context.Context) provenance {
+	p, _ := ctx.Value(provenanceKey{}).(provenance)
+	return p
+}
+
+type destination struct {
+	name string
+	push func(ctx context.Context, config *config, bal bca.Balance, auth []*http.Cookie, trxs []bca.Entry) (destinationResult, error)
+}
+
+// destinations returns the configured destinations. ynab is used unless
+// another destination is configured without --ynab
+func destinations() []destination {
+	var ds []destination
+	if csvFlag {
+		ds = append(ds, destination{"csv", pushCsv})
+	}
+	if fireflyUrl != "" {
+		ds = append(ds, destination{"firefly", pushFirefly})
+	}
+	if journalFormat != "" {
+		ds = append(ds, destination{"journal", pushJournal})
+	}
+	if sheetID != "" {
+		ds = append(ds, destination{"sheets", pushSheets})
+	}
+	if ynabEnabled() {
+		ds = append(ds, destination{"ynab", pushYNAB(ynabTarget{Budget: budget, Account: accountName})})
+		for _, t := range ynabTargets {
+			ds = append(ds, destination{"ynab:" + t.String(), pushYNAB(t)})
+		}
+	}
+	return ds
+}
+
+func ynabEnabled() bool {
+	return !(csvFlag || fireflyUrl != "" || journalFormat != "" || sheetID != "") || ynabFlag
+}
+
+// pushTransactions sends trxs to ds concurrently. a failing destination
+// doesn't stop the others
+func pushTransactions(ctx context.Context, ds []destination, config *config, bal bca.Balance, auth []*http.Cookie, trxs []bca.Entry, summary *runSummary) error {
+	var (
+		results = make([]destinationResult, len(ds))
+		errs    = make([]error, len(ds))
+		wg      sync.WaitGroup
+	)
+	ctx = context.WithValue(ctx, provenanceKey{}, provenance{RunID: summary.ID, Source: summary.Source})
+	for i, d := range ds {
+		wg.Add(1)
+		mapped, _ := mapTypes(d.name, trxs)
+		go func(i int, d destination) {
+			defer wg.Done()
+			results[i], errs[i] = d.push(ctx, config, bal, auth, mapped)
+			results[i].Name = d.name
+			results[i].accepted = acceptedEntries(results[i], mapped)
+			if errs[i] != nil {
+				results[i].Error = errs[i].Error()
+			}
+		}(i, d)
+	}
+	wg.Wait()
+
+	var failed []string
+	for i, r := range results {
+		summary.Created += r.Created
+		summary.Duplicates += r.Duplicates
+		summary.Adjusted = summary.Adjusted || r.Adjusted
+		if errs[i] != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", r.Name, errs[i]))
+		}
+	}
+	summary.Destinations = results
+
+	switch {
+	case len(failed) == 0:
+		return nil
+	case len(ds) == 1:
+		return withExitCode(errs[0], exitDestinationFailed)
+	case len(failed) < len(ds):
+		for _, r := range results {
+			fmt.Fprintf(stdout, "%s: %s\n", r.Name, destinationStatus(r))
+		}
+		return withExitCode(fmt.Errorf("some destinations failed:\n%s", strings.Join(failed, "\n")), exitPartialFailure)
+	default:
+		return withExitCode(fmt.Errorf("all destinations failed:\n%s", strings.Join(failed, "\n")), exitDestinationFailed)
+	}
+}
+
+func pushCsv(ctx context.Context, config *config, bal bca.Balance, auth []*http.Cookie, trxs []bca.Entry) (destinationResult, error) {
+	trxCsv, err := transactionsToCsv(obfuscateEntries(trxs))
+	if err != nil {
+		return destinationResult{}, fmt.Errorf("enable to csv marshal string: %w", err)
+	}
+	fmt.Fprint(dataout, trxCsv)
+	result := destinationResult{Created: len(trxs)}
+	for i, importID := range importIDs(trxs) {
+		result.Transactions = append(result.Transactions, toCreatedTransaction(trxs[i], importID, ""))
+	}
+	return result, nil
+}
+
+func pushFirefly(ctx context.Context, config *config, bal bca.Balance, auth []*http.Cookie, trxs []bca.Entry) (destinationResult, error) {
+	// transactions created before a failure are kept so they can be undone
+	created, duplicates, err := createFireflyTransactions(ctx, bal, trxs)
+	result := destinationResult{Created: len(created), Transactions: created, Duplicates: len(duplicates), DuplicateTransactions: duplicates}
+	if err != nil {
+		return result, fmt.Errorf("failed to create firefly transactions: %w", err)
+	}
+	return result, nil
+}
+
+// pushYNAB returns a destination pushing to the ynab target t. ynab
+// deduplicates import ids per account, so each target is deduplicated on
+// its own
+func pushYNAB(t ynabTarget) func(ctx context.Context, config *config, bal bca.Balance, auth []*http.Cookie, trxs []bca.Entry) (destinationResult, error) {
+	return func(ctx context.Context, config *config, bal bca.Balance, auth []*http.Cookie, trxs []bca.Entry) (destinationResult, error) {
+		result, err := pushYNABTarget(ctx, t, config, bal, auth, trxs)
+		return result, ynabHint(err)
+	}
+}
+
+func pushYNABTarget(ctx context.Context, t ynabTarget, config *config, bal bca.Balance, auth []*http.Cookie, trxs []bca.Entry) (destinationResult, error) {
+	result := destinationResult{Budget: t.Budget, Account: t.Account}
+
+	yc, err := newYNABClient(ctx, config.YNABToken)
+	if err != nil {
+		return result, err
+	}
+
+	a, err := getOrCreateYNABAccount(yc, t, bal, trxs)
+	if err != nil {
+		return result, err
+	}
+
+	if len(trxs) > 0 {
+		resp, err := createYNABTransactions(ctx, yc, trxs, a, t.Budget)
+		if resp == nil {
+			return result, fmt.Errorf("failed to create ynab transactions: %w", err)
+		}
+		result.Created = len(resp.TransactionIDs)
+		result.Duplicates = len(resp.DuplicateImportIDs)
+		result.categories = make(map[string]string)
+		ids := make(map[string]string)
+		for _, t := range resp.Transactions {
+			if t.ImportID == nil {
+				continue
+			}
+			ids[*t.ImportID] = t.ID
+			if t.CategoryName != nil {
+				result.categories[*t.ImportID] = *t.CategoryName
+			}
+		}
+		duplicates := make(map[string]bool)
+		for _, importID := range resp.DuplicateImportIDs {
+			duplicates[importID] = true
+		}
+		for i, importID := range importIDs(trxs) {
+			if id, ok := ids[importID]; ok {
+				result.Transactions = append(result.Transactions, toCreatedTransaction(trxs[i], importID, id))
+			}
+			if duplicates[importID] {
+				result.DuplicateTransactions = append(result.DuplicateTransactions, toCreatedTransaction(trxs[i], importID, ""))
+			}
+		}
+		if err != nil {
+			// the created transactions are kept in the result so they can
+			// be undone, and the next run retries the others
+			return result, fmt.Errorf("failed to create ynab transactions: %w", err)
+		}
+	}
+
+	if !noadjust {
+		// the transactions are in, so a failed adjustment only warns. the
+		// adjust command retries the one of the main target, the others
+		// are adjusted by the next run
+		adjusted, err := createYNABBalanceAdjustment(bal, ctx, auth, yc, t.Budget, a)
+		if err != nil {
+			result.AdjustmentError = err.Error()
+			fmt.Fprintf(stdout, "warning: failed to create balance adjustment for %s, retry with the next run or adjust: %v\n", t, err)
+			if t.main() {
+				if err := savePendingAdjustment(bal, err); err != nil {
+					fmt.Fprintf(stdout, "failed to save pending adjustment: %v\n", err)
+				}
+			}
+		} else if t.main() {
+			if err := clearPendingAdjustment(); err != nil {
+				fmt.Fprintf(stdout, "failed to clear pending adjustment: %v\n", err)
+			}
+		}
+		result.Adjusted = adjusted
+	}
+
+	return result, nil
+}
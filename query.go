@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/urfave/cli/v2"
+)
+
+var (
+	getOutput string
+)
+
+// queriedTransaction is a bca transaction printed by get transactions
+type queriedTransaction struct {
+	Date        time.Time       `json:"date"`
+	Pending     bool            `json:"pending"`
+	Payee       string          `json:"payee"`
+	Description string          `json:"description"`
+	Amount      decimal.Decimal `json:"amount"` // negative for debits
+	ImportID    string          `json:"importId"`
+	enrichment
+}
+
+// queriedBalance is the bca balance printed by get balance
+type queriedBalance struct {
+	AccountNumber string          `json:"accountNumber"`
+	Balance       decimal.Decimal `json:"balance"`
+	Time          time.Time       `json:"time"`
+}
+
+// quietOutput sends progress messages to stderr so that stdout only has
+// the queried data, and returns the writer for the data
+func quietOutput() io.Writer {
+	out := stdout
+	stdout = stderr
+	return out
+}
+
+func validateGetOutput() error {
+	switch getOutput {
+	case "table", "json":
+		return nil
+	default:
+		return fmt.Errorf("unknown output %q, expected table or json", getOutput)
+	}
+}
+
+// getTransactionsAction prints the bca transactions of the statement window
+// without pushing them anywhere
+func getTransactionsAction(c *cli.Context) error {
+	if err := validateGetOutput(); err != nil {
+		return err
+	}
+	if c.IsSet("days") {
+		days = c.Int("days")
+	}
+	out := quietOutput()
+
+	config, err := getOrDeleteConfig(username, password, token, false, noninteractive, reset, nostore)
+	if err != nil {
+		return err
+	}
+	if config == nil {
+		return nil
+	}
+	_, trxs, _, err := fetchBCA(c.Context, config)
+	if err != nil {
+		return err
+	}
+	trxs = filterTransactions(trxs)
+
+	ids := importIDs(trxs)
+	qs := make([]queriedTransaction, 0, len(trxs))
+	for i, trx := range trxs {
+		e := toLedgerEntry(trx, ids[i])
+		qs = append(qs, queriedTransaction{
+			Date:        e.Date,
+			Pending:     trx.Date.IsZero(),
+			Payee:       e.Payee,
+			Description: e.Description,
+			Amount:      e.Amount,
+			ImportID:    ids[i],
+			enrichment:  e.enrichment,
+		})
+	}
+
+	if getOutput == "json" {
+		return json.NewEncoder(out).Encode(qs)
+	}
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "DATE\tPAYEE\tAMOUNT\tDESCRIPTION\n")
+	for _, q := range qs {
+		date := q.Date.Format(dateLayout)
+		if q.Pending {
+			date = "pending"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", date, q.Payee, q.Amount.StringFixed(2), q.Description)
+	}
+	return w.Flush()
+}
+
+// getBalanceAction prints the bca balance without pushing it anywhere
+func getBalanceAction(c *cli.Context) error {
+	if err := validateGetOutput(); err != nil {
+		return err
+	}
+	out := quietOutput()
+
+	config, err := getOrDeleteConfig(username, password, token, false, noninteractive, reset, nostore)
+	if err != nil {
+		return err
+	}
+	if config == nil {
+		return nil
+	}
+	bal, err := fetchBCABalance(c.Context, config)
+	if err != nil {
+		return err
+	}
+
+	q := queriedBalance{AccountNumber: bal.AccountNumber, Balance: bal.Balance, Time: time.Now()}
+	if getOutput == "json" {
+		return json.NewEncoder(out).Encode(q)
+	}
+	fmt.Fprintf(out, "%s\n", q.Balance.StringFixed(2))
+	return nil
+}
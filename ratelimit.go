@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// ynabRateLimitReserve is the number of requests left in the window below
+	// which requests are spaced out
+	ynabRateLimitReserve = 10
+	ynabRateLimitRetries = 3
+	// ynabRequestTimeout bounds every attempt of a ynab request, not the
+	// waits for the rate limit between them
+	ynabRequestTimeout = 30 * time.Second
+)
+
+// rateLimitTransport tracks ynab's X-Rate-Limit header ("used/total" in a
+// rolling hour), slows down when the quota runs low and waits out 429s
+type rateLimitTransport struct {
+	base http.RoundTripper
+
+	mu    sync.Mutex
+	used  int
+	total int
+}
+
+func (t *rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		if err := t.throttle(req.Context()); err != nil {
+			return nil, err
+		}
+
+		// a round tripper mustn't change the request it is given, so every
+		// attempt sends a clone with its own deadline and body
+		ctx, cancel := context.WithTimeout(req.Context(), ynabRequestTimeout)
+		r := req.Clone(ctx)
+		if attempt > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				cancel()
+				return nil, err
+			}
+			r.Body = body
+		}
+		resp, err := t.base.RoundTrip(r)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		t.update(resp.Header.Get("X-Rate-Limit"))
+
+		if resp.StatusCode != http.StatusTooManyRequests || attempt == ynabRateLimitRetries || req.GetBody == nil && req.Body != nil {
+			// the deadline covers reading the body too
+			resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+			return resp, nil
+		}
+		resp.Body.Close()
+		cancel()
+
+		wait := time.Minute
+		if s, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+			wait = time.Duration(s) * time.Second
+		}
+		fmt.Fprintf(stdout, "ynab rate limit reached, retrying in %s\n", wait)
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// cancelBody cancels the context of its request once it is closed
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	defer b.cancel()
+	return b.ReadCloser.Close()
+}
+
+// throttle spreads the remaining quota over the rest of the hour once it
+// runs low
+func (t *rateLimitTransport) throttle(ctx context.Context) error {
+	t.mu.Lock()
+	total, remaining := t.total, t.total-t.used
+	t.mu.Unlock()
+	if total == 0 || remaining > ynabRateLimitReserve {
+		return nil
+	}
+	if remaining < 1 {
+		remaining = 1
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(time.Hour / time.Duration(remaining*ynabRateLimitReserve)):
+		return nil
+	}
+}
+
+func (t *rateLimitTransport) update(header string) {
+	parts := strings.SplitN(header, "/", 2)
+	if len(parts) != 2 {
+		return
+	}
+	used, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return
+	}
+	total, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return
+	}
+
+	t.mu.Lock()
+	t.used, t.total = used, total
+	t.mu.Unlock()
+
+	if verbose {
+		fmt.Fprintf(stdout, "ynab rate limit: %d of %d requests remaining\n", total-used, total)
+	}
+}
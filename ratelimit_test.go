@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateLimitRetriesWithBody(t *testing.T) {
+	oldStdout := stdout
+	stdout = ioutil.Discard
+	defer func() { stdout = oldStdout }()
+
+	var bodies []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, string(b))
+		if len(bodies) == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	req, err := http.NewRequest(http.MethodPost, srv.URL, strings.NewReader("payload"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: &rateLimitTransport{base: http.DefaultTransport}}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil || string(b) != "ok" {
+		t.Fatalf("response = %q, %v, want ok", b, err)
+	}
+	if len(bodies) != 2 || bodies[0] != "payload" || bodies[1] != "payload" {
+		t.Errorf("server got bodies %q, want the payload twice", bodies)
+	}
+}
+
+func TestThrottleStopsWithContext(t *testing.T) {
+	tr := &rateLimitTransport{used: 100, total: 100}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := tr.throttle(ctx); err != context.DeadlineExceeded {
+		t.Errorf("throttle() = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("throttle() ignored the context for %s", time.Since(start))
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"text/tabwriter"
+	"time"
+
+	"github.com/satraul/bca-go"
+	"github.com/shopspring/decimal"
+	"github.com/urfave/cli/v2"
+	"go.bmvs.io/ynab/api/transaction"
+)
+
+var (
+	reconcileToleranceDays int
+)
+
+// reconcileAction compares the bca statement window with the ynab account
+// and lists transactions only present on one side. transactions are
+// matched by import id, then by amount within --tolerance-days so that
+// manually entered ones count as matched
+func reconcileAction(c *cli.Context) error {
+	config, err := getOrDeleteConfig(username, password, token, false, noninteractive, reset, nostore)
+	if err != nil {
+		return err
+	}
+	if config == nil {
+		return nil
+	}
+
+	start, end, err := statementRange()
+	if err != nil {
+		return err
+	}
+	bal, trxs, _, err := fetchBCA(c.Context, config)
+	if err != nil {
+		return err
+	}
+	trxs = filterTransactions(trxs)
+
+	yc, err := newYNABClient(c.Context, config.YNABToken)
+	if err != nil {
+		return err
+	}
+	a, err := getYNABAccount(yc, budget, accountName)
+	if err != nil {
+		return err
+	}
+	ts, err := yc.getTransactions(budget, a.ID, start)
+	if err != nil {
+		return fmt.Errorf("failed to get ynab transactions: %w", err)
+	}
+
+	ids := importIDs(trxs)
+	onlyBCA, onlyYNAB := reconcile(trxs, ids, ts, end, reconcileToleranceDays)
+
+	w := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "only in bca (%d):\n", len(onlyBCA))
+	for _, i := range onlyBCA {
+		trx := trxs[i]
+		p := toPayloadTransaction(trx, "", ids[i])
+		fmt.Fprintf(w, "  %s\t%s\t%s\t%s\n", p.Date.Format(dateLayout), milliunitsToDecimal(p.Amount).StringFixed(2), trx.Payee, ids[i])
+	}
+	fmt.Fprintf(w, "only in ynab (%d):\n", len(onlyYNAB))
+	for _, t := range onlyYNAB {
+		payee := ""
+		if t.PayeeName != nil {
+			payee = *t.PayeeName
+		}
+		fmt.Fprintf(w, "  %s\t%s\t%s\t%s\n", t.Date.Format(dateLayout), milliunitsToDecimal(t.Amount).StringFixed(2), payee, t.ID)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(stdout, "bca balance %s, ynab balance %s\n", bal.Balance.StringFixed(2), milliunitsToDecimal(a.Balance).StringFixed(2))
+	return nil
+}
+
+// reconcile returns the indexes of the bca entries without a ynab
+// transaction and the ynab transactions up to end without a bca entry. ids
+// are the import ids of trxs
+func reconcile(trxs []bca.Entry, ids []string, ts []*transaction.Transaction, end time.Time, toleranceDays int) ([]int, []*transaction.Transaction) {
+	var (
+		byImportID = make(map[string]int)
+		matched    = make([]bool, len(ts))
+		unmatched  []int
+	)
+	for i, t := range ts {
+		if t.ImportID != nil {
+			byImportID[*t.ImportID] = i
+		}
+	}
+	for n, id := range ids {
+		if i, ok := byImportID[id]; ok && !matched[i] {
+			matched[i] = true
+			continue
+		}
+		unmatched = append(unmatched, n)
+	}
+
+	var onlyBCA []int
+	tolerance := time.Duration(toleranceDays) * 24 * time.Hour
+	for _, n := range unmatched {
+		p := toPayloadTransaction(trxs[n], "", ids[n])
+		found := false
+		for i, t := range ts {
+			if matched[i] || t.Deleted || t.Amount != p.Amount {
+				continue
+			}
+			if d := t.Date.Sub(p.Date.Time); d <= tolerance && d >= -tolerance {
+				matched[i] = true
+				found = true
+				break
+			}
+		}
+		if !found {
+			onlyBCA = append(onlyBCA, n)
+		}
+	}
+
+	var onlyYNAB []*transaction.Transaction
+	for i, t := range ts {
+		if matched[i] || t.Deleted || t.Date.After(end) {
+			continue
+		}
+		onlyYNAB = append(onlyYNAB, t)
+	}
+	return onlyBCA, onlyYNAB
+}
+
+func milliunitsToDecimal(m int64) decimal.Decimal {
+	return decimal.New(m, -3)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/satraul/bca-go"
+)
+
+const (
+	inflightFile = "inflight.json"
+)
+
+// inflightRun is written before a run pushes and removed once the push
+// returned, so that the next run finds a run that was killed midway, by a
+// crash, a reboot or the oom killer, and finishes it
+type inflightRun struct {
+	RunID   string      `json:"runId"`
+	Start   time.Time   `json:"start"`
+	Source  string      `json:"source"`
+	From    time.Time   `json:"from"`
+	To      time.Time   `json:"to"`
+	Balance bca.Balance `json:"balance"`
+	// Entries are the entries pushed, after filters, transforms and review
+	Entries      []bca.Entry `json:"entries"`
+	Destinations []string    `json:"destinations"`
+}
+
+type resumingKey struct{}
+
+// resuming tells whether ctx pushes the entries of an interrupted run again
+func resuming(ctx context.Context) bool {
+	r, _ := ctx.Value(resumingKey{}).(bool)
+	return r
+}
+
+func markInflight(summary *runSummary, ds []destination, bal bca.Balance, trxs []bca.Entry) error {
+	r := inflightRun{
+		RunID:   summary.ID,
+		Start:   summary.Start,
+		Source:  summary.Source,
+		From:    summary.From,
+		To:      summary.To,
+		Balance: bal,
+		Entries: trxs,
+	}
+	for _, d := range ds {
+		r.Destinations = append(r.Destinations, d.name)
+	}
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(profileFolder().Path, inflightFile), b, 0600)
+}
+
+func clearInflight() {
+	err := os.Remove(filepath.Join(profileFolder().Path, inflightFile))
+	if err != nil && !os.IsNotExist(err) {
+		fmt.Fprintf(stdout, "failed to clear %s: %v\n", inflightFile, err)
+	}
+}
+
+func readInflight() (*inflightRun, error) {
+	b, err := ioutil.ReadFile(filepath.Join(profileFolder().Path, inflightFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var r inflightRun
+	if err := json.Unmarshal(b, &r); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", inflightFile, err)
+	}
+	return &r, nil
+}
+
+// datePending dates the pending entries of trxs with their clearance as
+// predicted at start, so that they are pushed as the run started then did
+func datePending(trxs []bca.Entry, start time.Time) []bca.Entry {
+	dated := make([]bca.Entry, len(trxs))
+	for i, trx := range trxs {
+		if trx.Date.IsZero() {
+			trx.Date = clearDate(start)
+		}
+		dated[i] = trx
+	}
+	return dated
+}
+
+// resumeInterrupted finishes a run that was interrupted while pushing, by
+// pushing its entries to its destinations again. the import ids, which ynab,
+// sheets and journals deduplicate by, and firefly's duplicate check of
+// transactions tagged with the run tell what was created already, so only
+// the remainder is. the run is then recorded in the history under its own
+// id, and destinations that fail are left to the fetch cache
+func resumeInterrupted(ctx context.Context, config *config) error {
+	r, err := readInflight()
+	if err != nil {
+		return err
+	}
+	if r == nil {
+		return nil
+	}
+	fmt.Fprintf(stdout, "run %s was interrupted while pushing %d transaction(s) to %v, resuming it\n", r.RunID, len(r.Entries), r.Destinations)
+
+	summary := &runSummary{ID: r.RunID, Start: r.Start, Source: r.Source, From: r.From, To: r.To, Fetched: len(r.Entries), Balance: r.Balance.Balance}
+	ds := onlyDestinations(destinations(), r.Destinations)
+	if len(ds) == 0 {
+		fmt.Fprintf(stdout, "none of the destinations of run %s are configured, check them for missing transactions\n", r.RunID)
+	} else {
+		err = pushTransactions(context.WithValue(ctx, resumingKey{}, true), ds, config, r.Balance, nil, datePending(r.Entries, r.Start), summary)
+		recordLedger(summary)
+		cacheFailedPush(fetchCache{Time: r.Start, From: r.From, To: r.To, Balance: r.Balance, Entries: r.Entries}, summary.Destinations)
+	}
+	summary.End = time.Now()
+	if err != nil {
+		summary.Error = err.Error()
+		fmt.Fprintf(stdout, "failed to resume run %s: %v\n", r.RunID, err)
+	} else {
+		fmt.Fprintf(stdout, "run %s resumed: %d created, %d already there\n", r.RunID, summary.Created, summary.Duplicates)
+	}
+	recordHistory(summary)
+	clearInflight()
+	return nil
+}
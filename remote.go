@@ -0,0 +1,381 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// etagAny writes a remote file whatever its current version
+	etagAny = "*"
+)
+
+var (
+	stateRemote, s3Endpoint string
+
+	errRemoteNotFound = errors.New("not found")
+	errRemoteChanged  = errors.New("changed on the remote by another run")
+
+	// sharedStateFiles are the profile files kept in --state-remote.
+	// credentials and obfuscation keys stay on each machine
+	sharedStateFiles = []string{
+		ledgerFile,
+		historyFile,
+		balancesFile,
+		fetchCacheFile,
+		lastFetchFile,
+		pendingAdjustmentFile,
+		anomaliesFile,
+		lastSuccessFile,
+		importIDStrategyFile,
+		merchantAliasesFile,
+		payeeAliasesFile,
+		typeMapFile,
+	}
+	// appendOnlyStateFiles only ever get lines appended, so that a copy
+	// changed on both sides is merged instead of replaced
+	appendOnlyStateFiles = map[string]bool{
+		ledgerFile:  true,
+		historyFile: true,
+	}
+)
+
+// remoteStore is an s3 compatible bucket or a webdav folder
+type remoteStore interface {
+	// get returns a file and its etag, or errRemoteNotFound
+	get(ctx context.Context, name string) ([]byte, string, error)
+	put(ctx context.Context, name string, data []byte) error
+	// putIfMatch writes a file only if its etag is still etag, or if it
+	// doesn't exist for an empty etag, and returns the new etag. otherwise
+	// it returns errRemoteChanged
+	putIfMatch(ctx context.Context, name string, data []byte, etag string) (string, error)
+	// remove deletes a file if its etag is still etag
+	remove(ctx context.Context, name string, etag string) error
+	String() string
+}
+
+func isRemote(location string) bool {
+	return strings.HasPrefix(location, s3Scheme) || strings.HasPrefix(location, "https://") || strings.HasPrefix(location, "http://")
+}
+
+// newRemoteStore returns the store of an s3://bucket/prefix or a webdav
+// http(s) url
+func newRemoteStore(location string) (remoteStore, error) {
+	if strings.HasPrefix(location, s3Scheme) {
+		return newS3Bucket(location, s3Endpoint)
+	}
+	return newWebDAVFolder(location)
+}
+
+func conditionalHeader(etag string) http.Header {
+	h := make(http.Header)
+	switch etag {
+	case etagAny:
+	case "":
+		h.Set("If-None-Match", "*")
+	default:
+		h.Set("If-Match", etag)
+	}
+	return h
+}
+
+// remoteStatusError maps the status of a remote response to an error
+func remoteStatusError(resp *http.Response, name string) error {
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusNotFound:
+		return errRemoteNotFound
+	case resp.StatusCode == http.StatusPreconditionFailed:
+		return errRemoteChanged
+	default:
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s: %s %s", name, resp.Status, strings.TrimSpace(string(body)))
+	}
+}
+
+// webdavFolder is a webdav collection, like a nextcloud folder. basic auth
+// credentials are taken from the url
+type webdavFolder struct {
+	url      *url.URL
+	user     string
+	password string
+}
+
+func newWebDAVFolder(location string) (*webdavFolder, error) {
+	u, err := url.Parse(strings.TrimSuffix(location, "/") + "/")
+	if err != nil {
+		return nil, fmt.Errorf("invalid webdav url: %w", err)
+	}
+	f := &webdavFolder{url: u}
+	if u.User != nil {
+		f.user = u.User.Username()
+		f.password, _ = u.User.Password()
+		registerSecret(f.password)
+		u.User = nil
+	}
+	return f, nil
+}
+
+func (f *webdavFolder) String() string { return f.url.String() }
+
+func (f *webdavFolder) do(ctx context.Context, method, name string, data []byte, header http.Header) (*http.Response, error) {
+	u := f.url.ResolveReference(&url.URL{Path: name})
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if f.user != "" {
+		req.SetBasicAuth(f.user, f.password)
+	}
+	return http.DefaultClient.Do(req)
+}
+
+func (f *webdavFolder) get(ctx context.Context, name string) ([]byte, string, error) {
+	resp, err := f.do(ctx, http.MethodGet, name, nil, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if err := remoteStatusError(resp, name); err != nil {
+		return nil, "", err
+	}
+	data, err := io.ReadAll(resp.Body)
+	return data, resp.Header.Get("ETag"), err
+}
+
+func (f *webdavFolder) put(ctx context.Context, name string, data []byte) error {
+	_, err := f.putIfMatch(ctx, name, data, etagAny)
+	return err
+}
+
+func (f *webdavFolder) putIfMatch(ctx context.Context, name string, data []byte, etag string) (string, error) {
+	put := func() (*http.Response, error) {
+		return f.do(ctx, http.MethodPut, name, data, conditionalHeader(etag))
+	}
+	resp, err := put()
+	if err != nil {
+		return "", err
+	}
+	// the folder is created on the first write
+	if resp.StatusCode == http.StatusConflict || resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		mkcol, err := f.do(ctx, "MKCOL", "", nil, nil)
+		if err != nil {
+			return "", err
+		}
+		mkcol.Body.Close()
+		if resp, err = put(); err != nil {
+			return "", err
+		}
+	}
+	defer resp.Body.Close()
+	if err := remoteStatusError(resp, name); err != nil {
+		return "", err
+	}
+	return resp.Header.Get("ETag"), nil
+}
+
+func (f *webdavFolder) remove(ctx context.Context, name string, etag string) error {
+	resp, err := f.do(ctx, http.MethodDelete, name, nil, conditionalHeader(etag))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return remoteStatusError(resp, name)
+}
+
+// remoteState keeps the shared state files of the profile in --state-remote,
+// so that several machines syncing the same account share the ledger and
+// history and don't import twice. files are pulled before and pushed after
+// each run, and a file changed remotely in between isn't overwritten
+type remoteState struct {
+	store remoteStore
+
+	mu sync.Mutex
+	// etags and hashes are those of the files when last pulled or pushed
+	etags  map[string]string
+	hashes map[string]string
+}
+
+var sharedState *remoteState
+
+// configureStateRemote sets up --state-remote and pulls the shared state
+func configureStateRemote(ctx context.Context) error {
+	if stateRemote == "" {
+		return nil
+	}
+	if !isRemote(stateRemote) {
+		return fmt.Errorf("--state-remote must be an s3:// or webdav http(s):// url")
+	}
+	store, err := newRemoteStore(stateRemote)
+	if err != nil {
+		return err
+	}
+	sharedState = &remoteState{store: store, etags: make(map[string]string), hashes: make(map[string]string)}
+	return sharedState.pull(ctx)
+}
+
+// pull replaces the local shared state files with the remote ones. local
+// files changed since they were last pulled are kept, to be pushed
+func (r *remoteState) pull(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stateMu.Lock()
+	defer stateMu.Unlock()
+
+	for _, name := range sharedStateFiles {
+		path := filepath.Join(profileFolder().Path, name)
+		if h, ok := r.hashes[name]; ok {
+			if local, err := ioutil.ReadFile(path); err == nil && sha256Hex(local) != h {
+				continue
+			}
+		}
+		data, etag, err := r.store.get(ctx, name)
+		if err == errRemoteNotFound {
+			r.etags[name], r.hashes[name] = "", ""
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to pull %s from %s: %w", name, r.store, err)
+		}
+		if err := writeFileAtomic(path, data, 0600); err != nil {
+			return err
+		}
+		r.etags[name], r.hashes[name] = etag, sha256Hex(data)
+	}
+	return nil
+}
+
+// push writes the local shared state files changed since they were pulled
+func (r *remoteState) push(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stateMu.Lock()
+	defer stateMu.Unlock()
+
+	var changed []string
+	for _, name := range sharedStateFiles {
+		data, err := ioutil.ReadFile(filepath.Join(profileFolder().Path, name))
+		if os.IsNotExist(err) {
+			// removed since pulled, like a cleared fetch cache
+			if r.hashes[name] == "" {
+				continue
+			}
+			if err := r.store.remove(ctx, name, r.etags[name]); err != nil && err != errRemoteNotFound {
+				return fmt.Errorf("failed to remove %s from %s: %w", name, r.store, err)
+			}
+			r.etags[name], r.hashes[name] = "", ""
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		h := sha256Hex(data)
+		if h == r.hashes[name] {
+			continue
+		}
+		etag, err := r.store.putIfMatch(ctx, name, data, r.etags[name])
+		if err == errRemoteChanged && appendOnlyStateFiles[name] {
+			var merged string
+			if etag, merged, err = r.merge(ctx, name, data); err == nil {
+				h = merged
+			}
+		}
+		if err == errRemoteChanged {
+			// taking the local copy as pulled makes the next pull replace
+			// it with the remote one instead of keeping it
+			r.hashes[name] = h
+			changed = append(changed, name)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to push %s to %s: %w", name, r.store, err)
+		}
+		r.etags[name], r.hashes[name] = etag, h
+	}
+	if len(changed) > 0 {
+		return fmt.Errorf("%s %w. the local changes weren't pushed and the next pull replaces them with the remote ones", strings.Join(changed, ", "), errRemoteChanged)
+	}
+	return nil
+}
+
+// merge appends the lines of the local copy of an append-only file missing
+// from the remote one to it, and writes the result to both. it returns the
+// new etag and hash
+func (r *remoteState) merge(ctx context.Context, name string, local []byte) (string, string, error) {
+	remote, etag, err := r.store.get(ctx, name)
+	if err == errRemoteNotFound {
+		remote, etag, err = nil, "", nil
+	}
+	if err != nil {
+		return "", "", fmt.Errorf("failed to pull %s from %s: %w", name, r.store, err)
+	}
+	merged := mergeLines(remote, local)
+	if etag, err = r.store.putIfMatch(ctx, name, merged, etag); err != nil {
+		return "", "", err
+	}
+	if err := writeFileAtomic(filepath.Join(profileFolder().Path, name), merged, 0600); err != nil {
+		return "", "", err
+	}
+	return etag, sha256Hex(merged), nil
+}
+
+// mergeLines returns remote followed by the lines of local it doesn't have
+func mergeLines(remote, local []byte) []byte {
+	merged := append([]byte(nil), remote...)
+	if len(merged) > 0 && merged[len(merged)-1] != '\n' {
+		merged = append(merged, '\n')
+	}
+	seen := make(map[string]bool)
+	for _, line := range strings.Split(string(remote), "\n") {
+		seen[line] = true
+	}
+	for _, line := range strings.Split(string(local), "\n") {
+		if line != "" && !seen[line] {
+			seen[line] = true
+			merged = append(merged, line+"\n"...)
+		}
+	}
+	return merged
+}
+
+// pullState refreshes the shared state before a run in long running modes
+func pullState(ctx context.Context) error {
+	if sharedState == nil {
+		return nil
+	}
+	return sharedState.pull(ctx)
+}
+
+// pushState writes the shared state after a command
+func pushState(ctx context.Context) error {
+	if sharedState == nil {
+		return nil
+	}
+	return sharedState.push(ctx)
+}
+
+// pushRunState writes the shared state after a sync run. the run's context
+// may be why it failed, and failing doesn't fail the run
+func pushRunState() {
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	defer cancel()
+	if err := pushState(ctx); err != nil {
+		fmt.Fprintf(stdout, "failed to push state: %v\n", err)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeRemote is an in-memory remoteStore with counting etags. removed
+// files are nil
+type fakeRemote struct {
+	mu    sync.Mutex
+	files map[string][]byte
+	etags map[string]string
+	n     int
+}
+
+func newFakeRemote() *fakeRemote {
+	return &fakeRemote{files: make(map[string][]byte), etags: make(map[string]string)}
+}
+
+func (f *fakeRemote) get(ctx context.Context, name string) ([]byte, string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	data := f.files[name]
+	if data == nil {
+		return nil, "", errRemoteNotFound
+	}
+	return data, f.etags[name], nil
+}
+
+func (f *fakeRemote) put(ctx context.Context, name string, data []byte) error {
+	_, err := f.putIfMatch(ctx, name, data, etagAny)
+	return err
+}
+
+func (f *fakeRemote) putIfMatch(ctx context.Context, name string, data []byte, etag string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if etag != etagAny && etag != f.etags[name] {
+		return "", errRemoteChanged
+	}
+	f.n++
+	f.files[name], f.etags[name] = append([]byte{}, data...), fmt.Sprint(f.n)
+	return f.etags[name], nil
+}
+
+func (f *fakeRemote) remove(ctx context.Context, name string, etag string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.files[name] == nil {
+		return errRemoteNotFound
+	}
+	if etag != f.etags[name] {
+		return errRemoteChanged
+	}
+	f.files[name], f.etags[name] = nil, ""
+	return nil
+}
+
+func (f *fakeRemote) String() string { return "fake remote" }
+
+func TestRemoteStateConflict(t *testing.T) {
+	useDefaults(t)
+	ctx := context.Background()
+	store := newFakeRemote()
+	store.put(ctx, typeMapFile, []byte(`{"firefly":{}}`))
+	store.put(ctx, ledgerFile, []byte("a\n"))
+
+	r := &remoteState{store: store, etags: make(map[string]string), hashes: make(map[string]string)}
+	if err := r.pull(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// this machine and another one both change the files
+	local := func(name string) string {
+		b, _ := ioutil.ReadFile(filepath.Join(profileFolder().Path, name))
+		return string(b)
+	}
+	write := func(name, data string) {
+		if err := writeFileAtomic(filepath.Join(profileFolder().Path, name), []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(typeMapFile, `{"firefly":{"local":"DB"}}`)
+	write(ledgerFile, "a\nlocal\n")
+	store.put(ctx, typeMapFile, []byte(`{"firefly":{"remote":"DB"}}`))
+	store.put(ctx, ledgerFile, []byte("a\nremote\n"))
+
+	err := r.push(ctx)
+	if !errors.Is(err, errRemoteChanged) || !strings.Contains(err.Error(), typeMapFile) || strings.Contains(err.Error(), ledgerFile) {
+		t.Fatalf("push = %v, want %s and not %s reported as changed", err, typeMapFile, ledgerFile)
+	}
+	if got, want := string(store.files[ledgerFile]), "a\nremote\nlocal\n"; got != want || local(ledgerFile) != want {
+		t.Errorf("merged ledger = %q remotely and %q locally, want %q", got, local(ledgerFile), want)
+	}
+
+	// the next pull takes the remote copy, and the state converges
+	if err := r.pull(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := local(typeMapFile); got != `{"firefly":{"remote":"DB"}}` {
+		t.Errorf("type map after pull = %s, want the remote one", got)
+	}
+	if err := r.push(ctx); err != nil {
+		t.Errorf("push after pull = %v, want nothing to push", err)
+	}
+}
+
+func TestMergeLines(t *testing.T) {
+	tests := []struct {
+		remote, local, want string
+	}{
+		{"", "a\n", "a\n"},
+		{"a\n", "", "a\n"},
+		{"a\nb\n", "a\nc\n", "a\nb\nc\n"},
+		{"a\nb", "b\nc", "a\nb\nc\n"},
+		{"a\na\n", "a\n", "a\na\n"},
+	}
+	for _, tt := range tests {
+		if got := string(mergeLines([]byte(tt.remote), []byte(tt.local))); got != tt.want {
+			t.Errorf("mergeLines(%q, %q) = %q, want %q", tt.remote, tt.local, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	// bcaHost is the host whose responses are recorded and replayed
+	bcaHost = "klikbca.com"
+)
+
+var (
+	recordDir, replayDir string
+)
+
+// recordedResponse is a klikbca response kept by --record. request bodies
+// carry the credentials, so only the method and url are kept of requests
+type recordedResponse struct {
+	Method     string      `json:"method"`
+	URL        string      `json:"url"`
+	StatusCode int         `json:"statusCode"`
+	Header     http.Header `json:"header"`
+	Body       string      `json:"body"`
+}
+
+func isBCARequest(req *http.Request) bool {
+	host := req.URL.Hostname()
+	return host == bcaHost || strings.HasSuffix(host, "."+bcaHost)
+}
+
+// recordingTransport saves klikbca responses to dir in request order
+type recordingTransport struct {
+	base http.RoundTripper
+	dir  string
+
+	mu sync.Mutex
+	n  int
+}
+
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil || !isBCARequest(req) {
+		return resp, err
+	}
+
+	b, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(b))
+
+	header := resp.Header.Clone()
+	header.Del("Set-Cookie")
+	rec, err := json.MarshalIndent(recordedResponse{
+		Method:     req.Method,
+		URL:        req.URL.String(),
+		StatusCode: resp.StatusCode,
+		Header:     header,
+		Body:       string(b),
+	}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.n++
+	if err := writeFileAtomic(filepath.Join(t.dir, fmt.Sprintf("%04d.json", t.n)), rec, 0600); err != nil {
+		return nil, fmt.Errorf("failed to record response: %w", err)
+	}
+	return resp, nil
+}
+
+// replayTransport answers klikbca requests with the responses recorded for
+// the same method and url, in the order they were recorded. other requests,
+// like those to destinations, go out as usual
+type replayTransport struct {
+	base http.RoundTripper
+
+	mu        sync.Mutex
+	responses map[string][]recordedResponse
+}
+
+func newReplayTransport(base http.RoundTripper, dir string) (*replayTransport, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no recorded responses in %s, record some with --record first", dir)
+	}
+	sort.Strings(files)
+
+	t := &replayTransport{base: base, responses: make(map[string][]recordedResponse)}
+	for _, f := range files {
+		b, err := ioutil.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		var r recordedResponse
+		if err := json.Unmarshal(b, &r); err != nil {
+			return nil, fmt.Errorf("invalid recording %s: %w", f, err)
+		}
+		key := r.Method + " " + r.URL
+		t.responses[key] = append(t.responses[key], r)
+	}
+	return t, nil
+}
+
+func (t *replayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !isBCARequest(req) {
+		return t.base.RoundTrip(req)
+	}
+	if req.Body != nil {
+		req.Body.Close()
+	}
+
+	key := req.Method + " " + req.URL.String()
+	t.mu.Lock()
+	queue := t.responses[key]
+	if len(queue) == 0 {
+		t.mu.Unlock()
+		return nil, fmt.Errorf("no recorded response left for %s", key)
+	}
+	r := queue[0]
+	// the last response keeps answering, for runs making more requests
+	// than the recorded one
+	if len(queue) > 1 {
+		t.responses[key] = queue[1:]
+	}
+	t.mu.Unlock()
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", r.StatusCode, http.StatusText(r.StatusCode)),
+		StatusCode:    r.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        r.Header,
+		Body:          ioutil.NopCloser(strings.NewReader(r.Body)),
+		ContentLength: int64(len(r.Body)),
+		Request:       req,
+	}, nil
+}
+
+// withRecording wraps base for --record or --replay
+func withRecording(base http.RoundTripper) (http.RoundTripper, error) {
+	switch {
+	case recordDir != "" && replayDir != "":
+		return nil, fmt.Errorf("--record and --replay can't be used together")
+	case recordDir != "":
+		if err := os.MkdirAll(recordDir, 0700); err != nil {
+			return nil, err
+		}
+		// replaying a mix of two runs would answer out of order
+		if existing, _ := filepath.Glob(filepath.Join(recordDir, "*.json")); len(existing) > 0 {
+			return nil, fmt.Errorf("%s already contains recorded responses, record into an empty directory", recordDir)
+		}
+		return &recordingTransport{base: base, dir: recordDir}, nil
+	case replayDir != "":
+		t, err := newReplayTransport(base, replayDir)
+		if err != nil {
+			return nil, err
+		}
+		// the public ip doesn't matter to a recording
+		if publicIP == "" {
+			publicIP = placeholderIP
+		}
+		return t, nil
+	}
+	return base, nil
+}
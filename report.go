@@ -0,0 +1,291 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	uncategorized = "Uncategorized"
+	sparks        = "▁▂▃▄▅▆▇█"
+)
+
+var (
+	reportMonths int
+	reportOutput string
+	reportMonth  string
+	reportTop    int
+)
+
+// categoryTrend is the monthly spending of a category, oldest month first
+type categoryTrend struct {
+	Category string            `json:"category"`
+	Monthly  []decimal.Decimal `json:"monthly"`
+	Avg3     decimal.Decimal   `json:"avg3"`
+	Avg6     decimal.Decimal   `json:"avg6"`
+	Avg12    decimal.Decimal   `json:"avg12"`
+}
+
+func reportTrendsAction(c *cli.Context) error {
+	if reportMonths < 1 {
+		return fmt.Errorf("--months must be at least 1")
+	}
+
+	entries, err := readLedger()
+	if err != nil {
+		return fmt.Errorf("failed to read ledger: %w", err)
+	}
+
+	months, trends := categoryTrends(entries, time.Now(), reportMonths)
+
+	switch reportOutput {
+	case "json":
+		return json.NewEncoder(stdout).Encode(trends)
+	case "csv":
+		w := csv.NewWriter(stdout)
+		header := []string{"category"}
+		for _, m := range months {
+			header = append(header, m.Format("2006-01"))
+		}
+		w.Write(append(header, "avg3", "avg6", "avg12"))
+		for _, t := range trends {
+			row := []string{t.Category}
+			for _, m := range t.Monthly {
+				row = append(row, m.StringFixed(2))
+			}
+			w.Write(append(row, t.Avg3.StringFixed(2), t.Avg6.StringFixed(2), t.Avg12.StringFixed(2)))
+		}
+		w.Flush()
+		return w.Error()
+	case "table":
+		w := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(w, "CATEGORY\t%s TO %s\t3M AVG\t6M AVG\t12M AVG\n", months[0].Format("2006-01"), months[len(months)-1].Format("2006-01"))
+		for _, t := range trends {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", t.Category, sparkline(t.Monthly), t.Avg3.StringFixed(0), t.Avg6.StringFixed(0), t.Avg12.StringFixed(0))
+		}
+		return w.Flush()
+	default:
+		return fmt.Errorf("unknown output %q, expected table, csv or json", reportOutput)
+	}
+}
+
+// categoryTrends sums spending per category for the n months up to now
+func categoryTrends(entries []ledgerEntry, now time.Time, n int) ([]time.Time, []categoryTrend) {
+	var (
+		current = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+		months  = make([]time.Time, n)
+		byCat   = make(map[string][]decimal.Decimal)
+	)
+	for i := range months {
+		months[i] = current.AddDate(0, i-n+1, 0)
+	}
+
+	for _, e := range entries {
+		if !e.Amount.IsNegative() {
+			continue
+		}
+		d := e.Date.In(now.Location())
+		i := (d.Year()-months[0].Year())*12 + int(d.Month()) - int(months[0].Month())
+		if i < 0 || i >= n {
+			continue
+		}
+		category := e.Category
+		if category == "" {
+			category = uncategorized
+		}
+		if _, ok := byCat[category]; !ok {
+			byCat[category] = make([]decimal.Decimal, n)
+		}
+		byCat[category][i] = byCat[category][i].Add(e.Amount.Neg())
+	}
+
+	trends := make([]categoryTrend, 0, len(byCat))
+	for category, monthly := range byCat {
+		trends = append(trends, categoryTrend{
+			Category: category,
+			Monthly:  monthly,
+			Avg3:     trailingAverage(monthly, 3),
+			Avg6:     trailingAverage(monthly, 6),
+			Avg12:    trailingAverage(monthly, 12),
+		})
+	}
+	sort.Slice(trends, func(i, j int) bool {
+		return trends[i].Avg3.GreaterThan(trends[j].Avg3) ||
+			trends[i].Avg3.Equal(trends[j].Avg3) && trends[i].Category < trends[j].Category
+	})
+	return months, trends
+}
+
+// trailingAverage averages the last n values, or all of them if there are fewer
+func trailingAverage(values []decimal.Decimal, n int) decimal.Decimal {
+	if n > len(values) {
+		n = len(values)
+	}
+	sum := decimal.Zero
+	for _, v := range values[len(values)-n:] {
+		sum = sum.Add(v)
+	}
+	return sum.Div(decimal.NewFromInt(int64(n)))
+}
+
+func sparkline(values []decimal.Decimal) string {
+	top := decimal.Zero
+	for _, v := range values {
+		if v.GreaterThan(top) {
+			top = v
+		}
+	}
+
+	var (
+		b     strings.Builder
+		ticks = []rune(sparks)
+	)
+	for _, v := range values {
+		i := 0
+		if top.IsPositive() {
+			i = int(v.Div(top).Mul(decimal.NewFromInt(int64(len(ticks) - 1))).Round(0).IntPart())
+		}
+		b.WriteRune(ticks[i])
+	}
+	return b.String()
+}
+
+// monthSummary is the spending and income of a month in the ledger
+type monthSummary struct {
+	Month      string          `json:"month"`
+	Income     decimal.Decimal `json:"income"`
+	Spending   decimal.Decimal `json:"spending"`
+	Net        decimal.Decimal `json:"net"`
+	Categories []spendingTotal `json:"categories"`
+	Payees     []spendingTotal `json:"payees"`
+}
+
+// spendingTotal is what was spent on a category or at a payee
+type spendingTotal struct {
+	Name   string          `json:"name"`
+	Count  int             `json:"count"`
+	Amount decimal.Decimal `json:"amount"`
+}
+
+// reportMonthAction prints the totals per category and payee of a month,
+// a spending report that doesn't need ynab
+func reportMonthAction(c *cli.Context) error {
+	month := time.Now()
+	if reportMonth != "" {
+		var err error
+		if month, err = time.ParseInLocation("2006-01", reportMonth, time.Local); err != nil {
+			return fmt.Errorf("invalid --month %q, expected YYYY-MM", reportMonth)
+		}
+	}
+
+	entries, err := readLedger()
+	if err != nil {
+		return fmt.Errorf("failed to read ledger: %w", err)
+	}
+	s := summarizeMonth(entries, month)
+	if reportTop > 0 && len(s.Payees) > reportTop {
+		s.Payees = s.Payees[:reportTop]
+	}
+
+	switch reportOutput {
+	case "json":
+		return json.NewEncoder(stdout).Encode(s)
+	case "csv":
+		w := csv.NewWriter(stdout)
+		w.Write([]string{"kind", "name", "count", "amount"})
+		for _, t := range s.Categories {
+			w.Write([]string{"category", t.Name, fmt.Sprint(t.Count), t.Amount.StringFixed(2)})
+		}
+		for _, t := range s.Payees {
+			w.Write([]string{"payee", t.Name, fmt.Sprint(t.Count), t.Amount.StringFixed(2)})
+		}
+		w.Flush()
+		return w.Error()
+	case "table":
+		fmt.Fprintf(stdout, "%s: income %s, spending %s, net %s\n", s.Month, s.Income.StringFixed(2), s.Spending.StringFixed(2), s.Net.StringFixed(2))
+		for _, section := range []struct {
+			title  string
+			totals []spendingTotal
+		}{{"CATEGORY", s.Categories}, {"PAYEE", s.Payees}} {
+			if len(section.totals) == 0 {
+				continue
+			}
+			fmt.Fprintln(stdout)
+			w := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintf(w, "%s\tCOUNT\tSPENT\tSHARE\n", section.title)
+			for _, t := range section.totals {
+				share := decimal.Zero
+				if s.Spending.IsPositive() {
+					share = t.Amount.Div(s.Spending).Mul(decimal.NewFromInt(100))
+				}
+				fmt.Fprintf(w, "%s\t%d\t%s\t%s%%\n", t.Name, t.Count, t.Amount.StringFixed(2), share.StringFixed(0))
+			}
+			if err := w.Flush(); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown output %q, expected table, csv or json", reportOutput)
+	}
+}
+
+// summarizeMonth totals the ledger entries of the month of month. spending
+// is grouped by category and payee, largest first
+func summarizeMonth(entries []ledgerEntry, month time.Time) monthSummary {
+	var (
+		s          = monthSummary{Month: month.Format("2006-01")}
+		categories = make(map[string]*spendingTotal)
+		payees     = make(map[string]*spendingTotal)
+	)
+	add := func(totals map[string]*spendingTotal, name string, amount decimal.Decimal) {
+		t, ok := totals[name]
+		if !ok {
+			t = &spendingTotal{Name: name}
+			totals[name] = t
+		}
+		t.Count++
+		t.Amount = t.Amount.Add(amount)
+	}
+	for _, e := range entries {
+		if e.Date.In(month.Location()).Format("2006-01") != s.Month {
+			continue
+		}
+		if !e.Amount.IsNegative() {
+			s.Income = s.Income.Add(e.Amount)
+			continue
+		}
+		spent := e.Amount.Neg()
+		s.Spending = s.Spending.Add(spent)
+		category := e.Category
+		if category == "" {
+			category = uncategorized
+		}
+		add(categories, category, spent)
+		add(payees, e.Payee, spent)
+	}
+	s.Net = s.Income.Sub(s.Spending)
+	s.Categories = sortedTotals(categories)
+	s.Payees = sortedTotals(payees)
+	return s
+}
+
+func sortedTotals(totals map[string]*spendingTotal) []spendingTotal {
+	sorted := make([]spendingTotal, 0, len(totals))
+	for _, t := range totals {
+		sorted = append(sorted, *t)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Amount.GreaterThan(sorted[j].Amount) ||
+			sorted[i].Amount.Equal(sorted[j].Amount) && sorted[i].Name < sorted[j].Name
+	})
+	return sorted
+}
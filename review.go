@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/cnf/structhash"
+	"github.com/pkg/errors"
+	"github.com/satraul/bca-go"
+	"github.com/shopspring/decimal"
+	"go.bmvs.io/ynab/api/transaction"
+)
+
+var (
+	reviewFlag bool
+
+	reviewMu sync.RWMutex
+	// reviewEdits are the payees, memos and categories edited in --review or
+	// by --transform, by reviewKey. they only change what is pushed, never
+	// the import id
+	reviewEdits = make(map[string]reviewEdit)
+)
+
+type reviewEdit struct {
+	Payee, Memo, Category string
+	// Splits are set by --transform only
+	Splits []split
+}
+
+// reviewedSplits are the splits given to trx by --transform, if any
+func reviewedSplits(trx bca.Entry) []split {
+	e, _ := reviewed(trx)
+	return e.Splits
+}
+
+// reviewKey identifies a fetched entry by all its fields
+func reviewKey(trx bca.Entry) string {
+	key, _ := structhash.Hash(trx, 1)
+	return key
+}
+
+func reviewed(trx bca.Entry) (reviewEdit, bool) {
+	reviewMu.RLock()
+	defer reviewMu.RUnlock()
+	if len(reviewEdits) == 0 {
+		return reviewEdit{}, false
+	}
+	e, ok := reviewEdits[reviewKey(trx)]
+	return e, ok
+}
+
+// reviewedMemo is the memo of trx, as edited in --review
+func reviewedMemo(trx bca.Entry) string {
+	if e, ok := reviewed(trx); ok && e.Memo != "" {
+		return e.Memo
+	}
+	return trx.Description
+}
+
+// reviewedCategory is the category given to trx in --review, if any
+func reviewedCategory(trx bca.Entry) string {
+	e, _ := reviewed(trx)
+	return e.Category
+}
+
+// reviewRow is a transaction on the review screen
+type reviewRow struct {
+	trx      bca.Entry
+	included bool
+	edit     reviewEdit
+}
+
+const reviewHelp = `commands:
+  <n>[,<n>...]      include or exclude transactions, ranges like 2-5 work too
+  all / none        include or exclude every transaction
+  p <n> <payee>     set the payee
+  m <n> <memo>      set the memo
+  c <n> <category>  set the ynab or firefly category
+  y                 push the included transactions
+  q                 push nothing
+`
+
+// reviewTransactions shows trxs and lets them be excluded or edited before
+// they are pushed. it returns the included transactions
+func reviewTransactions(trxs []bca.Entry) ([]bca.Entry, error) {
+	if noninteractive {
+		return nil, fmt.Errorf("--review needs an interactive terminal")
+	}
+	if len(trxs) == 0 {
+		return trxs, nil
+	}
+
+	rows := make([]reviewRow, len(trxs))
+	for i, trx := range trxs {
+		rows[i] = reviewRow{trx: trx, included: true, edit: reviewEdit{Payee: payeeName(trx), Memo: reviewedMemo(trx), Category: reviewedCategory(trx), Splits: reviewedSplits(trx)}}
+	}
+	in := bufio.NewReader(os.Stdin)
+	printReview(rows)
+	fmt.Fprint(stdout, reviewHelp)
+
+	for {
+		fmt.Fprint(stdout, "review> ")
+		line, err := in.ReadString('\n')
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read review command")
+		}
+		line = strings.TrimSpace(line)
+		cmd, arg := line, ""
+		if i := strings.IndexByte(line, ' '); i >= 0 {
+			cmd, arg = line[:i], strings.TrimSpace(line[i+1:])
+		}
+
+		switch cmd {
+		case "":
+			continue
+		case "y", "yes":
+			learnPayees(rows)
+			return applyReview(rows), nil
+		case "q", "quit":
+			return nil, errNotConfirmed
+		case "?", "h", "help":
+			fmt.Fprint(stdout, reviewHelp)
+			continue
+		case "all", "none":
+			for i := range rows {
+				rows[i].included = cmd == "all"
+			}
+		case "p", "m", "c":
+			ns, value := arg, ""
+			if i := strings.IndexByte(arg, ' '); i >= 0 {
+				ns, value = arg[:i], strings.TrimSpace(arg[i+1:])
+			}
+			is, err := parseReviewSelection(ns, len(rows))
+			if err != nil {
+				fmt.Fprintln(stdout, err)
+				continue
+			}
+			for _, i := range is {
+				switch cmd {
+				case "p":
+					rows[i].edit.Payee = value
+				case "m":
+					rows[i].edit.Memo = value
+				case "c":
+					rows[i].edit.Category = value
+				}
+			}
+		default:
+			is, err := parseReviewSelection(line, len(rows))
+			if err != nil {
+				fmt.Fprintf(stdout, "%v. type help for the commands\n", err)
+				continue
+			}
+			for _, i := range is {
+				rows[i].included = !rows[i].included
+			}
+		}
+		printReview(rows)
+	}
+}
+
+// parseReviewSelection parses 1-based numbers and ranges like 1,3,5-7
+func parseReviewSelection(s string, n int) ([]int, error) {
+	var is []int
+	for _, part := range strings.Split(s, ",") {
+		lo, hi := part, part
+		if i := strings.IndexByte(part, '-'); i > 0 {
+			lo, hi = part[:i], part[i+1:]
+		}
+		a, err := strconv.Atoi(strings.TrimSpace(lo))
+		if err != nil {
+			return nil, fmt.Errorf("invalid transaction number %q", part)
+		}
+		b, err := strconv.Atoi(strings.TrimSpace(hi))
+		if err != nil {
+			return nil, fmt.Errorf("invalid transaction number %q", part)
+		}
+		if a < 1 || b > n || a > b {
+			return nil, fmt.Errorf("no transaction %s, expected 1 to %d", part, n)
+		}
+		for i := a; i <= b; i++ {
+			is = append(is, i-1)
+		}
+	}
+	return is, nil
+}
+
+func printReview(rows []reviewRow) {
+	var (
+		w     = tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+		n     int
+		total = decimal.Zero
+	)
+	fmt.Fprintf(w, "#\t\tDATE\tPAYEE\tAMOUNT\tMEMO\tCATEGORY\n")
+	for i, r := range rows {
+		mark := "[ ]"
+		e := toLedgerEntry(r.trx, "")
+		if r.included {
+			mark = "[x]"
+			n++
+			total = total.Add(e.Amount)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\n", i+1, mark, e.Date.Format(dateLayout), r.edit.Payee, e.Amount.StringFixed(2), r.edit.Memo, r.edit.Category)
+	}
+	w.Flush()
+	fmt.Fprintf(stdout, "%d of %d included, %s in total\n", n, len(rows), total.StringFixed(2))
+}
+
+// applyReview keeps the edits of the included rows for the destinations
+func applyReview(rows []reviewRow) []bca.Entry {
+	var (
+		trxs  []bca.Entry
+		edits = make(map[string]reviewEdit)
+	)
+	for _, r := range rows {
+		if !r.included {
+			continue
+		}
+		trxs = append(trxs, r.trx)
+		if r.edit.Payee != payeeName(r.trx) || r.edit.Memo != r.trx.Description || r.edit.Category != "" {
+			edits[reviewKey(r.trx)] = r.edit
+		}
+	}
+	setReviewEdits(edits)
+	return trxs
+}
+
+func setReviewEdits(edits map[string]reviewEdit) {
+	reviewMu.Lock()
+	defer reviewMu.Unlock()
+	for k, e := range edits {
+		reviewEdits[k] = e
+	}
+}
+
+// ynabCategoryIDs returns the ids of the categories of budget by lower-case
+// name
+func ynabCategoryIDs(yc ynabClient, budget string) (map[string]string, error) {
+	cs, err := yc.getCategories(budget)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get categories")
+	}
+	ids := make(map[string]string)
+	for _, group := range cs {
+		for _, c := range group.Categories {
+			ids[strings.ToLower(c.Name)] = c.ID
+		}
+	}
+	return ids, nil
+}
+
+// setReviewedCategories sets the categories given in --review on the ynab
+// payloads of trxs. unknown categories are left for ynab to assign
+func setReviewedCategories(yc ynabClient, budget string, trxs []bca.Entry, ps []transaction.PayloadTransaction) error {
+	names := make([]string, len(trxs))
+	found := false
+	for i, trx := range trxs {
+		names[i] = reviewedCategory(trx)
+		found = found || names[i] != ""
+	}
+	if !found {
+		return nil
+	}
+
+	ids, err := ynabCategoryIDs(yc, budget)
+	if err != nil {
+		return err
+	}
+	for i, name := range names {
+		if name == "" {
+			continue
+		}
+		id, ok := ids[strings.ToLower(name)]
+		if !ok {
+			fmt.Fprintf(stdout, "warning: no ynab category %s, left uncategorized\n", name)
+			continue
+		}
+		ps[i].CategoryID = &id
+	}
+	return nil
+}
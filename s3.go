@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	s3Scheme        = "s3://"
+	s3DefaultRegion = "us-east-1"
+	amzDateLayout   = "20060102T150405Z"
+)
+
+// s3Bucket is an s3 compatible bucket, like aws s3 or minio. requests are
+// signed with aws signature version 4 using the standard AWS_ACCESS_KEY_ID,
+// AWS_SECRET_ACCESS_KEY, AWS_SESSION_TOKEN and AWS_REGION variables
+type s3Bucket struct {
+	// endpoint is set for s3 compatible services, which are addressed with
+	// path style urls. aws s3 is addressed with virtual hosted style urls
+	endpoint     string
+	bucket       string
+	prefix       string
+	region       string
+	accessKey    string
+	secretKey    string
+	sessionToken string
+}
+
+// newS3Bucket parses s3://bucket/prefix
+func newS3Bucket(location, endpoint string) (*s3Bucket, error) {
+	path := strings.TrimPrefix(location, s3Scheme)
+	bucket, prefix := path, ""
+	if i := strings.Index(path, "/"); i >= 0 {
+		bucket, prefix = path[:i], strings.Trim(path[i+1:], "/")
+	}
+	if bucket == "" {
+		return nil, fmt.Errorf("missing bucket in %s", location)
+	}
+	b := &s3Bucket{
+		endpoint:     strings.TrimSuffix(endpoint, "/"),
+		bucket:       bucket,
+		prefix:       prefix,
+		region:       os.Getenv("AWS_REGION"),
+		accessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if b.region == "" {
+		b.region = s3DefaultRegion
+	}
+	if b.accessKey == "" || b.secretKey == "" {
+		return nil, fmt.Errorf("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY are required for %s", location)
+	}
+	registerSecret(b.secretKey)
+	registerSecret(b.sessionToken)
+	return b, nil
+}
+
+func (b *s3Bucket) String() string {
+	return s3Scheme + strings.TrimSuffix(b.bucket+"/"+b.prefix, "/")
+}
+
+func (b *s3Bucket) objectURL(name string) string {
+	key := strings.TrimPrefix(b.prefix+"/"+name, "/")
+	if b.endpoint != "" {
+		return b.endpoint + "/" + b.bucket + "/" + s3EscapePath(key)
+	}
+	return fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", b.bucket, b.region, s3EscapePath(key))
+}
+
+func (b *s3Bucket) put(ctx context.Context, name string, data []byte) error {
+	_, err := b.putIfMatch(ctx, name, data, etagAny)
+	return err
+}
+
+func (b *s3Bucket) putIfMatch(ctx context.Context, name string, data []byte, etag string) (string, error) {
+	resp, err := b.do(ctx, http.MethodPut, name, data, conditionalHeader(etag))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if err := remoteStatusError(resp, name); err != nil {
+		return "", err
+	}
+	return resp.Header.Get("ETag"), nil
+}
+
+func (b *s3Bucket) get(ctx context.Context, name string) ([]byte, string, error) {
+	resp, err := b.do(ctx, http.MethodGet, name, nil, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if err := remoteStatusError(resp, name); err != nil {
+		return nil, "", err
+	}
+	data, err := io.ReadAll(resp.Body)
+	return data, resp.Header.Get("ETag"), err
+}
+
+func (b *s3Bucket) remove(ctx context.Context, name string, etag string) error {
+	resp, err := b.do(ctx, http.MethodDelete, name, nil, conditionalHeader(etag))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return remoteStatusError(resp, name)
+}
+
+func (b *s3Bucket) do(ctx context.Context, method, name string, data []byte, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, b.objectURL(name), bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	b.sign(req, data, time.Now())
+	return http.DefaultClient.Do(req)
+}
+
+// sign adds an aws signature version 4 authorization header to req
+func (b *s3Bucket) sign(req *http.Request, payload []byte, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format(amzDateLayout)
+	date := now.Format("20060102")
+	payloadHash := sha256Hex(payload)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	signed := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	if b.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", b.sessionToken)
+		signed = append(signed, "x-amz-security-token")
+	}
+
+	var headers strings.Builder
+	for _, h := range signed {
+		v := req.Header.Get(h)
+		if h == "host" {
+			v = req.URL.Host
+		}
+		headers.WriteString(h + ":" + strings.TrimSpace(v) + "\n")
+	}
+	signedHeaders := strings.Join(signed, ";")
+	canonical := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		headers.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + b.region + "/s3/aws4_request"
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonical))
+	key := hmacSHA256([]byte("AWS4"+b.secretKey), date)
+	for _, s := range []string{b.region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, s)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", b.accessKey, scope, signedHeaders, signature))
+}
+
+// s3EscapePath escapes each segment of an object key as s3 expects
+func s3EscapePath(key string) string {
+	segments := strings.Split(key, "/")
+	for i, s := range segments {
+		segments[i] = strings.ReplaceAll(url.PathEscape(s), "+", "%2B")
+	}
+	return strings.Join(segments, "/")
+}
+
+func sha256Hex(b []byte) string {
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
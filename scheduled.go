@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.bmvs.io/ynab/api/transaction"
+)
+
+const (
+	// scheduledToleranceDays is how far a bca transaction may be from the
+	// date of the scheduled transaction it matches, for debits made on the
+	// next working day
+	scheduledToleranceDays = 3
+)
+
+var (
+	scheduledMatch string
+)
+
+// scheduledTransaction is a ynab scheduled transaction. go.bmvs.io/ynab
+// doesn't support them, so both clients use the native request
+type scheduledTransaction struct {
+	ID        string  `json:"id"`
+	DateNext  string  `json:"date_next"`
+	Frequency string  `json:"frequency"`
+	Amount    int64   `json:"amount"`
+	AccountID string  `json:"account_id"`
+	PayeeName *string `json:"payee_name"`
+	Deleted   bool    `json:"deleted"`
+}
+
+func (y *ynabAPI) getScheduledTransactions(budget string) ([]*scheduledTransaction, error) {
+	var data struct {
+		ScheduledTransactions []*scheduledTransaction `json:"scheduled_transactions"`
+	}
+	if err := y.do(http.MethodGet, fmt.Sprintf("/budgets/%s/scheduled_transactions", budget), nil, &data); err != nil {
+		return nil, err
+	}
+	return data.ScheduledTransactions, nil
+}
+
+func samePayee(a, b *string) bool {
+	return a == nil || b == nil || strings.EqualFold(*a, *b)
+}
+
+func withinDays(a, b time.Time, days int) bool {
+	d := a.Sub(b)
+	tolerance := time.Duration(days) * 24 * time.Hour
+	return d <= tolerance && d >= -tolerance
+}
+
+// matchScheduledTransactions marks the payloads that a scheduled
+// transaction of the account already accounts for in skip, so that
+// recurring payments like rent aren't booked twice:
+//   - transactions ynab entered from a schedule, without an import id, are
+//     matched by amount within scheduledToleranceDays. with --scheduled
+//     approve they are approved and cleared instead of left for review
+//   - schedules coming due within scheduledToleranceDays of a payload of the
+//     same amount are left for ynab to enter
+func matchScheduledTransactions(yc ynabClient, budget, accountID string, ts []*transaction.Transaction, ps []transaction.PayloadTransaction, skip map[int]bool) error {
+	if scheduledMatch == "off" {
+		return nil
+	}
+	all, err := yc.getScheduledTransactions(budget)
+	if err != nil {
+		return err
+	}
+	var schedules []*scheduledTransaction
+	for _, s := range all {
+		if !s.Deleted && s.AccountID == accountID {
+			schedules = append(schedules, s)
+		}
+	}
+	if len(schedules) == 0 {
+		return nil
+	}
+	scheduled := func(amount int64, payee *string) bool {
+		for _, s := range schedules {
+			if s.Amount == amount && samePayee(s.PayeeName, payee) {
+				return true
+			}
+		}
+		return false
+	}
+
+	var (
+		entered     = make([]bool, len(ts))
+		due         = make([]bool, len(schedules))
+		approved, n int
+	)
+	for i, p := range ps {
+		if skip[i] {
+			continue
+		}
+		for j, t := range ts {
+			if entered[j] || t.Deleted || t.ImportID != nil || t.Amount != p.Amount ||
+				!withinDays(t.Date.Time, p.Date.Time, scheduledToleranceDays) || !scheduled(t.Amount, t.PayeeName) {
+				continue
+			}
+			entered[j] = true
+			skip[i] = true
+			n++
+			if scheduledMatch == "approve" && (!t.Approved || t.Cleared == transaction.ClearingStatusUncleared) {
+				u := transactionToPayload(t)
+				u.Approved = true
+				u.Cleared = transaction.ClearingStatus(cleared)
+				if err := yc.updateTransaction(budget, t.ID, u); err != nil {
+					return fmt.Errorf("failed to approve scheduled transaction %s: %w", t.ID, err)
+				}
+				approved++
+			}
+			break
+		}
+		if skip[i] {
+			continue
+		}
+		for j, s := range schedules {
+			next, err := time.ParseInLocation("2006-01-02", s.DateNext, time.Local)
+			if err != nil || due[j] || s.Amount != p.Amount || !samePayee(s.PayeeName, p.PayeeName) ||
+				!withinDays(next, p.Date.Time, scheduledToleranceDays) {
+				continue
+			}
+			due[j] = true
+			skip[i] = true
+			n++
+			break
+		}
+	}
+	if n > 0 {
+		fmt.Fprintf(stdout, "%d transaction(s) match ynab scheduled transactions and were skipped, %d entered one(s) approved\n", n, approved)
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"go.bmvs.io/ynab/api"
+	"go.bmvs.io/ynab/api/transaction"
+)
+
+func scheduledPayload(date string, amount int64, payee string) transaction.PayloadTransaction {
+	id := "YNAB:" + date
+	return transaction.PayloadTransaction{AccountID: "account-1", Date: api.Date{Time: day(date)}, Amount: amount, PayeeName: &payee, ImportID: &id}
+}
+
+func schedule(id, next string, amount int64, payee string) map[string]interface{} {
+	return map[string]interface{}{
+		"id": id, "date_next": next, "frequency": "monthly", "amount": amount, "account_id": "account-1", "payee_name": payee, "deleted": false,
+	}
+}
+
+func TestMatchScheduledTransactions(t *testing.T) {
+	rent := "PT LANDLORD"
+	entered := []*transaction.Transaction{
+		{ID: "entered-1", AccountID: "account-1", Date: api.Date{Time: day("2024-05-01")}, Amount: -5000000000, PayeeName: &rent, Cleared: transaction.ClearingStatusUncleared},
+	}
+	tests := []struct {
+		name      string
+		mode      string
+		schedules []map[string]interface{}
+		existing  []*transaction.Transaction
+		payloads  []transaction.PayloadTransaction
+		skipped   []int
+		approved  []string
+	}{
+		{
+			name:      "due within the tolerance",
+			mode:      "skip",
+			schedules: []map[string]interface{}{schedule("rent", "2024-05-01", -5000000000, rent)},
+			payloads:  []transaction.PayloadTransaction{scheduledPayload("2024-05-03", -5000000000, rent)},
+			skipped:   []int{0},
+		},
+		{
+			name:      "due a day beyond the tolerance",
+			mode:      "skip",
+			schedules: []map[string]interface{}{schedule("rent", "2024-05-01", -5000000000, rent)},
+			payloads:  []transaction.PayloadTransaction{scheduledPayload("2024-05-05", -5000000000, rent)},
+		},
+		{
+			name:      "amount mismatch",
+			mode:      "skip",
+			schedules: []map[string]interface{}{schedule("rent", "2024-05-01", -5000000000, rent)},
+			payloads:  []transaction.PayloadTransaction{scheduledPayload("2024-05-01", -5000001000, rent)},
+		},
+		{
+			name:      "payee mismatch",
+			mode:      "skip",
+			schedules: []map[string]interface{}{schedule("rent", "2024-05-01", -5000000000, rent)},
+			payloads:  []transaction.PayloadTransaction{scheduledPayload("2024-05-01", -5000000000, "PT OTHER")},
+		},
+		{
+			// a schedule comes due once, the second payment is booked
+			name:      "one payload per due schedule",
+			mode:      "skip",
+			schedules: []map[string]interface{}{schedule("rent", "2024-05-01", -5000000000, rent)},
+			payloads: []transaction.PayloadTransaction{
+				scheduledPayload("2024-05-01", -5000000000, rent),
+				scheduledPayload("2024-05-02", -5000000000, rent),
+			},
+			skipped: []int{0},
+		},
+		{
+			name: "schedule of another account",
+			mode: "skip",
+			schedules: []map[string]interface{}{func() map[string]interface{} {
+				s := schedule("rent", "2024-05-01", -5000000000, rent)
+				s["account_id"] = "account-2"
+				return s
+			}()},
+			payloads: []transaction.PayloadTransaction{scheduledPayload("2024-05-01", -5000000000, rent)},
+		},
+		{
+			name:      "entered by ynab",
+			mode:      "skip",
+			schedules: []map[string]interface{}{schedule("rent", "2024-06-01", -5000000000, rent)},
+			existing:  entered,
+			payloads:  []transaction.PayloadTransaction{scheduledPayload("2024-05-02", -5000000000, rent)},
+			skipped:   []int{0},
+		},
+		{
+			name:      "entered by ynab and approved",
+			mode:      "approve",
+			schedules: []map[string]interface{}{schedule("rent", "2024-06-01", -5000000000, rent)},
+			existing:  entered,
+			payloads:  []transaction.PayloadTransaction{scheduledPayload("2024-05-02", -5000000000, rent)},
+			skipped:   []int{0},
+			approved:  []string{"entered-1"},
+		},
+		{
+			name:      "entered a day beyond the tolerance",
+			mode:      "approve",
+			schedules: []map[string]interface{}{schedule("rent", "2024-06-01", -5000000000, rent)},
+			existing:  entered,
+			payloads:  []transaction.PayloadTransaction{scheduledPayload("2024-05-05", -5000000000, rent)},
+		},
+		{
+			name:      "off",
+			mode:      "off",
+			schedules: []map[string]interface{}{schedule("rent", "2024-05-01", -5000000000, rent)},
+			existing:  entered,
+			payloads:  []transaction.PayloadTransaction{scheduledPayload("2024-05-01", -5000000000, rent)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useDefaults(t)
+			scheduledMatch = tt.mode
+			f := newFakeYNAB(t, "BCA")
+			f.scheduled = tt.schedules
+			f.transactions = []map[string]interface{}{
+				{"id": "entered-1", "account_id": "account-1", "date": "2024-05-01", "amount": -5000000000, "payee_name": rent, "approved": false, "cleared": "uncleared", "deleted": false},
+			}
+
+			skip := make(map[int]bool)
+			if err := matchScheduledTransactions(f.client(), "budget", "account-1", tt.existing, tt.payloads, skip); err != nil {
+				t.Fatal(err)
+			}
+			var skipped []int
+			for i := range skip {
+				skipped = append(skipped, i)
+			}
+			sort.Ints(skipped)
+			if !reflect.DeepEqual(skipped, tt.skipped) {
+				t.Errorf("skipped = %v, want %v", skipped, tt.skipped)
+			}
+			if !reflect.DeepEqual(f.updates, tt.approved) {
+				t.Errorf("updated = %v, want %v", f.updates, tt.approved)
+			}
+			if tt.approved != nil && (f.transactions[0]["approved"] != true || f.transactions[0]["cleared"] != "cleared") {
+				t.Errorf("entered transaction is approved %v and %v, want approved and cleared", f.transactions[0]["approved"], f.transactions[0]["cleared"])
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+var (
+	passwordStdin                             bool
+	passwordFile, tokenFile, fireflyTokenFile string
+)
+
+// readSecretFlags sets the secrets given as files or on stdin, for secret
+// managers that can't or shouldn't put them in the environment, like docker
+// secrets, systemd LoadCredential or a vault agent template. a file can
+// also be a file descriptor, like /dev/fd/3
+func readSecretFlags() error {
+	secrets := []struct {
+		name, path string
+		dst        *string
+		flag       string
+	}{
+		{"--password-file", passwordFile, &password, "--password"},
+		{"--token-file", tokenFile, &token, "--token"},
+		{"--firefly-token-file", fireflyTokenFile, &fireflyToken, "--firefly-token"},
+	}
+	for _, s := range secrets {
+		if s.path == "" {
+			continue
+		}
+		if *s.dst != "" {
+			return fmt.Errorf("%s can't be used with %s", s.name, s.flag)
+		}
+		b, err := ioutil.ReadFile(s.path)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", s.name, err)
+		}
+		if *s.dst = trimSecret(b); *s.dst == "" {
+			return fmt.Errorf("%s %s is empty", s.name, s.path)
+		}
+	}
+
+	if !passwordStdin {
+		return nil
+	}
+	switch {
+	case password != "":
+		return fmt.Errorf("--password-stdin can't be used with --password or --password-file")
+	case reviewFlag:
+		return fmt.Errorf("--password-stdin can't be used with --review, which reads answers from stdin")
+	}
+	b, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return fmt.Errorf("failed to read the password from stdin: %w", err)
+	}
+	if password = trimSecret(b); password == "" {
+		return fmt.Errorf("--password-stdin got an empty password")
+	}
+	return nil
+}
+
+// trimSecret drops the line ending files and echo leave after a secret
+func trimSecret(b []byte) string {
+	return strings.TrimRight(string(b), "\r\n")
+}
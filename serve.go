@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	// serverRunsKept is how long the server remembers runs for
+	serverRunsKept = 8 * 24 * time.Hour
+	// serverShutdownTimeout is how long in-flight requests get to finish
+	// on ctrl+c
+	serverShutdownTimeout = time.Minute
+)
+
+var (
+	errSyncRunning = errors.New("sync already running")
+
+	listen     string
+	serveToken string
+	digest     bool
+	interval   time.Duration
+)
+
+// server exposes sync runs over http so that other automations can trigger
+// and inspect them without shelling out to the cli
+type server struct {
+	config *config
+	// ctx is cancelled on shutdown. syncs run on it rather than on the
+	// context of the request triggering them
+	ctx context.Context
+
+	mu      sync.Mutex
+	running bool
+	runs    []*runSummary
+}
+
+func serveAction(c *cli.Context) error {
+	config, err := getOrDeleteConfig(username, password, token, false, noninteractive, reset, nostore)
+	if err != nil {
+		return err
+	}
+	if config == nil {
+		return nil
+	}
+	if serveToken == "" {
+		return fmt.Errorf("--serve-token is required, every request has to send it as a bearer token")
+	}
+	registerSecret(serveToken)
+
+	defer logoutKeptSession()
+	s := &server{config: config, ctx: c.Context}
+	if digest {
+		if _, err := nextDigest(time.Now()); err != nil {
+			return err
+		}
+		go s.runDigests(c.Context)
+	}
+	go runHeartbeat(c.Context)
+	if interval > 0 {
+		go s.runInterval(c.Context)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/sync", s.handleSync)
+	mux.HandleFunc("/status", s.handleStatus)
+	mux.HandleFunc("/last-run", s.handleLastRun)
+
+	srv := &http.Server{Addr: listen, Handler: requireToken(mux)}
+	go func() {
+		<-c.Context.Done()
+		sctx, cancel := context.WithTimeout(context.Background(), serverShutdownTimeout)
+		defer cancel()
+		srv.Shutdown(sctx)
+	}()
+
+	fmt.Fprintf(stdout, "listening on %s\n", listen)
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+func (s *server) handleSync(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+
+	// a client hanging up mustn't abort a sync halfway through pushing, so
+	// only shutting down cancels it
+	summary, err := s.sync(s.ctx)
+	switch {
+	case err == errSyncRunning:
+		writeJSON(w, http.StatusConflict, map[string]string{"error": err.Error()})
+	case err != nil:
+		writeJSON(w, http.StatusInternalServerError, summary)
+	default:
+		writeJSON(w, http.StatusOK, summary)
+	}
+}
+
+// begin marks a klikbca session as running. klikbca allows a single
+// session, so it fails if another one is running
+func (s *server) begin() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.running {
+		return errSyncRunning
+	}
+	s.running = true
+	return nil
+}
+
+func (s *server) end() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.running = false
+}
+
+// sync runs a sync unless one is already running and records its outcome
+func (s *server) sync(ctx context.Context) (*runSummary, error) {
+	if err := s.begin(); err != nil {
+		return nil, err
+	}
+
+	summary, err := runSync(ctx, s.config)
+	if err != nil {
+		summary.Error = err.Error()
+	} else if werr := writeStateTime(lastSuccessFile, summary.End); werr != nil {
+		fmt.Fprintf(stdout, "failed to write last success: %v\n", werr)
+	}
+
+	s.mu.Lock()
+	s.runs = append(s.runs, summary)
+	for len(s.runs) > 0 && time.Since(s.runs[0].End) > serverRunsKept {
+		s.runs = s.runs[1:]
+	}
+	s.mu.Unlock()
+	s.end()
+
+	return summary, err
+}
+
+// runInterval syncs every --interval until ctx is done
+func (s *server) runInterval(ctx context.Context) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		if _, err := s.sync(ctx); err != nil {
+			fmt.Fprintf(stdout, "sync failed: %v\n", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+func (s *server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	writeJSON(w, http.StatusOK, struct {
+		Running bool        `json:"running"`
+		LastRun *runSummary `json:"lastRun"`
+	}{s.running, s.lastRun()})
+}
+
+func (s *server) handleLastRun(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	lastRun := s.lastRun()
+	if lastRun == nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "no sync has run yet"})
+		return
+	}
+	writeJSON(w, http.StatusOK, lastRun)
+}
+
+// lastRun must be called with mu held
+func (s *server) lastRun() *runSummary {
+	if len(s.runs) == 0 {
+		return nil
+	}
+	return s.runs[len(s.runs)-1]
+}
+
+func (s *server) recentRuns(since time.Time) []*runSummary {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var runs []*runSummary
+	for _, r := range s.runs {
+		if r.Start.After(since) {
+			runs = append(runs, r)
+		}
+	}
+	return runs
+}
+
+// requireToken rejects requests without the --serve-token bearer token
+func requireToken(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		got := strings.TrimPrefix(auth, "Bearer ")
+		if got == auth || subtle.ConstantTimeCompare([]byte(got), []byte(serveToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	serviceName  = "bca-sync-ynab"
+	launchdLabel = "com.github.satraul.bca-sync-ynab"
+)
+
+var (
+	serviceInterval time.Duration
+	serviceDryRun   bool
+	serviceRemove   bool
+)
+
+var systemdServiceTemplate = texttemplate.Must(texttemplate.New("service").Parse(`[Unit]
+Description=Sync BCA transactions to YNAB ({{.Profile}})
+Wants=network-online.target
+After=network-online.target
+
+[Service]
+Type=oneshot
+ExecStart={{.Command}}
+`))
+
+var systemdTimerTemplate = texttemplate.Must(texttemplate.New("timer").Parse(`[Unit]
+Description=Sync BCA transactions to YNAB every {{.Interval}} ({{.Profile}})
+
+[Timer]
+OnBootSec=5min
+OnUnitActiveSec={{.Seconds}}s
+
+[Install]
+WantedBy=timers.target
+`))
+
+var launchdTemplate = texttemplate.Must(texttemplate.New("plist").Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Label</key>
+	<string>{{.Label}}</string>
+	<key>ProgramArguments</key>
+	<array>
+{{range .Args}}		<string>{{.}}</string>
+{{end}}	</array>
+	<key>StartInterval</key>
+	<integer>{{.Seconds}}</integer>
+	<key>RunAtLoad</key>
+	<true/>
+	<key>StandardOutPath</key>
+	<string>{{.Log}}</string>
+	<key>StandardErrorPath</key>
+	<string>{{.Log}}</string>
+</dict>
+</plist>
+`))
+
+// service is the scheduled sync of a profile
+type service struct {
+	Profile  string
+	Args     []string
+	Interval time.Duration
+	Log      string
+	Label    string
+}
+
+func (s service) Seconds() int64 { return int64(s.Interval / time.Second) }
+
+// Command is Args quoted for systemd and windows
+func (s service) Command() string {
+	quoted := make([]string, len(s.Args))
+	for i, a := range s.Args {
+		if strings.ContainsAny(a, " \t\"") {
+			a = `"` + strings.ReplaceAll(a, `"`, `\"`) + `"`
+		}
+		quoted[i] = a
+	}
+	return strings.Join(quoted, " ")
+}
+
+// unitName is the name of the systemd units, launchd job and windows task
+// of the profile
+func (s service) unitName() string {
+	if s.Profile == defaultProfile {
+		return serviceName
+	}
+	return serviceName + "-" + s.Profile
+}
+
+// newService builds the scheduled sync of the current profile. the sync
+// runs unattended, so it uses the stored credentials and the saved
+// preferences of the profile
+func newService() (service, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return service{}, err
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return service{}, err
+	}
+	args := []string{exe, "--profile", profile}
+	if configDir != "" {
+		dir, err := filepath.Abs(configDir)
+		if err != nil {
+			return service{}, err
+		}
+		args = append(args, "--config", dir)
+	}
+	if preferencesPath != "" {
+		p, err := filepath.Abs(preferencesPath)
+		if err != nil {
+			return service{}, err
+		}
+		args = append(args, "--preferences", p)
+	}
+
+	s := service{
+		Profile:  profile,
+		Args:     args,
+		Interval: serviceInterval,
+		Log:      filepath.Join(profileFolder().Path, serviceName+".log"),
+	}
+	s.Label = launchdLabel
+	if s.Profile != defaultProfile {
+		s.Label += "." + s.Profile
+	}
+	return s, nil
+}
+
+// serviceFile is a file to install and the commands that enable it
+type serviceFile struct {
+	path    string
+	content []byte
+}
+
+func (s service) files() ([]serviceFile, [][]string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, nil, err
+	}
+	render := func(t *texttemplate.Template) ([]byte, error) {
+		var b bytes.Buffer
+		err := t.Execute(&b, s)
+		return b.Bytes(), err
+	}
+
+	switch runtime.GOOS {
+	case "linux":
+		dir := filepath.Join(home, ".config", "systemd", "user")
+		unit, err := render(systemdServiceTemplate)
+		if err != nil {
+			return nil, nil, err
+		}
+		timer, err := render(systemdTimerTemplate)
+		if err != nil {
+			return nil, nil, err
+		}
+		return []serviceFile{
+			{filepath.Join(dir, s.unitName()+".service"), unit},
+			{filepath.Join(dir, s.unitName()+".timer"), timer},
+		}, [][]string{
+			{"systemctl", "--user", "daemon-reload"},
+			{"systemctl", "--user", "enable", "--now", s.unitName() + ".timer"},
+		}, nil
+	case "darwin":
+		path := filepath.Join(home, "Library", "LaunchAgents", s.Label+".plist")
+		plist, err := render(launchdTemplate)
+		if err != nil {
+			return nil, nil, err
+		}
+		return []serviceFile{{path, plist}}, [][]string{
+			{"launchctl", "unload", path},
+			{"launchctl", "load", "-w", path},
+		}, nil
+	case "windows":
+		minutes := int64(s.Interval / time.Minute)
+		return nil, [][]string{
+			{"schtasks", "/Create", "/F", "/SC", "MINUTE", "/MO", fmt.Sprint(minutes), "/TN", s.unitName(), "/TR", s.Command()},
+		}, nil
+	default:
+		return nil, nil, fmt.Errorf("install-service doesn't support %s, schedule %q with your system's scheduler", runtime.GOOS, s.Command())
+	}
+}
+
+// removal returns the files and commands that uninstall s
+func (s service) removal() ([]string, [][]string, error) {
+	files, _, err := s.files()
+	if err != nil {
+		return nil, nil, err
+	}
+	var paths []string
+	for _, f := range files {
+		paths = append(paths, f.path)
+	}
+	switch runtime.GOOS {
+	case "linux":
+		return paths, [][]string{{"systemctl", "--user", "disable", "--now", s.unitName() + ".timer"}}, nil
+	case "darwin":
+		return paths, [][]string{{"launchctl", "unload", "-w", paths[0]}}, nil
+	default:
+		return nil, [][]string{{"schtasks", "/Delete", "/F", "/TN", s.unitName()}}, nil
+	}
+}
+
+// runServiceCommands runs cmds, ignoring the failure of launchctl unload
+// for jobs that weren't loaded
+func runServiceCommands(cmds [][]string) error {
+	for _, args := range cmds {
+		out, err := exec.Command(args[0], args[1:]...).CombinedOutput()
+		if err != nil && !(args[0] == "launchctl" && args[1] == "unload") {
+			return fmt.Errorf("%s: %w: %s", strings.Join(args, " "), err, out)
+		}
+	}
+	return nil
+}
+
+func installServiceAction(c *cli.Context) error {
+	if serviceInterval < time.Minute {
+		return fmt.Errorf("invalid --interval %s, expected at least 1m", serviceInterval)
+	}
+	s, err := newService()
+	if err != nil {
+		return err
+	}
+
+	if serviceRemove {
+		paths, cmds, err := s.removal()
+		if err != nil {
+			return err
+		}
+		if serviceDryRun {
+			for _, p := range paths {
+				fmt.Fprintf(stdout, "would remove %s\n", p)
+			}
+			for _, cmd := range cmds {
+				fmt.Fprintf(stdout, "would run %s\n", strings.Join(cmd, " "))
+			}
+			return nil
+		}
+		if err := runServiceCommands(cmds); err != nil {
+			return err
+		}
+		for _, p := range paths {
+			if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		fmt.Fprintf(stdout, "scheduled sync %s was removed\n", s.unitName())
+		return nil
+	}
+
+	files, cmds, err := s.files()
+	if err != nil {
+		return err
+	}
+	if serviceDryRun {
+		for _, f := range files {
+			fmt.Fprintf(stdout, "# %s\n%s\n", f.path, f.content)
+		}
+		for _, cmd := range cmds {
+			fmt.Fprintf(stdout, "would run %s\n", strings.Join(cmd, " "))
+		}
+		return nil
+	}
+
+	// an unattended sync can't prompt for credentials
+	if credentialsFolder() == nil {
+		return fmt.Errorf("no stored credentials for profile %s. run a sync interactively once to store them", profile)
+	}
+	for _, f := range files {
+		if err := os.MkdirAll(filepath.Dir(f.path), 0755); err != nil {
+			return err
+		}
+		if err := writeFileAtomic(f.path, f.content, 0644); err != nil {
+			return err
+		}
+	}
+	if err := runServiceCommands(cmds); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "scheduled sync %s every %s with profile %s. flags are taken from its saved preferences, see save-preferences\n", s.unitName(), s.Interval, s.Profile)
+	return nil
+}
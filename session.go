@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/satraul/bca-go"
+)
+
+var (
+	// sessionTTL keeps the klikbca session of serve and telegram logged in
+	// this long after a run, instead of logging out right away
+	sessionTTL time.Duration
+
+	// keptSession is the session kept for sessionTTL. its cookies are kept
+	// in memory, and on disk only with --persist-session
+	keptMu      sync.Mutex
+	keptSession *idleSession
+
+	// sessionExpired matches the errors klikbca pages give once the session
+	// timed out and it asks to log in again
+	sessionExpired = regexp.MustCompile(`(?i)session.*(expired|timeout|timed out)|sesi.*(habis|berakhir)|(silakan|please) (login|log in)`)
+)
+
+// bcaSession is a logged in klikbca session which logs in again once when
+// klikbca expires it mid-run, e.g. during long backfills
+type bcaSession struct {
+	bc     *bca.BCAApiService
+	config *config
+	ip     string
+
+	// mu guards auth and generation, which counts logins so that
+	// concurrent requests failing on the same expired session log in once
+	mu         sync.Mutex
+	auth       []*http.Cookie
+	generation int
+}
+
+func newBCASession(ctx context.Context, config *config) (*bcaSession, error) {
+	ip, err := clientIP(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s := &bcaSession{bc: newBCAClient(), config: config, ip: ip}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s, s.login(ctx)
+}
+
+// login must be called with mu held
+func (s *bcaSession) login(ctx context.Context) error {
+	auth, err := s.bc.Login(ctx, s.config.BCAUser, s.config.BCAPassword, s.ip)
+	if err != nil {
+		return withExitCode(errors.Wrap(err, "failed to get bca login"), exitAuthFailed)
+	}
+	s.auth = auth
+	s.generation++
+	return nil
+}
+
+func (s *bcaSession) cookies() ([]*http.Cookie, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.auth, s.generation
+}
+
+// do calls fn with the session cookies, and once more after logging in
+// again if the session expired
+func (s *bcaSession) do(ctx context.Context, fn func(auth []*http.Cookie) error) error {
+	auth, generation := s.cookies()
+	err := fn(auth)
+	if err == nil || !sessionExpired.MatchString(err.Error()) {
+		return err
+	}
+
+	s.mu.Lock()
+	if s.generation == generation {
+		fmt.Fprintln(stdout, "klikbca session expired, logging in again")
+		if err := s.login(ctx); err != nil {
+			s.mu.Unlock()
+			return err
+		}
+	}
+	auth = s.auth
+	s.mu.Unlock()
+	return fn(auth)
+}
+
+func (s *bcaSession) balance(ctx context.Context) (bca.Balance, error) {
+	var bal bca.Balance
+	err := s.do(ctx, func(auth []*http.Cookie) (err error) {
+		bal, err = s.bc.BalanceInquiry(ctx, auth)
+		return err
+	})
+	if err != nil {
+		return bca.Balance{}, withExitCode(errors.Wrap(err, "failed to get bca balance"), exitScrapeFailed)
+	}
+	return bal, checkBCAAccount(bal)
+}
+
+// logout logs out even when ctx is done, since klikbca allows a single
+// session, and wipes the persisted session
+func (s *bcaSession) logout() error {
+	ctx, cancel := context.WithTimeout(context.Background(), logoutTimeout)
+	defer cancel()
+	wipeSessionJar()
+	auth, _ := s.cookies()
+	return s.bc.Logout(ctx, auth)
+}
+
+// idleSession is a session kept logged in between runs. it holds the
+// profile lock, as another process logging in would end it
+type idleSession struct {
+	s      *bcaSession
+	timer  *time.Timer
+	unlock func()
+}
+
+// openBCASession returns the kept session, the one persisted before a
+// restart, or logs in
+func openBCASession(ctx context.Context, config *config) (*bcaSession, error) {
+	keptMu.Lock()
+	defer keptMu.Unlock()
+	if keptSession != nil && keptSession.timer.Stop() {
+		s := keptSession.s
+		keptSession.unlock()
+		keptSession = nil
+		return s, nil
+	}
+	if s := restoreSessionJar(config); s != nil {
+		return s, nil
+	}
+	return newBCASession(ctx, config)
+}
+
+// closeBCASession logs out, or keeps a healthy session for --session-ttl
+func closeBCASession(s *bcaSession, healthy bool) error {
+	if sessionTTL <= 0 || !healthy {
+		return s.logout()
+	}
+	unlock, err := lockProfile()
+	if err != nil {
+		return s.logout()
+	}
+	keptMu.Lock()
+	defer keptMu.Unlock()
+	kept := &idleSession{s: s, unlock: unlock}
+	kept.timer = time.AfterFunc(sessionTTL, func() {
+		keptMu.Lock()
+		defer keptMu.Unlock()
+		if keptSession != kept {
+			return
+		}
+		keptSession = nil
+		if err := s.logout(); err != nil {
+			fmt.Fprintf(stdout, "failed to logout of kept klikbca session: %v\n", err)
+		}
+		unlock()
+	})
+	keptSession = kept
+	saveSessionJar(s, time.Now().Add(sessionTTL))
+	return nil
+}
+
+// logoutKeptSession logs out of the kept session on shutdown. a persisted
+// session stays logged in for the next process instead
+func logoutKeptSession() {
+	keptMu.Lock()
+	defer keptMu.Unlock()
+	if keptSession == nil || !keptSession.timer.Stop() {
+		return
+	}
+	if persistSession {
+		keptSession.unlock()
+		keptSession = nil
+		return
+	}
+	if err := keptSession.s.logout(); err != nil {
+		fmt.Fprintf(stdout, "failed to logout of kept klikbca session: %v\n", err)
+	}
+	keptSession.unlock()
+	keptSession = nil
+}
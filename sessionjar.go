@@ -0,0 +1,91 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	sessionJarFile = "session.json"
+)
+
+var (
+	// persistSession writes the kept session to disk, so that serve and
+	// telegram pick it up again after a restart within --session-ttl
+	persistSession bool
+)
+
+// sessionJar is a kept klikbca session as written to disk, encrypted with a
+// key derived from the bca credentials
+type sessionJar struct {
+	User    string         `json:"user"`
+	IP      string         `json:"ip"`
+	Cookies []*http.Cookie `json:"cookies"`
+	Expires time.Time      `json:"expires"`
+}
+
+// sessionJarSecret derives the key of the session jar from the bca
+// credentials, so that a jar written before a password change can't be read
+// and is wiped
+func sessionJarSecret(config *config) []byte {
+	sum := sha256.Sum256([]byte(config.BCAUser + "\x00" + config.BCAPassword))
+	return sum[:]
+}
+
+// saveSessionJar writes the session kept until expires. failing to do so
+// only costs a login after a restart
+func saveSessionJar(s *bcaSession, expires time.Time) {
+	if !persistSession {
+		return
+	}
+	auth, _ := s.cookies()
+	b, err := json.Marshal(sessionJar{User: s.config.BCAUser, IP: s.ip, Cookies: auth, Expires: expires})
+	if err == nil {
+		b, err = encrypt(b, sessionJarSecret(s.config))
+	}
+	if err == nil {
+		err = writeFileAtomic(filepath.Join(profileFolder().Path, sessionJarFile), b, 0600)
+	}
+	if err != nil {
+		fmt.Fprintf(stdout, "failed to persist klikbca session: %v\n", err)
+	}
+}
+
+// restoreSessionJar returns the session persisted by an earlier process, or
+// nil when there is none that is still valid for config. a stale jar is
+// wiped. klikbca may have ended the session already, which the session finds
+// out on its first request and logs in again
+func restoreSessionJar(config *config) *bcaSession {
+	if !persistSession {
+		return nil
+	}
+	b, err := ioutil.ReadFile(filepath.Join(profileFolder().Path, sessionJarFile))
+	if err != nil {
+		return nil
+	}
+	var jar sessionJar
+	plain, err := decrypt(b, sessionJarSecret(config))
+	if err == nil {
+		err = json.Unmarshal(plain, &jar)
+	}
+	if err != nil || jar.User != config.BCAUser || time.Now().After(jar.Expires) || len(jar.Cookies) == 0 {
+		wipeSessionJar()
+		return nil
+	}
+	fmt.Fprintln(stdout, "reusing the persisted klikbca session")
+	return &bcaSession{bc: newBCAClient(), config: config, ip: jar.IP, auth: jar.Cookies, generation: 1}
+}
+
+// wipeSessionJar removes the persisted session, once it is logged out
+func wipeSessionJar() {
+	err := os.Remove(filepath.Join(profileFolder().Path, sessionJarFile))
+	if err != nil && !os.IsNotExist(err) {
+		fmt.Fprintf(stdout, "failed to remove %s: %v\n", sessionJarFile, err)
+	}
+}
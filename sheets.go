@@ -0,0 +1,392 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/satraul/bca-go"
+)
+
+const (
+	sheetsBaseURL   = "https://sheets.googleapis.com/v4/spreadsheets"
+	googleTokenURL  = "https://oauth2.googleapis.com/token"
+	googleCodeURL   = "https://oauth2.googleapis.com/device/code"
+	sheetsTokenFile = "sheets-token.json"
+
+	// service accounts get access to sheets shared with them. the device
+	// flow doesn't allow the spreadsheets scope, so it's limited to sheets
+	// created by bca-sync-ynab with --sheet new
+	sheetsScope      = "https://www.googleapis.com/auth/spreadsheets"
+	sheetsOAuthScope = "https://www.googleapis.com/auth/drive.file"
+)
+
+var (
+	sheetID, sheetName, sheetCredentials string
+
+	sheetHeader = []interface{}{"Date", "Payee", "Description", "Amount", "Type", "Import ID"}
+)
+
+// googleCredentials is either a service account key or an oauth client
+// downloaded from the google cloud console
+type googleCredentials struct {
+	Type        string `json:"type"`
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+	Installed   *struct {
+		ClientID     string `json:"client_id"`
+		ClientSecret string `json:"client_secret"`
+	} `json:"installed"`
+}
+
+type googleToken struct {
+	AccessToken  string `json:"access_token,omitempty"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	Error        string `json:"error,omitempty"`
+}
+
+type sheetsClient struct {
+	token  string
+	client *http.Client
+}
+
+// newSheetsClient authenticates with --sheet-credentials
+func newSheetsClient(ctx context.Context) (*sheetsClient, error) {
+	if sheetCredentials == "" {
+		return nil, fmt.Errorf("--sheet-credentials is required for --sheet")
+	}
+	b, err := ioutil.ReadFile(sheetCredentials)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sheet credentials: %w", err)
+	}
+	var creds googleCredentials
+	if err := json.Unmarshal(b, &creds); err != nil {
+		return nil, fmt.Errorf("failed to parse sheet credentials: %w", err)
+	}
+
+	s := &sheetsClient{client: &http.Client{Timeout: 30 * time.Second}}
+	var t *googleToken
+	switch {
+	case creds.Type == "service_account":
+		t, err = s.serviceAccountToken(ctx, creds)
+	case creds.Installed != nil:
+		t, err = s.oauthToken(ctx, creds)
+	default:
+		return nil, fmt.Errorf("sheet credentials must be a service account key or an oauth desktop client")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to authenticate with google: %w", err)
+	}
+	registerSecret(t.AccessToken)
+	s.token = t.AccessToken
+	return s, nil
+}
+
+// serviceAccountToken exchanges a signed jwt for an access token
+func (s *sheetsClient) serviceAccountToken(ctx context.Context, creds googleCredentials) (*googleToken, error) {
+	block, _ := pem.Decode([]byte(creds.PrivateKey))
+	if block == nil {
+		return nil, fmt.Errorf("invalid service account private key")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("service account private key is not rsa")
+	}
+	if creds.TokenURI == "" {
+		creds.TokenURI = googleTokenURL
+	}
+
+	now := time.Now()
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	claims, _ := json.Marshal(map[string]interface{}{
+		"iss":   creds.ClientEmail,
+		"scope": sheetsScope,
+		"aud":   creds.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	sum := sha256.Sum256([]byte(unsigned))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, sum[:])
+	if err != nil {
+		return nil, err
+	}
+
+	return s.postToken(ctx, creds.TokenURI, url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {unsigned + "." + base64.RawURLEncoding.EncodeToString(sig)},
+	})
+}
+
+// oauthToken refreshes the stored token, or runs the device flow the first
+// time and stores the refresh token in the profile folder
+func (s *sheetsClient) oauthToken(ctx context.Context, creds googleCredentials) (*googleToken, error) {
+	var (
+		path   = filepath.Join(profileFolder().Path, sheetsTokenFile)
+		client = url.Values{"client_id": {creds.Installed.ClientID}, "client_secret": {creds.Installed.ClientSecret}}
+		stored googleToken
+	)
+	if b, err := ioutil.ReadFile(path); err == nil {
+		if err := json.Unmarshal(b, &stored); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if stored.RefreshToken != "" {
+		form := url.Values{"grant_type": {"refresh_token"}, "refresh_token": {stored.RefreshToken}}
+		for k, v := range client {
+			form[k] = v
+		}
+		return s.postToken(ctx, googleTokenURL, form)
+	}
+
+	if noninteractive {
+		return nil, fmt.Errorf("no stored google authorization, run once without --non-interactive")
+	}
+	t, err := s.deviceFlow(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+	b, err := json.Marshal(googleToken{RefreshToken: t.RefreshToken})
+	if err != nil {
+		return nil, err
+	}
+	if err := writeFileAtomic(path, b, 0600); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (s *sheetsClient) deviceFlow(ctx context.Context, client url.Values) (*googleToken, error) {
+	form := url.Values{"scope": {sheetsOAuthScope}, "client_id": client["client_id"]}
+	var code struct {
+		DeviceCode      string `json:"device_code"`
+		UserCode        string `json:"user_code"`
+		VerificationURL string `json:"verification_url"`
+		Interval        int    `json:"interval"`
+		ExpiresIn       int    `json:"expires_in"`
+	}
+	if err := s.postForm(ctx, googleCodeURL, form, &code); err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(stdout, "to allow access to google sheets, visit %s and enter %s\n", code.VerificationURL, code.UserCode)
+
+	interval := time.Duration(code.Interval) * time.Second
+	if interval == 0 {
+		interval = 5 * time.Second
+	}
+	deadline := time.Now().Add(time.Duration(code.ExpiresIn) * time.Second)
+	form = url.Values{"grant_type": {"urn:ietf:params:oauth:grant-type:device_code"}, "device_code": {code.DeviceCode}}
+	for k, v := range client {
+		form[k] = v
+	}
+	for time.Now().Before(deadline) {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(interval):
+		}
+		var t googleToken
+		if err := s.postForm(ctx, googleTokenURL, form, &t); err != nil && t.Error == "" {
+			return nil, err
+		}
+		switch t.Error {
+		case "":
+			return &t, nil
+		case "authorization_pending":
+		case "slow_down":
+			interval += 5 * time.Second
+		default:
+			return nil, fmt.Errorf("google authorization failed: %s", t.Error)
+		}
+	}
+	return nil, fmt.Errorf("google authorization timed out")
+}
+
+func (s *sheetsClient) postToken(ctx context.Context, tokenURL string, form url.Values) (*googleToken, error) {
+	var t googleToken
+	if err := s.postForm(ctx, tokenURL, form, &t); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// postForm decodes the response into out even when it's an error, since
+// the device flow reports pending authorization as one
+func (s *sheetsClient) postForm(ctx context.Context, u string, form url.Values, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	json.Unmarshal(b, out)
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("google %s returned %d: %s", u, resp.StatusCode, string(b))
+	}
+	return nil
+}
+
+func (s *sheetsClient) do(ctx context.Context, method, u string, body, out interface{}) error {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, r)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+s.token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("sheets %s returned %d: %s", method, resp.StatusCode, string(b))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(b, out)
+}
+
+// createSpreadsheet creates a spreadsheet with an empty sheet named sheetName
+func (s *sheetsClient) createSpreadsheet(ctx context.Context) (string, error) {
+	body := map[string]interface{}{
+		"properties": map[string]string{"title": "bca-sync-ynab"},
+		"sheets":     []interface{}{map[string]interface{}{"properties": map[string]string{"title": sheetName}}},
+	}
+	var created struct {
+		SpreadsheetID string `json:"spreadsheetId"`
+	}
+	if err := s.do(ctx, http.MethodPost, sheetsBaseURL, body, &created); err != nil {
+		return "", err
+	}
+	return created.SpreadsheetID, nil
+}
+
+func (s *sheetsClient) values(ctx context.Context, id string) ([][]interface{}, error) {
+	var resp struct {
+		Values [][]interface{} `json:"values"`
+	}
+	u := fmt.Sprintf("%s/%s/values/%s", sheetsBaseURL, id, url.PathEscape(sheetName))
+	if err := s.do(ctx, http.MethodGet, u, nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Values, nil
+}
+
+func (s *sheetsClient) append(ctx context.Context, id string, rows [][]interface{}) error {
+	u := fmt.Sprintf("%s/%s/values/%s:append?valueInputOption=USER_ENTERED&insertDataOption=INSERT_ROWS", sheetsBaseURL, id, url.PathEscape(sheetName))
+	return s.do(ctx, http.MethodPost, u, map[string]interface{}{"values": rows}, nil)
+}
+
+// sheetRow is trx as a row matching sheetHeader
+func sheetRow(trx bca.Entry, importID string) []interface{} {
+	p := toPayloadTransaction(trx, "", importID)
+	amount := trx.Amount
+	if trx.Type == "DB" {
+		amount = amount.Neg()
+	}
+	return []interface{}{p.Date.Format(dateLayout), payeeName(trx), trx.Description, amount.String(), trx.Type, importID}
+}
+
+// pushSheets appends transactions whose import id isn't in the sheet yet,
+// adding the header row to an empty sheet
+func pushSheets(ctx context.Context, config *config, bal bca.Balance, auth []*http.Cookie, trxs []bca.Entry) (destinationResult, error) {
+	var result destinationResult
+
+	s, err := newSheetsClient(ctx)
+	if err != nil {
+		return result, err
+	}
+
+	id := sheetID
+	if id == "new" {
+		if id, err = s.createSpreadsheet(ctx); err != nil {
+			return result, fmt.Errorf("failed to create spreadsheet: %w", err)
+		}
+		fmt.Fprintf(stdout, "created spreadsheet %s, use --sheet %s from now on\n", id, id)
+	}
+
+	existing, err := s.values(ctx, id)
+	if err != nil {
+		return result, fmt.Errorf("failed to read sheet: %w", err)
+	}
+	var (
+		seen = make(map[string]bool)
+		col  = len(sheetHeader) - 1
+		rows [][]interface{}
+	)
+	for _, row := range existing {
+		if len(row) > col {
+			seen[fmt.Sprint(row[col])] = true
+		}
+	}
+	if len(existing) == 0 {
+		rows = append(rows, sheetHeader)
+	}
+	for i, id := range importIDs(trxs) {
+		if seen[id] {
+			result.Duplicates++
+			result.DuplicateTransactions = append(result.DuplicateTransactions, toCreatedTransaction(trxs[i], id, ""))
+			continue
+		}
+		seen[id] = true
+		rows = append(rows, sheetRow(trxs[i], id))
+		result.Created++
+		result.Transactions = append(result.Transactions, toCreatedTransaction(trxs[i], id, ""))
+	}
+
+	if result.Created == 0 {
+		return result, nil
+	}
+	if err := s.append(ctx, id, rows); err != nil {
+		result.Created, result.Transactions = 0, nil
+		return result, fmt.Errorf("failed to append to sheet: %w", err)
+	}
+	fmt.Fprintf(stdout, "%d rows were successfully appended to the sheet\n", result.Created)
+	return result, nil
+}
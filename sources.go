@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	sourceDefault     = "default"
+	sourceFlag        = "flag"
+	sourcePreferences = "preferences"
+	sourceTemplate    = "template"
+)
+
+var (
+	showEffective bool
+
+	// flagSources is where the value of each global flag came from. flags
+	// are resolved from lowest to highest precedence as defaults, the
+	// imported template, the preferences, the environment and the command
+	// line
+	flagSources = make(map[string]string)
+	// globalContext is the context of the global flags
+	globalContext *cli.Context
+)
+
+// secretFlags are never shown by config show
+var secretFlags = map[string]bool{
+	"username":        true,
+	"password":        true,
+	"token":           true,
+	"firefly-token":   true,
+	"credentials-key": true,
+	"smtp-password":   true,
+	"ntfy-token":      true,
+	"pushover-token":  true,
+}
+
+// flagEnvVars returns the environment variables of f
+func flagEnvVars(f cli.Flag) []string {
+	switch f := f.(type) {
+	case *cli.StringFlag:
+		return f.EnvVars
+	case *cli.BoolFlag:
+		return f.EnvVars
+	case *cli.IntFlag:
+		return f.EnvVars
+	case *cli.Int64Flag:
+		return f.EnvVars
+	case *cli.Float64Flag:
+		return f.EnvVars
+	case *cli.DurationFlag:
+		return f.EnvVars
+	case *cli.StringSliceFlag:
+		return f.EnvVars
+	}
+	return nil
+}
+
+// recordFlagSources notes the global flags given on the command line or in
+// the environment. it must run before preferences and templates are
+// applied, as they set flags too
+func recordFlagSources(c *cli.Context) {
+	globalContext = c
+	given := make(map[string]bool)
+	for _, name := range c.LocalFlagNames() {
+		given[name] = true
+	}
+	for _, f := range c.App.Flags {
+		name := f.Names()[0]
+		for _, n := range f.Names() {
+			if given[n] {
+				flagSources[name] = sourceFlag
+			}
+		}
+		if flagSources[name] != "" || !f.IsSet() {
+			continue
+		}
+		for _, env := range flagEnvVars(f) {
+			if _, ok := os.LookupEnv(env); ok {
+				flagSources[name] = "env " + env
+				break
+			}
+		}
+	}
+}
+
+// setFlagFrom sets a flag that wasn't given to value from source
+func setFlagFrom(c *cli.Context, source, name, value string) error {
+	if c.IsSet(name) {
+		return nil
+	}
+	if err := c.Set(name, value); err != nil {
+		return err
+	}
+	flagSources[name] = source
+	return nil
+}
+
+func configShowAction(c *cli.Context) error {
+	var rows [][3]string
+	for _, f := range globalContext.App.Flags {
+		name := f.Names()[0]
+		source := flagSources[name]
+		if source == "" {
+			source = sourceDefault
+		}
+		if source == sourceDefault && !showEffective {
+			continue
+		}
+		value := fmt.Sprint(globalContext.Value(name))
+		if _, ok := f.(*cli.StringSliceFlag); ok {
+			value = strings.Join(globalContext.StringSlice(name), ",")
+		}
+		if secretFlags[name] && value != "" {
+			value = redacted
+		}
+		rows = append(rows, [3]string{name, value, source})
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i][0] < rows[j][0] })
+	if len(rows) == 0 {
+		fmt.Fprintln(stdout, "every flag has its default value. see config show --effective")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "FLAG\tVALUE\tSOURCE\n")
+	for _, r := range rows {
+		fmt.Fprintf(w, "--%s\t%s\t%s\n", r[0], strings.ReplaceAll(r[1], "\n", " "), r[2])
+	}
+	return w.Flush()
+}
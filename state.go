@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/urfave/cli/v2"
+	"go.bmvs.io/ynab/api/account"
+	"go.bmvs.io/ynab/api/transaction"
+)
+
+var (
+	// stateMu serializes read-modify-write cycles of state files
+	stateMu sync.Mutex
+
+	stateRepair bool
+)
+
+// writeFileAtomic writes data to a temporary file next to path, syncs it and
+// renames it over path, so that a crash leaves either the old or the new file
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(dir, "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	defer os.Remove(tmp)
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(perm); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+
+	// persist the rename itself
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+func stateVerifyAction(c *cli.Context) error {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+
+	entries, corrupt, err := readLedgerFile(false)
+	if err != nil {
+		return fmt.Errorf("failed to read ledger: %w", err)
+	}
+
+	var (
+		problems int
+		seen     = make(map[string]bool)
+		unique   = make([]ledgerEntry, 0, len(entries))
+	)
+	if corrupt > 0 {
+		fmt.Fprintf(stdout, "%d corrupt ledger line(s)\n", corrupt)
+		problems += corrupt
+	}
+	for _, e := range entries {
+		if seen[e.ImportID] {
+			fmt.Fprintf(stdout, "duplicate ledger entry %s\n", e.ImportID)
+			problems++
+			continue
+		}
+		seen[e.ImportID] = true
+		unique = append(unique, e)
+	}
+
+	var missing []ledgerEntry
+	if ynabEnabled() && len(unique) > 0 {
+		n, m, err := verifyLedgerWithYNAB(c.Context, unique)
+		if err != nil {
+			return err
+		}
+		problems += n
+		missing = m
+	}
+
+	if problems == 0 {
+		fmt.Fprintln(stdout, "state is consistent")
+		return nil
+	}
+	if !stateRepair {
+		return fmt.Errorf("%d problem(s) found. use --repair to fix them", problems)
+	}
+	question := "rewrite the ledger without corrupt and duplicate entries"
+	if len(missing) > 0 {
+		question += fmt.Sprintf(" and create the %d entries missing from ynab again", len(missing))
+	}
+	if err := confirm("%s", question); err != nil {
+		return err
+	}
+	if err := writeLedger(unique); err != nil {
+		return fmt.Errorf("failed to repair ledger: %w", err)
+	}
+	fmt.Fprintln(stdout, "ledger repaired")
+	if len(missing) > 0 {
+		if err := restoreLedgerInYNAB(c.Context, missing); err != nil {
+			return fmt.Errorf("failed to create missing entries in ynab: %w", err)
+		}
+	}
+	return nil
+}
+
+// verifyLedgerWithYNAB reports ledger entries missing from the ynab account
+// and refreshes categories from it. the missing entries are returned for
+// --repair
+func verifyLedgerWithYNAB(ctx context.Context, entries []ledgerEntry) (int, []ledgerEntry, error) {
+	yc, a, err := ledgerYNABAccount(ctx)
+	if err != nil || yc == nil {
+		return 0, nil, err
+	}
+
+	since := time.Now()
+	for _, e := range entries {
+		if e.Date.Before(since) {
+			since = e.Date
+		}
+	}
+	ts, err := yc.getTransactions(budget, a.ID, since)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to get ynab transactions: %w", err)
+	}
+	categories := make(map[string]string)
+	for _, t := range ts {
+		if t.ImportID == nil || t.Deleted {
+			continue
+		}
+		categories[*t.ImportID] = ""
+		if t.CategoryName != nil {
+			categories[*t.ImportID] = *t.CategoryName
+		}
+	}
+
+	var (
+		problems int
+		missing  []ledgerEntry
+	)
+	for i, e := range entries {
+		c, ok := categories[e.ImportID]
+		if !ok {
+			fmt.Fprintf(stdout, "ledger entry %s (%s %s %s) is missing from ynab\n", e.ImportID, e.Date.Format(dateLayout), e.Payee, e.Amount)
+			missing = append(missing, e)
+			problems++
+			continue
+		}
+		if c != e.Category {
+			fmt.Fprintf(stdout, "ledger entry %s has category %q but %q in ynab\n", e.ImportID, e.Category, c)
+			entries[i].Category = c
+			problems++
+		}
+	}
+	return problems, missing, nil
+}
+
+// restoreLedgerInYNAB creates entries in the ynab account again, with the
+// import ids they were first created with
+func restoreLedgerInYNAB(ctx context.Context, entries []ledgerEntry) error {
+	yc, a, err := ledgerYNABAccount(ctx)
+	if err != nil || yc == nil {
+		return err
+	}
+	ps := make([]transaction.PayloadTransaction, len(entries))
+	for i, e := range entries {
+		ps[i] = toPayloadTransaction(ledgerEntryToBCA(e), a.ID, e.ImportID)
+	}
+	resp, err := yc.createTransactions(budget, ps)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "%d missing entries were created in ynab again\n", len(resp.TransactionIDs))
+	return nil
+}
+
+// ledgerYNABAccount returns a client of the ynab account the ledger is kept
+// for, or a nil client when there are no credentials
+func ledgerYNABAccount(ctx context.Context) (ynabClient, *account.Account, error) {
+	config, err := getOrDeleteConfig(username, password, token, false, noninteractive, reset, nostore)
+	if err != nil || config == nil {
+		return nil, nil, err
+	}
+	yc, err := newYNABClient(ctx, config.YNABToken)
+	if err != nil {
+		return nil, nil, err
+	}
+	a, err := getYNABAccount(yc, budget, accountName)
+	if err != nil {
+		return nil, nil, err
+	}
+	return yc, a, nil
+}
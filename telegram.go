@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/satraul/bca-go"
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	telegramAPI = "https://api.telegram.org/bot"
+	// telegramPollTimeout is how long telegram holds a getUpdates request
+	// open waiting for messages
+	telegramPollTimeout = 50 * time.Second
+	// telegramRetryDelay is the wait after a failed getUpdates
+	telegramRetryDelay = 10 * time.Second
+)
+
+var (
+	telegramToken string
+	telegramChat  int64
+)
+
+type telegramUpdate struct {
+	UpdateID int64 `json:"update_id"`
+	Message  *struct {
+		Chat struct {
+			ID int64 `json:"id"`
+		} `json:"chat"`
+		Text string `json:"text"`
+	} `json:"message"`
+}
+
+// telegramBot answers /sync, /balance and /last from a single chat. it
+// long polls, so it works behind nat without exposing a port
+type telegramBot struct {
+	s *server
+}
+
+func telegramAction(c *cli.Context) error {
+	registerSecret(telegramToken)
+	config, err := getOrDeleteConfig(username, password, token, false, noninteractive, reset, nostore)
+	if err != nil {
+		return err
+	}
+	if config == nil {
+		return nil
+	}
+
+	defer logoutKeptSession()
+	b := &telegramBot{s: &server{config: config, ctx: c.Context}}
+	fmt.Fprintf(stdout, "answering telegram chat %d\n", telegramChat)
+	var offset int64
+	for {
+		updates, err := b.getUpdates(c.Context, offset)
+		if c.Context.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			fmt.Fprintf(stdout, "failed to get telegram updates: %v\n", err)
+			select {
+			case <-c.Context.Done():
+				return nil
+			case <-time.After(telegramRetryDelay):
+			}
+			continue
+		}
+		for _, u := range updates {
+			offset = u.UpdateID + 1
+			// anyone can message a bot, only the configured chat is answered
+			if u.Message == nil || u.Message.Chat.ID != telegramChat {
+				continue
+			}
+			reply := b.handle(c.Context, u.Message.Text)
+			if err := b.send(c.Context, reply); err != nil {
+				fmt.Fprintf(stdout, "failed to send telegram reply: %v\n", err)
+			}
+		}
+	}
+}
+
+// handle runs the command in text and returns the reply
+func (b *telegramBot) handle(ctx context.Context, text string) string {
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return telegramHelp
+	}
+	// in groups commands are sent as /command@botname
+	cmd := strings.SplitN(fields[0], "@", 2)[0]
+
+	switch cmd {
+	case "/sync":
+		summary, err := b.s.sync(ctx)
+		if err == errSyncRunning {
+			return "a sync is already running"
+		}
+		return formatSummary(summary)
+	case "/balance":
+		if err := b.s.begin(); err != nil {
+			return "a sync is running, try again later"
+		}
+		bal, err := fetchBCABalance(ctx, b.s.config)
+		b.s.end()
+		if err != nil {
+			return fmt.Sprintf("failed to get balance: %v", err)
+		}
+		return fmt.Sprintf("balance %s", bal.Balance.StringFixed(2))
+	case "/last":
+		runs, err := readHistory()
+		if err != nil {
+			return fmt.Sprintf("failed to read history: %v", err)
+		}
+		if len(runs) == 0 {
+			return "no sync has run yet"
+		}
+		return formatSummary(runs[len(runs)-1])
+	default:
+		return telegramHelp
+	}
+}
+
+const telegramHelp = "/sync syncs now\n/balance shows the bca balance\n/last shows the last sync"
+
+// formatSummary describes a run in a few lines for chat replies
+func formatSummary(r *runSummary) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "run %s %s\n", r.ID, r.status())
+	fmt.Fprintf(&sb, "window %s\n", r.window())
+	fmt.Fprintf(&sb, "fetched %d, created %d, duplicates %d\n", r.Fetched, r.Created, r.Duplicates)
+	if !r.Balance.IsZero() {
+		fmt.Fprintf(&sb, "balance %s\n", r.Balance.StringFixed(2))
+	}
+	if r.Error != "" {
+		fmt.Fprintf(&sb, "error: %s\n", r.Error)
+	}
+	return strings.TrimSpace(sb.String())
+}
+
+func (b *telegramBot) getUpdates(ctx context.Context, offset int64) ([]telegramUpdate, error) {
+	q := url.Values{}
+	q.Set("offset", fmt.Sprint(offset))
+	q.Set("timeout", fmt.Sprint(int(telegramPollTimeout.Seconds())))
+	q.Set("allowed_updates", `["message"]`)
+	var updates []telegramUpdate
+	return updates, telegramCall(ctx, "getUpdates?"+q.Encode(), nil, &updates)
+}
+
+// send replies to the configured chat. replies are redacted like
+// notifications
+func (b *telegramBot) send(ctx context.Context, text string) error {
+	body := map[string]interface{}{
+		"chat_id": telegramChat,
+		"text":    redact(obfuscation().text(text)),
+	}
+	return telegramCall(ctx, "sendMessage", body, nil)
+}
+
+func telegramCall(ctx context.Context, method string, body, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, telegramAPI+telegramToken+"/"+method, nil)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		req.Method = http.MethodPost
+		req.Body = ioutil.NopCloser(bytes.NewReader(b))
+		req.ContentLength = int64(len(b))
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		// the url carries the token
+		return errors.New(redact(err.Error()))
+	}
+	defer resp.Body.Close()
+
+	var data struct {
+		OK          bool            `json:"ok"`
+		Description string          `json:"description"`
+		Result      json.RawMessage `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return fmt.Errorf("telegram returned %d", resp.StatusCode)
+	}
+	if !data.OK {
+		return fmt.Errorf("telegram returned %d: %s", resp.StatusCode, data.Description)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(data.Result, out)
+}
+
+// fetchBCABalance logs in to klikbca only for the balance
+func fetchBCABalance(ctx context.Context, config *config) (bca.Balance, error) {
+	unlock, err := lockProfile()
+	if err != nil {
+		return bca.Balance{}, err
+	}
+	defer unlock()
+
+	loggedIn := startSpinner("logging in to klikbca")
+	s, err := openBCASession(ctx, config)
+	loggedIn(err)
+	if err != nil {
+		return bca.Balance{}, err
+	}
+	fetched := startSpinner("fetching bca balance")
+	bal, err := s.balance(ctx)
+	fetched(err)
+	if err == nil {
+		recordBalance(bal)
+	}
+	if lerr := closeBCASession(s, err == nil); lerr != nil {
+		fmt.Fprintf(stdout, "failed to logout: %v\n", lerr)
+	}
+	return bal, err
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	templateFile    = "template.json"
+	templateVersion = 1
+)
+
+// template is the shareable part of a setup. it leaves out secrets and
+// anything specific to one person's accounts, like budget ids and urls
+type template struct {
+	Version    int    `json:"version"`
+	Account    string `json:"account,omitempty"`
+	Days       int    `json:"days,omitempty"`
+	NoAdjust   bool   `json:"noAdjust,omitempty"`
+	FlagName   string `json:"flagName,omitempty"`
+	FlagColor  string `json:"flagColor,omitempty"`
+	Unapproved bool   `json:"unapproved,omitempty"`
+	Cleared    string `json:"cleared,omitempty"`
+	YNABClient string `json:"ynabClient,omitempty"`
+}
+
+func currentTemplate() template {
+	return template{
+		Version:    templateVersion,
+		Account:    accountName,
+		Days:       days,
+		NoAdjust:   noadjust,
+		FlagName:   flagName,
+		FlagColor:  flagColor,
+		Unapproved: unapproved,
+		Cleared:    cleared,
+		YNABClient: ynabClientName,
+	}
+}
+
+// flags returns the template as flag values by flag name
+func (t template) flags() map[string]string {
+	fs := make(map[string]string)
+	if t.Account != "" {
+		fs["account"] = t.Account
+	}
+	if t.Days != 0 {
+		fs["days"] = strconv.Itoa(t.Days)
+	}
+	if t.NoAdjust {
+		fs["no-adjust"] = "true"
+	}
+	if t.FlagName != "" {
+		fs["flag-name"] = t.FlagName
+	}
+	if t.FlagColor != "" {
+		fs["flag-color"] = t.FlagColor
+	}
+	if t.Unapproved {
+		fs["unapproved"] = "true"
+	}
+	if t.Cleared != "" {
+		fs["cleared"] = t.Cleared
+	}
+	if t.YNABClient != "" {
+		fs["ynab-client"] = t.YNABClient
+	}
+	return fs
+}
+
+func exportTemplateAction(c *cli.Context) error {
+	b, err := json.MarshalIndent(currentTemplate(), "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(stdout, string(b))
+	return nil
+}
+
+func importTemplateAction(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return errors.New("expected a template file")
+	}
+	b, err := ioutil.ReadFile(c.Args().First())
+	if err != nil {
+		return err
+	}
+	var t template
+	if err := json.Unmarshal(b, &t); err != nil {
+		return errors.Wrap(err, "invalid template")
+	}
+	if t.Version != templateVersion {
+		return fmt.Errorf("unsupported template version %d", t.Version)
+	}
+
+	folder := profileFolder()
+	b, _ = json.MarshalIndent(t, "", "  ")
+	if err := writeFileAtomic(filepath.Join(folder.Path, templateFile), b, 0644); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "imported template to %s. it is used for flags that aren't set\n", folder.Path)
+	return nil
+}
+
+// applyTemplate sets flags that weren't given from the imported template
+func applyTemplate(c *cli.Context) error {
+	b, err := ioutil.ReadFile(filepath.Join(profileFolder().Path, templateFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var t template
+	if err := json.Unmarshal(b, &t); err != nil {
+		return errors.Wrap(err, "invalid imported template")
+	}
+	for name, value := range t.flags() {
+		if err := setFlagFrom(c, sourceTemplate+" "+filepath.Join(profileFolder().Path, templateFile), name, value); err != nil {
+			return fmt.Errorf("failed to apply template %s: %w", name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/satraul/bca-go"
+	"github.com/shopspring/decimal"
+)
+
+var (
+	verifyTotalsMode string
+
+	htmlTag          = regexp.MustCompile(`<[^>]*>`)
+	totalCreditsText = regexp.MustCompile(`(?i)mutasi\s+kredit\s*:?\s*([\d.,]+)`)
+	totalDebitsText  = regexp.MustCompile(`(?i)mutasi\s+deb[ei]t\s*:?\s*([\d.,]+)`)
+
+	statementTotals = &totalsRecorder{totals: make(map[string]statementTotal)}
+)
+
+// statementTotal is the total of credits and debits klikbca states at the
+// bottom of a statement page
+type statementTotal struct {
+	Credits, Debits decimal.Decimal
+}
+
+// totalsRecorder keeps the totals of the statement pages fetched through
+// it, by request, so that a statement requested again after a relogin
+// isn't counted twice
+type totalsRecorder struct {
+	base http.RoundTripper
+
+	mu     sync.Mutex
+	totals map[string]statementTotal
+}
+
+// withTotals routes klikbca requests through statementTotals for
+// --verify-totals
+func withTotals(base http.RoundTripper) (http.RoundTripper, error) {
+	switch verifyTotalsMode {
+	case "off":
+		return base, nil
+	case "warn", "abort":
+	default:
+		return nil, fmt.Errorf("invalid --verify-totals %q, expected abort, warn or off", verifyTotalsMode)
+	}
+	statementTotals.base = base
+	return statementTotals, nil
+}
+
+func (t *totalsRecorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !isBCARequest(req) || !strings.Contains(strings.ToLower(req.URL.Path), "accountstmt") {
+		return t.base.RoundTrip(req)
+	}
+
+	var key []byte
+	if req.Body != nil {
+		b, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = ioutil.NopCloser(bytes.NewReader(b))
+		key = b
+	}
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+	b, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(b))
+
+	if total, ok := parseStatementTotal(b); ok {
+		t.mu.Lock()
+		t.totals[req.URL.String()+"?"+string(key)] = total
+		t.mu.Unlock()
+	}
+	return resp, nil
+}
+
+// reset forgets the totals of earlier fetches
+func (t *totalsRecorder) reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.totals = make(map[string]statementTotal)
+}
+
+// sum returns the totals of the statement pages since reset, and false if
+// none stated any
+func (t *totalsRecorder) sum() (statementTotal, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var s statementTotal
+	for _, total := range t.totals {
+		s.Credits = s.Credits.Add(total.Credits)
+		s.Debits = s.Debits.Add(total.Debits)
+	}
+	return s, len(t.totals) > 0
+}
+
+// parseStatementTotal finds the mutasi kredit and mutasi debet totals of a
+// statement page
+func parseStatementTotal(page []byte) (statementTotal, bool) {
+	text := strings.Join(strings.Fields(htmlTag.ReplaceAllString(string(page), " ")), " ")
+	credits := totalCreditsText.FindStringSubmatch(text)
+	debits := totalDebitsText.FindStringSubmatch(text)
+	if credits == nil || debits == nil {
+		return statementTotal{}, false
+	}
+	c, err := parseWalletAmount(credits[1])
+	if err != nil {
+		return statementTotal{}, false
+	}
+	d, err := parseWalletAmount(debits[1])
+	if err != nil {
+		return statementTotal{}, false
+	}
+	return statementTotal{Credits: c, Debits: d}, true
+}
+
+// verifyTotals compares the parsed transactions with the totals klikbca
+// stated for them, catching entries the scraper dropped or misread. with
+// --verify-totals abort a difference fails the run before anything is
+// pushed
+func verifyTotals(trxs []bca.Entry) error {
+	if verifyTotalsMode == "off" {
+		return nil
+	}
+	stated, ok := statementTotals.sum()
+	if !ok {
+		return nil
+	}
+	var parsed statementTotal
+	for _, trx := range trxs {
+		if trx.Type == "DB" {
+			parsed.Debits = parsed.Debits.Add(trx.Amount)
+		} else {
+			parsed.Credits = parsed.Credits.Add(trx.Amount)
+		}
+	}
+	if parsed.Credits.Equal(stated.Credits) && parsed.Debits.Equal(stated.Debits) {
+		return nil
+	}
+
+	msg := fmt.Sprintf("parsed bca transactions don't add up to the statement totals: credits %s instead of %s, debits %s instead of %s",
+		parsed.Credits.StringFixed(2), stated.Credits.StringFixed(2), parsed.Debits.StringFixed(2), stated.Debits.StringFixed(2))
+	if verifyTotalsMode == "warn" {
+		fmt.Fprintln(stdout, colorize(colorYellow, msg))
+		return nil
+	}
+	return withExitCode(fmt.Errorf("%s. nothing was pushed, use --verify-totals warn to push anyway", msg), exitScrapeFailed)
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/satraul/bca-go"
+	"github.com/shopspring/decimal"
+)
+
+// useTotals checks totals in mode against a recorder of its own
+func useTotals(t *testing.T, mode string) {
+	useDefaults(t)
+	oldMode, oldTotals, oldTypes := verifyTotalsMode, statementTotals, entryTypes
+	verifyTotalsMode, statementTotals, entryTypes = mode, &totalsRecorder{totals: make(map[string]statementTotal)}, typeMapping{}
+	t.Cleanup(func() {
+		verifyTotalsMode, statementTotals, entryTypes = oldMode, oldTotals, oldTypes
+	})
+}
+
+func statementPage(credits, debits string) string {
+	return `<table><tr><td>Mutasi Kredit</td><td>:</td><td>` + credits + `</td></tr>
+<tr><td>Mutasi Debet</td><td>:</td><td>` + debits + `</td></tr></table>`
+}
+
+func TestParseStatementTotal(t *testing.T) {
+	tests := []struct {
+		name    string
+		page    string
+		credits string
+		debits  string
+		ok      bool
+	}{
+		{name: "table cells", page: statementPage("7,501,234.56", "86,246.91"), credits: "7501234.56", debits: "86246.91", ok: true},
+		{name: "debit spelling", page: "MUTASI KREDIT : 0.00 MUTASI DEBIT : 18,000.00", credits: "0", debits: "18000", ok: true},
+		{name: "no debits", page: "Mutasi Kredit : 7,500,000.00"},
+		{name: "no totals", page: "<html>Tidak ada transaksi</html>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseStatementTotal([]byte(tt.page))
+			if ok != tt.ok {
+				t.Fatalf("ok = %v, want %v", ok, tt.ok)
+			}
+			if !ok {
+				return
+			}
+			if !got.Credits.Equal(decimal.RequireFromString(tt.credits)) || !got.Debits.Equal(decimal.RequireFromString(tt.debits)) {
+				t.Errorf("got credits %s debits %s, want %s and %s", got.Credits, got.Debits, tt.credits, tt.debits)
+			}
+		})
+	}
+}
+
+func TestTotalsRecorderCountsPagesOnce(t *testing.T) {
+	useTotals(t, "abort")
+	base := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		b, _ := ioutil.ReadAll(req.Body)
+		if strings.Contains(string(b), "page=2") {
+			return htmlResponse(statementPage("1,000.00", "250.00")), nil
+		}
+		return htmlResponse(statementPage("7,500,000.00", "86,000.00")), nil
+	})
+	rt, err := withTotals(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: rt}
+	// the first page is requested again, as after a relogin
+	for _, body := range []string{"page=1", "page=2", "page=1"} {
+		resp, err := client.Post("https://ibank.klikbca.com/accountstmt.do?value(actions)=acctstmtview", "application/x-www-form-urlencoded", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+
+	got, ok := statementTotals.sum()
+	if !ok {
+		t.Fatal("no totals recorded")
+	}
+	if !got.Credits.Equal(decimal.RequireFromString("7501000")) || !got.Debits.Equal(decimal.RequireFromString("86250")) {
+		t.Errorf("got credits %s debits %s, want 7501000 and 86250", got.Credits, got.Debits)
+	}
+}
+
+func TestVerifyTotals(t *testing.T) {
+	entry := func(typ, amount, desc string) bca.Entry {
+		return bca.Entry{Date: day("2024-05-02"), Description: desc, Type: typ, Amount: decimal.RequireFromString(amount)}
+	}
+	mixed := []bca.Entry{
+		entry("DB", "50000", "TRSF E-BANKING DB"),
+		entry("CR", "7500000", "TRSF E-BANKING CR"),
+		entry("DB", "18000", "KARTU DEBIT"),
+		entry("CR", "1234.56", "BUNGA"),
+	}
+	tests := []struct {
+		name    string
+		mode    string
+		types   typeMapping
+		stated  *statementTotal
+		trxs    []bca.Entry
+		wantErr bool
+		warned  bool
+	}{
+		{
+			name:   "mixed debits and credits",
+			mode:   "abort",
+			stated: &statementTotal{Credits: decimal.RequireFromString("7501234.56"), Debits: decimal.RequireFromString("68000")},
+			trxs:   mixed,
+		},
+		{
+			name:    "a debit read as a credit",
+			mode:    "abort",
+			stated:  &statementTotal{Credits: decimal.RequireFromString("7501234.56"), Debits: decimal.RequireFromString("68000")},
+			trxs:    append(append([]bca.Entry(nil), mixed[1:]...), entry("CR", "50000", "TRSF E-BANKING DB")),
+			wantErr: true,
+		},
+		{
+			name:    "a dropped entry",
+			mode:    "abort",
+			stated:  &statementTotal{Credits: decimal.RequireFromString("7501234.56"), Debits: decimal.RequireFromString("68000")},
+			trxs:    mixed[:3],
+			wantErr: true,
+		},
+		{
+			name:   "a difference with warn",
+			mode:   "warn",
+			stated: &statementTotal{Credits: decimal.RequireFromString("7501234.56"), Debits: decimal.RequireFromString("68000")},
+			trxs:   mixed[:3],
+			warned: true,
+		},
+		{
+			// the type map applies to destinations, the statement totals
+			// are of the types bca states
+			name:   "mapped types keep the bca sides",
+			mode:   "abort",
+			types:  typeMapping{Types: map[string]string{"DB": mapCredit}, Descriptions: map[string]string{"BUNGA": mapSkip}},
+			stated: &statementTotal{Credits: decimal.RequireFromString("7501234.56"), Debits: decimal.RequireFromString("68000")},
+			trxs:   mixed,
+		},
+		{
+			// like mapTypes, entries of other types are credits
+			name:   "other types are credits",
+			mode:   "abort",
+			stated: &statementTotal{Credits: decimal.RequireFromString("7501234.56"), Debits: decimal.RequireFromString("68000")},
+			trxs:   append(append([]bca.Entry(nil), mixed[:3]...), entry("BNG", "1234.56", "BUNGA")),
+		},
+		{
+			name: "no stated totals",
+			mode: "abort",
+			trxs: mixed[:1],
+		},
+		{
+			name:   "off",
+			mode:   "off",
+			stated: &statementTotal{},
+			trxs:   mixed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useTotals(t, tt.mode)
+			entryTypes = tt.types
+			if tt.stated != nil {
+				statementTotals.totals["page"] = *tt.stated
+			}
+			var out bytes.Buffer
+			stdout = &out
+
+			err := verifyTotals(tt.trxs)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil && exitCode(err) != exitScrapeFailed {
+				t.Errorf("exit code %d, want %d", exitCode(err), exitScrapeFailed)
+			}
+			if warned := strings.Contains(out.String(), "don't add up"); warned != tt.warned {
+				t.Errorf("warned = %v, want %v: %q", warned, tt.warned, out.String())
+			}
+		})
+	}
+}
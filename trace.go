@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httputil"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+)
+
+var (
+	traceHTTP string
+	httpTrace *traceFile
+
+	// tracedHeaders never make it to the trace, whatever their value
+	tracedHeaders = []string{"Authorization", "Cookie", "Set-Cookie", "Proxy-Authorization"}
+	// secretParam matches form and query parameters that carry secrets, as
+	// the klikbca login form does
+	secretParam = regexp.MustCompile(`(?i)((?:password|pswd|pin|token|secret|assertion|refresh_token|client_secret)[\w.\[\]]*=)[^&\s"]*`)
+)
+
+// traceFile is the --trace-http file, shared by the bca transport and the
+// default one
+type traceFile struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+// tracingTransport writes every request and response to a file with
+// credentials, cookies and tokens redacted
+type tracingTransport struct {
+	base http.RoundTripper
+	*traceFile
+}
+
+// openHTTPTrace opens path for --trace-http
+func openHTTPTrace(path string) error {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open --trace-http file: %w", err)
+	}
+	httpTrace = &traceFile{f: f}
+	return nil
+}
+
+// traced traces the requests of base to --trace-http, if given
+func traced(base http.RoundTripper) http.RoundTripper {
+	if httpTrace == nil {
+		return base
+	}
+	return &tracingTransport{base: base, traceFile: httpTrace}
+}
+
+func (t *tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+
+	r := req.Clone(req.Context())
+	if req.Body != nil {
+		b, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = ioutil.NopCloser(bytes.NewReader(b))
+		r.Body = ioutil.NopCloser(bytes.NewReader(b))
+	}
+	redactHeaders(r.Header)
+	reqDump, _ := httputil.DumpRequestOut(r, true)
+
+	resp, err := t.base.RoundTrip(req)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	fmt.Fprintf(t.f, "=== %s %s %s\n%s\n", start.Format(time.RFC3339), req.Method, redactTrace(req.URL.String()), redactTrace(string(reqDump)))
+	if err != nil {
+		fmt.Fprintf(t.f, "--- error after %s: %s\n\n", time.Since(start).Round(time.Millisecond), redactTrace(err.Error()))
+		return nil, err
+	}
+
+	b, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(b))
+	dumped := *resp
+	dumped.Header = resp.Header.Clone()
+	dumped.Body = ioutil.NopCloser(bytes.NewReader(b))
+	redactHeaders(dumped.Header)
+	respDump, _ := httputil.DumpResponse(&dumped, true)
+	fmt.Fprintf(t.f, "--- response after %s\n%s\n\n", time.Since(start).Round(time.Millisecond), redactTrace(string(respDump)))
+	return resp, nil
+}
+
+func redactHeaders(h http.Header) {
+	for _, name := range tracedHeaders {
+		if h.Get(name) != "" {
+			h.Set(name, redacted)
+		}
+	}
+}
+
+func redactTrace(s string) string {
+	return redact(secretParam.ReplaceAllString(s, "${1}"+redacted))
+}
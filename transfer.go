@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/shopspring/decimal"
+	"go.bmvs.io/ynab/api/transaction"
+)
+
+var (
+	// transferAccounts are the ynab accounts of other pipelines, like a
+	// credit card paid from bca, whose mirror transactions are paired with
+	// this account's into transfers
+	transferAccounts  []string
+	transferWindow    int
+	transferTolerance string
+
+	// transferToleranceMilliunits is --transfer-tolerance parsed by
+	// validateTransfers
+	transferToleranceMilliunits int64
+)
+
+// ynabPayee is a ynab payee. transfer payees have the account they
+// transfer to
+type ynabPayee struct {
+	ID                string  `json:"id"`
+	Name              string  `json:"name"`
+	TransferAccountID *string `json:"transfer_account_id"`
+	Deleted           bool    `json:"deleted"`
+}
+
+// transferPayeeID returns the id of the payee transferring to accountID
+func (y *ynabAPI) transferPayeeID(budget, accountID string) (string, error) {
+	var data struct {
+		Payees []*ynabPayee `json:"payees"`
+	}
+	if err := y.do(http.MethodGet, fmt.Sprintf("/budgets/%s/payees", budget), nil, &data); err != nil {
+		return "", err
+	}
+	for _, p := range data.Payees {
+		if !p.Deleted && p.TransferAccountID != nil && *p.TransferAccountID == accountID {
+			return p.ID, nil
+		}
+	}
+	return "", fmt.Errorf("no transfer payee for ynab account %s", accountID)
+}
+
+func validateTransfers() error {
+	if transferWindow < 0 {
+		return fmt.Errorf("invalid --transfer-window %d", transferWindow)
+	}
+	if transferTolerance != "" {
+		d, err := decimal.NewFromString(transferTolerance)
+		if err != nil || d.IsNegative() {
+			return fmt.Errorf("invalid --transfer-tolerance %q", transferTolerance)
+		}
+		transferToleranceMilliunits = toMilliunits(d)
+	}
+	for _, a := range transferAccounts {
+		if a == accountName {
+			return fmt.Errorf("--transfer-account %s is the synced account", a)
+		}
+	}
+	return nil
+}
+
+// mirrors tells whether a and b are the two sides of one transfer
+func mirrors(a, b int64) bool {
+	d := a + b
+	if d < 0 {
+		d = -d
+	}
+	return a != 0 && (a < 0) != (b < 0) && d <= transferToleranceMilliunits
+}
+
+// pairTransfers marks the payloads that are one side of a transfer with a
+// --transfer-account in skip, so that a bca payment of a credit card isn't
+// booked as an unlinked outflow next to the card's inflow:
+//   - a transaction of a transfer account of the opposite amount within
+//     --transfer-window days that isn't a transfer yet, synced by another
+//     pipeline, is turned into a transfer with this account. ynab then
+//     creates this side
+//   - transfers already in the account without an import id, paired by an
+//     earlier run or entered by hand, are matched the same way
+func pairTransfers(yc ynabClient, budget, accountID string, ts []*transaction.Transaction, ps []transaction.PayloadTransaction, skip map[int]bool) error {
+	if len(transferAccounts) == 0 {
+		return nil
+	}
+	accs, err := yc.getAccounts(budget)
+	if err != nil {
+		return err
+	}
+	ids := make(map[string]string)
+	for _, a := range accs {
+		if !a.Deleted && !a.Closed {
+			ids[a.Name] = a.ID
+		}
+	}
+	others := make(map[string]bool)
+	for _, name := range transferAccounts {
+		id, ok := ids[name]
+		if !ok {
+			return fmt.Errorf("%w %s", errAccountNotFound, name)
+		}
+		others[id] = true
+	}
+
+	since := ps[0].Date.Time
+	for _, p := range ps {
+		if p.Date.Before(since) {
+			since = p.Date.Time
+		}
+	}
+	since = since.AddDate(0, 0, -transferWindow)
+
+	var (
+		candidates []*transaction.Transaction
+		used       = make(map[string]bool)
+		payeeID    string
+		paired, n  int
+	)
+	for id := range others {
+		cs, err := yc.getTransactions(budget, id, since)
+		if err != nil {
+			return fmt.Errorf("failed to get transactions of transfer account: %w", err)
+		}
+		candidates = append(candidates, cs...)
+	}
+
+	for i, p := range ps {
+		if skip[i] {
+			continue
+		}
+		for _, t := range ts {
+			if used[t.ID] || t.Deleted || t.ImportID != nil || t.TransferAccountID == nil || !others[*t.TransferAccountID] ||
+				!mirrors(-t.Amount, p.Amount) || !withinDays(t.Date.Time, p.Date.Time, transferWindow) {
+				continue
+			}
+			used[t.ID] = true
+			skip[i] = true
+			n++
+			break
+		}
+		if skip[i] {
+			continue
+		}
+		for _, t := range candidates {
+			if used[t.ID] || t.Deleted || t.TransferAccountID != nil || t.Amount == 0 ||
+				!mirrors(t.Amount, p.Amount) || !withinDays(t.Date.Time, p.Date.Time, transferWindow) {
+				continue
+			}
+			if payeeID == "" {
+				if payeeID, err = yc.transferPayeeID(budget, accountID); err != nil {
+					return err
+				}
+			}
+			u := transactionToPayload(t)
+			u.PayeeID = &payeeID
+			u.PayeeName = nil
+			if err := yc.updateTransaction(budget, t.ID, u); err != nil {
+				return fmt.Errorf("failed to turn transaction %s into a transfer: %w", t.ID, err)
+			}
+			used[t.ID] = true
+			skip[i] = true
+			paired++
+			n++
+			break
+		}
+	}
+	if n > 0 {
+		fmt.Fprintf(stdout, "%d transaction(s) are transfers with a --transfer-account and were skipped, %d newly paired\n", n, paired)
+	}
+	return nil
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+
+	"go.bmvs.io/ynab/api"
+	"go.bmvs.io/ynab/api/transaction"
+)
+
+// useTransfers pairs the test's payloads with the account "Credit Card"
+func useTransfers(t *testing.T, window int, tolerance int64) {
+	useDefaults(t)
+	oldAccounts, oldWindow, oldTolerance := transferAccounts, transferWindow, transferToleranceMilliunits
+	transferAccounts, transferWindow, transferToleranceMilliunits = []string{"Credit Card"}, window, tolerance
+	t.Cleanup(func() {
+		transferAccounts, transferWindow, transferToleranceMilliunits = oldAccounts, oldWindow, oldTolerance
+	})
+}
+
+func transferPayload(date string, amount int64) transaction.PayloadTransaction {
+	id := "YNAB:" + date
+	return transaction.PayloadTransaction{AccountID: "account-1", Date: api.Date{Time: day(date)}, Amount: amount, ImportID: &id}
+}
+
+// cardTransaction is a transaction of the card account, synced by its own
+// pipeline
+func cardTransaction(id, date string, amount int64) map[string]interface{} {
+	return map[string]interface{}{
+		"id": id, "account_id": "account-2", "date": date, "amount": amount, "cleared": "cleared", "approved": true,
+		"deleted": false, "transfer_account_id": nil, "import_id": "CARD:" + id, "payee_name": "PAYMENT THANK YOU",
+	}
+}
+
+func TestPairTransfers(t *testing.T) {
+	card := "account-2"
+	tests := []struct {
+		name      string
+		window    int
+		tolerance int64
+		card      []map[string]interface{}
+		existing  []*transaction.Transaction
+		payloads  []transaction.PayloadTransaction
+		// skipped are the payloads paired, updated the card transactions
+		// turned into transfers
+		skipped []int
+		updated []string
+	}{
+		{
+			name:     "mirror within the window",
+			window:   3,
+			card:     []map[string]interface{}{cardTransaction("card-1", "2024-05-03", 1500000000)},
+			payloads: []transaction.PayloadTransaction{transferPayload("2024-05-02", -1500000000)},
+			skipped:  []int{0},
+			updated:  []string{"card-1"},
+		},
+		{
+			name:     "different dates beyond the window",
+			window:   3,
+			card:     []map[string]interface{}{cardTransaction("card-1", "2024-05-09", 1500000000)},
+			payloads: []transaction.PayloadTransaction{transferPayload("2024-05-02", -1500000000)},
+		},
+		{
+			name:     "same day without a window",
+			card:     []map[string]interface{}{cardTransaction("card-1", "2024-05-02", 1500000000), cardTransaction("card-2", "2024-05-03", 1500000000)},
+			payloads: []transaction.PayloadTransaction{transferPayload("2024-05-02", -1500000000)},
+			skipped:  []int{0},
+			updated:  []string{"card-1"},
+		},
+		{
+			name:     "same sign isn't a transfer",
+			window:   3,
+			card:     []map[string]interface{}{cardTransaction("card-1", "2024-05-02", -1500000000)},
+			payloads: []transaction.PayloadTransaction{transferPayload("2024-05-02", -1500000000)},
+		},
+		{
+			name:     "amount off by more than the tolerance",
+			window:   3,
+			card:     []map[string]interface{}{cardTransaction("card-1", "2024-05-02", 1500001000)},
+			payloads: []transaction.PayloadTransaction{transferPayload("2024-05-02", -1500000000)},
+		},
+		{
+			name:      "amount within the tolerance",
+			window:    3,
+			tolerance: 1000,
+			card:      []map[string]interface{}{cardTransaction("card-1", "2024-05-02", 1500001000)},
+			payloads:  []transaction.PayloadTransaction{transferPayload("2024-05-02", -1500000000)},
+			skipped:   []int{0},
+			updated:   []string{"card-1"},
+		},
+		{
+			// one card payment can't be the other side of two bca debits
+			name:   "same-amount collision",
+			window: 3,
+			card:   []map[string]interface{}{cardTransaction("card-1", "2024-05-02", 1500000000)},
+			payloads: []transaction.PayloadTransaction{
+				transferPayload("2024-05-02", -1500000000),
+				transferPayload("2024-05-02", -1500000000),
+			},
+			skipped: []int{0},
+			updated: []string{"card-1"},
+		},
+		{
+			// each side is used once, in order
+			name:   "ambiguous pairs",
+			window: 3,
+			card: []map[string]interface{}{
+				cardTransaction("card-1", "2024-05-01", 1500000000),
+				cardTransaction("card-2", "2024-05-03", 1500000000),
+			},
+			payloads: []transaction.PayloadTransaction{
+				transferPayload("2024-05-02", -1500000000),
+				transferPayload("2024-05-02", -1500000000),
+			},
+			skipped: []int{0, 1},
+			updated: []string{"card-1", "card-2"},
+		},
+		{
+			name:   "card transaction already a transfer",
+			window: 3,
+			card: []map[string]interface{}{func() map[string]interface{} {
+				t := cardTransaction("card-1", "2024-05-02", 1500000000)
+				t["transfer_account_id"] = "account-3"
+				return t
+			}()},
+			payloads: []transaction.PayloadTransaction{transferPayload("2024-05-02", -1500000000)},
+		},
+		{
+			name:   "transfer paired by an earlier run",
+			window: 3,
+			card:   []map[string]interface{}{cardTransaction("card-1", "2024-05-02", 1500000000)},
+			existing: []*transaction.Transaction{
+				{ID: "bca-1", AccountID: "account-1", Date: api.Date{Time: day("2024-05-02")}, Amount: -1500000000, TransferAccountID: &card},
+			},
+			payloads: []transaction.PayloadTransaction{
+				transferPayload("2024-05-02", -1500000000),
+				transferPayload("2024-05-02", -1500000000),
+			},
+			// the second debit pairs with the card payment left
+			skipped: []int{0, 1},
+			updated: []string{"card-1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useTransfers(t, tt.window, tt.tolerance)
+			f := newFakeYNAB(t, "BCA", "Credit Card")
+			f.transactions = tt.card
+
+			skip := make(map[int]bool)
+			if err := pairTransfers(f.client(), "budget", "account-1", tt.existing, tt.payloads, skip); err != nil {
+				t.Fatal(err)
+			}
+			var skipped []int
+			for i := range skip {
+				skipped = append(skipped, i)
+			}
+			sort.Ints(skipped)
+			if !reflect.DeepEqual(skipped, tt.skipped) {
+				t.Errorf("skipped = %v, want %v", skipped, tt.skipped)
+			}
+			if !reflect.DeepEqual(f.updates, tt.updated) {
+				t.Errorf("updated = %v, want %v", f.updates, tt.updated)
+			}
+			for _, c := range f.transactions {
+				for _, id := range tt.updated {
+					if c["id"] == id && c["transfer_account_id"] != "account-1" {
+						t.Errorf("%s transfers to %v, want account-1", id, c["transfer_account_id"])
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestPairTransfersUnknownAccount(t *testing.T) {
+	useTransfers(t, 3, 0)
+	f := newFakeYNAB(t, "BCA")
+
+	err := pairTransfers(f.client(), "budget", "account-1", nil, []transaction.PayloadTransaction{transferPayload("2024-05-02", -1500000000)}, map[int]bool{})
+	if !errors.Is(err, errAccountNotFound) {
+		t.Fatalf("err = %v, want %v", err, errAccountNotFound)
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"time"
+
+	"github.com/satraul/bca-go"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	transformTimeout = time.Minute
+)
+
+var (
+	transformCommand string
+)
+
+// transformInput is a transaction as sent to --transform. Payee, Memo and
+// Category are what would be pushed without the transform
+type transformInput struct {
+	ledgerEntry
+	Memo string `json:"memo"`
+}
+
+// transformOutput is what --transform returns for a transaction. empty
+// fields keep what would be pushed
+type transformOutput struct {
+	Payee    string  `json:"payee,omitempty"`
+	Memo     string  `json:"memo,omitempty"`
+	Category string  `json:"category,omitempty"`
+	Skip     bool    `json:"skip,omitempty"`
+	Splits   []split `json:"splits,omitempty"`
+}
+
+// split is a part of a transaction, pushed to ynab as a subtransaction.
+// Amount is signed like the ledger, negative for debits, and the splits of
+// a transaction add up to its amount
+type split struct {
+	Amount   decimal.Decimal `json:"amount"`
+	Payee    string          `json:"payee,omitempty"`
+	Category string          `json:"category,omitempty"`
+	Memo     string          `json:"memo,omitempty"`
+}
+
+// transformTransactions runs --transform once for trxs. the command gets a
+// json line per transaction on stdin and answers with a json line per
+// transaction on stdout, in the same order. the payees, memos and
+// categories it returns are kept like --review edits, and transactions it
+// skips are dropped. it returns the transactions that weren't skipped
+func transformTransactions(ctx context.Context, trxs []bca.Entry) ([]bca.Entry, error) {
+	if transformCommand == "" || len(trxs) == 0 {
+		return trxs, nil
+	}
+
+	var in bytes.Buffer
+	enc := json.NewEncoder(&in)
+	for i, importID := range importIDs(trxs) {
+		trx := trxs[i]
+		e := toLedgerEntry(trx, importID)
+		e.Payee = payeeName(trx)
+		e.Category = reviewedCategory(trx)
+		if err := enc.Encode(transformInput{ledgerEntry: e, Memo: reviewedMemo(trx)}); err != nil {
+			return nil, err
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, transformTimeout)
+	defer cancel()
+	var out bytes.Buffer
+	cmd := exec.CommandContext(ctx, transformCommand)
+	cmd.Stdin = &in
+	cmd.Stdout = &out
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("transform %s failed: %w", transformCommand, err)
+	}
+
+	var (
+		kept    []bca.Entry
+		edits   = make(map[string]reviewEdit)
+		skipped int
+		scanner = bufio.NewScanner(&out)
+		i       int
+	)
+	scanner.Buffer(nil, 1024*1024)
+	for ; scanner.Scan(); i++ {
+		if i >= len(trxs) {
+			return nil, fmt.Errorf("transform %s returned more lines than the %d transaction(s) it was given", transformCommand, len(trxs))
+		}
+		var o transformOutput
+		if err := json.Unmarshal(scanner.Bytes(), &o); err != nil {
+			return nil, fmt.Errorf("transform %s returned invalid line %d: %w", transformCommand, i+1, err)
+		}
+		trx := trxs[i]
+		if o.Skip {
+			skipped++
+			continue
+		}
+		kept = append(kept, trx)
+		if o.Payee == "" && o.Memo == "" && o.Category == "" && len(o.Splits) == 0 {
+			continue
+		}
+		if err := checkSplits(trx, o.Splits); err != nil {
+			return nil, fmt.Errorf("transform %s returned invalid splits on line %d: %w", transformCommand, i+1, err)
+		}
+		e := reviewEdit{Payee: payeeName(trx), Memo: reviewedMemo(trx), Category: reviewedCategory(trx), Splits: o.Splits}
+		if o.Payee != "" {
+			e.Payee = o.Payee
+		}
+		if o.Memo != "" {
+			e.Memo = o.Memo
+		}
+		if o.Category != "" {
+			e.Category = o.Category
+		}
+		edits[reviewKey(trx)] = e
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read transform output: %w", err)
+	}
+	if i != len(trxs) {
+		return nil, fmt.Errorf("transform %s returned %d line(s) for %d transaction(s)", transformCommand, i, len(trxs))
+	}
+
+	setReviewEdits(edits)
+	if skipped > 0 || len(edits) > 0 {
+		fmt.Fprintf(stdout, "transform changed %d and skipped %d bca transaction(s)\n", len(edits), skipped)
+	}
+	return kept, nil
+}
+
+// checkSplits fails unless splits are empty or at least two adding up to
+// the amount of trx
+func checkSplits(trx bca.Entry, splits []split) error {
+	if len(splits) == 0 {
+		return nil
+	}
+	if len(splits) == 1 {
+		return fmt.Errorf("a single split, give at least two")
+	}
+	var sum decimal.Decimal
+	for _, s := range splits {
+		sum = sum.Add(s.Amount)
+	}
+	if want := signedAmount(trx); !sum.Equal(want) {
+		return fmt.Errorf("splits add up to %s instead of %s", sum, want)
+	}
+	return nil
+}
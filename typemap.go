@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/satraul/bca-go"
+)
+
+const (
+	typeMapFile = "type-map.json"
+
+	mapDebit  = "debit"
+	mapCredit = "credit"
+	mapSkip   = "skip"
+)
+
+var (
+	typeMapPath string
+
+	// entryTypes is the loaded --type-map
+	entryTypes typeMapping
+)
+
+// typeMapping classifies bca entries as debits or credits, or skips them,
+// by their type and by upper-case description prefixes. Destinations
+// override it for a destination, like firefly or ynab
+type typeMapping struct {
+	Types        map[string]string      `json:"types,omitempty"`
+	Descriptions map[string]string      `json:"descriptions,omitempty"`
+	Destinations map[string]typeMapping `json:"destinations,omitempty"`
+}
+
+// loadTypeMap reads --type-map, or the file in the profile
+func loadTypeMap() error {
+	path := typeMapPath
+	if path == "" {
+		path = filepath.Join(profileFolder().Path, typeMapFile)
+	}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) && typeMapPath == "" {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var m typeMapping
+	if err := json.Unmarshal(b, &m); err != nil {
+		return fmt.Errorf("invalid type map %s: %w", path, err)
+	}
+	if err := m.normalize(path); err != nil {
+		return err
+	}
+	for name, d := range m.Destinations {
+		if len(d.Destinations) > 0 {
+			return fmt.Errorf("invalid type map %s: destination %s can't have destinations", path, name)
+		}
+		if err := d.normalize(path); err != nil {
+			return err
+		}
+		m.Destinations[name] = d
+	}
+	entryTypes = m
+	return nil
+}
+
+// normalize upper-cases the keys of m and checks its classifications
+func (m *typeMapping) normalize(path string) error {
+	for _, rules := range []*map[string]string{&m.Types, &m.Descriptions} {
+		upper := make(map[string]string, len(*rules))
+		for k, v := range *rules {
+			switch v {
+			case mapDebit, mapCredit, mapSkip:
+			default:
+				return fmt.Errorf("invalid type map %s: %q maps to %q, expected debit, credit or skip", path, k, v)
+			}
+			upper[strings.ToUpper(strings.TrimSpace(k))] = v
+		}
+		*rules = upper
+	}
+	return nil
+}
+
+// classify returns how m classifies trx, or "" if no rule matches. the
+// longest matching description prefix wins over the type
+func (m typeMapping) classify(trx bca.Entry) string {
+	desc := strings.ToUpper(strings.Join(strings.Fields(trx.Description), " "))
+	prefixes := make([]string, 0, len(m.Descriptions))
+	for prefix := range m.Descriptions {
+		if strings.HasPrefix(desc, prefix) {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	if len(prefixes) > 0 {
+		sort.Slice(prefixes, func(i, j int) bool { return len(prefixes[i]) > len(prefixes[j]) })
+		return m.Descriptions[prefixes[0]]
+	}
+	return m.Types[strings.ToUpper(trx.Type)]
+}
+
+// mapTypes returns trxs as the destination named dest should get them, with
+// mapped entries turned into DB or CR entries and skipped ones dropped.
+// entries of other types than DB and CR that aren't mapped are pushed as
+// credits, as they always were, with a warning. idx are the indices in trxs
+// of the entries returned
+func mapTypes(dest string, trxs []bca.Entry) (mapped []bca.Entry, idx []int) {
+	override, ok := entryTypes.Destinations[dest]
+	if i := strings.Index(dest, ":"); !ok && i >= 0 {
+		// ynab:budget/account falls back to ynab
+		override = entryTypes.Destinations[dest[:i]]
+	}
+
+	var (
+		unmapped = make(map[string]bool)
+		skipped  int
+	)
+	mapped = make([]bca.Entry, 0, len(trxs))
+	idx = make([]int, 0, len(trxs))
+	for i, trx := range trxs {
+		as := override.classify(trx)
+		if as == "" {
+			as = entryTypes.classify(trx)
+		}
+		switch as {
+		case mapSkip:
+			skipped++
+			continue
+		case mapDebit:
+			trx.Type = "DB"
+		case mapCredit:
+			trx.Type = "CR"
+		default:
+			if trx.Type != "DB" && trx.Type != "CR" {
+				unmapped[trx.Type] = true
+			}
+		}
+		mapped = append(mapped, trx)
+		idx = append(idx, i)
+	}
+
+	if skipped > 0 {
+		fmt.Fprintf(stdout, "%s: skipped %d transaction(s) by the type map\n", dest, skipped)
+	}
+	for t := range unmapped {
+		fmt.Fprintf(stdout, "%s: bca type %q isn't in the type map, pushed as a credit\n", dest, t)
+	}
+	return mapped, idx
+}
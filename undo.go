@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+	"go.bmvs.io/ynab/api/transaction"
+)
+
+var (
+	undoFlag bool
+)
+
+// undoAction deletes, or with --flag flags, the transactions a run created
+// in ynab and firefly and marks the run as undone
+func undoAction(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return fmt.Errorf("expected a run id")
+	}
+	r, err := findRun(c.Args().First())
+	if err != nil {
+		return err
+	}
+	if r.Undone {
+		return fmt.Errorf("run %s was already undone", r.ID)
+	}
+
+	n := 0
+	for _, d := range r.Destinations {
+		n += len(d.Transactions)
+	}
+	verb := "delete"
+	if undoFlag {
+		verb = "flag"
+	}
+	if err := confirm("%s the %d transaction(s) created by run %s", verb, n, r.ID); err != nil {
+		return err
+	}
+
+	config, err := getOrDeleteConfig(username, password, token, false, noninteractive, reset, nostore)
+	if err != nil {
+		return err
+	}
+	if config == nil {
+		return nil
+	}
+
+	for _, d := range r.Destinations {
+		if len(d.Transactions) == 0 {
+			continue
+		}
+		var n int
+		switch {
+		case d.Name == "ynab" || strings.HasPrefix(d.Name, "ynab:"):
+			// runs from before ynab targets have no budget and account
+			t := ynabTarget{Budget: budget, Account: accountName}
+			if d.Budget != "" {
+				t = ynabTarget{Budget: d.Budget, Account: d.Account}
+			}
+			n, err = undoYNAB(c.Context, config, t, d.Transactions)
+		case d.Name == "firefly":
+			if undoFlag {
+				fmt.Fprintf(stdout, "firefly: can't flag transactions, left %d in place\n", len(d.Transactions))
+				continue
+			}
+			n, err = undoFirefly(c.Context, d.Transactions)
+		default:
+			fmt.Fprintf(stdout, "%s: can't be undone, remove %d transaction(s) manually. see history show %s\n", d.Name, len(d.Transactions), r.ID)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to undo %s after %d succeeded: %w", d.Name, n, err)
+		}
+		action := "deleted"
+		if undoFlag {
+			action = "flagged"
+		}
+		fmt.Fprintf(stdout, "%s: %d transaction(s) were successfully %s\n", d.Name, n, action)
+	}
+
+	return markUndone(r.ID)
+}
+
+func undoYNAB(ctx context.Context, config *config, target ynabTarget, created []createdTransaction) (int, error) {
+	yc, err := newYNABClient(ctx, config.YNABToken)
+	if err != nil {
+		return 0, err
+	}
+	a, err := getYNABAccount(yc, target.Budget, target.Account)
+	if err != nil {
+		return 0, err
+	}
+
+	// transactions changed or deleted since the run are looked up first, so
+	// that edits made in ynab aren't overwritten when flagging
+	since := created[0].Date
+	for _, t := range created {
+		if t.Date.Before(since) {
+			since = t.Date
+		}
+	}
+	ts, err := yc.getTransactions(target.Budget, a.ID, since)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get ynab transactions: %w", err)
+	}
+	byID := make(map[string]*transaction.Transaction, len(ts))
+	for _, t := range ts {
+		byID[t.ID] = t
+	}
+
+	n := 0
+	for _, ct := range created {
+		t, ok := byID[ct.ID]
+		if !ok || t.Deleted {
+			continue
+		}
+		if undoFlag {
+			p := transactionToPayload(t)
+			red := transaction.FlagColorRed
+			p.FlagColor = &red
+			err = yc.updateTransaction(target.Budget, t.ID, p)
+		} else {
+			err = yc.deleteTransaction(target.Budget, t.ID)
+		}
+		if err != nil {
+			return n, fmt.Errorf("transaction %s: %w", t.ID, err)
+		}
+		n++
+	}
+	return n, nil
+}
+
+func undoFirefly(ctx context.Context, created []createdTransaction) (int, error) {
+	if fireflyUrl == "" {
+		return 0, fmt.Errorf("--firefly-url is required to undo firefly transactions")
+	}
+	ff, auth := newFireflyClient(ctx)
+
+	n := 0
+	for _, t := range created {
+		resp, err := ff.TransactionsApi.DeleteTransaction(auth, stringToInt32(t.ID)).Execute()
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			continue
+		}
+		if err != nil {
+			return n, fmt.Errorf("transaction %s: %w", t.ID, err)
+		}
+		if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+			b, _ := io.ReadAll(resp.Body)
+			return n, fmt.Errorf("transaction %s: status code not OK response %q", t.ID, string(b))
+		}
+		n++
+	}
+	return n, nil
+}
+
+// markUndone rewrites the history with run id marked as undone
+func markUndone(id string) error {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+
+	runs, err := readHistory()
+	if err != nil {
+		return err
+	}
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	for _, r := range runs {
+		if r.ID == id {
+			r.Undone = true
+			r.UndoneAt = time.Now()
+		}
+		if err := enc.Encode(r); err != nil {
+			return err
+		}
+	}
+	return writeFileAtomic(filepath.Join(profileFolder().Path, historyFile), b.Bytes(), 0600)
+}
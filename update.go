@@ -0,0 +1,273 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	releasesURL   = "https://api.github.com/repos/satraul/bca-sync-ynab/releases/latest"
+	checksumsFile = "checksums.txt"
+	// maxReleaseSize bounds the download of a release archive
+	maxReleaseSize = 100 << 20
+)
+
+var (
+	// version, commit and date are set by goreleaser with -X
+	version = "1.3.4"
+	commit  = "none"
+	date    = "unknown"
+
+	updateCheck bool
+
+	// releaseNames are the names goreleaser gives operating systems and
+	// architectures in archive names
+	releaseNames = map[string]string{
+		"darwin":  "MacOS",
+		"linux":   "Linux",
+		"windows": "Windows",
+		"386":     "i386",
+		"amd64":   "x86_64",
+	}
+)
+
+// appVersion is the version shown by --version
+func appVersion() string {
+	return "v" + strings.TrimPrefix(version, "v")
+}
+
+func printVersion(c *cli.Context) {
+	fmt.Fprintf(dataout, "bca-sync-ynab %s\ncommit: %s\nbuilt: %s\ngo: %s %s/%s\n", appVersion(), commit, date, runtime.Version(), runtime.GOOS, runtime.GOARCH)
+}
+
+// release is a github release
+type release struct {
+	TagName string `json:"tag_name"`
+	Assets  []struct {
+		Name string `json:"name"`
+		URL  string `json:"browser_download_url"`
+	} `json:"assets"`
+}
+
+func (r release) asset(name string) (string, bool) {
+	for _, a := range r.Assets {
+		if a.Name == name {
+			return a.URL, true
+		}
+	}
+	return "", false
+}
+
+// newerVersion tells whether version a is newer than b, both like v1.2.3
+func newerVersion(a, b string) bool {
+	parse := func(v string) []int {
+		parts := strings.Split(strings.SplitN(strings.TrimPrefix(v, "v"), "-", 2)[0], ".")
+		n := make([]int, 3)
+		for i := 0; i < len(parts) && i < 3; i++ {
+			n[i], _ = strconv.Atoi(parts[i])
+		}
+		return n
+	}
+	va, vb := parse(a), parse(b)
+	for i := range va {
+		if va[i] != vb[i] {
+			return va[i] > vb[i]
+		}
+	}
+	return false
+}
+
+// packageManager returns the package manager that installed exe, which
+// should update it instead
+func packageManager(exe string) string {
+	p := filepath.ToSlash(strings.ToLower(exe))
+	switch {
+	case strings.Contains(p, "/cellar/") || strings.Contains(p, "/homebrew/") || strings.Contains(p, "/linuxbrew/"):
+		return "brew upgrade bca-sync-ynab"
+	case strings.Contains(p, "/scoop/"):
+		return "scoop update bca-sync-ynab"
+	}
+	return ""
+}
+
+// selfUpdateAction replaces the running binary with the latest release
+// after verifying it against the release checksums
+func selfUpdateAction(c *cli.Context) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return err
+	}
+
+	var r release
+	if err := getJSON(c.Context, releasesURL, &r); err != nil {
+		return fmt.Errorf("failed to get the latest release: %w", err)
+	}
+	if !newerVersion(r.TagName, appVersion()) {
+		fmt.Fprintf(stdout, "bca-sync-ynab %s is the latest version\n", appVersion())
+		return nil
+	}
+	fmt.Fprintf(stdout, "bca-sync-ynab %s is available, this is %s\n", r.TagName, appVersion())
+	if updateCheck {
+		return nil
+	}
+	if pm := packageManager(exe); pm != "" {
+		return fmt.Errorf("%s is managed by a package manager, update it with %s", exe, pm)
+	}
+
+	osName, arch := releaseNames[runtime.GOOS], releaseNames[runtime.GOARCH]
+	if osName == "" {
+		return fmt.Errorf("no releases for %s", runtime.GOOS)
+	}
+	if arch == "" {
+		arch = runtime.GOARCH
+	}
+	name := fmt.Sprintf("bca-sync-ynab_%s_%s_%s.tar.gz", strings.TrimPrefix(r.TagName, "v"), osName, arch)
+	archiveURL, ok := r.asset(name)
+	if !ok {
+		return fmt.Errorf("release %s has no %s", r.TagName, name)
+	}
+	checksumsURL, ok := r.asset(checksumsFile)
+	if !ok {
+		return fmt.Errorf("release %s has no %s, not updating without verifying", r.TagName, checksumsFile)
+	}
+
+	sums, err := download(c.Context, checksumsURL)
+	if err != nil {
+		return err
+	}
+	want, err := releaseChecksum(sums, name)
+	if err != nil {
+		return err
+	}
+	archive, err := download(c.Context, archiveURL)
+	if err != nil {
+		return err
+	}
+	if got := sha256.Sum256(archive); hex.EncodeToString(got[:]) != want {
+		return fmt.Errorf("checksum of %s doesn't match %s, not updating", name, checksumsFile)
+	}
+	bin, err := extractBinary(archive)
+	if err != nil {
+		return fmt.Errorf("failed to extract %s: %w", name, err)
+	}
+	if err := replaceExecutable(exe, bin); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", exe, err)
+	}
+	fmt.Fprintf(stdout, "updated %s to %s\n", exe, r.TagName)
+	return nil
+}
+
+func getJSON(ctx context.Context, url string, v interface{}) error {
+	b, err := download(ctx, url)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+func download(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxReleaseSize))
+}
+
+// releaseChecksum finds the sha256 of name in a checksums file
+func releaseChecksum(sums []byte, name string) (string, error) {
+	s := bufio.NewScanner(bytes.NewReader(sums))
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) == 2 && fields[1] == name {
+			return strings.ToLower(fields[0]), nil
+		}
+	}
+	return "", fmt.Errorf("no checksum for %s in %s", name, checksumsFile)
+}
+
+// extractBinary returns the executable in a release archive
+func extractBinary(archive []byte) ([]byte, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		return nil, err
+	}
+	tr := tar.NewReader(gz)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("no bca-sync-ynab executable in the archive")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if base := filepath.Base(h.Name); base == "bca-sync-ynab" || base == "bca-sync-ynab.exe" {
+			return io.ReadAll(io.LimitReader(tr, maxReleaseSize))
+		}
+	}
+}
+
+// replaceExecutable swaps exe for bin. the running executable can't be
+// overwritten on windows, but it can be renamed, so it is moved aside first
+// and left for the next update to remove
+func replaceExecutable(exe string, bin []byte) error {
+	dir := filepath.Dir(exe)
+	old := exe + ".old"
+	os.Remove(old)
+
+	tmp, err := ioutil.TempFile(dir, ".bca-sync-ynab-update-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(bin); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0755); err != nil {
+		return err
+	}
+
+	if err := os.Rename(exe, old); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), exe); err != nil {
+		// put the old executable back
+		os.Rename(old, exe)
+		return err
+	}
+	if runtime.GOOS != "windows" {
+		os.Remove(old)
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	vaultTimeout = 30 * time.Second
+)
+
+// vault resolves vault://path#field with the vault http api, where path is
+// the api path of the secret, like secret/data/bca-sync-ynab for the kv v2
+// engine mounted at secret. it is configured like the vault cli, with
+// VAULT_ADDR, VAULT_NAMESPACE and VAULT_TOKEN or ~/.vault-token, or logs in
+// with approle when VAULT_ROLE_ID and VAULT_SECRET_ID are set instead
+type vault struct {
+	mu    sync.Mutex
+	token string
+	// secrets are the secrets read in this run by path, so that fields of
+	// the same secret are read once
+	secrets map[string]map[string]interface{}
+}
+
+func (v *vault) resolve(ref string) (string, error) {
+	path, field := strings.TrimPrefix(ref, "vault://"), ""
+	if i := strings.LastIndex(path, "#"); i >= 0 {
+		path, field = path[:i], path[i+1:]
+	}
+	path = strings.Trim(path, "/")
+	if path == "" || field == "" {
+		return "", errors.New("expected vault://path#field")
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	data, ok := v.secrets[path]
+	if !ok {
+		var err error
+		if data, err = v.read(path); err != nil {
+			return "", err
+		}
+		if v.secrets == nil {
+			v.secrets = make(map[string]map[string]interface{})
+		}
+		v.secrets[path] = data
+	}
+	value, ok := data[field].(string)
+	if !ok {
+		return "", fmt.Errorf("vault secret %s has no field %s", path, field)
+	}
+	return value, nil
+}
+
+// read returns the fields of the secret at path, unwrapping the data of kv
+// v2 secrets
+func (v *vault) read(path string) (map[string]interface{}, error) {
+	token, err := v.login()
+	if err != nil {
+		return nil, err
+	}
+	var resp struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := vaultDo(http.MethodGet, path, token, nil, &resp); err != nil {
+		return nil, err
+	}
+	if inner, ok := resp.Data["data"].(map[string]interface{}); ok && strings.Contains(path, "/data/") {
+		return inner, nil
+	}
+	return resp.Data, nil
+}
+
+// login returns the token to read secrets with
+func (v *vault) login() (string, error) {
+	if v.token != "" {
+		return v.token, nil
+	}
+	roleID, secretID := os.Getenv("VAULT_ROLE_ID"), os.Getenv("VAULT_SECRET_ID")
+	switch {
+	case os.Getenv("VAULT_TOKEN") != "":
+		v.token = os.Getenv("VAULT_TOKEN")
+	case roleID != "" && secretID != "":
+		mount := os.Getenv("VAULT_APPROLE_PATH")
+		if mount == "" {
+			mount = "approle"
+		}
+		var resp struct {
+			Auth struct {
+				ClientToken string `json:"client_token"`
+			} `json:"auth"`
+		}
+		body := map[string]string{"role_id": roleID, "secret_id": secretID}
+		if err := vaultDo(http.MethodPost, "auth/"+strings.Trim(mount, "/")+"/login", "", body, &resp); err != nil {
+			return "", fmt.Errorf("approle login failed: %w", err)
+		}
+		v.token = resp.Auth.ClientToken
+	default:
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		b, err := ioutil.ReadFile(filepath.Join(home, ".vault-token"))
+		if err != nil {
+			return "", errors.New("no vault token, set VAULT_TOKEN, or VAULT_ROLE_ID and VAULT_SECRET_ID for approle, or log in with vault login")
+		}
+		v.token = strings.TrimSpace(string(b))
+	}
+	registerSecret(v.token)
+	return v.token, nil
+}
+
+// vaultDo calls the vault api at VAULT_ADDR
+func vaultDo(method, path, token string, body, v interface{}) error {
+	addr := strings.TrimRight(os.Getenv("VAULT_ADDR"), "/")
+	if addr == "" {
+		return errors.New("VAULT_ADDR isn't set")
+	}
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest(method, addr+"/v1/"+path, &buf)
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	if ns := os.Getenv("VAULT_NAMESPACE"); ns != "" {
+		req.Header.Set("X-Vault-Namespace", ns)
+	}
+	resp, err := (&http.Client{Timeout: vaultTimeout}).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Errors []string `json:"errors"`
+		}
+		json.NewDecoder(resp.Body).Decode(&e)
+		if len(e.Errors) == 0 {
+			return fmt.Errorf("vault %s %s returned %s", method, path, resp.Status)
+		}
+		return fmt.Errorf("vault %s %s returned %s: %s", method, path, resp.Status, strings.Join(e.Errors, ", "))
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/satraul/bca-go"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	formatKlikBCA   = "klikbca"
+	formatGoPay     = "gopay"
+	formatOVO       = "ovo"
+	formatShopeePay = "shopeepay"
+)
+
+var (
+	importFormat string
+
+	// walletNames are the e-wallets whose csv exports can be imported, by
+	// --format
+	walletNames = map[string]string{
+		formatGoPay:     "GoPay",
+		formatOVO:       "OVO",
+		formatShopeePay: "ShopeePay",
+	}
+
+	// walletLayouts are the date layouts of e-wallet exports, which unlike
+	// bca statements usually carry the time too
+	walletLayouts = []string{
+		"2006-01-02 15:04:05", "2006-01-02 15:04", time.RFC3339, "2006-01-02",
+		"02/01/2006 15:04:05", "02/01/2006 15:04", "02/01/2006",
+		"02-01-2006 15:04:05", "02-01-2006 15:04", "02-01-2006",
+		"2 Jan 2006 15:04", "2 Jan 2006, 15:04", "2 Jan 2006",
+		"2 January 2006 15:04", "2 January 2006, 15:04", "2 January 2006",
+	}
+
+	// walletDebitTypes and walletCreditTypes tell the direction of a row
+	// from its type column
+	walletDebitTypes  = regexp.MustCompile(`(?i)debit|^db$|out|keluar|pay|bayar|transfer ke|kirim|withdraw|tarik`)
+	walletCreditTypes = regexp.MustCompile(`(?i)credit|kredit|^cr$|\bin\b|masuk|top ?up|isi saldo|refund|cashback|terima`)
+)
+
+// walletColumns are the header names accepted for each column of an
+// e-wallet export. exports differ between wallets and app versions, so
+// columns are found by name rather than position
+var walletColumns = struct {
+	date, description, amount, debit, credit, kind []string
+}{
+	date:        []string{"date", "tanggal", "transaction date", "tanggal transaksi", "waktu", "time", "created at"},
+	description: []string{"description", "deskripsi", "keterangan", "details", "detail", "transaction details", "detail transaksi", "merchant", "title"},
+	amount:      []string{"amount", "nominal", "jumlah", "total", "amount (idr)", "amount (rp)"},
+	debit:       []string{"debit", "uang keluar", "money out", "outgoing"},
+	credit:      []string{"credit", "kredit", "uang masuk", "money in", "incoming"},
+	kind:        []string{"type", "jenis", "tipe", "transaction type", "jenis transaksi", "debit/credit", "direction"},
+}
+
+// walletSource is the source recorded for runs importing format
+func walletSource(format string) string {
+	if name, ok := walletNames[format]; ok {
+		return name + " e-wallet"
+	}
+	return sourceEStatement
+}
+
+func validateImportFormat() error {
+	if _, ok := walletNames[importFormat]; ok || importFormat == formatKlikBCA {
+		return nil
+	}
+	return fmt.Errorf("unknown --format %q, expected klikbca, gopay, ovo or shopeepay", importFormat)
+}
+
+// parseWalletCSV parses an e-wallet csv export into entries like those of
+// bca, so that they go through the same filters and destinations.
+// payments are debits and top ups and refunds credits
+func parseWalletCSV(r io.Reader) ([]bca.Entry, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.LazyQuotes = true
+
+	var (
+		columns map[string]int
+		trxs    = make([]bca.Entry, 0)
+		row     int
+	)
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		row++
+		for i := range record {
+			record[i] = strings.TrimSpace(record[i])
+		}
+
+		// rows before the header, like the account name, are skipped
+		if columns == nil {
+			columns = walletHeader(record)
+			continue
+		}
+		if strings.Join(record, "") == "" {
+			continue
+		}
+		trx, err := parseWalletRow(record, columns)
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %w", row, err)
+		}
+		trxs = append(trxs, trx)
+	}
+	if columns == nil {
+		return nil, fmt.Errorf("no header with date, description and amount columns found")
+	}
+	return trxs, nil
+}
+
+// walletHeader returns the column indexes of record if it is the header
+func walletHeader(record []string) map[string]int {
+	columns := make(map[string]int)
+	find := func(name string, names []string) {
+		for i, field := range record {
+			for _, n := range names {
+				if strings.EqualFold(field, n) {
+					if _, ok := columns[name]; !ok {
+						columns[name] = i
+					}
+				}
+			}
+		}
+	}
+	find("date", walletColumns.date)
+	find("description", walletColumns.description)
+	find("amount", walletColumns.amount)
+	find("debit", walletColumns.debit)
+	find("credit", walletColumns.credit)
+	find("kind", walletColumns.kind)
+
+	_, hasAmount := columns["amount"]
+	_, hasDebit := columns["debit"]
+	_, hasCredit := columns["credit"]
+	_, hasDate := columns["date"]
+	_, hasDescription := columns["description"]
+	if !hasDate || !hasDescription || !(hasAmount || hasDebit && hasCredit) {
+		return nil
+	}
+	return columns
+}
+
+func parseWalletRow(record []string, columns map[string]int) (bca.Entry, error) {
+	field := func(name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return record[i]
+		}
+		return ""
+	}
+
+	var trx bca.Entry
+	date, err := parseWalletDate(field("date"))
+	if err != nil {
+		return trx, err
+	}
+	trx.Date = date
+	trx.Description = strings.Join(strings.Fields(field("description")), " ")
+	trx.Payee = trx.Description
+
+	var amount decimal.Decimal
+	if _, ok := columns["amount"]; ok && field("amount") != "" {
+		if amount, err = parseWalletAmount(field("amount")); err != nil {
+			return trx, err
+		}
+		switch kind := field("kind"); {
+		case kind == "":
+			// signed amounts, negative for payments
+		case walletDebitTypes.MatchString(kind):
+			amount = amount.Abs().Neg()
+		case walletCreditTypes.MatchString(kind):
+			amount = amount.Abs()
+		default:
+			return trx, fmt.Errorf("unknown transaction type %q", kind)
+		}
+	} else {
+		debit, credit := field("debit"), field("credit")
+		switch {
+		case debit != "" && debit != "0" && debit != "-":
+			if amount, err = parseWalletAmount(debit); err != nil {
+				return trx, err
+			}
+			amount = amount.Abs().Neg()
+		case credit != "" && credit != "0" && credit != "-":
+			if amount, err = parseWalletAmount(credit); err != nil {
+				return trx, err
+			}
+			amount = amount.Abs()
+		default:
+			return trx, fmt.Errorf("no debit or credit amount")
+		}
+	}
+
+	trx.Amount = amount.Abs()
+	trx.Type = "CR"
+	if amount.IsNegative() {
+		trx.Type = "DB"
+	}
+	return trx, nil
+}
+
+func parseWalletDate(s string) (time.Time, error) {
+	s = indonesianMonths.Replace(strings.TrimSpace(s))
+	for _, layout := range walletLayouts {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid transaction date %q", s)
+}
+
+// parseWalletAmount parses amounts like Rp25.000, -25,000.00 or (25.000)
+// with either separator for thousands
+func parseWalletAmount(s string) (decimal.Decimal, error) {
+	original := s
+	s = strings.NewReplacer("Rp", "", "rp", "", "IDR", "", " ", "", "\u00a0", "").Replace(s)
+	negative := false
+	if strings.HasPrefix(s, "(") && strings.HasSuffix(s, ")") {
+		negative, s = true, strings.Trim(s, "()")
+	}
+	if strings.HasPrefix(s, "-") {
+		negative, s = true, s[1:]
+	}
+	s = strings.TrimPrefix(s, "+")
+
+	dot, comma := strings.LastIndex(s, "."), strings.LastIndex(s, ",")
+	switch {
+	case dot >= 0 && comma >= 0:
+		// the last separator is the decimal one
+		if dot > comma {
+			s = strings.ReplaceAll(s, ",", "")
+		} else {
+			s = strings.ReplaceAll(strings.ReplaceAll(s, ".", ""), ",", ".")
+		}
+	case dot >= 0:
+		if isThousands(s, ".") {
+			s = strings.ReplaceAll(s, ".", "")
+		}
+	case comma >= 0:
+		if isThousands(s, ",") {
+			s = strings.ReplaceAll(s, ",", "")
+		} else {
+			s = strings.ReplaceAll(s, ",", ".")
+		}
+	}
+
+	d, err := decimal.NewFromString(s)
+	if err != nil {
+		return decimal.Zero, fmt.Errorf("invalid amount %q", original)
+	}
+	if negative {
+		d = d.Neg()
+	}
+	return d, nil
+}
+
+// isThousands reports whether sep only separates groups of 3 digits in s,
+// as rupiah amounts rarely have decimals
+func isThousands(s, sep string) bool {
+	parts := strings.Split(s, sep)
+	for _, p := range parts[1:] {
+		if len(p) != 3 {
+			return false
+		}
+	}
+	return true
+}
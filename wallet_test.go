@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func parseWalletFixture(t *testing.T, name string) (string, error) {
+	t.Helper()
+	f, err := os.Open(filepath.Join("testdata", "wallet", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	trxs, err := parseWalletCSV(f)
+	var b strings.Builder
+	for _, trx := range trxs {
+		fmt.Fprintf(&b, "%s %s %s %s\n", trx.Date.Format(dateLayout), trx.Type, trx.Amount, trx.Description)
+	}
+	return b.String(), err
+}
+
+func TestParseWalletCSV(t *testing.T) {
+	tests := []struct {
+		file, want string
+	}{
+		{
+			// rows before the header, a type column and a trailing blank line
+			"gopay.csv",
+			"2024-05-02 DB 18000 Payment to KOPI KENANGAN\n" +
+				"2024-05-02 CR 200000 Top Up from BCA VA\n" +
+				"2024-05-03 CR 25500 Refund GoFood\n",
+		},
+		{
+			// debit and credit columns, indonesian headers and months
+			"ovo.csv",
+			"2024-05-02 DB 25000 Pembayaran GRAB FOOD\n" +
+				"2024-05-03 CR 1000000 Isi Saldo\n" +
+				"2024-05-04 DB 150000 Transfer ke 0812xxxx\n",
+		},
+		{
+			// signed amounts, with a sign or in parentheses
+			"shopeepay.csv",
+			"2024-05-02 DB 45000 Pembayaran Shopee\n" +
+				"2024-05-05 CR 4500 Cashback Shopee\n" +
+				"2024-05-06 DB 1250.5 Koreksi\n",
+		},
+	}
+	for _, tt := range tests {
+		got, err := parseWalletFixture(t, tt.file)
+		if err != nil {
+			t.Errorf("%s: %v", tt.file, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s parsed as\n%s\nwant\n%s", tt.file, got, tt.want)
+		}
+	}
+}
+
+func TestParseWalletCSVMalformed(t *testing.T) {
+	tests := []struct {
+		file, want string
+	}{
+		{"no-header.csv", "no header"},
+		{"bad-date.csv", `row 3: invalid transaction date "yesterday"`},
+		{"bad-type.csv", `row 2: unknown transaction type "Mystery"`},
+		{"no-amount.csv", "row 2: no debit or credit amount"},
+	}
+	for _, tt := range tests {
+		if _, err := parseWalletFixture(t, tt.file); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: error %v, want %q", tt.file, err, tt.want)
+		}
+	}
+}
+
+func TestParseWalletAmount(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"Rp25.000", "25000"},
+		{"-25,000.00", "-25000"},
+		{"(25.000)", "-25000"},
+		{"1.234.567,89", "1234567.89"},
+		{"12,5", "12.5"},
+		{"IDR 1 000", "1000"},
+		{"+4.500", "4500"},
+	}
+	for _, tt := range tests {
+		got, err := parseWalletAmount(tt.in)
+		if err != nil || got.String() != tt.want {
+			t.Errorf("parseWalletAmount(%q) = %s, %v, want %s", tt.in, got, err, tt.want)
+		}
+	}
+	if _, err := parseWalletAmount("abc"); err == nil {
+		t.Error(`parseWalletAmount("abc") succeeded`)
+	}
+}
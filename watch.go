@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+var (
+	watchPoll    time.Duration
+	watchBacklog int
+	watchDesktop bool
+)
+
+// watchAction prints transactions as they are added to the ledger by serve
+// or any other sync of the profile
+func watchAction(c *cli.Context) error {
+	if watchPoll <= 0 {
+		return fmt.Errorf("--poll must be positive")
+	}
+
+	entries, err := readLedger()
+	if err != nil {
+		return fmt.Errorf("failed to read ledger: %w", err)
+	}
+	seen := make(map[string]bool, len(entries))
+	for _, e := range entries {
+		seen[e.ImportID] = true
+	}
+	if watchBacklog > 0 && len(entries) > 0 {
+		from := len(entries) - watchBacklog
+		if from < 0 {
+			from = 0
+		}
+		for _, e := range entries[from:] {
+			printWatchEntry(e)
+		}
+	}
+	fmt.Fprintf(stdout, "watching for new transactions of profile %s, press ctrl+c to stop\n", profile)
+
+	var (
+		path     = filepath.Join(profileFolder().Path, ledgerFile)
+		modified time.Time
+		t        = time.NewTicker(watchPoll)
+	)
+	if fi, err := os.Stat(path); err == nil {
+		modified = fi.ModTime()
+	}
+	defer t.Stop()
+	for {
+		select {
+		case <-c.Context.Done():
+			return nil
+		case <-t.C:
+		}
+
+		fi, err := os.Stat(path)
+		if err != nil || !fi.ModTime().After(modified) {
+			continue
+		}
+		modified = fi.ModTime()
+
+		entries, err := readLedger()
+		if err != nil {
+			// the ledger may be mid repair, try again next tick
+			fmt.Fprintf(stdout, "failed to read ledger: %v\n", err)
+			continue
+		}
+		for _, e := range entries {
+			if seen[e.ImportID] {
+				continue
+			}
+			seen[e.ImportID] = true
+			printWatchEntry(e)
+			if watchDesktop {
+				n := notification{Title: redact("BCA " + e.Payee), Message: redact(e.Amount.StringFixed(0) + " " + e.Description)}
+				if err := (desktopNotifier{}).notify(c.Context, n); err != nil {
+					fmt.Fprintf(stdout, "failed to send desktop notification: %v\n", err)
+				}
+			}
+		}
+	}
+}
+
+func printWatchEntry(e ledgerEntry) {
+	fmt.Fprintf(stdout, "%s  %15s  %s  %s\n", e.Date.Format(dateLayout), e.Amount.StringFixed(2), e.Payee, e.Description)
+}
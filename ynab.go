@@ -4,143 +4,444 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
 	"go.bmvs.io/ynab/api"
 	"go.bmvs.io/ynab/api/account"
-	"go.bmvs.io/ynab/api/category"
 	"go.bmvs.io/ynab/api/transaction"
 
-	"github.com/cnf/structhash"
 	"github.com/satraul/bca-go"
 	"github.com/shopspring/decimal"
-	"go.bmvs.io/ynab"
 
 	"github.com/pkg/errors"
 )
 
-func createYNABTransactions(yc ynab.ClientServicer, trxs []bca.Entry, account *account.Account, budget string) error {
-	ps := make([]transaction.PayloadTransaction, 0)
-	for _, trx := range trxs {
-		ps = append(ps, toPayloadTransaction(trx, account.ID))
+var (
+	adjustPayee, adjustCategory, adjustMemo, adjustCleared, adjustThreshold string
+	adjustUnapproved                                                        bool
+
+	// adjustThresholdMilliunits is --adjust-threshold parsed by validatePolicy
+	adjustThresholdMilliunits int64
+
+	createAccount bool
+	accountType   string
+
+	// ynabTargets are the --ynab-target accounts pushed to besides --budget
+	// and --account
+	ynabTargets []ynabTarget
+)
+
+// ynabTarget is a ynab account transactions are pushed to
+type ynabTarget struct {
+	Budget  string
+	Account string
+}
+
+func (t ynabTarget) String() string {
+	return t.Budget + "/" + t.Account
+}
+
+// main tells whether t is the target of --budget and --account
+func (t ynabTarget) main() bool {
+	return t.Budget == budget && t.Account == accountName
+}
+
+// parseYNABTargets parses --ynab-target values of the form budget:account.
+// budget ids never contain a colon, account names may
+func parseYNABTargets(values []string) ([]ynabTarget, error) {
+	seen := map[ynabTarget]bool{{Budget: budget, Account: accountName}: true}
+	var ts []ynabTarget
+	for _, v := range values {
+		i := strings.Index(v, ":")
+		if i <= 0 || i == len(v)-1 {
+			return nil, fmt.Errorf("invalid --ynab-target %q, expected budget:account", v)
+		}
+		t := ynabTarget{Budget: v[:i], Account: v[i+1:]}
+		if seen[t] {
+			return nil, fmt.Errorf("ynab target %s is given more than once", t)
+		}
+		seen[t] = true
+		ts = append(ts, t)
+	}
+	return ts, nil
+}
+
+func createYNABTransactions(ctx context.Context, yc ynabClient, trxs []bca.Entry, account *account.Account, budget string) (*transaction.OperationSummary, error) {
+	all := make([]transaction.PayloadTransaction, 0)
+	ids := importIDs(trxs)
+	for i, trx := range trxs {
+		all = append(all, toPayloadTransaction(trx, account.ID, ids[i]))
+	}
+	if err := setReviewedCategories(yc, budget, trxs, all); err != nil {
+		return nil, err
+	}
+	skip, err := checkExistingTransactions(yc, budget, account.ID, all)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		ps       = make([]transaction.PayloadTransaction, 0, len(all))
+		keptTrxs = make([]bca.Entry, 0, len(all))
+		skipped  []string
+	)
+	for i, p := range all {
+		if skip[i] {
+			skipped = append(skipped, *p.ImportID)
+			continue
+		}
+		ps = append(ps, p)
+		keptTrxs = append(keptTrxs, trxs[i])
+	}
+	if len(ps) == 0 {
+		return &transaction.OperationSummary{DuplicateImportIDs: skipped}, nil
 	}
 
-	resp, err := yc.Transaction().CreateTransactions(budget, ps)
+	caps, err := ynabCapabilitiesFor(yc, budget, account.ID)
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("failed to detect ynab capabilities: %w", err)
+	}
+	if flagName != "" && !caps.FlagNames {
+		fmt.Fprintln(stdout, "ynab flag names are not supported, ignoring --flag-name")
+	}
+	resp, err := createYNABChunks(ctx, keptTrxs, ps, func(idx []int) (*transaction.OperationSummary, error) {
+		batch := make([]transaction.PayloadTransaction, len(idx))
+		batchTrxs := make([]bca.Entry, len(idx))
+		for j, i := range idx {
+			batch[j], batchTrxs[j] = ps[i], keptTrxs[i]
+		}
+		if caps.FlagNames || caps.ImportPayeeName || hasSplits(batchTrxs) {
+			return createYNABSaveTransactions(yc, batchTrxs, batch, caps, budget)
+		}
+		return yc.createTransactions(budget, batch)
+	})
+	if resp == nil {
+		return nil, err
 	}
+	resp.DuplicateImportIDs = append(resp.DuplicateImportIDs, skipped...)
 	if len(resp.DuplicateImportIDs) > 0 {
-		fmt.Printf("%d transaction(s) already exists\n", len(resp.DuplicateImportIDs))
+		fmt.Fprintf(stdout, "%d transaction(s) already exists\n", len(resp.DuplicateImportIDs))
 	}
-	fmt.Printf("%d transaction(s) were successfully created\n", len(resp.TransactionIDs))
-	return nil
+	fmt.Fprintf(stdout, "%d transaction(s) were successfully created\n", len(resp.TransactionIDs))
+	// with a *ynabBatchError, resp has the transactions that were created
+	return resp, err
+}
+
+// createYNABSaveTransactions creates transactions with the fields
+// go.bmvs.io/ynab doesn't know about: the splits of --transform as
+// subtransactions, and those of caps, --flag-name and the raw bca payee as
+// import payee name, which ynab matches payee renames on
+func createYNABSaveTransactions(yc ynabClient, trxs []bca.Entry, ps []transaction.PayloadTransaction, caps ynabCapabilities, budget string) (*transaction.OperationSummary, error) {
+	var categories map[string]string
+	if hasSplits(trxs) {
+		var err error
+		if categories, err = ynabCategoryIDs(yc, budget); err != nil {
+			return nil, err
+		}
+	}
+	ss := make([]saveTransaction, 0, len(ps))
+	for i, p := range ps {
+		st := toSaveTransaction(p)
+		st.Subtransactions = toSaveSubTransactions(trxs[i], p, categories)
+		if st.Subtransactions != nil {
+			// ynab categorizes split transactions as split
+			st.CategoryID = nil
+		}
+		if caps.FlagNames && flagName != "" {
+			st.FlagName = &flagName
+		}
+		if caps.ImportPayeeName {
+			payee := trxs[i].Payee
+			st.ImportPayeeName = &payee
+		}
+		ss = append(ss, st)
+	}
+
+	resp, err := yc.saveTransactions(budget, ss)
+	if err != nil {
+		return nil, err
+	}
+	return &transaction.OperationSummary{
+		TransactionIDs:     resp.TransactionIDs,
+		DuplicateImportIDs: resp.DuplicateImportIDs,
+		Transactions:       resp.Transactions,
+	}, nil
+}
+
+func hasSplits(trxs []bca.Entry) bool {
+	for _, trx := range trxs {
+		if len(reviewedSplits(trx)) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// toSaveSubTransactions returns the subtransactions of the splits of trx,
+// with categories resolved by name. splits that don't add up to the amount
+// of p, as after a --type-map changed its sign, are dropped with a warning
+func toSaveSubTransactions(trx bca.Entry, p transaction.PayloadTransaction, categories map[string]string) []saveSubTransaction {
+	splits := reviewedSplits(trx)
+	if len(splits) == 0 {
+		return nil
+	}
+	var (
+		subs = make([]saveSubTransaction, len(splits))
+		sum  int64
+	)
+	for i, s := range splits {
+		sub := saveSubTransaction{Amount: toMilliunits(s.Amount)}
+		sum += sub.Amount
+		if s.Payee != "" {
+			payee := s.Payee
+			sub.PayeeName = &payee
+		}
+		if s.Memo != "" {
+			memo := s.Memo
+			sub.Memo = &memo
+		}
+		if s.Category != "" {
+			if id, ok := categories[strings.ToLower(s.Category)]; ok {
+				sub.CategoryID = &id
+			} else {
+				fmt.Fprintf(stdout, "warning: no ynab category %s, split left uncategorized\n", s.Category)
+			}
+		}
+		subs[i] = sub
+	}
+	if sum != p.Amount {
+		fmt.Fprintf(stdout, "warning: the splits of %s add up to %d milliunits instead of %d, pushed without them\n", *p.ImportID, sum, p.Amount)
+		return nil
+	}
+	return subs
+}
+
+// checkExistingTransactions compares the payloads with the ynab
+// transactions already in their date range, for transactions imported with
+// another import id strategy and scheduled transactions. it returns the
+// indexes of the payloads not to create
+func checkExistingTransactions(yc ynabClient, budget, accountID string, ps []transaction.PayloadTransaction) (map[int]bool, error) {
+	skip := make(map[int]bool)
+	if len(ps) == 0 || (strategyDuplicates == "create" && scheduledMatch == "off" && len(transferAccounts) == 0) {
+		return skip, nil
+	}
+
+	since := ps[0].Date.Time
+	for _, p := range ps {
+		if p.Date.Before(since) {
+			since = p.Date.Time
+		}
+	}
+	days := scheduledToleranceDays
+	if transferWindow > days {
+		days = transferWindow
+	}
+	ts, err := yc.getTransactions(budget, accountID, since.AddDate(0, 0, -days))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get existing ynab transactions: %w", err)
+	}
+
+	if err := matchScheduledTransactions(yc, budget, accountID, ts, ps, skip); err != nil {
+		return nil, fmt.Errorf("failed to match scheduled transactions: %w", err)
+	}
+	checkStrategyDuplicates(ts, ps, skip)
+	if err := pairTransfers(yc, budget, accountID, ts, ps, skip); err != nil {
+		return nil, fmt.Errorf("failed to pair transfers: %w", err)
+	}
+	return skip, nil
 }
 
-func getYNABAccount(yc ynab.ClientServicer, budget string, accountName string) (*account.Account, error) {
-	accs, err := yc.Account().GetAccounts(budget, nil)
+func getYNABAccount(yc ynabClient, budget string, accountName string) (*account.Account, error) {
+	accs, err := yc.getAccounts(budget)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to get ynab accounts. try -r")
 	}
 
-	for _, acc := range accs.Accounts {
+	for _, acc := range accs {
 		if acc.Name == accountName {
 			return acc, nil
 		}
 	}
-	return nil, errors.New("couldnt find account " + accountName)
+	return nil, fmt.Errorf("%w %s", errAccountNotFound, accountName)
+}
+
+var errAccountNotFound = errors.New("couldnt find account")
+
+// getOrCreateYNABAccount gets the account of t, creating it with
+// --create-account. the opening balance is the bca balance before trxs, so
+// that the account matches bca once they are pushed
+func getOrCreateYNABAccount(yc ynabClient, t ynabTarget, bal bca.Balance, trxs []bca.Entry) (*account.Account, error) {
+	a, err := getYNABAccount(yc, t.Budget, t.Account)
+	if !createAccount || !errors.Is(err, errAccountNotFound) {
+		return a, err
+	}
+
+	opening := toMilliunits(bal.Balance)
+	for _, trx := range trxs {
+		opening -= toPayloadTransaction(trx, "", "").Amount
+	}
+	a, err = yc.createAccount(t.Budget, t.Account, account.Type(accountType), opening)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create ynab account "+t.Account)
+	}
+	fmt.Fprintf(stdout, "ynab account %s successfully created with an opening balance of %s\n", t.Account, milliunitsToDecimal(opening).StringFixed(2))
+	return a, nil
 }
 
-func createYNABBalanceAdjustment(bal bca.Balance, ctx context.Context, auth []*http.Cookie, yc ynab.ClientServicer, budget string, a *account.Account) error {
-	anew, err := yc.Account().GetAccount(budget, a.ID)
+func createYNABBalanceAdjustment(bal bca.Balance, ctx context.Context, auth []*http.Cookie, yc ynabClient, budget string, a *account.Account) (bool, error) {
+	anew, err := yc.getAccount(budget, a.ID)
 	if err != nil {
-		return errors.Wrap(err, "failed to get ynab account")
-	}
-	delta := bal.Balance.IntPart()*1000 - anew.Balance
-	if delta != 0 {
-		var (
-			payee = "Automated Balance Adjustment"
-		)
-
-		c, err := func() (*category.Category, error) {
-			cs, err := yc.Category().GetCategories(budget, nil)
-			if err != nil {
-				return nil, errors.Wrap(err, "failed to get categories")
-			}
+		return false, errors.Wrap(err, "failed to get ynab account")
+	}
+	delta := toMilliunits(bal.Balance) - anew.Balance
+	if delta == 0 {
+		return false, nil
+	}
+	abs := delta
+	if abs < 0 {
+		abs = -abs
+	}
+	if abs < adjustThresholdMilliunits {
+		fmt.Fprintf(stdout, "balance differs by %s, below --adjust-threshold. no adjustment created\n", milliunitsToDecimal(delta))
+		return false, nil
+	}
 
-			for _, group := range cs.GroupWithCategories {
-				for _, c := range group.Categories {
-					if c.Name == "Inflows" {
-						return c, nil
-					}
+	var categoryID *string
+	if adjustCategory != "" {
+		cs, err := yc.getCategories(budget)
+		if err != nil {
+			return false, errors.Wrap(err, "failed to get categories")
+		}
+		for _, group := range cs {
+			for _, c := range group.Categories {
+				if c.Name == adjustCategory {
+					categoryID = &c.ID
 				}
 			}
-			return nil, errors.New("couldnt find to be budgeted category")
-		}()
-		if err != nil {
-			return err
-		}
-
-		_, err = yc.Transaction().CreateTransaction(budget, transaction.PayloadTransaction{
-			AccountID: a.ID,
-			Date: api.Date{
-				Time: time.Now(),
-			},
-			Amount:     delta,
-			Cleared:    transaction.ClearingStatusReconciled,
-			Approved:   true,
-			PayeeID:    nil,
-			PayeeName:  &payee,
-			CategoryID: &c.ID,
-			Memo:       nil,
-			FlagColor:  nil,
-			ImportID:   nil,
-		})
-		if err != nil {
-			return errors.Wrap(err, "failed to create balance adjustment transaction")
 		}
+		if categoryID == nil {
+			return false, errors.New("couldnt find adjustment category " + adjustCategory)
+		}
+	}
 
-		fmt.Printf("balance adjustment transaction successfully created\n")
+	p := transaction.PayloadTransaction{
+		AccountID: a.ID,
+		Date: api.Date{
+			Time: time.Now(),
+		},
+		Amount:     delta,
+		Cleared:    transaction.ClearingStatus(adjustCleared),
+		Approved:   !adjustUnapproved,
+		PayeeID:    nil,
+		PayeeName:  &adjustPayee,
+		CategoryID: categoryID,
+		Memo:       nil,
+		FlagColor:  nil,
+		ImportID:   nil,
+	}
+	if adjustMemo != "" {
+		p.Memo = &adjustMemo
+	}
+	if err := yc.createTransaction(budget, p); err != nil {
+		return false, errors.Wrap(err, "failed to create balance adjustment transaction")
 	}
-	return nil
-}
 
-func toPayloadTransaction(trx bca.Entry, accountID string) transaction.PayloadTransaction {
-	// description unreliable for hash
-	desc := trx.Description
-	trx.Description = ""
-	// use predicted clearance date for PEND transactions hash
+	fmt.Fprintf(stdout, "balance adjustment transaction successfully created\n")
+	return true, nil
+}
 
+// toPayloadTransaction maps trx to a ynab payload. importID is the id of
+// trx among the entries pushed with it, as given by importIDs, or empty for
+// payloads that aren't created
+func toPayloadTransaction(trx bca.Entry, accountID, importID string) transaction.PayloadTransaction {
+	desc := reviewedMemo(trx)
+	// use predicted clearance date for PEND transactions
 	if trx.Date.IsZero() {
 		trx.Date = clearDate(time.Now())
 	}
 
 	var (
-		t           = trx.Date
-		miliunit    = trx.Amount.Mul(decimal.NewFromInt(1000)).IntPart()
-		payee       = trx.Payee
-		memo        = desc
-		importid, _ = structhash.Hash(trx, 1)
+		t        = trx.Date
+		miliunit = toMilliunits(signedAmount(trx))
+		payee    = payeeName(trx)
+		memo     = withMemoMarker(desc, time.Now())
 	)
 	if t.After(time.Now()) {
 		t = time.Now()
 	}
-	if trx.Type == "DB" {
-		miliunit = -miliunit
-	}
 	p := transaction.PayloadTransaction{
 		AccountID: accountID,
 		Date: api.Date{
 			Time: t,
 		},
 		Amount:     miliunit,
-		Cleared:    transaction.ClearingStatusCleared,
-		Approved:   true,
+		Cleared:    transaction.ClearingStatus(cleared),
+		Approved:   !unapproved,
 		PayeeID:    nil,
 		PayeeName:  &payee,
 		CategoryID: nil,
 		Memo:       &memo,
 		FlagColor:  nil,
-		ImportID:   &importid,
+	}
+	if importID != "" {
+		p.ImportID = &importID
+	}
+	if flagColor != "" {
+		color := transaction.FlagColor(flagColor)
+		p.FlagColor = &color
 	}
 	return p
 }
+
+// validatePolicy checks --cleared and --flag-color
+func validatePolicy() error {
+	switch transaction.ClearingStatus(cleared) {
+	case transaction.ClearingStatusCleared, transaction.ClearingStatusUncleared, transaction.ClearingStatusReconciled:
+	default:
+		return fmt.Errorf("invalid --cleared %q, expected cleared, uncleared or reconciled", cleared)
+	}
+	switch transaction.FlagColor(flagColor) {
+	case "", transaction.FlagColorRed, transaction.FlagColorOrange, transaction.FlagColorYellow,
+		transaction.FlagColorGreen, transaction.FlagColorBlue, transaction.FlagColorPurple:
+	default:
+		return fmt.Errorf("invalid --flag-color %q, expected red, orange, yellow, green, blue or purple", flagColor)
+	}
+	switch transaction.ClearingStatus(adjustCleared) {
+	case transaction.ClearingStatusCleared, transaction.ClearingStatusUncleared, transaction.ClearingStatusReconciled:
+	default:
+		return fmt.Errorf("invalid --adjust-cleared %q, expected cleared, uncleared or reconciled", adjustCleared)
+	}
+	if err := validateRounding(); err != nil {
+		return err
+	}
+	switch scheduledMatch {
+	case "off", "skip", "approve":
+	default:
+		return fmt.Errorf("invalid --scheduled %q, expected off, skip or approve", scheduledMatch)
+	}
+	switch strategyDuplicates {
+	case "skip", "flag", "create":
+	default:
+		return fmt.Errorf("invalid --strategy-duplicates %q, expected skip, flag or create", strategyDuplicates)
+	}
+	switch account.Type(accountType) {
+	case account.TypeChecking, account.TypeSavings, account.TypeCash:
+	default:
+		return fmt.Errorf("invalid --account-type %q, expected checking, savings or cash", accountType)
+	}
+	if adjustThreshold != "" {
+		d, err := decimal.NewFromString(adjustThreshold)
+		if err != nil || d.IsNegative() {
+			return fmt.Errorf("invalid --adjust-threshold %q", adjustThreshold)
+		}
+		adjustThresholdMilliunits = toMilliunits(d)
+	}
+	if fireflyReconcileThreshold != "" {
+		d, err := decimal.NewFromString(fireflyReconcileThreshold)
+		if err != nil || d.IsNegative() {
+			return fmt.Errorf("invalid --firefly-reconcile-threshold %q", fireflyReconcileThreshold)
+		}
+		fireflyReconcileMin = d
+	}
+	return nil
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"go.bmvs.io/ynab/api"
+	"go.bmvs.io/ynab/api/account"
+	"go.bmvs.io/ynab/api/category"
+	"go.bmvs.io/ynab/api/transaction"
+)
+
+const (
+	ynabBaseURL = "https://api.youneedabudget.com/v1"
+)
+
+// ynabAPI is a minimal native ynab client. it covers the endpoints
+// go.bmvs.io/ynab lacks, like transaction update/delete and scheduled
+// transactions
+type ynabAPI struct {
+	// ctx cancels in-flight requests
+	ctx     context.Context
+	token   string
+	baseURL string
+	client  *http.Client
+}
+
+func newYNABAPI(ctx context.Context, token string) *ynabAPI {
+	return &ynabAPI{
+		ctx:     ctx,
+		token:   token,
+		baseURL: ynabBaseURL,
+		// the transport times out every attempt on its own, a client
+		// timeout would cut the waits for the rate limit short
+		client: &http.Client{
+			Transport: &rateLimitTransport{base: http.DefaultTransport},
+		},
+	}
+}
+
+// ynabCapabilities are newer api features that may not be available
+type ynabCapabilities struct {
+	FlagNames       bool
+	ImportPayeeName bool
+}
+
+// saveTransaction mirrors transaction.PayloadTransaction with the newer fields
+type saveTransaction struct {
+	AccountID       string                     `json:"account_id"`
+	Date            string                     `json:"date"`
+	Amount          int64                      `json:"amount"`
+	Cleared         transaction.ClearingStatus `json:"cleared"`
+	Approved        bool                       `json:"approved"`
+	PayeeID         *string                    `json:"payee_id,omitempty"`
+	PayeeName       *string                    `json:"payee_name,omitempty"`
+	CategoryID      *string                    `json:"category_id,omitempty"`
+	Memo            *string                    `json:"memo,omitempty"`
+	FlagColor       *transaction.FlagColor     `json:"flag_color,omitempty"`
+	FlagName        *string                    `json:"flag_name,omitempty"`
+	ImportID        *string                    `json:"import_id,omitempty"`
+	ImportPayeeName *string                    `json:"import_payee_name,omitempty"`
+	Subtransactions []saveSubTransaction       `json:"subtransactions,omitempty"`
+}
+
+// saveSubTransaction is a split of a saveTransaction
+type saveSubTransaction struct {
+	Amount     int64   `json:"amount"`
+	PayeeName  *string `json:"payee_name,omitempty"`
+	CategoryID *string `json:"category_id,omitempty"`
+	Memo       *string `json:"memo,omitempty"`
+}
+
+type saveTransactionsResponse struct {
+	TransactionIDs     []string                   `json:"transaction_ids"`
+	DuplicateImportIDs []string                   `json:"duplicate_import_ids"`
+	Transactions       []*transaction.Transaction `json:"transactions"`
+}
+
+func toSaveTransaction(p transaction.PayloadTransaction) saveTransaction {
+	return saveTransaction{
+		AccountID:  p.AccountID,
+		Date:       p.Date.Format("2006-01-02"),
+		Amount:     p.Amount,
+		Cleared:    p.Cleared,
+		Approved:   p.Approved,
+		PayeeID:    p.PayeeID,
+		PayeeName:  p.PayeeName,
+		CategoryID: p.CategoryID,
+		Memo:       p.Memo,
+		FlagColor:  p.FlagColor,
+		ImportID:   p.ImportID,
+	}
+}
+
+func (y *ynabAPI) do(method, path string, body, out interface{}) error {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequestWithContext(y.ctx, method, y.baseURL+path, r)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+y.token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := y.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		e := &ynabAPIError{Method: method, Path: path, Status: resp.StatusCode}
+		var envelope struct {
+			Error api.Error `json:"error"`
+		}
+		if json.Unmarshal(b, &envelope) == nil && envelope.Error.ID != "" {
+			e.ID, e.Name, e.Detail = envelope.Error.ID, envelope.Error.Name, envelope.Error.Detail
+		} else {
+			e.Detail = string(b)
+		}
+		return e
+	}
+	if out == nil {
+		return nil
+	}
+
+	var envelope struct {
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(b, &envelope); err != nil {
+		return err
+	}
+	return json.Unmarshal(envelope.Data, out)
+}
+
+// capabilities detects newer transaction fields by looking at the fields the
+// api returns for recent transactions of the account, or of the budget if
+// the account has none. with no transaction to look at the fields of the
+// current api are assumed, which ynab ignores if it doesn't know them
+func (y *ynabAPI) capabilities(budget, accountID string) (ynabCapabilities, error) {
+	since := time.Now().AddDate(0, 0, -31).Format("2006-01-02")
+	for _, path := range []string{
+		fmt.Sprintf("/budgets/%s/accounts/%s/transactions?since_date=%s", budget, accountID, since),
+		fmt.Sprintf("/budgets/%s/transactions?since_date=%s", budget, since),
+	} {
+		var data struct {
+			Transactions []map[string]json.RawMessage `json:"transactions"`
+		}
+		if err := y.do(http.MethodGet, path, nil, &data); err != nil {
+			return ynabCapabilities{}, err
+		}
+		if len(data.Transactions) > 0 {
+			var caps ynabCapabilities
+			_, caps.FlagNames = data.Transactions[0]["flag_name"]
+			_, caps.ImportPayeeName = data.Transactions[0]["import_payee_name"]
+			return caps, nil
+		}
+	}
+	return ynabCapabilities{FlagNames: true, ImportPayeeName: true}, nil
+}
+
+func (y *ynabAPI) saveTransactions(budget string, ts []saveTransaction) (*saveTransactionsResponse, error) {
+	var (
+		body = struct {
+			Transactions []saveTransaction `json:"transactions"`
+		}{ts}
+		resp saveTransactionsResponse
+	)
+	if err := y.do(http.MethodPost, fmt.Sprintf("/budgets/%s/transactions", budget), body, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func (y *ynabAPI) getAccounts(budget string) ([]*account.Account, error) {
+	var data struct {
+		Accounts []*account.Account `json:"accounts"`
+	}
+	if err := y.do(http.MethodGet, fmt.Sprintf("/budgets/%s/accounts", budget), nil, &data); err != nil {
+		return nil, err
+	}
+	return data.Accounts, nil
+}
+
+func (y *ynabAPI) getAccount(budget, accountID string) (*account.Account, error) {
+	var data struct {
+		Account *account.Account `json:"account"`
+	}
+	if err := y.do(http.MethodGet, fmt.Sprintf("/budgets/%s/accounts/%s", budget, accountID), nil, &data); err != nil {
+		return nil, err
+	}
+	return data.Account, nil
+}
+
+func (y *ynabAPI) createAccount(budget, name string, t account.Type, balance int64) (*account.Account, error) {
+	body := map[string]interface{}{
+		"account": map[string]interface{}{"name": name, "type": t, "balance": balance},
+	}
+	var data struct {
+		Account *account.Account `json:"account"`
+	}
+	if err := y.do(http.MethodPost, fmt.Sprintf("/budgets/%s/accounts", budget), body, &data); err != nil {
+		return nil, err
+	}
+	return data.Account, nil
+}
+
+func (y *ynabAPI) getCategories(budget string) ([]*category.GroupWithCategories, error) {
+	var data struct {
+		CategoryGroups []*category.GroupWithCategories `json:"category_groups"`
+	}
+	if err := y.do(http.MethodGet, fmt.Sprintf("/budgets/%s/categories", budget), nil, &data); err != nil {
+		return nil, err
+	}
+	return data.CategoryGroups, nil
+}
+
+func (y *ynabAPI) getTransactions(budget, accountID string, since time.Time) ([]*transaction.Transaction, error) {
+	var data struct {
+		Transactions []*transaction.Transaction `json:"transactions"`
+	}
+	err := y.do(http.MethodGet, fmt.Sprintf("/budgets/%s/accounts/%s/transactions?since_date=%s", budget, accountID, since.Format("2006-01-02")), nil, &data)
+	if err != nil {
+		return nil, err
+	}
+	return data.Transactions, nil
+}
+
+func (y *ynabAPI) createTransaction(budget string, p transaction.PayloadTransaction) error {
+	body := struct {
+		Transaction saveTransaction `json:"transaction"`
+	}{toSaveTransaction(p)}
+	return y.do(http.MethodPost, fmt.Sprintf("/budgets/%s/transactions", budget), body, nil)
+}
+
+func (y *ynabAPI) createTransactions(budget string, ps []transaction.PayloadTransaction) (*transaction.OperationSummary, error) {
+	ts := make([]saveTransaction, 0, len(ps))
+	for _, p := range ps {
+		ts = append(ts, toSaveTransaction(p))
+	}
+	resp, err := y.saveTransactions(budget, ts)
+	if err != nil {
+		return nil, err
+	}
+	return &transaction.OperationSummary{
+		TransactionIDs:     resp.TransactionIDs,
+		DuplicateImportIDs: resp.DuplicateImportIDs,
+		Transactions:       resp.Transactions,
+	}, nil
+}
+
+func (y *ynabAPI) updateTransaction(budget, id string, p transaction.PayloadTransaction) error {
+	body := struct {
+		Transaction saveTransaction `json:"transaction"`
+	}{toSaveTransaction(p)}
+	return y.do(http.MethodPut, fmt.Sprintf("/budgets/%s/transactions/%s", budget, id), body, nil)
+}
+
+func (y *ynabAPI) deleteTransaction(budget, id string) error {
+	return y.do(http.MethodDelete, fmt.Sprintf("/budgets/%s/transactions/%s", budget, id), nil, nil)
+}
+
+func (y *ynabAPI) setBudgeted(budget, month, categoryID string, milliunits int64) error {
+	body := map[string]interface{}{
+		"category": map[string]int64{"budgeted": milliunits},
+	}
+	return y.do(http.MethodPatch, fmt.Sprintf("/budgets/%s/months/%s/categories/%s", budget, month, categoryID), body, nil)
+}
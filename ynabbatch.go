@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/satraul/bca-go"
+	"go.bmvs.io/ynab/api/transaction"
+)
+
+var (
+	ynabBatchSize  int
+	ynabBatchDelay time.Duration
+)
+
+// failedEntry is a bca entry ynab didn't create
+type failedEntry struct {
+	Entry    bca.Entry
+	ImportID string
+	Err      error
+}
+
+// ynabBatchError lists the bca entries of a push that ynab didn't create,
+// while the others were
+type ynabBatchError struct {
+	Failed []failedEntry
+}
+
+func (e *ynabBatchError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d transaction(s) weren't created:", len(e.Failed))
+	for _, f := range e.Failed {
+		fmt.Fprintf(&b, "\n  %s %s %s %s (%s): %v", entryDate(f.Entry), f.Entry.Type, f.Entry.Amount.StringFixed(2), f.Entry.Payee, f.ImportID, f.Err)
+	}
+	return b.String()
+}
+
+// Unwrap returns the error of the first failed entry, so hints see it
+func (e *ynabBatchError) Unwrap() error {
+	return e.Failed[0].Err
+}
+
+func entryDate(trx bca.Entry) string {
+	if trx.Date.IsZero() {
+		return "PEND"
+	}
+	return trx.Date.Format(dateLayout)
+}
+
+// retryableBatch tells whether a batch failing with err may succeed in
+// smaller parts. errors of the token, budget or rate limit fail every part
+// the same way
+func retryableBatch(err error) bool {
+	switch ynabStatus(err) {
+	case 401, 403, 404, 429:
+		return false
+	}
+	return true
+}
+
+// createYNABBatches creates the payloads at idx with create. when ynab
+// rejects a batch, or the request fails midway, it is split in halves that
+// are retried on their own, down to single transactions, so that one bad
+// transaction doesn't keep the others out. retrying is safe as ynab reports
+// transactions created before as duplicates by their import id. it returns
+// what was created along with a *ynabBatchError of the entries that
+// weren't, or the error of the first request if nothing could be retried
+func createYNABBatches(trxs []bca.Entry, ps []transaction.PayloadTransaction, create func(idx []int) (*transaction.OperationSummary, error)) (*transaction.OperationSummary, error) {
+	idx := make([]int, len(ps))
+	for i := range idx {
+		idx[i] = i
+	}
+	resp, err := create(idx)
+	if err == nil {
+		return resp, nil
+	}
+	if !retryableBatch(err) {
+		return nil, err
+	}
+
+	var (
+		all    = &transaction.OperationSummary{}
+		failed []failedEntry
+		split  func(idx []int, err error)
+	)
+	split = func(idx []int, err error) {
+		if len(idx) == 1 || !retryableBatch(err) {
+			for _, i := range idx {
+				failed = append(failed, failedEntry{Entry: trxs[i], ImportID: *ps[i].ImportID, Err: err})
+			}
+			return
+		}
+		for _, half := range [][]int{idx[:len(idx)/2], idx[len(idx)/2:]} {
+			resp, err := create(half)
+			if err != nil {
+				split(half, err)
+				continue
+			}
+			all.TransactionIDs = append(all.TransactionIDs, resp.TransactionIDs...)
+			all.DuplicateImportIDs = append(all.DuplicateImportIDs, resp.DuplicateImportIDs...)
+			all.Transactions = append(all.Transactions, resp.Transactions...)
+		}
+	}
+	fmt.Fprintf(stdout, "ynab rejected %d transaction(s) at once, retrying in smaller batches: %v\n", len(ps), err)
+	split(idx, err)
+	if len(failed) == 0 {
+		return all, nil
+	}
+	return all, &ynabBatchError{Failed: failed}
+}
+
+// createYNABChunks creates the payloads in chunks of --ynab-batch-size, one
+// request each and --ynab-batch-delay apart, so that backfills don't send
+// everything in one huge request. a chunk ynab rejects is retried in halves
+// by createYNABBatches, and the chunks after it are still pushed, unless the
+// error would fail them too or ctx is done while waiting
+func createYNABChunks(ctx context.Context, trxs []bca.Entry, ps []transaction.PayloadTransaction, create func(idx []int) (*transaction.OperationSummary, error)) (*transaction.OperationSummary, error) {
+	size := ynabBatchSize
+	if size <= 0 || len(ps) <= size {
+		return createYNABBatches(trxs, ps, create)
+	}
+
+	var (
+		chunks = (len(ps) + size - 1) / size
+		all    = &transaction.OperationSummary{}
+		failed []failedEntry
+	)
+	for n := 0; n < chunks; n++ {
+		lo, hi := n*size, (n+1)*size
+		if hi > len(ps) {
+			hi = len(ps)
+		}
+		if n > 0 && ynabBatchDelay > 0 {
+			select {
+			case <-ctx.Done():
+				for i := lo; i < len(ps); i++ {
+					failed = append(failed, failedEntry{Entry: trxs[i], ImportID: *ps[i].ImportID, Err: ctx.Err()})
+				}
+				return all, &ynabBatchError{Failed: failed}
+			case <-time.After(ynabBatchDelay):
+			}
+		}
+		fmt.Fprintf(stdout, "pushing batch %d of %d to ynab, %d transaction(s)\n", n+1, chunks, hi-lo)
+
+		resp, err := createYNABBatches(trxs[lo:hi], ps[lo:hi], func(idx []int) (*transaction.OperationSummary, error) {
+			shifted := make([]int, len(idx))
+			for i, j := range idx {
+				shifted[i] = j + lo
+			}
+			return create(shifted)
+		})
+		if resp == nil {
+			if n == 0 {
+				return nil, err
+			}
+			// the remaining chunks would fail the same way
+			for i := lo; i < len(ps); i++ {
+				failed = append(failed, failedEntry{Entry: trxs[i], ImportID: *ps[i].ImportID, Err: err})
+			}
+			break
+		}
+		all.TransactionIDs = append(all.TransactionIDs, resp.TransactionIDs...)
+		all.DuplicateImportIDs = append(all.DuplicateImportIDs, resp.DuplicateImportIDs...)
+		all.Transactions = append(all.Transactions, resp.Transactions...)
+		var batchErr *ynabBatchError
+		if errors.As(err, &batchErr) {
+			failed = append(failed, batchErr.Failed...)
+		}
+	}
+	if len(failed) == 0 {
+		return all, nil
+	}
+	return all, &ynabBatchError{Failed: failed}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.bmvs.io/ynab"
+	"go.bmvs.io/ynab/api"
+	"go.bmvs.io/ynab/api/account"
+	"go.bmvs.io/ynab/api/category"
+	"go.bmvs.io/ynab/api/transaction"
+)
+
+// ynabClient is everything the sync needs from ynab. it is implemented by
+// libYNAB on top of go.bmvs.io/ynab and natively by ynabAPI
+type ynabClient interface {
+	getAccounts(budget string) ([]*account.Account, error)
+	getAccount(budget, accountID string) (*account.Account, error)
+	createAccount(budget, name string, t account.Type, balance int64) (*account.Account, error)
+	getCategories(budget string) ([]*category.GroupWithCategories, error)
+	getTransactions(budget, accountID string, since time.Time) ([]*transaction.Transaction, error)
+	createTransaction(budget string, p transaction.PayloadTransaction) error
+	createTransactions(budget string, ps []transaction.PayloadTransaction) (*transaction.OperationSummary, error)
+	updateTransaction(budget, id string, p transaction.PayloadTransaction) error
+	deleteTransaction(budget, id string) error
+	getScheduledTransactions(budget string) ([]*scheduledTransaction, error)
+	transferPayeeID(budget, accountID string) (string, error)
+	// setBudgeted sets the budgeted milliunits of a category in month,
+	// given as yyyy-mm-01
+	setBudgeted(budget, month, categoryID string, milliunits int64) error
+
+	capabilities(budget, accountID string) (ynabCapabilities, error)
+	saveTransactions(budget string, ts []saveTransaction) (*saveTransactionsResponse, error)
+}
+
+// newYNABClient returns the client chosen by --ynab-client. only native
+// requests are cancelled with ctx, go.bmvs.io/ynab doesn't support it
+func newYNABClient(ctx context.Context, token string) (ynabClient, error) {
+	switch ynabClientName {
+	case "lib":
+		return &libYNAB{ynabAPI: newYNABAPI(ctx, token), yc: ynab.NewClient(token)}, nil
+	case "native":
+		return newYNABAPI(ctx, token), nil
+	default:
+		return nil, fmt.Errorf("unknown ynab client %q, expected lib or native", ynabClientName)
+	}
+}
+
+// libYNAB uses go.bmvs.io/ynab where it can and falls back to the native
+// client for endpoints it lacks
+type libYNAB struct {
+	*ynabAPI
+	yc ynab.ClientServicer
+}
+
+func (l *libYNAB) getAccounts(budget string) ([]*account.Account, error) {
+	accs, err := l.yc.Account().GetAccounts(budget, nil)
+	if err != nil {
+		return nil, err
+	}
+	return accs.Accounts, nil
+}
+
+func (l *libYNAB) getAccount(budget, accountID string) (*account.Account, error) {
+	return l.yc.Account().GetAccount(budget, accountID)
+}
+
+func (l *libYNAB) getCategories(budget string) ([]*category.GroupWithCategories, error) {
+	cs, err := l.yc.Category().GetCategories(budget, nil)
+	if err != nil {
+		return nil, err
+	}
+	return cs.GroupWithCategories, nil
+}
+
+func (l *libYNAB) getTransactions(budget, accountID string, since time.Time) ([]*transaction.Transaction, error) {
+	return l.yc.Transaction().GetTransactionsByAccount(budget, accountID, &transaction.Filter{Since: &api.Date{Time: since}})
+}
+
+func (l *libYNAB) createTransaction(budget string, p transaction.PayloadTransaction) error {
+	_, err := l.yc.Transaction().CreateTransaction(budget, p)
+	return err
+}
+
+func (l *libYNAB) createTransactions(budget string, ps []transaction.PayloadTransaction) (*transaction.OperationSummary, error) {
+	return l.yc.Transaction().CreateTransactions(budget, ps)
+}
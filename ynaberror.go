@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"go.bmvs.io/ynab/api"
+)
+
+// ynabAPIError is an error response of the native ynab client
+type ynabAPIError struct {
+	Method, Path     string
+	Status           int
+	ID, Name, Detail string
+}
+
+func (e *ynabAPIError) Error() string {
+	return strings.TrimSpace(fmt.Sprintf("ynab %s %s returned %d: %s %s", e.Method, e.Path, e.Status, e.Name, e.Detail))
+}
+
+// ynabStatus returns the http status of a ynab error from either client, or
+// 0 for other errors
+func ynabStatus(err error) int {
+	var native *ynabAPIError
+	if errors.As(err, &native) {
+		return native.Status
+	}
+	// go.bmvs.io/ynab only keeps the error id, like 404.2
+	var lib *api.Error
+	if errors.As(err, &lib) {
+		status, _ := strconv.Atoi(strings.SplitN(lib.ID, ".", 2)[0])
+		return status
+	}
+	return 0
+}
+
+// ynabHint adds what to do about common ynab errors to err, and the exit
+// code telling them apart
+func ynabHint(err error) error {
+	if err == nil {
+		return nil
+	}
+	var (
+		hint string
+		code int
+	)
+	switch status := ynabStatus(err); {
+	case errors.Is(err, errAccountNotFound):
+		hint = "check --account and --ynab-target, account names are case sensitive. --create-account creates missing accounts"
+		code = exitYNABNotFound
+	case status == 401:
+		hint = "the ynab token is invalid or expired. create a new personal access token at https://app.youneedabudget.com/settings/developer and store it with -r"
+		code = exitYNABAuth
+	case status == 403:
+		hint = "ynab refused access, the ynab subscription may have lapsed or the budget may be read-only"
+		code = exitYNABAuth
+	case status == 404:
+		hint = "ynab couldn't find the budget or account. check --budget, --account and --ynab-target with doctor"
+		code = exitYNABNotFound
+	case status == 429:
+		hint = "ynab allows 200 requests an hour per token and they are used up. wait for the hour to pass, or sync less often"
+		code = exitYNABRateLimited
+	default:
+		return err
+	}
+	return withExitCode(fmt.Errorf("%w\nhint: %s", err, hint), code)
+}